@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	auditService service.AuditService
+}
+
+func NewAuditHandler(auditService service.AuditService) *AuditHandler {
+	return &AuditHandler{auditService: auditService}
+}
+
+func (h *AuditHandler) ListEvents(c *gin.Context) {
+	const op = "api.ListAuditEvents"
+
+	filter := model.AuditFilter{
+		Actor:      c.Query("actor"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		raw := c.Query(p.name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, p.name, raw), "invalid "+p.name+" timestamp, expected RFC3339")
+			return
+		}
+		*p.dst = &t
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page", c.Query("page")), "invalid page number")
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page_size", c.Query("page_size")), "invalid page size")
+		return
+	}
+
+	events, err := h.auditService.ListEvents(c.Request.Context(), filter, page, pageSize)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err, "")
+		return
+	}
+
+	response := make([]model.AuditEventResponse, 0, len(events))
+	for _, e := range events {
+		response = append(response, model.AuditEventResponse{
+			ID:         e.ID,
+			EventID:    e.EventID,
+			Actor:      e.Actor,
+			Action:     e.Action,
+			TargetType: e.TargetType,
+			TargetID:   e.TargetID,
+			RequestID:  e.RequestID,
+			Before:     rawJSON(e.Before),
+			After:      rawJSON(e.After),
+			ClientIP:   e.ClientIP,
+			UserAgent:  e.UserAgent,
+			PrevHash:   e.PrevHash,
+			Hash:       e.Hash,
+			Chain:      e.Chain,
+			CreatedAt:  e.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *AuditHandler) VerifyChain(c *gin.Context) {
+	result, err := h.auditService.VerifyChain(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err, "")
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func rawJSON(s *string) json.RawMessage {
+	if s == nil {
+		return nil
+	}
+	return json.RawMessage(*s)
+}
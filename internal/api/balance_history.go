@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// defaultDailyBalanceDays is the length of a daily series when from is not given
+const defaultDailyBalanceDays = 30
+
+type BalanceHistoryHandler struct {
+	balanceHistoryService service.BalanceHistoryService
+}
+
+func NewBalanceHistoryHandler(balanceHistoryService service.BalanceHistoryService) *BalanceHistoryHandler {
+	return &BalanceHistoryHandler{balanceHistoryService: balanceHistoryService}
+}
+
+func (h *BalanceHistoryHandler) GetBalanceAt(c *gin.Context) {
+	const op = "api.GetBalanceAt"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	currency := c.Query("currency")
+	if currency == "" {
+		currency = "USD"
+	}
+
+	at, err := time.Parse(time.RFC3339, c.Query("at"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "at", c.Query("at")), "invalid at timestamp, expected RFC3339")
+		return
+	}
+
+	balance, err := h.balanceHistoryService.GetBalanceAt(c.Request.Context(), userID, currency, at)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, model.HistoricalBalanceResponse{
+		Balance:  balance,
+		Currency: currency,
+		At:       at,
+	})
+}
+
+func (h *BalanceHistoryHandler) GetDailyBalances(c *gin.Context) {
+	const op = "api.GetDailyBalances"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	currency := c.Query("currency")
+	if currency == "" {
+		currency = "USD"
+	}
+
+	to := time.Now().UTC()
+	if raw := c.Query("to"); raw != "" {
+		if to, err = time.Parse(time.DateOnly, raw); err != nil {
+			respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "to", raw), "invalid to date, expected YYYY-MM-DD")
+			return
+		}
+	}
+	from := to.AddDate(0, 0, 1-defaultDailyBalanceDays)
+	if raw := c.Query("from"); raw != "" {
+		if from, err = time.Parse(time.DateOnly, raw); err != nil {
+			respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "from", raw), "invalid from date, expected YYYY-MM-DD")
+			return
+		}
+	}
+
+	balances, err := h.balanceHistoryService.GetDailyBalances(c.Request.Context(), userID, currency, from, to)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	response := model.DailyBalancesResponse{
+		Currency: currency,
+		Balances: make([]model.DailyBalanceResponse, 0, len(balances)),
+	}
+	for _, b := range balances {
+		response.Balances = append(response.Balances, model.DailyBalanceResponse{
+			Date:    b.Date.Format(time.DateOnly),
+			Balance: b.Balance,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// IdempotencyKeyHeader carries the client's key for a batch submission
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type BatchHandler struct {
+	batchService service.BatchService
+}
+
+func NewBatchHandler(batchService service.BatchService) *BatchHandler {
+	return &BatchHandler{batchService: batchService}
+}
+
+// Submit answers 201 with the results of a batch run inline, 202 for a queued
+// batch and 200 with the original batch when the idempotency key was seen before.
+func (h *BatchHandler) Submit(c *gin.Context) {
+	const op = "api.SubmitBatch"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "idempotency key", key), IdempotencyKeyHeader+" header is required")
+		return
+	}
+
+	var req model.CreateBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), err.Error())
+		return
+	}
+
+	batch, created, err := h.batchService.Submit(c.Request.Context(), userID, key, req)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	resp, err := batchResponse(batch)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errors.NewInternal(op, err), "")
+		return
+	}
+	switch {
+	case !created:
+		c.Header("Idempotent-Replayed", "true")
+		c.JSON(http.StatusOK, resp)
+	case batch.Status == model.BatchPending:
+		c.Header("Location", "/api/v1/batches/"+batch.ID.String())
+		c.JSON(http.StatusAccepted, resp)
+	default:
+		c.JSON(http.StatusCreated, resp)
+	}
+}
+
+func (h *BatchHandler) GetBatch(c *gin.Context) {
+	const op = "api.GetBatch"
+
+	userID, batchID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	batch, err := h.batchService.GetBatch(c.Request.Context(), userID, batchID)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	resp, err := batchResponse(batch)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errors.NewInternal(op, err), "")
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func batchResponse(batch *model.Batch) (model.BatchResponse, error) {
+	resp := model.BatchResponse{
+		ID:          batch.ID,
+		Mode:        batch.Mode,
+		Status:      batch.Status,
+		ItemCount:   batch.ItemCount,
+		Succeeded:   batch.Succeeded,
+		Failed:      batch.Failed,
+		CreatedAt:   batch.CreatedAt,
+		CompletedAt: batch.CompletedAt,
+	}
+	if batch.Results != nil {
+		if err := json.Unmarshal([]byte(*batch.Results), &resp.Results); err != nil {
+			return model.BatchResponse{}, err
+		}
+	}
+	return resp, nil
+}
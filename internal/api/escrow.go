@@ -0,0 +1,168 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type EscrowHandler struct {
+	escrowService service.EscrowService
+}
+
+func NewEscrowHandler(escrowService service.EscrowService) *EscrowHandler {
+	return &EscrowHandler{escrowService: escrowService}
+}
+
+func (h *EscrowHandler) CreateEscrow(c *gin.Context) {
+	const op = "api.CreateEscrow"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	var req model.CreateEscrowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), err.Error())
+		return
+	}
+
+	escrow, err := h.escrowService.CreateEscrow(c.Request.Context(), userID, req)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusCreated, escrowResponse(escrow))
+}
+
+func (h *EscrowHandler) ListEscrows(c *gin.Context) {
+	const op = "api.ListEscrows"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page", c.Query("page")), "invalid page number")
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page_size", c.Query("page_size")), "invalid page size")
+		return
+	}
+
+	escrows, err := h.escrowService.ListEscrows(c.Request.Context(), userID, page, pageSize)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	response := make([]model.EscrowResponse, 0, len(escrows))
+	for i := range escrows {
+		response = append(response, escrowResponse(&escrows[i]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *EscrowHandler) GetEscrow(c *gin.Context) {
+	const op = "api.GetEscrow"
+
+	userID, escrowID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	escrow, err := h.escrowService.GetEscrow(c.Request.Context(), userID, escrowID)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusOK, escrowResponse(escrow))
+}
+
+func (h *EscrowHandler) Release(c *gin.Context) {
+	const op = "api.ReleaseEscrow"
+
+	userID, escrowID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	escrow, err := h.escrowService.Release(c.Request.Context(), userID, escrowID)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusOK, escrowResponse(escrow))
+}
+
+func (h *EscrowHandler) Refund(c *gin.Context) {
+	const op = "api.RefundEscrow"
+
+	userID, escrowID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	escrow, err := h.escrowService.Refund(c.Request.Context(), userID, escrowID)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusOK, escrowResponse(escrow))
+}
+
+func (h *EscrowHandler) Split(c *gin.Context) {
+	const op = "api.SplitEscrow"
+
+	userID, escrowID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	var req model.SplitEscrowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), err.Error())
+		return
+	}
+
+	escrow, err := h.escrowService.Split(c.Request.Context(), userID, escrowID, req.SellerAmount)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusOK, escrowResponse(escrow))
+}
+
+func escrowResponse(e *model.Escrow) model.EscrowResponse {
+	return model.EscrowResponse{
+		ID:             e.ID,
+		BuyerID:        e.BuyerID,
+		SellerID:       e.SellerID,
+		ArbiterID:      e.ArbiterID,
+		WalletID:       e.WalletID,
+		Amount:         e.Amount,
+		Currency:       e.Currency,
+		Reference:      e.Reference,
+		Status:         e.Status,
+		Deadline:       e.Deadline,
+		DeadlineAction: e.DeadlineAction,
+		ReleasedAmount: e.ReleasedAmount,
+		RefundedAmount: e.RefundedAmount,
+		ResolvedBy:     e.ResolvedBy,
+		CreatedAt:      e.CreatedAt,
+		ResolvedAt:     e.ResolvedAt,
+	}
+}
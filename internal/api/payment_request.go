@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type PaymentRequestHandler struct {
+	paymentRequestService service.PaymentRequestService
+}
+
+func NewPaymentRequestHandler(paymentRequestService service.PaymentRequestService) *PaymentRequestHandler {
+	return &PaymentRequestHandler{paymentRequestService: paymentRequestService}
+}
+
+func (h *PaymentRequestHandler) CreatePaymentRequest(c *gin.Context) {
+	const op = "api.CreatePaymentRequest"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	var req model.CreatePaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), err.Error())
+		return
+	}
+
+	pr, err := h.paymentRequestService.CreatePaymentRequest(c.Request.Context(), userID, req)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusCreated, paymentRequestResponse(pr))
+}
+
+// ListIncoming lists the requests the user has been asked to pay
+func (h *PaymentRequestHandler) ListIncoming(c *gin.Context) {
+	h.list(c, "api.ListIncomingPaymentRequests", model.PaymentRequestsIncoming)
+}
+
+// ListOutgoing lists the requests the user has sent
+func (h *PaymentRequestHandler) ListOutgoing(c *gin.Context) {
+	h.list(c, "api.ListOutgoingPaymentRequests", model.PaymentRequestsOutgoing)
+}
+
+func (h *PaymentRequestHandler) list(c *gin.Context, op, direction string) {
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page", c.Query("page")), "invalid page number")
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page_size", c.Query("page_size")), "invalid page size")
+		return
+	}
+
+	reqs, err := h.paymentRequestService.ListPaymentRequests(c.Request.Context(), userID, direction, c.Query("status"), page, pageSize)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	response := make([]model.PaymentRequestResponse, 0, len(reqs))
+	for i := range reqs {
+		response = append(response, paymentRequestResponse(&reqs[i]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *PaymentRequestHandler) GetPaymentRequest(c *gin.Context) {
+	const op = "api.GetPaymentRequest"
+
+	userID, requestID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	pr, err := h.paymentRequestService.GetPaymentRequest(c.Request.Context(), userID, requestID)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusOK, paymentRequestResponse(pr))
+}
+
+func (h *PaymentRequestHandler) Accept(c *gin.Context) {
+	h.resolve(c, "api.AcceptPaymentRequest", h.paymentRequestService.AcceptPaymentRequest)
+}
+
+func (h *PaymentRequestHandler) Decline(c *gin.Context) {
+	h.resolve(c, "api.DeclinePaymentRequest", h.paymentRequestService.DeclinePaymentRequest)
+}
+
+func (h *PaymentRequestHandler) Cancel(c *gin.Context) {
+	h.resolve(c, "api.CancelPaymentRequest", h.paymentRequestService.CancelPaymentRequest)
+}
+
+func (h *PaymentRequestHandler) resolve(
+	c *gin.Context,
+	op string,
+	apply func(ctx context.Context, userID, requestID uuid.UUID) (*model.PaymentRequest, error),
+) {
+	userID, requestID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	pr, err := apply(c.Request.Context(), userID, requestID)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusOK, paymentRequestResponse(pr))
+}
+
+func paymentRequestResponse(pr *model.PaymentRequest) model.PaymentRequestResponse {
+	status := pr.Status
+	// the expiry sweep runs periodically, don't show an overdue request as payable meanwhile
+	if status == model.PaymentRequestPending && !time.Now().Before(pr.ExpiresAt) {
+		status = model.PaymentRequestExpired
+	}
+	return model.PaymentRequestResponse{
+		ID:          pr.ID,
+		RequesterID: pr.RequesterID,
+		PayerID:     pr.PayerID,
+		Amount:      pr.Amount,
+		Currency:    pr.Currency,
+		Note:        pr.Note,
+		Status:      status,
+		ExpiresAt:   pr.ExpiresAt,
+		CreatedAt:   pr.CreatedAt,
+		ResolvedAt:  pr.ResolvedAt,
+	}
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ScheduleHandler struct {
+	scheduleService service.ScheduleService
+}
+
+func NewScheduleHandler(scheduleService service.ScheduleService) *ScheduleHandler {
+	return &ScheduleHandler{scheduleService: scheduleService}
+}
+
+func (h *ScheduleHandler) CreateSchedule(c *gin.Context) {
+	const op = "api.CreateSchedule"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	var req model.CreateScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), err.Error())
+		return
+	}
+
+	sched, err := h.scheduleService.CreateSchedule(c.Request.Context(), userID, req)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusCreated, scheduleResponse(sched))
+}
+
+func (h *ScheduleHandler) ListSchedules(c *gin.Context) {
+	const op = "api.ListSchedules"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	schedules, err := h.scheduleService.ListSchedules(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	response := make([]model.ScheduleResponse, 0, len(schedules))
+	for i := range schedules {
+		response = append(response, scheduleResponse(&schedules[i]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *ScheduleHandler) GetSchedule(c *gin.Context) {
+	const op = "api.GetSchedule"
+
+	userID, scheduleID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	sched, err := h.scheduleService.GetSchedule(c.Request.Context(), userID, scheduleID)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusOK, scheduleResponse(sched))
+}
+
+func (h *ScheduleHandler) PauseSchedule(c *gin.Context) {
+	h.transition(c, "api.PauseSchedule", h.scheduleService.PauseSchedule)
+}
+
+func (h *ScheduleHandler) ResumeSchedule(c *gin.Context) {
+	h.transition(c, "api.ResumeSchedule", h.scheduleService.ResumeSchedule)
+}
+
+func (h *ScheduleHandler) CancelSchedule(c *gin.Context) {
+	h.transition(c, "api.CancelSchedule", h.scheduleService.CancelSchedule)
+}
+
+// transition applies a status change and answers with the updated schedule
+func (h *ScheduleHandler) transition(c *gin.Context, op string, apply func(ctx context.Context, userID, scheduleID uuid.UUID) error) {
+	userID, scheduleID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	if err := apply(c.Request.Context(), userID, scheduleID); err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	sched, err := h.scheduleService.GetSchedule(c.Request.Context(), userID, scheduleID)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusOK, scheduleResponse(sched))
+}
+
+func (h *ScheduleHandler) ListRuns(c *gin.Context) {
+	const op = "api.ListScheduleRuns"
+
+	userID, scheduleID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page", c.Query("page")), "invalid page number")
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page_size", c.Query("page_size")), "invalid page size")
+		return
+	}
+
+	runs, err := h.scheduleService.ListRuns(c.Request.Context(), userID, scheduleID, page, pageSize)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	response := make([]model.ScheduleRunResponse, 0, len(runs))
+	for _, r := range runs {
+		response = append(response, model.ScheduleRunResponse{
+			ScheduledFor: r.ScheduledFor,
+			Attempt:      r.Attempt,
+			Status:       r.Status,
+			Error:        r.Error,
+			ExecutedAt:   r.ExecutedAt,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func scheduleResponse(s *model.TransferSchedule) model.ScheduleResponse {
+	resp := model.ScheduleResponse{
+		ID:            s.ID,
+		ToUserID:      s.ToUserID,
+		Amount:        s.Amount,
+		Currency:      s.Currency,
+		Reference:     s.Reference,
+		Kind:          s.Kind,
+		Timezone:      s.Timezone,
+		StartAt:       s.StartAt,
+		EndAt:         s.EndAt,
+		Status:        s.Status,
+		NextRunAt:     s.NextRunAt,
+		LastRunAt:     s.LastRunAt,
+		Runs:          s.Runs,
+		MaxRetries:    s.MaxRetries,
+		RetryInterval: (time.Duration(s.RetryIntervalSeconds) * time.Second).String(),
+		LastError:     s.LastError,
+		CreatedAt:     s.CreatedAt,
+	}
+	if s.CronExpr != nil {
+		resp.Cron = *s.CronExpr
+	}
+	if s.IntervalSeconds != nil {
+		resp.Interval = (time.Duration(*s.IntervalSeconds) * time.Second).String()
+	}
+	return resp
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/internal/settlement"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxSettlementFileSize caps an uploaded settlement file
+const maxSettlementFileSize = 32 << 20
+
+type SettlementHandler struct {
+	settlementService service.SettlementService
+}
+
+func NewSettlementHandler(settlementService service.SettlementService) *SettlementHandler {
+	return &SettlementHandler{settlementService: settlementService}
+}
+
+// Import takes the settlement file as the request body, or as the file field
+// of a multipart form. It answers 201 with the matching summary, or 200 with
+// the first import when the same file was imported before.
+func (h *SettlementHandler) Import(c *gin.Context) {
+	const op = "api.ImportSettlement"
+
+	format := c.DefaultQuery("format", settlement.FormatCSV)
+	source := c.Query("source")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSettlementFileSize)
+	var file io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "missing file field")
+			return
+		}
+		f, err := header.Open()
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "unreadable file field")
+			return
+		}
+		defer f.Close()
+		file = f
+		if source == "" {
+			source = header.Filename
+		}
+	}
+
+	result, created, err := h.settlementService.Import(c.Request.Context(), source, format, file)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	if !created {
+		c.Header("Idempotent-Replayed", "true")
+		c.JSON(http.StatusOK, settlementResponse(result))
+		return
+	}
+	c.Header("Location", "/api/v1/settlements/"+result.ID.String())
+	c.JSON(http.StatusCreated, settlementResponse(result))
+}
+
+func (h *SettlementHandler) GetSettlement(c *gin.Context) {
+	const op = "api.GetSettlement"
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid settlement ID")
+		return
+	}
+
+	result, err := h.settlementService.GetSettlement(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusOK, settlementResponse(result))
+}
+
+// ListLines pages through the lines of a settlement, ?status=unmatched lists
+// the lines needing attention
+func (h *SettlementHandler) ListLines(c *gin.Context) {
+	const op = "api.ListSettlementLines"
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid settlement ID")
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page", c.Query("page")), "invalid page number")
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page_size", c.Query("page_size")), "invalid page size")
+		return
+	}
+
+	lines, err := h.settlementService.ListLines(c.Request.Context(), id, c.Query("status"), page, pageSize)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	response := make([]model.SettlementLineResponse, 0, len(lines))
+	for _, l := range lines {
+		line := model.SettlementLineResponse{
+			LineNumber:    l.LineNumber,
+			Reference:     l.Reference,
+			Direction:     l.Direction,
+			Amount:        l.Amount,
+			Currency:      l.Currency,
+			Status:        l.Status,
+			TransactionID: l.TransactionID,
+			Detail:        l.Detail,
+		}
+		if l.ValueDate != nil {
+			line.ValueDate = l.ValueDate.Format(time.DateOnly)
+		}
+		response = append(response, line)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func settlementResponse(s *model.Settlement) model.SettlementResponse {
+	return model.SettlementResponse{
+		ID:         s.ID,
+		Source:     s.Source,
+		Format:     s.Format,
+		LineCount:  s.LineCount,
+		Matched:    s.Matched,
+		Unmatched:  s.Unmatched,
+		Mismatched: s.Mismatched,
+		Duplicate:  s.Duplicate,
+		CreatedAt:  s.CreatedAt,
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/internal/statement"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type StatementHandler struct {
+	statementService service.StatementService
+}
+
+func NewStatementHandler(statementService service.StatementService) *StatementHandler {
+	return &StatementHandler{statementService: statementService}
+}
+
+// GetStatement streams a statement. from and to are RFC 3339 timestamps or
+// YYYY-MM-DD dates, a to date including that whole UTC day.
+func (h *StatementHandler) GetStatement(c *gin.Context) {
+	const op = "api.GetStatement"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	currency := c.Query("currency")
+	if currency == "" {
+		currency = "USD"
+	}
+	format := c.DefaultQuery("format", statement.FormatCSV)
+
+	from, ok := parseStatementTime(c.Query("from"), false)
+	if !ok {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "from", c.Query("from")), "invalid from, expected RFC3339 or YYYY-MM-DD")
+		return
+	}
+	to := time.Now()
+	if raw := c.Query("to"); raw != "" {
+		if to, ok = parseStatementTime(raw, true); !ok {
+			respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "to", raw), "invalid to, expected RFC3339 or YYYY-MM-DD")
+			return
+		}
+	}
+
+	out := &statementOutput{
+		c:           c,
+		contentType: statement.ContentType(format),
+		filename:    fmt.Sprintf("statement-%s-%s.%s", currency, from.UTC().Format(time.DateOnly), format),
+	}
+	if _, err := h.statementService.WriteStatement(c.Request.Context(), userID, currency, from, to, format, out); err != nil {
+		if !out.started {
+			respondError(c, errorStatus(err), err, "")
+			return
+		}
+		// the status line is gone, all that's left is cutting the body short
+		_ = c.Error(err)
+		c.Abort()
+	}
+}
+
+func parseStatementTime(raw string, endOfDay bool) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, true
+	}
+	t, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, true
+}
+
+// statementOutput sets the download headers on the first write, so a statement
+// failing before any output can still be answered with a JSON error.
+type statementOutput struct {
+	c           *gin.Context
+	contentType string
+	filename    string
+	started     bool
+}
+
+func (o *statementOutput) Write(p []byte) (int, error) {
+	if !o.started {
+		o.started = true
+		header := o.c.Writer.Header()
+		header.Set("Content-Type", o.contentType)
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", o.filename))
+		o.c.Status(http.StatusOK)
+	}
+	return o.c.Writer.Write(p)
+}
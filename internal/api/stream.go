@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/stream"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type StreamHandler struct {
+	hub       *stream.Hub
+	heartbeat time.Duration
+}
+
+func NewStreamHandler(hub *stream.Hub, heartbeat time.Duration) *StreamHandler {
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	return &StreamHandler{hub: hub, heartbeat: heartbeat}
+}
+
+// Balances streams the user's balance updates as Server-Sent Events. Clients
+// resume with the Last-Event-ID header (or last_event_id query parameter); a
+// "reset" event means updates were missed and balances should be refetched.
+func (h *StreamHandler) Balances(c *gin.Context) {
+	const op = "api.StreamBalances"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+	currency := c.Query("currency")
+
+	rawLastID := c.GetHeader("Last-Event-ID")
+	if rawLastID == "" {
+		rawLastID = c.Query("last_event_id")
+	}
+	var (
+		epoch  string
+		lastID uint64
+	)
+	resume := rawLastID != ""
+	if resume {
+		if epoch, lastID, err = stream.ParseEventID(rawLastID); err != nil {
+			respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "Last-Event-ID", rawLastID), "invalid Last-Event-ID")
+			return
+		}
+	}
+
+	sub, backlog, complete := h.hub.Subscribe(userID, epoch, lastID, resume)
+	epoch = h.hub.Epoch()
+	defer sub.Close()
+
+	w := c.Writer
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// stop reverse proxies from buffering the stream
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if complete {
+		for _, e := range backlog {
+			if currency == "" || e.Update.Currency == currency {
+				writeSSE(w, stream.FormatEventID(epoch, e.ID), "balance", e.Update)
+			}
+		}
+	} else {
+		writeSSE(w, stream.FormatEventID(epoch, sub.Head), "reset", gin.H{"reason": "updates since Last-Event-ID are no longer available"})
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			w.Flush()
+		case e, ok := <-sub.C:
+			if !ok {
+				// dropped for falling behind, the client reconnects and resumes
+				return
+			}
+			if currency != "" && e.Update.Currency != currency {
+				continue
+			}
+			writeSSE(w, stream.FormatEventID(epoch, e.ID), "balance", e.Update)
+			w.Flush()
+		}
+	}
+}
+
+func writeSSE(w gin.ResponseWriter, id string, event string, data any) {
+	b, _ := json.Marshal(data)
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, b)
+}
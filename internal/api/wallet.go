@@ -1,7 +1,7 @@
 package api
 
 import (
-	"fmt"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
 	"github.com/Jiang-hao/walletApiService/internal/model"
 	"github.com/Jiang-hao/walletApiService/internal/service"
 	"github.com/gin-gonic/gin"
@@ -20,26 +20,28 @@ func NewWalletHandler(walletService service.WalletService) *WalletHandler {
 }
 
 func (h *WalletHandler) Deposit(c *gin.Context) {
+	const op = "api.Deposit"
+
 	userID, err := uuid.Parse(c.Query("user_id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
 		return
 	}
 
 	var req model.DepositRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), err.Error())
 		return
 	}
 
 	if req.Amount.LessThanOrEqual(decimal.Zero) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "amount", req.Amount), "amount must be positive")
 		return
 	}
 
 	wallet, err := h.walletService.Deposit(c.Request.Context(), userID, req.Amount, req.Currency, req.Reference)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, errorStatus(err), err, "")
 		return
 	}
 
@@ -47,26 +49,28 @@ func (h *WalletHandler) Deposit(c *gin.Context) {
 }
 
 func (h *WalletHandler) Withdraw(c *gin.Context) {
+	const op = "api.Withdraw"
+
 	userID, err := uuid.Parse(c.Query("user_id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
 		return
 	}
 
 	var req model.WithdrawalRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), err.Error())
 		return
 	}
 
 	if req.Amount.LessThanOrEqual(decimal.Zero) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "amount", req.Amount), "amount must be positive")
 		return
 	}
 
 	wallet, err := h.walletService.Withdraw(c.Request.Context(), userID, req.Amount, req.Currency, req.Reference)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, errorStatus(err), err, "")
 		return
 	}
 
@@ -74,26 +78,28 @@ func (h *WalletHandler) Withdraw(c *gin.Context) {
 }
 
 func (h *WalletHandler) Transfer(c *gin.Context) {
+	const op = "api.Transfer"
+
 	fromUserID, err := uuid.Parse(c.Query("user_id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
 		return
 	}
 
 	var req model.TransferRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), err.Error())
 		return
 	}
 
 	if req.Amount.LessThanOrEqual(decimal.Zero) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "amount", req.Amount), "amount must be positive")
 		return
 	}
 
 	wallet, err := h.walletService.Transfer(c.Request.Context(), fromUserID, req.ToUserId, req.Amount, req.Currency, req.Reference)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, errorStatus(err), err, "")
 		return
 	}
 
@@ -101,10 +107,11 @@ func (h *WalletHandler) Transfer(c *gin.Context) {
 }
 
 func (h *WalletHandler) GetBalance(c *gin.Context) {
+	const op = "api.GetBalance"
+
 	userID, err := uuid.Parse(c.Query("user_id"))
 	if err != nil {
-		fmt.Errorf("user id null: %w", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
 		return
 	}
 
@@ -115,7 +122,7 @@ func (h *WalletHandler) GetBalance(c *gin.Context) {
 
 	balance, err := h.walletService.GetBalance(c.Request.Context(), userID, currency)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, errorStatus(err), err, "")
 		return
 	}
 
@@ -126,9 +133,11 @@ func (h *WalletHandler) GetBalance(c *gin.Context) {
 }
 
 func (h *WalletHandler) GetTransactionHistory(c *gin.Context) {
+	const op = "api.GetTransactionHistory"
+
 	userID, err := uuid.Parse(c.Query("user_id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
 		return
 	}
 
@@ -136,23 +145,23 @@ func (h *WalletHandler) GetTransactionHistory(c *gin.Context) {
 
 	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
 	if err != nil || page < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page number"})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page", c.Query("page")), "invalid page number")
 		return
 	}
 
 	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "10"))
 	if err != nil || pageSize < 1 || pageSize > 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page size"})
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page_size", c.Query("page_size")), "invalid page size")
 		return
 	}
 
 	transactions, err := h.walletService.GetTransactionHistory(c.Request.Context(), userID, currency, page, pageSize)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, errorStatus(err), err, "")
 		return
 	}
 
-	var response []model.TransactionResponse
+	response := make([]model.TransactionResponse, 0, len(transactions))
 	for _, tx := range transactions {
 		response = append(response, model.TransactionResponse{
 			ID:            tx.ID,
@@ -163,8 +172,37 @@ func (h *WalletHandler) GetTransactionHistory(c *gin.Context) {
 			Type:          tx.Type,
 			Reference:     tx.Reference,
 			CreatedAt:     tx.CreatedAt,
+			SettledAt:     tx.SettledAt,
 		})
 	}
 
 	c.JSON(http.StatusOK, response)
 }
+
+// respondError attaches err to the request so the access log records it with
+// its Type and Op chain, then writes the error body. An empty message exposes err.Error().
+func respondError(c *gin.Context, status int, err error, message string) {
+	_ = c.Error(err)
+	if message == "" {
+		message = err.Error()
+	}
+	c.JSON(status, gin.H{"error": message})
+}
+
+// errorStatus maps the business error type in err's chain to an HTTP status
+func errorStatus(err error) int {
+	switch errors.TypeOf(err) {
+	case errors.InvalidRequest:
+		return http.StatusBadRequest
+	case errors.NotFound:
+		return http.StatusNotFound
+	case errors.InsufficientFund:
+		return http.StatusUnprocessableEntity
+	case errors.WalletFrozen, errors.Forbidden:
+		return http.StatusForbidden
+	case errors.Conflict:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
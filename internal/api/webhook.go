@@ -0,0 +1,202 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type WebhookHandler struct {
+	webhookService service.WebhookService
+}
+
+func NewWebhookHandler(webhookService service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService}
+}
+
+func (h *WebhookHandler) CreateSubscription(c *gin.Context) {
+	const op = "api.CreateWebhookSubscription"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	var req model.CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), err.Error())
+		return
+	}
+
+	sub, err := h.webhookService.CreateSubscription(c.Request.Context(), userID, req.URL, req.EventTypes, req.Secret)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	// the secret is only ever returned on creation
+	resp := subscriptionResponse(sub)
+	resp.Secret = sub.Secret
+	c.JSON(http.StatusCreated, resp)
+}
+
+func (h *WebhookHandler) ListSubscriptions(c *gin.Context) {
+	const op = "api.ListWebhookSubscriptions"
+
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return
+	}
+
+	subs, err := h.webhookService.ListSubscriptions(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	response := make([]model.WebhookSubscriptionResponse, 0, len(subs))
+	for i := range subs {
+		response = append(response, subscriptionResponse(&subs[i]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *WebhookHandler) DeleteSubscription(c *gin.Context) {
+	const op = "api.DeleteWebhookSubscription"
+
+	userID, subID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	if err := h.webhookService.DeleteSubscription(c.Request.Context(), userID, subID); err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	const op = "api.ListWebhookDeliveries"
+
+	userID, subID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page", c.Query("page")), "invalid page number")
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidInput(op, "page_size", c.Query("page_size")), "invalid page size")
+		return
+	}
+
+	deliveries, err := h.webhookService.ListDeliveries(c.Request.Context(), userID, subID, page, pageSize)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	response := make([]model.WebhookDeliveryResponse, 0, len(deliveries))
+	for _, d := range deliveries {
+		response = append(response, model.WebhookDeliveryResponse{
+			ID:             d.ID,
+			SubscriptionID: d.SubscriptionID,
+			EventID:        d.EventID,
+			EventType:      d.EventType,
+			Payload:        rawJSON(&d.Payload),
+			Status:         d.Status,
+			Attempts:       d.Attempts,
+			NextAttemptAt:  d.NextAttemptAt,
+			LastStatusCode: d.LastStatusCode,
+			LastError:      d.LastError,
+			CreatedAt:      d.CreatedAt,
+			DeliveredAt:    d.DeliveredAt,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *WebhookHandler) ListAttempts(c *gin.Context) {
+	const op = "api.ListWebhookAttempts"
+
+	userID, deliveryID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	attempts, err := h.webhookService.ListAttempts(c.Request.Context(), userID, deliveryID)
+	if err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+
+	response := make([]model.WebhookAttemptResponse, 0, len(attempts))
+	for _, a := range attempts {
+		response = append(response, model.WebhookAttemptResponse{
+			Attempt:     a.Attempt,
+			StatusCode:  a.StatusCode,
+			Error:       a.Error,
+			DurationMs:  a.DurationMs,
+			AttemptedAt: a.AttemptedAt,
+		})
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	const op = "api.RedeliverWebhook"
+
+	userID, deliveryID, ok := parseOwnerAndID(c, op)
+	if !ok {
+		return
+	}
+
+	if err := h.webhookService.Redeliver(c.Request.Context(), userID, deliveryID); err != nil {
+		respondError(c, errorStatus(err), err, "")
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"status": model.WebhookPending})
+}
+
+// parseOwnerAndID reads the user_id query parameter and the :id path parameter
+func parseOwnerAndID(c *gin.Context, op string) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid user ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errors.NewInvalidRequest(op, err), "invalid ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, id, true
+}
+
+func subscriptionResponse(sub *model.WebhookSubscription) model.WebhookSubscriptionResponse {
+	eventTypes := []string(sub.EventTypes)
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	return model.WebhookSubscriptionResponse{
+		ID:         sub.ID,
+		UserID:     sub.UserID,
+		URL:        sub.URL,
+		EventTypes: eventTypes,
+		Active:     sub.Active,
+		CreatedAt:  sub.CreatedAt,
+	}
+}
@@ -0,0 +1,261 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/google/uuid"
+)
+
+// Actions
+const (
+	ActionDeposit    = "wallet.deposit"
+	ActionWithdrawal = "wallet.withdrawal"
+	ActionTransfer   = "wallet.transfer"
+	ActionFreeze     = "wallet.freeze"
+	ActionUnfreeze   = "wallet.unfreeze"
+	ActionAdjustment = "wallet.adjustment"
+
+	ActionEscrowHold    = "escrow.hold"
+	ActionEscrowRelease = "escrow.release"
+	ActionEscrowRefund  = "escrow.refund"
+
+	ActionAdjustmentRequest = "adjustment.request"
+	ActionAdjustmentApprove = "adjustment.approve"
+	ActionAdjustmentReject  = "adjustment.reject"
+)
+
+// Target types
+const (
+	TargetWallet     = "wallet"
+	TargetAdjustment = "adjustment"
+)
+
+// GenesisHash is the prev_hash of the first event in a chain
+var GenesisHash = strings.Repeat("0", 64)
+
+// Metadata describes who triggered a request and from where
+type Metadata struct {
+	Actor     string
+	RequestID string
+	ClientIP  string
+	UserAgent string
+}
+
+type ctxKey struct{}
+
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, ctxKey{}, md)
+}
+
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(ctxKey{}).(Metadata)
+	return md
+}
+
+// UserActor is the actor recorded when a user acts on their own wallets
+func UserActor(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+// OperatorActor is the actor recorded for changes made by support and
+// back-office staff
+func OperatorActor(operator string) string {
+	return "operator:" + operator
+}
+
+// SystemActor is the actor recorded for changes made by background jobs
+func SystemActor(job string) string {
+	return "system:" + job
+}
+
+// WalletSnapshot is the before/after state recorded for wallet changes
+type WalletSnapshot struct {
+	WalletID uuid.UUID `json:"wallet_id"`
+	Balance  string    `json:"balance"`
+	Version  int       `json:"version"`
+	Frozen   bool      `json:"frozen,omitempty"`
+}
+
+func SnapshotWallet(w *model.Wallet) WalletSnapshot {
+	return WalletSnapshot{WalletID: w.ID, Balance: w.Balance.String(), Version: w.Version, Frozen: w.Frozen()}
+}
+
+// AdjustmentSnapshot is the state recorded for adjustment changes
+type AdjustmentSnapshot struct {
+	AdjustmentID  uuid.UUID  `json:"adjustment_id"`
+	WalletID      uuid.UUID  `json:"wallet_id"`
+	Amount        string     `json:"amount"`
+	ReasonCode    string     `json:"reason_code"`
+	Note          string     `json:"note,omitempty"`
+	Status        string     `json:"status"`
+	RequestedBy   string     `json:"requested_by"`
+	ReviewedBy    string     `json:"reviewed_by,omitempty"`
+	TransactionID *uuid.UUID `json:"transaction_id,omitempty"`
+}
+
+func SnapshotAdjustment(a *model.Adjustment) AdjustmentSnapshot {
+	s := AdjustmentSnapshot{
+		AdjustmentID:  a.ID,
+		WalletID:      a.WalletID,
+		Amount:        a.Amount.String(),
+		ReasonCode:    a.ReasonCode,
+		Note:          a.Note,
+		Status:        a.Status,
+		RequestedBy:   a.RequestedBy,
+		TransactionID: a.TransactionID,
+	}
+	if a.ReviewedBy != nil {
+		s.ReviewedBy = *a.ReviewedBy
+	}
+	return s
+}
+
+// NewEvent builds an event from the request metadata in ctx. defaultActor is
+// used when the request did not carry an explicit actor.
+func NewEvent(ctx context.Context, defaultActor, action, targetType, targetID string, before, after any) (*model.AuditEvent, error) {
+	const op = "audit.NewEvent"
+
+	md := MetadataFromContext(ctx)
+	actor := md.Actor
+	if actor == "" {
+		actor = defaultActor
+	}
+
+	beforeJSON, err := marshalState(before)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	afterJSON, err := marshalState(after)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+
+	return &model.AuditEvent{
+		EventID:    uuid.New(),
+		Actor:      actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		RequestID:  md.RequestID,
+		Before:     beforeJSON,
+		After:      afterJSON,
+		ClientIP:   md.ClientIP,
+		UserAgent:  md.UserAgent,
+		Chain:      Chain(targetType, targetID),
+		// postgres keeps microseconds, truncate so the hash can be recomputed from the stored row
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}, nil
+}
+
+func marshalState(v any) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+// Chain names the hash chain of a target's events. Every target has a chain
+// of its own, so appends only wait for appends to the same target, which
+// the target's own row lock mostly serializes already.
+func Chain(targetType, targetID string) string {
+	return targetType + ":" + targetID
+}
+
+// ComputeHash chains e to the previous event's hash
+func ComputeHash(prevHash string, e *model.AuditEvent) string {
+	h := sha256.New()
+	for _, field := range []string{
+		prevHash,
+		e.EventID.String(),
+		e.Actor,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		e.RequestID,
+		derefOrEmpty(e.Before),
+		derefOrEmpty(e.After),
+		e.ClientIP,
+		e.UserAgent,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	} {
+		// length prefix so field boundaries can't be shifted
+		h.Write([]byte(strconv.Itoa(len(field))))
+		h.Write([]byte{':'})
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func derefOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Verifier checks the chains of events fed to it in id order
+type Verifier struct {
+	// heads holds the hash of the last event checked in each chain
+	heads   map[string]string
+	checked int
+	result  *model.AuditVerification
+}
+
+func NewVerifier() *Verifier {
+	return &Verifier{heads: make(map[string]string)}
+}
+
+// Check verifies the next event and reports whether the chain is still intact
+func (v *Verifier) Check(e *model.AuditEvent) bool {
+	if v.result != nil {
+		return false
+	}
+	v.checked++
+
+	prevHash, ok := v.heads[e.Chain]
+	if !ok {
+		prevHash = GenesisHash
+	}
+
+	var reason string
+	switch {
+	case e.Chain != "" && e.Chain != Chain(e.TargetType, e.TargetID):
+		reason = "chain does not match the event's target"
+	case e.PrevHash != prevHash:
+		reason = "prev_hash does not match the previous event"
+	case ComputeHash(e.PrevHash, e) != e.Hash:
+		reason = "hash does not match event contents"
+	}
+	if reason != "" {
+		id := e.ID
+		v.result = &model.AuditVerification{
+			Valid:          false,
+			EventsChecked:  v.checked,
+			FirstInvalidID: &id,
+			Reason:         reason,
+		}
+		return false
+	}
+	v.heads[e.Chain] = e.Hash
+	return true
+}
+
+func (v *Verifier) Result() *model.AuditVerification {
+	if v.result != nil {
+		return v.result
+	}
+	return &model.AuditVerification{Valid: true, EventsChecked: v.checked}
+}
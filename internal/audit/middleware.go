@@ -0,0 +1,30 @@
+package audit
+
+import (
+	"github.com/Jiang-hao/walletApiService/internal/auth"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// ActorHeader is set by the gateway or back-office tooling to name the
+// operator acting on a user's behalf. It is only believed from callers that
+// authenticated with auth.Middleware, without it the acting user is recorded.
+const ActorHeader = "X-Actor-ID"
+
+// Middleware captures the actor, request id, client IP and user agent for audit events
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var actor string
+		if auth.CallerFromContext(c.Request.Context()) != "" {
+			actor = c.GetHeader(ActorHeader)
+		}
+		ctx := WithMetadata(c.Request.Context(), Metadata{
+			Actor:     actor,
+			RequestID: logging.RequestIDFromContext(c.Request.Context()),
+			ClientIP:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
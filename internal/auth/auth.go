@@ -0,0 +1,108 @@
+// Package auth authenticates the services and back-office tools calling the
+// API with bearer tokens. End users aren't authenticated here, the gateway
+// in front of the API does that.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/gin-gonic/gin"
+)
+
+type callerKey struct{}
+
+// ParseTokens reads a list of callers and their tokens, e.g.
+// "ledger=s3cret,payouts=0ther", into a map from token to caller name
+func ParseTokens(s string) (map[string]string, error) {
+	const op = "auth.ParseTokens"
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, "=")
+		if !ok || name == "" || token == "" {
+			return nil, errors.NewInvalidInput(op, "token", "entries must be name=token")
+		}
+		if _, dup := tokens[token]; dup {
+			return nil, errors.NewInvalidInput(op, "token", "duplicate token for "+name)
+		}
+		tokens[token] = name
+	}
+	return tokens, nil
+}
+
+// Lookup returns the name of the caller holding token, "" when no one does
+func Lookup(tokens map[string]string, token string) string {
+	// compare against every token so the time taken doesn't tell which matched
+	var caller string
+	for known, name := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			caller = name
+		}
+	}
+	return caller
+}
+
+// WithCaller marks ctx as a request of the authenticated caller
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the authenticated caller of the request, "" when
+// it didn't authenticate
+func CallerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// Middleware authenticates requests carrying an "Authorization: Bearer"
+// header against tokens and names the caller in the request context and
+// logger. Requests without the header go on unauthenticated, a wrong token
+// is answered with 401.
+func Middleware(tokens map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			c.Next()
+			return
+		}
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		caller := ""
+		if ok {
+			caller = Lookup(tokens, token)
+		}
+		if caller == "" {
+			unauthorized(c, "invalid bearer token")
+			return
+		}
+
+		ctx := WithCaller(c.Request.Context(), caller)
+		ctx = logging.WithContext(ctx, logging.FromContext(ctx).With("caller", caller))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// Require answers 401 to requests that didn't authenticate, it guards the
+// operators' routes behind Middleware
+func Require() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if CallerFromContext(c.Request.Context()) == "" {
+			unauthorized(c, "missing bearer token")
+			return
+		}
+		c.Next()
+	}
+}
+
+func unauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", "Bearer")
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+}
@@ -1,6 +1,9 @@
 package errors
 
-import "fmt"
+import (
+	stderrors "errors"
+	"fmt"
+)
 
 type ErrorType string
 
@@ -9,6 +12,8 @@ const (
 	NotFound         ErrorType = "NOT_FOUND"
 	InsufficientFund ErrorType = "INSUFFICIENT_FUND"
 	Conflict         ErrorType = "CONFLICT"
+	WalletFrozen     ErrorType = "WALLET_FROZEN"
+	Forbidden        ErrorType = "FORBIDDEN"
 	Internal         ErrorType = "INTERNAL_ERROR"
 )
 
@@ -39,6 +44,15 @@ func NewInvalidInput(op, field string, value interface{}) *Error {
 	}
 }
 
+func NewInvalidRequest(op string, err error) *Error {
+	return &Error{
+		Type:    InvalidRequest,
+		Op:      op,
+		Message: "invalid request",
+		Err:     err,
+	}
+}
+
 func NewNotFound(op, resource string) *Error {
 	return &Error{
 		Type:    NotFound,
@@ -55,6 +69,24 @@ func NewInsufficientBalance(op string) *Error {
 	}
 }
 
+func NewWalletFrozen(op string) *Error {
+	return &Error{
+		Type:    WalletFrozen,
+		Op:      op,
+		Message: "wallet is frozen",
+	}
+}
+
+// NewForbidden is a caller acting on something it is party to, in a role that
+// may not, e.g. the seller releasing an escrow
+func NewForbidden(op, msg string) *Error {
+	return &Error{
+		Type:    Forbidden,
+		Op:      op,
+		Message: msg,
+	}
+}
+
 func NewCurrencyMismatch(op string) *Error {
 	return &Error{
 		Type:    InvalidRequest,
@@ -101,3 +133,50 @@ func IfInternalError(op string, err error) error {
 	}
 	return NewInternal(op, err)
 }
+
+// TypeOf 返回错误链中最具体的业务错误类型, 被 WrapInternal 包裹的业务错误也能识别
+func TypeOf(err error) ErrorType {
+	if err == nil {
+		return ""
+	}
+	for err != nil {
+		var e *Error
+		if !stderrors.As(err, &e) {
+			break
+		}
+		if e.Type != Internal {
+			return e.Type
+		}
+		err = e.Err
+	}
+	return Internal
+}
+
+// Ops 返回错误链上依次经过的 Op, 由外向内
+func Ops(err error) []string {
+	var ops []string
+	for err != nil {
+		var e *Error
+		if !stderrors.As(err, &e) {
+			break
+		}
+		ops = append(ops, e.Op)
+		err = e.Err
+	}
+	return ops
+}
+
+// MessageOf 返回 TypeOf 所指那层业务错误的 Message, 可以直接展示给调用方
+func MessageOf(err error) string {
+	for err != nil {
+		var e *Error
+		if !stderrors.As(err, &e) {
+			break
+		}
+		if e.Type != Internal {
+			return e.Message
+		}
+		err = e.Err
+	}
+	return "internal server error"
+}
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Event types
+const (
+	WalletCreated     = "WalletCreated"
+	FundsDeposited    = "FundsDeposited"
+	FundsWithdrawn    = "FundsWithdrawn"
+	TransferCompleted = "TransferCompleted"
+	TransferReceived  = "TransferReceived"
+)
+
+// Types lists every event type, for validating subscriptions
+var Types = []string{WalletCreated, FundsDeposited, FundsWithdrawn, TransferCompleted, TransferReceived}
+
+// AggregateWallet is the aggregate every wallet event is ordered by
+const AggregateWallet = "wallet"
+
+// Message is what publishers deliver. Consumers should dedupe on ID since
+// delivery is at-least-once; Sequence increases per aggregate.
+type Message struct {
+	ID            uuid.UUID       `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   uuid.UUID       `json:"aggregate_id"`
+	Sequence      int64           `json:"sequence"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+func MessageFromOutbox(e *model.OutboxEvent) Message {
+	return Message{
+		ID:            e.EventID,
+		Type:          e.EventType,
+		AggregateType: e.AggregateType,
+		AggregateID:   e.AggregateID,
+		Sequence:      e.ID,
+		OccurredAt:    e.CreatedAt,
+		Payload:       json.RawMessage(e.Payload),
+	}
+}
+
+// Publisher delivers messages to downstream consumers. Publish must only
+// return nil once the message is durably handed off.
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+type WalletCreatedPayload struct {
+	WalletID uuid.UUID `json:"wallet_id"`
+	UserID   uuid.UUID `json:"user_id"`
+	Currency string    `json:"currency"`
+}
+
+type FundsMovedPayload struct {
+	WalletID      uuid.UUID       `json:"wallet_id"`
+	UserID        uuid.UUID       `json:"user_id"`
+	TransactionID uuid.UUID       `json:"transaction_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency"`
+	BalanceAfter  decimal.Decimal `json:"balance_after"`
+	Reference     string          `json:"reference,omitempty"`
+}
+
+type TransferPayload struct {
+	TransactionID uuid.UUID       `json:"transaction_id"`
+	FromWalletID  uuid.UUID       `json:"from_wallet_id"`
+	FromUserID    uuid.UUID       `json:"from_user_id"`
+	ToWalletID    uuid.UUID       `json:"to_wallet_id"`
+	ToUserID      uuid.UUID       `json:"to_user_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency"`
+	Reference     string          `json:"reference,omitempty"`
+}
+
+// New builds an outbox row for an event on the given wallet
+func New(eventType string, walletID uuid.UUID, payload any) (*model.OutboxEvent, error) {
+	const op = "events.New"
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return &model.OutboxEvent{
+		EventID:       uuid.New(),
+		AggregateType: AggregateWallet,
+		AggregateID:   walletID,
+		EventType:     eventType,
+		Payload:       string(b),
+	}, nil
+}
+
+func NewWalletCreated(w *model.Wallet) (*model.OutboxEvent, error) {
+	return New(WalletCreated, w.ID, WalletCreatedPayload{
+		WalletID: w.ID,
+		UserID:   w.UserID,
+		Currency: w.Currency,
+	})
+}
+
+// NewFundsMoved builds a FundsDeposited or FundsWithdrawn event from its transaction record
+func NewFundsMoved(tx *model.Transaction) (*model.OutboxEvent, error) {
+	eventType := FundsDeposited
+	if tx.Amount.IsNegative() {
+		eventType = FundsWithdrawn
+	}
+	return New(eventType, tx.WalletID, FundsMovedPayload{
+		WalletID:      tx.WalletID,
+		UserID:        tx.UserID,
+		TransactionID: tx.ID,
+		Amount:        tx.Amount.Abs(),
+		Currency:      tx.Currency,
+		BalanceAfter:  tx.BalanceAfter,
+		Reference:     tx.Reference,
+	})
+}
+
+// NewTransferEvents returns TransferCompleted on the sender's wallet and
+// TransferReceived on the recipient's, so each wallet's stream is complete.
+func NewTransferEvents(txID uuid.UUID, from, to *model.Wallet, amount decimal.Decimal, reference string) ([]*model.OutboxEvent, error) {
+	payload := TransferPayload{
+		TransactionID: txID,
+		FromWalletID:  from.ID,
+		FromUserID:    from.UserID,
+		ToWalletID:    to.ID,
+		ToUserID:      to.UserID,
+		Amount:        amount,
+		Currency:      from.Currency,
+		Reference:     reference,
+	}
+	completed, err := New(TransferCompleted, from.ID, payload)
+	if err != nil {
+		return nil, err
+	}
+	received, err := New(TransferReceived, to.ID, payload)
+	if err != nil {
+		return nil, err
+	}
+	return []*model.OutboxEvent{completed, received}, nil
+}
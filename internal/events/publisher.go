@@ -0,0 +1,92 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+)
+
+// MemoryPublisher keeps published messages in memory, for tests and local runs
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []Message
+	err      error
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, msg Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+// SetError makes every Publish fail with err until it is reset with nil
+func (p *MemoryPublisher) SetError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+func (p *MemoryPublisher) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Message(nil), p.messages...)
+}
+
+// FilePublisher appends messages as JSON lines
+type FilePublisher struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	const op = "events.NewFilePublisher"
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return &FilePublisher{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (p *FilePublisher) Publish(ctx context.Context, msg Message) error {
+	const op = "events.FilePublisher.Publish"
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.enc.Encode(msg); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	return errors.IfInternalError(op, p.file.Sync())
+}
+
+func (p *FilePublisher) Close() error {
+	const op = "events.FilePublisher.Close"
+	return errors.IfInternalError(op, p.file.Close())
+}
+
+// Fanout publishes every message to each publisher in turn. If one fails the
+// message is retried for all of them, so each must tolerate duplicates.
+type Fanout []Publisher
+
+func (f Fanout) Publish(ctx context.Context, msg Message) error {
+	for _, p := range f {
+		if err := p.Publish(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+)
+
+type RelayConfig struct {
+	BatchSize    int
+	PollInterval time.Duration
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+func (c RelayConfig) withDefaults() RelayConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 5 * time.Minute
+	}
+	return c
+}
+
+// Relay moves events from the outbox to a Publisher. An event is marked
+// published only after Publish returns, so a crash in between redelivers it.
+type Relay struct {
+	repo      repository.OutboxRepository
+	publisher Publisher
+	cfg       RelayConfig
+}
+
+func NewRelay(repo repository.OutboxRepository, publisher Publisher, cfg RelayConfig) *Relay {
+	return &Relay{repo: repo, publisher: publisher, cfg: cfg.withDefaults()}
+}
+
+// Run polls the outbox until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	const op = "events.Relay.Run"
+
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		// drain full batches before waiting for the next tick
+		for {
+			n, err := r.ProcessBatch(ctx)
+			if err != nil {
+				logging.FromContext(ctx).Error("outbox relay failed", logging.KeyOp, op, logging.Err(err))
+				break
+			}
+			if n < r.cfg.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessBatch publishes one batch of due events and returns how many were claimed
+func (r *Relay) ProcessBatch(ctx context.Context) (int, error) {
+	const op = "events.Relay.ProcessBatch"
+
+	batch, err := r.repo.ClaimBatch(ctx, r.cfg.BatchSize)
+	if err != nil {
+		return 0, errors.WrapInternal(op, err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			batch.Rollback()
+		}
+	}()
+
+	events := batch.Events()
+	for i := range events {
+		e := &events[i]
+		if pubErr := r.publisher.Publish(ctx, MessageFromOutbox(e)); pubErr != nil {
+			next := time.Now().Add(r.backoff(e.Attempts + 1))
+			if err := batch.MarkFailed(ctx, e.ID, next, pubErr.Error()); err != nil {
+				return 0, errors.WrapInternal(op, err)
+			}
+			metrics.OutboxEvents.WithLabelValues(e.EventType, metrics.OutcomeError).Inc()
+			logging.FromContext(ctx).Warn("outbox publish failed",
+				logging.KeyOp, op,
+				"event_id", e.EventID,
+				"event_type", e.EventType,
+				"attempts", e.Attempts+1,
+				"next_attempt_at", next,
+				logging.Err(pubErr),
+			)
+			continue
+		}
+		if err := batch.MarkPublished(ctx, e.ID); err != nil {
+			return 0, errors.WrapInternal(op, err)
+		}
+		metrics.OutboxEvents.WithLabelValues(e.EventType, metrics.OutcomeSuccess).Inc()
+	}
+
+	if err := batch.Commit(); err != nil {
+		return 0, errors.WrapInternal(op, err)
+	}
+	committed = true
+	return len(events), nil
+}
+
+// backoff doubles per attempt up to MaxBackoff
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.cfg.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= r.cfg.MaxBackoff {
+			return r.cfg.MaxBackoff
+		}
+	}
+	return d
+}
@@ -0,0 +1,278 @@
+// Package loadtest drives a mix of wallet operations against a Target from
+// many workers at once and reports throughput, latency percentiles and errors
+// by type. Every user's balance is tracked from the operations that
+// succeeded, so the run ends by checking the target's balances against it.
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Op is an operation the load test performs
+type Op string
+
+const (
+	OpDeposit  Op = "deposit"
+	OpWithdraw Op = "withdraw"
+	OpTransfer Op = "transfer"
+	OpRead     Op = "read"
+)
+
+var Ops = []Op{OpDeposit, OpWithdraw, OpTransfer, OpRead}
+
+// Target is what the load is applied to, the service itself or a server
+type Target interface {
+	Deposit(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency string) error
+	Withdraw(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency string) error
+	Transfer(ctx context.Context, fromUserID, toUserID uuid.UUID, amount decimal.Decimal, currency string) error
+	GetBalance(ctx context.Context, userID uuid.UUID, currency string) (decimal.Decimal, error)
+}
+
+// Transport is the error type of a request whose outcome is unknown, the
+// target may or may not have applied it
+const Transport errors.ErrorType = "TRANSPORT"
+
+type Config struct {
+	// Users take part in the run, each funded with InitialBalance first
+	Users          []uuid.UUID
+	Currency       string
+	InitialBalance decimal.Decimal
+	// MaxAmount bounds the amount of each operation, drawn in whole units from 1
+	MaxAmount int64
+	// Mix weighs the operations, e.g. 70 transfers to 30 reads
+	Mix map[Op]int
+	// Skew concentrates the load on a few hot users. 0 spreads it evenly,
+	// above 1 users are picked from a Zipf distribution with that exponent.
+	Skew float64
+
+	Workers int
+	// the run stops after Duration or Requests, whichever comes first,
+	// a zero value doesn't limit
+	Duration time.Duration
+	Requests int
+	Seed     uint64
+}
+
+func (c *Config) validate() error {
+	const op = "loadtest.Config.validate"
+	if len(c.Users) < 2 {
+		return errors.NewInvalidInput(op, "users", len(c.Users))
+	}
+	if c.Workers < 1 {
+		return errors.NewInvalidInput(op, "workers", c.Workers)
+	}
+	if c.MaxAmount < 1 {
+		return errors.NewInvalidInput(op, "max amount", c.MaxAmount)
+	}
+	if c.Skew != 0 && c.Skew <= 1 {
+		return errors.NewInvalidInput(op, "skew", c.Skew)
+	}
+	if c.Duration <= 0 && c.Requests <= 0 {
+		return errors.NewInvalidInput(op, "duration or requests", "neither set")
+	}
+	total := 0
+	for o, w := range c.Mix {
+		if w < 0 {
+			return errors.NewInvalidInput(op, "mix weight of "+string(o), w)
+		}
+		total += w
+	}
+	if total == 0 {
+		return errors.NewInvalidInput(op, "mix", "no operation weighted")
+	}
+	return nil
+}
+
+// Run funds the users, applies the load and verifies the balances
+func Run(ctx context.Context, target Target, cfg Config) (*Report, error) {
+	const op = "loadtest.Run"
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	expected := make(map[uuid.UUID]decimal.Decimal, len(cfg.Users))
+	for _, user := range cfg.Users {
+		if cfg.InitialBalance.IsPositive() {
+			if err := target.Deposit(ctx, user, cfg.InitialBalance, cfg.Currency); err != nil {
+				return nil, errors.WrapInternal(op, fmt.Errorf("funding user %s: %w", user, err))
+			}
+		}
+		expected[user] = cfg.InitialBalance
+	}
+
+	// the deadline only stops workers taking new requests, one in flight
+	// completes so its outcome is known
+	stop := ctx
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		stop, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	// next hands out the request budget, when there is one
+	var (
+		mu     sync.Mutex
+		budget = cfg.Requests
+		next   = func() bool {
+			if cfg.Requests <= 0 {
+				return true
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			budget--
+			return budget >= 0
+		}
+	)
+
+	workers := make([]*worker, cfg.Workers)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range workers {
+		workers[i] = newWorker(&cfg, cfg.Seed, uint64(i))
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			for stop.Err() == nil && next() {
+				w.step(ctx, target)
+			}
+		}(workers[i])
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := newReport(elapsed)
+	for _, w := range workers {
+		report.merge(w.stats)
+		for user, delta := range w.deltas {
+			expected[user] = expected[user].Add(delta)
+		}
+	}
+	report.finish()
+	report.Verification = verify(ctx, target, cfg, expected, report.Errors[Transport])
+	return report, nil
+}
+
+type worker struct {
+	cfg    *Config
+	rnd    *rand.Rand
+	zipf   *rand.Zipf
+	ops    []Op
+	stats  map[Op]*opStats
+	deltas map[uuid.UUID]decimal.Decimal
+}
+
+func newWorker(cfg *Config, seed, index uint64) *worker {
+	w := &worker{
+		cfg:    cfg,
+		rnd:    rand.New(rand.NewPCG(seed, index)),
+		stats:  make(map[Op]*opStats),
+		deltas: make(map[uuid.UUID]decimal.Decimal),
+	}
+	// expand the mix so drawing an operation is a single index
+	for _, o := range Ops {
+		for i := 0; i < cfg.Mix[o]; i++ {
+			w.ops = append(w.ops, o)
+		}
+	}
+	if cfg.Skew > 1 {
+		w.zipf = rand.NewZipf(w.rnd, cfg.Skew, 1, uint64(len(cfg.Users)-1))
+	}
+	return w
+}
+
+func (w *worker) user() uuid.UUID {
+	if w.zipf != nil {
+		return w.cfg.Users[w.zipf.Uint64()]
+	}
+	return w.cfg.Users[w.rnd.IntN(len(w.cfg.Users))]
+}
+
+func (w *worker) step(ctx context.Context, target Target) {
+	o := w.ops[w.rnd.IntN(len(w.ops))]
+	user := w.user()
+	amount := decimal.NewFromInt(1 + w.rnd.Int64N(w.cfg.MaxAmount))
+	currency := w.cfg.Currency
+
+	var (
+		to  uuid.UUID
+		err error
+	)
+	began := time.Now()
+	switch o {
+	case OpDeposit:
+		err = target.Deposit(ctx, user, amount, currency)
+	case OpWithdraw:
+		err = target.Withdraw(ctx, user, amount, currency)
+	case OpTransfer:
+		for to = w.user(); to == user; to = w.user() {
+		}
+		err = target.Transfer(ctx, user, to, amount, currency)
+	case OpRead:
+		_, err = target.GetBalance(ctx, user, currency)
+	}
+	w.stat(o).record(time.Since(began), errorType(err))
+	if err != nil {
+		return
+	}
+	switch o {
+	case OpDeposit:
+		w.deltas[user] = w.deltas[user].Add(amount)
+	case OpWithdraw:
+		w.deltas[user] = w.deltas[user].Sub(amount)
+	case OpTransfer:
+		w.deltas[user] = w.deltas[user].Sub(amount)
+		w.deltas[to] = w.deltas[to].Add(amount)
+	}
+}
+
+func (w *worker) stat(o Op) *opStats {
+	s, ok := w.stats[o]
+	if !ok {
+		s = &opStats{errors: make(map[errors.ErrorType]int)}
+		w.stats[o] = s
+	}
+	return s
+}
+
+// errorType classifies err, untyped errors count as internal
+func errorType(err error) errors.ErrorType {
+	if err == nil {
+		return ""
+	}
+	if t := errors.TypeOf(err); t != "" {
+		return t
+	}
+	return errors.Internal
+}
+
+// verify compares every user's balance on the target with the one the
+// successful operations add up to. Requests of unknown outcome make a
+// mismatch inconclusive rather than a failure.
+func verify(ctx context.Context, target Target, cfg Config, expected map[uuid.UUID]decimal.Decimal, unknown int) Verification {
+	v := Verification{Users: len(cfg.Users), UnknownOutcomes: unknown}
+	for _, user := range cfg.Users {
+		actual, err := target.GetBalance(ctx, user, cfg.Currency)
+		if err != nil {
+			v.Mismatches = append(v.Mismatches, Mismatch{UserID: user, Expected: expected[user], Error: err.Error()})
+			continue
+		}
+		v.Total = v.Total.Add(actual)
+		v.ExpectedTotal = v.ExpectedTotal.Add(expected[user])
+		if actual.IsNegative() {
+			v.Negative++
+		}
+		if !actual.Equal(expected[user]) {
+			v.Mismatches = append(v.Mismatches, Mismatch{UserID: user, Expected: expected[user], Actual: actual})
+		}
+	}
+	v.Consistent = len(v.Mismatches) == 0 && v.Negative == 0
+	return v
+}
@@ -0,0 +1,217 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// opStats is one worker's record of an operation
+type opStats struct {
+	latencies []time.Duration
+	errors    map[errors.ErrorType]int
+}
+
+func (s *opStats) record(latency time.Duration, errType errors.ErrorType) {
+	s.latencies = append(s.latencies, latency)
+	if errType != "" {
+		s.errors[errType]++
+	}
+}
+
+type Report struct {
+	Elapsed  time.Duration `json:"elapsed"`
+	Requests int           `json:"requests"`
+	Failed   int           `json:"failed"`
+	// Throughput counts every request per second, Goodput only those that succeeded
+	Throughput float64                  `json:"throughput"`
+	Goodput    float64                  `json:"goodput"`
+	Ops        map[Op]*OpReport         `json:"ops"`
+	Errors     map[errors.ErrorType]int `json:"errors"`
+
+	Verification Verification `json:"verification"`
+
+	latencies map[Op][]time.Duration
+}
+
+type OpReport struct {
+	Requests   int                      `json:"requests"`
+	Failed     int                      `json:"failed"`
+	Throughput float64                  `json:"throughput"`
+	Errors     map[errors.ErrorType]int `json:"errors,omitempty"`
+	P50        time.Duration            `json:"p50"`
+	P90        time.Duration            `json:"p90"`
+	P99        time.Duration            `json:"p99"`
+	Max        time.Duration            `json:"max"`
+}
+
+type Verification struct {
+	// Consistent is set when every balance is what the successful operations add up to
+	Consistent      bool            `json:"consistent"`
+	Users           int             `json:"users"`
+	Negative        int             `json:"negative"`
+	Total           decimal.Decimal `json:"total"`
+	ExpectedTotal   decimal.Decimal `json:"expected_total"`
+	Mismatches      []Mismatch      `json:"mismatches,omitempty"`
+	UnknownOutcomes int             `json:"unknown_outcomes"`
+}
+
+type Mismatch struct {
+	UserID   uuid.UUID       `json:"user_id"`
+	Expected decimal.Decimal `json:"expected"`
+	Actual   decimal.Decimal `json:"actual"`
+	Error    string          `json:"error,omitempty"`
+}
+
+func newReport(elapsed time.Duration) *Report {
+	return &Report{
+		Elapsed:   elapsed,
+		Ops:       make(map[Op]*OpReport),
+		Errors:    make(map[errors.ErrorType]int),
+		latencies: make(map[Op][]time.Duration),
+	}
+}
+
+func (r *Report) merge(stats map[Op]*opStats) {
+	for o, s := range stats {
+		op, ok := r.Ops[o]
+		if !ok {
+			op = &OpReport{Errors: make(map[errors.ErrorType]int)}
+			r.Ops[o] = op
+		}
+		op.Requests += len(s.latencies)
+		for t, n := range s.errors {
+			op.Errors[t] += n
+			op.Failed += n
+			r.Errors[t] += n
+		}
+		r.latencies[o] = append(r.latencies[o], s.latencies...)
+	}
+}
+
+// finish works out the totals and percentiles once every worker is merged
+func (r *Report) finish() {
+	seconds := r.Elapsed.Seconds()
+	for o, op := range r.Ops {
+		r.Requests += op.Requests
+		r.Failed += op.Failed
+		if seconds > 0 {
+			op.Throughput = float64(op.Requests) / seconds
+		}
+
+		latencies := r.latencies[o]
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		op.P50 = percentile(latencies, 0.50)
+		op.P90 = percentile(latencies, 0.90)
+		op.P99 = percentile(latencies, 0.99)
+		op.Max = percentile(latencies, 1)
+	}
+	if seconds > 0 {
+		r.Throughput = float64(r.Requests) / seconds
+		r.Goodput = float64(r.Requests-r.Failed) / seconds
+	}
+	r.latencies = nil
+}
+
+// percentile picks from sorted latencies by the nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+	return sorted[rank]
+}
+
+// WriteText writes the report as tables
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "%d requests in %s, %.1f/s, %.1f/s succeeded\n\n",
+		r.Requests, r.Elapsed.Round(time.Millisecond), r.Throughput, r.Goodput)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OP\tREQUESTS\tFAILED\tREQ/S\tP50\tP90\tP99\tMAX\t")
+	for _, o := range Ops {
+		op, ok := r.Ops[o]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t\n", o, op.Requests, op.Failed, op.Throughput,
+			round(op.P50), round(op.P90), round(op.P99), round(op.Max))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ERROR\tCOUNT\tBY OP")
+		for _, t := range sortedTypes(r.Errors) {
+			var byOp []string
+			for _, o := range Ops {
+				if n := r.Ops[o].errorCount(t); n > 0 {
+					byOp = append(byOp, fmt.Sprintf("%s=%d", o, n))
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", t, r.Errors[t], strings.Join(byOp, " "))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	v := r.Verification
+	fmt.Fprintln(w)
+	switch {
+	case v.Consistent:
+		fmt.Fprintf(w, "Balances consistent: %d users hold %s, as expected\n", v.Users, v.Total)
+	case v.UnknownOutcomes > 0:
+		fmt.Fprintf(w, "Balances inconclusive: %d mismatched, but %d requests have an unknown outcome\n",
+			len(v.Mismatches), v.UnknownOutcomes)
+	default:
+		fmt.Fprintf(w, "Balances INCONSISTENT: %d mismatched, %d negative, %d users hold %s, expected %s\n",
+			len(v.Mismatches), v.Negative, v.Users, v.Total, v.ExpectedTotal)
+	}
+	for _, m := range v.Mismatches {
+		if m.Error != "" {
+			fmt.Fprintf(w, "  %s: expected %s, %s\n", m.UserID, m.Expected, m.Error)
+		} else {
+			fmt.Fprintf(w, "  %s: expected %s, holds %s\n", m.UserID, m.Expected, m.Actual)
+		}
+	}
+	return nil
+}
+
+func (op *OpReport) errorCount(t errors.ErrorType) int {
+	if op == nil {
+		return 0
+	}
+	return op.Errors[t]
+}
+
+func sortedTypes(counts map[errors.ErrorType]int) []errors.ErrorType {
+	types := make([]errors.ErrorType, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
@@ -0,0 +1,98 @@
+package loadtest
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/package/client"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// ServiceTarget calls the wallet service in process, which leaves out HTTP
+// and measures the service and its storage alone
+type ServiceTarget struct {
+	svc service.WalletService
+}
+
+func NewServiceTarget(svc service.WalletService) *ServiceTarget {
+	return &ServiceTarget{svc: svc}
+}
+
+func (t *ServiceTarget) Deposit(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency string) error {
+	_, err := t.svc.Deposit(ctx, userID, amount, currency, "loadtest")
+	return err
+}
+
+func (t *ServiceTarget) Withdraw(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency string) error {
+	_, err := t.svc.Withdraw(ctx, userID, amount, currency, "loadtest")
+	return err
+}
+
+func (t *ServiceTarget) Transfer(ctx context.Context, fromUserID, toUserID uuid.UUID, amount decimal.Decimal, currency string) error {
+	_, err := t.svc.Transfer(ctx, fromUserID, toUserID, amount, currency, "loadtest")
+	return err
+}
+
+func (t *ServiceTarget) GetBalance(ctx context.Context, userID uuid.UUID, currency string) (decimal.Decimal, error) {
+	return t.svc.GetBalance(ctx, userID, currency)
+}
+
+// HTTPTarget calls a running server's wallet API through the client
+// package, without retries so every failure is counted
+type HTTPTarget struct {
+	client *client.Client
+}
+
+// NewHTTPTarget targets the server at baseURL, e.g. http://localhost:8080
+func NewHTTPTarget(baseURL string, httpClient *http.Client) *HTTPTarget {
+	return &HTTPTarget{client: client.New(baseURL, httpClient, client.Config{
+		Retry: client.RetryPolicy{MaxAttempts: 1},
+	})}
+}
+
+func (t *HTTPTarget) Deposit(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency string) error {
+	_, err := t.client.Deposit(ctx, userID, client.DepositRequest{
+		Amount: amount, Currency: currency, Reference: "loadtest",
+	})
+	return targetError(err)
+}
+
+func (t *HTTPTarget) Withdraw(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency string) error {
+	_, err := t.client.Withdraw(ctx, userID, client.WithdrawalRequest{
+		Amount: amount, Currency: currency, Reference: "loadtest",
+	})
+	return targetError(err)
+}
+
+func (t *HTTPTarget) Transfer(ctx context.Context, fromUserID, toUserID uuid.UUID, amount decimal.Decimal, currency string) error {
+	_, err := t.client.Transfer(ctx, fromUserID, client.TransferRequest{
+		ToUserId: toUserID, Amount: amount, Currency: currency, Reference: "loadtest",
+	})
+	return targetError(err)
+}
+
+func (t *HTTPTarget) GetBalance(ctx context.Context, userID uuid.UUID, currency string) (decimal.Decimal, error) {
+	balance, err := t.client.Balance(ctx, userID, currency)
+	if err != nil {
+		return decimal.Zero, targetError(err)
+	}
+	return balance.Balance, nil
+}
+
+// targetError keeps the type of an error the server answered with. Any other
+// failure leaves the outcome unknown and is a Transport error.
+func targetError(err error) error {
+	const op = "loadtest.HTTPTarget"
+	if err == nil {
+		return nil
+	}
+	var answered *client.Error
+	if stderrors.As(err, &answered) {
+		return &errors.Error{Type: answered.Type, Op: op, Message: answered.Error()}
+	}
+	return &errors.Error{Type: Transport, Op: op, Message: "request failed", Err: err}
+}
@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+)
+
+// Consistent field names used across layers
+const (
+	KeyRequestID = "request_id"
+	KeyOp        = "op"
+	KeyUser      = "user"
+	KeyWallet    = "wallet"
+	KeyTxID      = "tx_id"
+	KeyCurrency  = "currency"
+	KeyDuration  = "duration"
+	KeyError     = "error"
+)
+
+const redacted = "[REDACTED]"
+
+// Output formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+type Config struct {
+	Format string
+	Level  string
+	// Writer defaults to os.Stdout
+	Writer io.Writer
+}
+
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"secret":        true,
+	"token":         true,
+	"authorization": true,
+	"api_key":       true,
+	"dsn":           true,
+	"signature":     true,
+}
+
+var sensitivePattern = regexp.MustCompile(`(?i)\b(password|secret|token|api_key|sslpassword)=\S+`)
+
+// New builds a logger with the configured output format and level. Attributes
+// named after sensitive values are redacted regardless of where they are logged.
+func New(cfg Config) (*slog.Logger, error) {
+	const op = "logging.New"
+
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, errors.NewInvalidInput(op, "level", cfg.Level)
+		}
+	}
+
+	w := cfg.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+
+	switch strings.ToLower(cfg.Format) {
+	case "", FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, errors.NewInvalidInput(op, "format", cfg.Format)
+	}
+}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	if a.Value.Kind() == slog.KindString {
+		return slog.String(a.Key, Redact(a.Value.String()))
+	}
+	return a
+}
+
+// Redact masks key=value pairs of sensitive values inside free-form text such as error messages
+func Redact(s string) string {
+	return sensitivePattern.ReplaceAllString(s, "${1}="+redacted)
+}
+
+// Err renders an error with its internal/errors Type and Op chain
+func Err(err error) slog.Attr {
+	if err == nil {
+		return slog.Attr{}
+	}
+	return slog.Group(KeyError,
+		slog.String("message", err.Error()),
+		slog.String("type", string(errors.TypeOf(err))),
+		slog.Any("ops", errors.Ops(err)),
+	)
+}
+
+type ctxKey int
+
+const (
+	loggerKey ctxKey = iota
+	requestIDKey
+)
+
+// WithContext stores l in ctx so service and repository code logs with request fields
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the request logger, or the default logger outside a request
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// RequestID assigns every request a correlation id, honouring an incoming
+// X-Request-ID header, and puts a logger carrying it into the request context.
+func RequestID(base *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.NewString()
+		}
+		c.Header(RequestIDHeader, id)
+
+		ctx := WithRequestID(c.Request.Context(), id)
+		ctx = WithContext(ctx, base.With(KeyRequestID, id))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// AccessLog writes one line per request and logs every error attached to the
+// gin context with its Type and Op chain.
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		logger := FromContext(c.Request.Context())
+		status := c.Writer.Status()
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+
+		for _, e := range c.Errors {
+			logger.Error("request failed",
+				"method", c.Request.Method,
+				"route", route,
+				"status", status,
+				Err(e.Err),
+			)
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		logger.Log(c.Request.Context(), level, "request completed",
+			"method", c.Request.Method,
+			"route", route,
+			"status", status,
+			"client_ip", c.ClientIP(),
+			KeyDuration, time.Since(start),
+		)
+	}
+}
@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/shopspring/decimal"
+)
+
+const namespace = "walletapi"
+
+// Operation outcomes
+const (
+	OutcomeSuccess           = "success"
+	OutcomeInsufficientFunds = "insufficient_funds"
+	OutcomeConflict          = "conflict"
+	OutcomeError             = "error"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	HTTPRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "rate_limited_total",
+		Help:      "Number of HTTP requests answered with 429 by route class (read, write) and the key that ran out (user, ip).",
+	}, []string{"class", "key"})
+
+	GRPCRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "Number of gRPC requests by method and status code.",
+	}, []string{"method", "code"})
+
+	GRPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "gRPC request latency by method and status code, for streams until the stream ends.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	WalletOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "wallet",
+		Name:      "operations_total",
+		Help:      "Number of wallet operations by operation and outcome.",
+	}, []string{"operation", "outcome"})
+
+	WalletOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "wallet",
+		Name:      "operation_duration_seconds",
+		Help:      "Wallet operation latency by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	OptimisticLockRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "wallet",
+		Name:      "optimistic_lock_retries_total",
+		Help:      "Number of optimistic lock conflicts in UpdateBalanceWithRetry retried against a re-read wallet.",
+	}, []string{"type"})
+
+	AmountMoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "wallet",
+		Name:      "amount_moved_total",
+		Help:      "Total amount moved by successful operations, per operation and currency.",
+	}, []string{"operation", "currency"})
+
+	OutboxEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "outbox",
+		Name:      "publish_attempts_total",
+		Help:      "Number of outbox publish attempts by event type and outcome.",
+	}, []string{"event_type", "outcome"})
+
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "webhook",
+		Name:      "delivery_attempts_total",
+		Help:      "Number of webhook delivery attempts by outcome (succeeded, retry, dead).",
+	}, []string{"outcome"})
+
+	StreamSubscribers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "stream",
+		Name:      "subscribers",
+		Help:      "Number of connected balance stream clients.",
+	})
+
+	StreamDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "stream",
+		Name:      "dropped_clients_total",
+		Help:      "Number of stream clients disconnected for falling behind.",
+	})
+
+	Batches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "batch",
+		Name:      "processed_total",
+		Help:      "Number of processed batches by mode and final status.",
+	}, []string{"mode", "status"})
+
+	BatchItems = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "batch",
+		Name:      "items_total",
+		Help:      "Number of batch items by mode and outcome (succeeded, failed, rolled_back, interrupted).",
+	}, []string{"mode", "outcome"})
+
+	ScheduledTransfers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "schedule",
+		Name:      "runs_total",
+		Help:      "Number of scheduled transfer runs by outcome (succeeded, retrying, failed).",
+	}, []string{"outcome"})
+
+	PaymentRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "payment_request",
+		Name:      "transitions_total",
+		Help:      "Number of payment requests reaching each status (pending, accepted, declined, cancelled, expired).",
+	}, []string{"status"})
+
+	Escrows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "escrow",
+		Name:      "transitions_total",
+		Help:      "Number of escrows reaching each status (held, released, refunded, split).",
+	}, []string{"status"})
+
+	BalanceSnapshots = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "balance",
+		Name:      "snapshots_total",
+		Help:      "Number of end-of-day wallet balance snapshots written.",
+	})
+
+	Statements = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "statement",
+		Name:      "exported_total",
+		Help:      "Number of exported statements by format and balance check result (ok, mismatch).",
+	}, []string{"format", "check"})
+
+	SettlementLines = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "settlement",
+		Name:      "lines_total",
+		Help:      "Number of imported settlement lines by file format and status (matched, unmatched, mismatched, duplicate).",
+	}, []string{"format", "status"})
+
+	ReconciliationDiscrepancies = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "reconciliation",
+		Name:      "discrepancies",
+		Help:      "Discrepancies between wallets and the transaction log found by the last reconciliation, by kind.",
+	}, []string{"kind"})
+
+	ReconciliationLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "reconciliation",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time the last reconciliation finished.",
+	})
+)
+
+// Outcome classifies an operation error into an outcome label
+func Outcome(err error) string {
+	if err == nil {
+		return OutcomeSuccess
+	}
+	switch errors.TypeOf(err) {
+	case errors.InsufficientFund:
+		return OutcomeInsufficientFunds
+	case errors.Conflict:
+		return OutcomeConflict
+	default:
+		return OutcomeError
+	}
+}
+
+// ObserveOperation records the outcome, latency and, on success, the amount of a wallet operation
+func ObserveOperation(operation, currency string, amount decimal.Decimal, start time.Time, err error) {
+	WalletOperations.WithLabelValues(operation, Outcome(err)).Inc()
+	WalletOperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err == nil {
+		AmountMoved.WithLabelValues(operation, currency).Add(amount.Abs().InexactFloat64())
+	}
+}
+
+// RegisterDBStats exposes sqlx connection pool statistics (open, in-use, idle, wait count/duration)
+func RegisterDBStats(db *sqlx.DB) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(db.DB, "walletapi"))
+}
+
+// Middleware records request counts and latency per matched route and status
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		HTTPRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		HTTPDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the /metrics endpoint
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,149 @@
+// Package migrate applies the SQL migrations to a database and records each
+// one it applied in schema_migrations, so every run only applies new ones.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const suffix = ".up.sql"
+
+// Migration is one up migration, Version is its file name without the suffix
+type Migration struct {
+	Version   string     `db:"version" json:"version"`
+	AppliedAt *time.Time `db:"applied_at" json:"applied_at,omitempty"`
+}
+
+func (m Migration) Applied() bool {
+	return m.AppliedAt != nil
+}
+
+const createTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`
+
+// Status lists every migration in fsys, with when it was applied
+func Status(ctx context.Context, db *sqlx.DB, fsys fs.FS) ([]Migration, error) {
+	versions, err := versions(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, createTable); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	var applied []Migration
+	if err := db.SelectContext(ctx, &applied, `SELECT version, applied_at FROM schema_migrations`); err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	appliedAt := make(map[string]*time.Time, len(applied))
+	for _, m := range applied {
+		appliedAt[m.Version] = m.AppliedAt
+	}
+
+	migrations := make([]Migration, len(versions))
+	for i, version := range versions {
+		migrations[i] = Migration{Version: version, AppliedAt: appliedAt[version]}
+	}
+	return migrations, nil
+}
+
+// Up applies the migrations not applied yet, in order, and returns their
+// versions. Each runs in a transaction with its schema_migrations row, so a
+// failed one leaves nothing behind and stops the run.
+func Up(ctx context.Context, db *sqlx.DB, fsys fs.FS) ([]string, error) {
+	migrations, err := Status(ctx, db, fsys)
+	if err != nil {
+		return nil, err
+	}
+	var done []string
+	for _, m := range migrations {
+		if m.Applied() {
+			continue
+		}
+		sql, err := fs.ReadFile(fsys, m.Version+suffix)
+		if err != nil {
+			return done, err
+		}
+		if err := apply(ctx, db, m.Version, string(sql)); err != nil {
+			return done, fmt.Errorf("applying %s: %w", m.Version, err)
+		}
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+// Baseline records the migrations up to and including version as applied
+// without running them, for a database migrated by hand before
+// schema_migrations existed
+func Baseline(ctx context.Context, db *sqlx.DB, fsys fs.FS, version string) ([]string, error) {
+	migrations, err := Status(ctx, db, fsys)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, m := range migrations {
+		if m.Version == version {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no migration %s", version)
+	}
+
+	var done []string
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+		if m.Applied() {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, m.Version); err != nil {
+			return done, fmt.Errorf("recording %s: %w", m.Version, err)
+		}
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+func apply(ctx context.Context, db *sqlx.DB, version, sql string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// without arguments lib/pq uses the simple protocol, which runs every
+	// statement in the file
+	if _, err := tx.ExecContext(ctx, sql); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func versions(fsys fs.FS) ([]string, error) {
+	files, err := fs.Glob(fsys, "*"+suffix)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no migrations found")
+	}
+	sort.Strings(files)
+	versions := make([]string, len(files))
+	for i, file := range files {
+		versions[i] = strings.TrimSuffix(file, suffix)
+	}
+	return versions, nil
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// TxAdjustment is the ledger entry type of an operator's balance correction
+const TxAdjustment = "adjustment"
+
+// Adjustment statuses
+const (
+	AdjustmentPending  = "pending"
+	AdjustmentApplied  = "applied"
+	AdjustmentRejected = "rejected"
+)
+
+// Adjustment reason codes
+const (
+	// AdjustmentIncident corrects the effects of an outage or a bug
+	AdjustmentIncident = "incident"
+	// AdjustmentDuplicate reverses money booked twice
+	AdjustmentDuplicate  = "duplicate"
+	AdjustmentChargeback = "chargeback"
+	AdjustmentFeeRefund  = "fee_refund"
+	AdjustmentGoodwill   = "goodwill"
+)
+
+// AdjustmentReasonCodes lists every reason code, for validation
+var AdjustmentReasonCodes = []string{AdjustmentIncident, AdjustmentDuplicate, AdjustmentChargeback, AdjustmentFeeRefund, AdjustmentGoodwill}
+
+// Adjustment credits or debits a wallet on an operator's decision. One above
+// the approval threshold stays pending until a second operator approves it.
+type Adjustment struct {
+	ID       uuid.UUID `db:"id"`
+	WalletID uuid.UUID `db:"wallet_id"`
+	UserID   uuid.UUID `db:"user_id"`
+	Currency string    `db:"currency"`
+	// Amount is signed, negative debits the wallet
+	Amount      decimal.Decimal `db:"amount"`
+	ReasonCode  string          `db:"reason_code"`
+	Note        string          `db:"note"`
+	RequestedBy string          `db:"requested_by"`
+	// ReviewedBy is the operator who approved or rejected a pending adjustment
+	ReviewedBy *string `db:"reviewed_by"`
+	Status     string  `db:"status"`
+	// TransactionID is the ledger entry of an applied adjustment
+	TransactionID *uuid.UUID `db:"transaction_id"`
+	CreatedAt     time.Time  `db:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at"`
+	ResolvedAt    *time.Time `db:"resolved_at"`
+}
+
+// Reference is the reference of the adjustment's ledger entry
+func (a *Adjustment) Reference() string {
+	if a.Note == "" {
+		return a.ReasonCode
+	}
+	return a.ReasonCode + ": " + a.Note
+}
+
+type AdjustmentRequest struct {
+	UserID     uuid.UUID
+	Currency   string
+	Amount     decimal.Decimal
+	ReasonCode string
+	Note       string
+	// Operator is who asks for the adjustment
+	Operator string
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditEvent struct {
+	ID         int64     `db:"id"`
+	EventID    uuid.UUID `db:"event_id"`
+	Actor      string    `db:"actor"`
+	Action     string    `db:"action"`
+	TargetType string    `db:"target_type"`
+	TargetID   string    `db:"target_id"`
+	RequestID  string    `db:"request_id"`
+	Before     *string   `db:"before_state"`
+	After      *string   `db:"after_state"`
+	ClientIP   string    `db:"client_ip"`
+	UserAgent  string    `db:"user_agent"`
+	PrevHash   string    `db:"prev_hash"`
+	Hash       string    `db:"hash"`
+	// Chain is the hash chain the event is linked in, "" for the single
+	// chain of the events written before chains were per target
+	Chain     string    `db:"chain"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type AuditFilter struct {
+	Actor      string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+	Offset     int
+	Limit      int
+}
+
+type AuditEventResponse struct {
+	ID         int64           `json:"id"`
+	EventID    uuid.UUID       `json:"event_id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	ClientIP   string          `json:"client_ip,omitempty"`
+	UserAgent  string          `json:"user_agent,omitempty"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+	Chain      string          `json:"chain,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type AuditVerification struct {
+	Valid          bool   `json:"valid"`
+	EventsChecked  int    `json:"events_checked"`
+	FirstInvalidID *int64 `json:"first_invalid_id,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// DailyBalance is one point of a daily balance series
+type DailyBalance struct {
+	Date    time.Time       `db:"day"`
+	Balance decimal.Decimal `db:"balance"`
+}
+
+type HistoricalBalanceResponse struct {
+	Balance  decimal.Decimal `json:"balance"`
+	Currency string          `json:"currency"`
+	At       time.Time       `json:"at"`
+}
+
+type DailyBalanceResponse struct {
+	Date    string          `json:"date"`
+	Balance decimal.Decimal `json:"balance"`
+}
+
+type DailyBalancesResponse struct {
+	Currency string                 `json:"currency"`
+	Balances []DailyBalanceResponse `json:"balances"`
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Batch modes
+const (
+	// BatchAtomic applies every item in one DB transaction or none of them
+	BatchAtomic = "atomic"
+	// BatchBestEffort applies items one by one and reports each outcome
+	BatchBestEffort = "best_effort"
+)
+
+// Batch statuses
+const (
+	BatchPending    = "pending"
+	BatchProcessing = "processing"
+	BatchCompleted  = "completed"
+	BatchFailed     = "failed"
+)
+
+// Batch item types and outcomes
+const (
+	BatchItemDeposit  = "deposit"
+	BatchItemWithdraw = "withdraw"
+	BatchItemTransfer = "transfer"
+
+	BatchItemSucceeded  = "succeeded"
+	BatchItemFailed     = "failed"
+	BatchItemRolledBack = "rolled_back"
+	// BatchItemInterrupted is an item whose run was cut short by a restart, it
+	// may or may not have been applied
+	BatchItemInterrupted = "interrupted"
+)
+
+type Batch struct {
+	ID             uuid.UUID  `db:"id"`
+	UserID         uuid.UUID  `db:"user_id"`
+	IdempotencyKey string     `db:"idempotency_key"`
+	RequestHash    string     `db:"request_hash"`
+	Mode           string     `db:"mode"`
+	Status         string     `db:"status"`
+	Items          string     `db:"items"`
+	ItemCount      int        `db:"item_count"`
+	Results        *string    `db:"results"`
+	Succeeded      int        `db:"succeeded"`
+	Failed         int        `db:"failed"`
+	CreatedAt      time.Time  `db:"created_at"`
+	UpdatedAt      time.Time  `db:"updated_at"`
+	CompletedAt    *time.Time `db:"completed_at"`
+	LockedUntil    *time.Time `db:"locked_until"`
+	// Claims counts the times the batch was leased to a worker
+	Claims int `db:"claims"`
+	// InFlight is the index of the item being applied, Results holds the
+	// outcomes of the items before it
+	InFlight *int `db:"in_flight"`
+}
+
+type BatchItem struct {
+	Type      string          `json:"type" binding:"required,oneof=deposit withdraw transfer"`
+	UserID    uuid.UUID       `json:"user_id" binding:"required"`
+	ToUserID  *uuid.UUID      `json:"to_user_id,omitempty"`
+	Amount    decimal.Decimal `json:"amount"`
+	Currency  string          `json:"currency" binding:"required,len=3"`
+	Reference string          `json:"reference"`
+}
+
+type BatchItemResult struct {
+	Index         int              `json:"index"`
+	Status        string           `json:"status"`
+	TransactionID *uuid.UUID       `json:"transaction_id,omitempty"`
+	Balance       *decimal.Decimal `json:"balance,omitempty"`
+	Error         string           `json:"error,omitempty"`
+}
+
+type CreateBatchRequest struct {
+	Mode  string      `json:"mode" binding:"omitempty,oneof=atomic best_effort"`
+	Async bool        `json:"async"`
+	Items []BatchItem `json:"items" binding:"required,min=1,dive"`
+}
+
+type BatchResponse struct {
+	ID          uuid.UUID         `json:"id"`
+	Mode        string            `json:"mode"`
+	Status      string            `json:"status"`
+	ItemCount   int               `json:"item_count"`
+	Succeeded   int               `json:"succeeded"`
+	Failed      int               `json:"failed"`
+	Results     []BatchItemResult `json:"results,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// EscrowUserID owns every escrow wallet, seeded by the escrows migration
+var EscrowUserID = uuid.MustParse("00000000-0000-0000-0000-00000000e5c0")
+
+// Escrow statuses
+const (
+	EscrowHeld     = "held"
+	EscrowReleased = "released"
+	EscrowRefunded = "refunded"
+	EscrowSplit    = "split"
+)
+
+// What happens to held funds when an escrow's deadline passes
+const (
+	EscrowDeadlineRelease = "release"
+	EscrowDeadlineRefund  = "refund"
+)
+
+// Ledger entry types of escrow movements
+const (
+	TxEscrowHold    = "escrow_hold"
+	TxEscrowRelease = "escrow_release"
+	TxEscrowRefund  = "escrow_refund"
+)
+
+// Escrow holds the buyer's funds in a dedicated wallet until they are
+// released to the seller, refunded to the buyer or split between them.
+type Escrow struct {
+	ID             uuid.UUID       `db:"id"`
+	BuyerID        uuid.UUID       `db:"buyer_id"`
+	SellerID       uuid.UUID       `db:"seller_id"`
+	ArbiterID      *uuid.UUID      `db:"arbiter_id"`
+	WalletID       uuid.UUID       `db:"wallet_id"`
+	Amount         decimal.Decimal `db:"amount"`
+	Currency       string          `db:"currency"`
+	Reference      string          `db:"reference"`
+	Status         string          `db:"status"`
+	Deadline       *time.Time      `db:"deadline"`
+	DeadlineAction string          `db:"deadline_action"`
+	ReleasedAmount decimal.Decimal `db:"released_amount"`
+	RefundedAmount decimal.Decimal `db:"refunded_amount"`
+	ResolvedBy     *uuid.UUID      `db:"resolved_by"`
+	CreatedAt      time.Time       `db:"created_at"`
+	UpdatedAt      time.Time       `db:"updated_at"`
+	ResolvedAt     *time.Time      `db:"resolved_at"`
+}
+
+type CreateEscrowRequest struct {
+	SellerID  uuid.UUID       `json:"seller_id" binding:"required"`
+	ArbiterID *uuid.UUID      `json:"arbiter_id"`
+	Amount    decimal.Decimal `json:"amount"`
+	Currency  string          `json:"currency" binding:"required,len=3"`
+	Reference string          `json:"reference"`
+	Deadline  *time.Time      `json:"deadline"`
+	// DeadlineAction is release (default) or refund
+	DeadlineAction string `json:"deadline_action" binding:"omitempty,oneof=release refund"`
+}
+
+// SplitEscrowRequest names the seller's share, the rest goes back to the buyer
+type SplitEscrowRequest struct {
+	SellerAmount decimal.Decimal `json:"seller_amount"`
+}
+
+type EscrowResponse struct {
+	ID             uuid.UUID       `json:"id"`
+	BuyerID        uuid.UUID       `json:"buyer_id"`
+	SellerID       uuid.UUID       `json:"seller_id"`
+	ArbiterID      *uuid.UUID      `json:"arbiter_id,omitempty"`
+	WalletID       uuid.UUID       `json:"wallet_id"`
+	Amount         decimal.Decimal `json:"amount"`
+	Currency       string          `json:"currency"`
+	Reference      string          `json:"reference,omitempty"`
+	Status         string          `json:"status"`
+	Deadline       *time.Time      `json:"deadline,omitempty"`
+	DeadlineAction string          `json:"deadline_action"`
+	ReleasedAmount decimal.Decimal `json:"released_amount"`
+	RefundedAmount decimal.Decimal `json:"refunded_amount"`
+	ResolvedBy     *uuid.UUID      `json:"resolved_by,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	ResolvedAt     *time.Time      `json:"resolved_at,omitempty"`
+}
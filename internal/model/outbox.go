@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OutboxEvent struct {
+	ID            int64      `db:"id"`
+	EventID       uuid.UUID  `db:"event_id"`
+	AggregateType string     `db:"aggregate_type"`
+	AggregateID   uuid.UUID  `db:"aggregate_id"`
+	EventType     string     `db:"event_type"`
+	Payload       string     `db:"payload"`
+	Attempts      int        `db:"attempts"`
+	NextAttemptAt time.Time  `db:"next_attempt_at"`
+	LastError     *string    `db:"last_error"`
+	CreatedAt     time.Time  `db:"created_at"`
+	PublishedAt   *time.Time `db:"published_at"`
+}
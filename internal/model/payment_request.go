@@ -0,0 +1,60 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Payment request statuses
+const (
+	PaymentRequestPending   = "pending"
+	PaymentRequestAccepted  = "accepted"
+	PaymentRequestDeclined  = "declined"
+	PaymentRequestCancelled = "cancelled"
+	PaymentRequestExpired   = "expired"
+)
+
+// Payment request list directions, seen from the user listing them
+const (
+	PaymentRequestsIncoming = "incoming"
+	PaymentRequestsOutgoing = "outgoing"
+)
+
+// PaymentRequest asks the payer to transfer amount to the requester
+type PaymentRequest struct {
+	ID          uuid.UUID       `db:"id"`
+	RequesterID uuid.UUID       `db:"requester_id"`
+	PayerID     uuid.UUID       `db:"payer_id"`
+	Amount      decimal.Decimal `db:"amount"`
+	Currency    string          `db:"currency"`
+	Note        string          `db:"note"`
+	Status      string          `db:"status"`
+	ExpiresAt   time.Time       `db:"expires_at"`
+	CreatedAt   time.Time       `db:"created_at"`
+	UpdatedAt   time.Time       `db:"updated_at"`
+	ResolvedAt  *time.Time      `db:"resolved_at"`
+}
+
+type CreatePaymentRequest struct {
+	PayerID  uuid.UUID       `json:"payer_id" binding:"required"`
+	Amount   decimal.Decimal `json:"amount"`
+	Currency string          `json:"currency" binding:"required,len=3"`
+	Note     string          `json:"note" binding:"max=255"`
+	// ExpiresIn is a duration such as "72h", seven days when empty
+	ExpiresIn string `json:"expires_in"`
+}
+
+type PaymentRequestResponse struct {
+	ID          uuid.UUID       `json:"id"`
+	RequesterID uuid.UUID       `json:"requester_id"`
+	PayerID     uuid.UUID       `json:"payer_id"`
+	Amount      decimal.Decimal `json:"amount"`
+	Currency    string          `json:"currency"`
+	Note        string          `json:"note,omitempty"`
+	Status      string          `json:"status"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	ResolvedAt  *time.Time      `json:"resolved_at,omitempty"`
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Discrepancy kinds found by reconciliation
+const (
+	// DiscrepancyBalanceMismatch: the wallet balance differs from its last transaction's balance_after
+	DiscrepancyBalanceMismatch = "balance_mismatch"
+	// DiscrepancyBrokenChain: a transaction doesn't continue from the previous one's balance_after,
+	// or its balance_before + amount isn't its balance_after
+	DiscrepancyBrokenChain = "broken_chain"
+	// DiscrepancyUnpairedTransfer: a linked debit or credit leg is missing its counterpart
+	DiscrepancyUnpairedTransfer = "unpaired_transfer"
+	// DiscrepancyTransferImbalance: the two legs of a move don't net to zero in one currency
+	DiscrepancyTransferImbalance = "transfer_imbalance"
+	// DiscrepancyCurrencyTotal: the wallets of a currency don't hold the net of its transactions
+	DiscrepancyCurrencyTotal = "currency_total_mismatch"
+)
+
+var DiscrepancyKinds = []string{
+	DiscrepancyBalanceMismatch,
+	DiscrepancyBrokenChain,
+	DiscrepancyUnpairedTransfer,
+	DiscrepancyTransferImbalance,
+	DiscrepancyCurrencyTotal,
+}
+
+// LinkedTransactionTypes are recorded as a debit and a credit leg, the credit
+// pointing at the debit through related_tx_id
+var LinkedTransactionTypes = []string{"transfer", TxEscrowHold, TxEscrowRelease, TxEscrowRefund}
+
+type Discrepancy struct {
+	Kind          string          `db:"kind" json:"kind"`
+	WalletID      *uuid.UUID      `db:"wallet_id" json:"wallet_id,omitempty"`
+	TransactionID *uuid.UUID      `db:"transaction_id" json:"transaction_id,omitempty"`
+	Currency      string          `db:"currency" json:"currency"`
+	Expected      decimal.Decimal `db:"expected" json:"expected"`
+	Actual        decimal.Decimal `db:"actual" json:"actual"`
+	Detail        string          `db:"detail" json:"detail"`
+}
+
+type ReconciliationReport struct {
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	Wallets      int64     `json:"wallets"`
+	Transactions int64     `json:"transactions"`
+	// Counts has every kind, Discrepancies lists at most a configured number of each
+	Counts        map[string]int64 `json:"counts"`
+	Discrepancies []Discrepancy    `json:"discrepancies"`
+	Truncated     bool             `json:"truncated"`
+}
+
+// Total is the number of discrepancies of all kinds
+func (r *ReconciliationReport) Total() int64 {
+	var total int64
+	for _, n := range r.Counts {
+		total += n
+	}
+	return total
+}
+
+// WriteText writes the report as tables, for a terminal
+func (r *ReconciliationReport) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "Reconciled %d wallets and %d transactions in %s\n\n",
+		r.Wallets, r.Transactions, r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, kind := range DiscrepancyKinds {
+		fmt.Fprintf(tw, "%s\t%d\n", kind, r.Counts[kind])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(r.Discrepancies) == 0 {
+		_, err := fmt.Fprintln(w, "\nNo discrepancies")
+		return err
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tWALLET\tTRANSACTION\tCURRENCY\tEXPECTED\tACTUAL\tDETAIL")
+	for _, d := range r.Discrepancies {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			d.Kind, optionalID(d.WalletID), optionalID(d.TransactionID), d.Currency, d.Expected, d.Actual, d.Detail)
+	}
+	if r.Truncated {
+		fmt.Fprintln(tw, "...\t\t\t\t\t\tmore discrepancies than listed, see the counts above")
+	}
+	return tw.Flush()
+}
+
+func optionalID(id *uuid.UUID) string {
+	if id == nil {
+		return "-"
+	}
+	return id.String()
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Schedule kinds
+const (
+	ScheduleOnce     = "once"
+	ScheduleInterval = "interval"
+	ScheduleCron     = "cron"
+)
+
+// Schedule statuses
+const (
+	ScheduleActive    = "active"
+	SchedulePaused    = "paused"
+	ScheduleCompleted = "completed"
+	ScheduleCancelled = "cancelled"
+	ScheduleFailed    = "failed"
+)
+
+// Schedule run outcomes
+const (
+	RunSucceeded = "succeeded"
+	RunRetrying  = "retrying"
+	RunFailed    = "failed"
+)
+
+type TransferSchedule struct {
+	ID                   uuid.UUID       `db:"id"`
+	UserID               uuid.UUID       `db:"user_id"`
+	ToUserID             uuid.UUID       `db:"to_user_id"`
+	Amount               decimal.Decimal `db:"amount"`
+	Currency             string          `db:"currency"`
+	Reference            string          `db:"reference"`
+	Kind                 string          `db:"kind"`
+	CronExpr             *string         `db:"cron_expr"`
+	IntervalSeconds      *int64          `db:"interval_seconds"`
+	Timezone             string          `db:"timezone"`
+	StartAt              time.Time       `db:"start_at"`
+	EndAt                *time.Time      `db:"end_at"`
+	Status               string          `db:"status"`
+	NextRunAt            *time.Time      `db:"next_run_at"`
+	LastRunAt            *time.Time      `db:"last_run_at"`
+	Runs                 int             `db:"runs"`
+	Attempt              int             `db:"attempt"`
+	MaxRetries           int             `db:"max_retries"`
+	RetryIntervalSeconds int64           `db:"retry_interval_seconds"`
+	LastError            *string         `db:"last_error"`
+	LockedUntil          *time.Time      `db:"locked_until"`
+	CreatedAt            time.Time       `db:"created_at"`
+	UpdatedAt            time.Time       `db:"updated_at"`
+}
+
+// ClaimedSchedule is a due schedule leased to one scheduler instance
+type ClaimedSchedule struct {
+	TransferSchedule
+	// Interrupted is set when an earlier lease ran out before its run was recorded
+	Interrupted bool `db:"interrupted"`
+}
+
+type ScheduleRun struct {
+	ID           int64     `db:"id"`
+	ScheduleID   uuid.UUID `db:"schedule_id"`
+	ScheduledFor time.Time `db:"scheduled_for"`
+	Attempt      int       `db:"attempt"`
+	Status       string    `db:"status"`
+	Error        *string   `db:"error"`
+	ExecutedAt   time.Time `db:"executed_at"`
+}
+
+// CreateScheduleRequest describes a one-off transfer (run_at only), a
+// recurring one every interval from run_at, or one following a cron expression.
+type CreateScheduleRequest struct {
+	ToUserID      uuid.UUID       `json:"to_user_id" binding:"required"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency" binding:"required,len=3"`
+	Reference     string          `json:"reference"`
+	RunAt         *time.Time      `json:"run_at"`
+	Interval      string          `json:"interval"`
+	Cron          string          `json:"cron"`
+	Timezone      string          `json:"timezone"`
+	EndAt         *time.Time      `json:"end_at"`
+	MaxRetries    *int            `json:"max_retries"`
+	RetryInterval string          `json:"retry_interval"`
+}
+
+type ScheduleResponse struct {
+	ID            uuid.UUID       `json:"id"`
+	ToUserID      uuid.UUID       `json:"to_user_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency"`
+	Reference     string          `json:"reference,omitempty"`
+	Kind          string          `json:"kind"`
+	Cron          string          `json:"cron,omitempty"`
+	Interval      string          `json:"interval,omitempty"`
+	Timezone      string          `json:"timezone"`
+	StartAt       time.Time       `json:"start_at"`
+	EndAt         *time.Time      `json:"end_at,omitempty"`
+	Status        string          `json:"status"`
+	NextRunAt     *time.Time      `json:"next_run_at,omitempty"`
+	LastRunAt     *time.Time      `json:"last_run_at,omitempty"`
+	Runs          int             `json:"runs"`
+	MaxRetries    int             `json:"max_retries"`
+	RetryInterval string          `json:"retry_interval"`
+	LastError     *string         `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+type ScheduleRunResponse struct {
+	ScheduledFor time.Time `json:"scheduled_for"`
+	Attempt      int       `json:"attempt"`
+	Status       string    `json:"status"`
+	Error        *string   `json:"error,omitempty"`
+	ExecutedAt   time.Time `json:"executed_at"`
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Settlement line statuses
+const (
+	// SettlementMatched lines settle the transaction they name
+	SettlementMatched = "matched"
+	// SettlementUnmatched lines name no deposit or withdrawal
+	SettlementUnmatched = "unmatched"
+	// SettlementMismatched lines name a transaction with a different amount,
+	// currency or direction
+	SettlementMismatched = "mismatched"
+	// SettlementDuplicate lines name a transaction that was already settled
+	SettlementDuplicate = "duplicate"
+)
+
+// Settlement is one imported settlement file and the outcome of matching it
+type Settlement struct {
+	ID         uuid.UUID `db:"id"`
+	Source     string    `db:"source"`
+	Format     string    `db:"format"`
+	FileHash   string    `db:"file_hash"`
+	LineCount  int       `db:"line_count"`
+	Matched    int       `db:"matched"`
+	Unmatched  int       `db:"unmatched"`
+	Mismatched int       `db:"mismatched"`
+	Duplicate  int       `db:"duplicate"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+type SettlementLine struct {
+	SettlementID uuid.UUID `db:"settlement_id"`
+	// Position orders the lines, LineNumber points into the file
+	Position      int             `db:"position"`
+	LineNumber    int             `db:"line_number"`
+	Reference     string          `db:"reference"`
+	Direction     string          `db:"direction"`
+	Amount        decimal.Decimal `db:"amount"`
+	Currency      string          `db:"currency"`
+	ValueDate     *time.Time      `db:"value_date"`
+	Status        string          `db:"status"`
+	TransactionID *uuid.UUID      `db:"transaction_id"`
+	Detail        string          `db:"detail"`
+}
+
+type SettlementResponse struct {
+	ID         uuid.UUID `json:"id"`
+	Source     string    `json:"source,omitempty"`
+	Format     string    `json:"format"`
+	LineCount  int       `json:"line_count"`
+	Matched    int       `json:"matched"`
+	Unmatched  int       `json:"unmatched"`
+	Mismatched int       `json:"mismatched"`
+	Duplicate  int       `json:"duplicate"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type SettlementLineResponse struct {
+	LineNumber    int             `json:"line_number"`
+	Reference     string          `json:"reference"`
+	Direction     string          `json:"direction"`
+	Amount        decimal.Decimal `json:"amount"`
+	Currency      string          `json:"currency"`
+	ValueDate     string          `json:"value_date,omitempty"`
+	Status        string          `json:"status"`
+	TransactionID *uuid.UUID      `json:"transaction_id,omitempty"`
+	Detail        string          `json:"detail,omitempty"`
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// BalanceUpdate is pushed to streaming clients after a wallet change commits
+type BalanceUpdate struct {
+	UserID        uuid.UUID       `json:"user_id"`
+	WalletID      uuid.UUID       `json:"wallet_id"`
+	Currency      string          `json:"currency"`
+	Balance       decimal.Decimal `json:"balance"`
+	TransactionID uuid.UUID       `json:"transaction_id"`
+	Type          string          `json:"type"`
+	Amount        decimal.Decimal `json:"amount"`
+	Reference     string          `json:"reference,omitempty"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+}
+
+func NewBalanceUpdate(tx *Transaction) BalanceUpdate {
+	return BalanceUpdate{
+		UserID:        tx.UserID,
+		WalletID:      tx.WalletID,
+		Currency:      tx.Currency,
+		Balance:       tx.BalanceAfter,
+		TransactionID: tx.ID,
+		Type:          tx.Type,
+		Amount:        tx.Amount,
+		Reference:     tx.Reference,
+		OccurredAt:    time.Now().UTC(),
+	}
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 )
@@ -17,6 +19,10 @@ type Transaction struct {
 	RelatedTxID   *uuid.UUID      `db:"related_tx_id"`
 	Reference     string          `db:"reference"`
 	CreatedAt     string          `db:"created_at"`
+	Seq           int64           `db:"seq"` // insertion order, ties created_at within a DB transaction
+	// SettledAt is set once a bank settlement file confirms a deposit or withdrawal
+	SettledAt    *time.Time `db:"settled_at"`
+	SettlementID *uuid.UUID `db:"settlement_id"`
 }
 
 type DepositRequest struct {
@@ -48,8 +54,9 @@ type TransactionResponse struct {
 	Amount        decimal.Decimal `json:"amount"`
 	BalanceBefore decimal.Decimal `json:"balance_before"`
 	BalanceAfter  decimal.Decimal `json:"balance_after"`
-	Currency      string          `jon:"currency"`
+	Currency      string          `json:"currency"`
 	Type          string          `json:"type"`
 	Reference     string          `json:"reference"`
 	CreatedAt     string          `json:"created_at"`
+	SettledAt     *time.Time      `json:"settled_at,omitempty"`
 }
@@ -1,18 +1,32 @@
 package model
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 )
 
 type Wallet struct {
-	ID        uuid.UUID       `db:"id"`
-	UserID    uuid.UUID       `db:"user_id"`
-	Currency  string          `db:"currency"`
-	Balance   decimal.Decimal `db:"balance"`
-	Version   int             `db:"version"`
-	CreatedAt string          `db:"created_at"`
-	UpdatedAt string          `db:"updated_at"`
+	ID       uuid.UUID       `db:"id"`
+	UserID   uuid.UUID       `db:"user_id"`
+	Currency string          `db:"currency"`
+	Balance  decimal.Decimal `db:"balance"`
+	// OpeningBalance is the balance the wallet's ledger starts from, non-zero
+	// only for wallets funded before their first transaction
+	OpeningBalance decimal.Decimal `db:"opening_balance"`
+	Version        int             `db:"version"`
+	EscrowID       *uuid.UUID      `db:"escrow_id"` // set on the wallet holding an escrow's funds
+	CreatedAt      string          `db:"created_at"`
+	UpdatedAt      string          `db:"updated_at"`
+	// FrozenAt is set while the wallet is frozen, it takes money in but
+	// doesn't let any out
+	FrozenAt     *time.Time `db:"frozen_at"`
+	FrozenReason string     `db:"frozen_reason"`
+}
+
+func (w *Wallet) Frozen() bool {
+	return w.FrozenAt != nil
 }
 
 type WalletResponse struct {
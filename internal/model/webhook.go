@@ -0,0 +1,106 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Webhook delivery statuses
+const (
+	WebhookPending   = "pending"
+	WebhookSucceeded = "succeeded"
+	WebhookDead      = "dead"
+)
+
+type WebhookSubscription struct {
+	ID         uuid.UUID      `db:"id"`
+	UserID     uuid.UUID      `db:"user_id"`
+	URL        string         `db:"url"`
+	EventTypes pq.StringArray `db:"event_types"`
+	Secret     string         `db:"secret"`
+	Active     bool           `db:"active"`
+	CreatedAt  time.Time      `db:"created_at"`
+}
+
+// Matches reports whether the subscription wants events of the given type
+func (s *WebhookSubscription) Matches(eventType string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID `db:"id"`
+	SubscriptionID uuid.UUID `db:"subscription_id"`
+	EventID        uuid.UUID `db:"event_id"`
+	EventType      string    `db:"event_type"`
+	Payload        string    `db:"payload"`
+	Status         string    `db:"status"`
+	Attempts       int       `db:"attempts"`
+	NextAttemptAt  time.Time `db:"next_attempt_at"`
+	// Claims counts the times the delivery was leased to a worker
+	Claims         int        `db:"claims"`
+	LastStatusCode *int       `db:"last_status_code"`
+	LastError      *string    `db:"last_error"`
+	CreatedAt      time.Time  `db:"created_at"`
+	UpdatedAt      time.Time  `db:"updated_at"`
+	DeliveredAt    *time.Time `db:"delivered_at"`
+}
+
+type WebhookAttempt struct {
+	ID          int64     `db:"id"`
+	DeliveryID  uuid.UUID `db:"delivery_id"`
+	Attempt     int       `db:"attempt"`
+	StatusCode  *int      `db:"status_code"`
+	Error       *string   `db:"error"`
+	DurationMs  int       `db:"duration_ms"`
+	AttemptedAt time.Time `db:"attempted_at"`
+}
+
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret"`
+}
+
+type WebhookSubscriptionResponse struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     uuid.UUID `json:"user_id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Secret     string    `json:"secret,omitempty"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type WebhookDeliveryResponse struct {
+	ID             uuid.UUID       `json:"id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id"`
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+type WebhookAttemptResponse struct {
+	Attempt     int       `json:"attempt"`
+	StatusCode  *int      `json:"status_code,omitempty"`
+	Error       *string   `json:"error,omitempty"`
+	DurationMs  int       `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
@@ -0,0 +1,301 @@
+// Package openapi holds the OpenAPI document of the /api/v1/wallet routes,
+// the single description of their parameters, bodies and responses. It serves
+// the document and checks requests against it, and responses too in tests, so
+// handlers, models and docs can't drift apart unnoticed.
+package openapi
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.yaml
+var document []byte
+
+type Spec struct {
+	doc  *openapi3.T
+	json []byte
+}
+
+// New loads and validates the embedded document
+func New() (*Spec, error) {
+	const op = "openapi.New"
+
+	doc, err := openapi3.NewLoader().LoadFromData(document)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return &Spec{doc: doc, json: raw}, nil
+}
+
+// Document returns the parsed document
+func (s *Spec) Document() *openapi3.T {
+	return s.doc
+}
+
+// Handler serves the document as JSON
+func (s *Spec) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", s.json)
+	}
+}
+
+// Docs serves a Swagger UI page for the document served at jsonPath
+func Docs(jsonPath string) gin.HandlerFunc {
+	page := []byte(fmt.Sprintf(docsPage, jsonPath))
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+	}
+}
+
+const docsPage = `<!doctype html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Wallet API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: %q, dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+type Config struct {
+	// Responses checks every JSON response against the document too. A
+	// mismatch is a bug in the server and is answered with 500. Responses are
+	// buffered whole, so this is meant for tests.
+	Responses bool
+}
+
+// Middleware rejects requests that don't match the document with 400.
+// Routes the document doesn't describe pass through.
+func (s *Spec) Middleware(cfg Config) gin.HandlerFunc {
+	requestOptions := &openapi3filter.Options{
+		// the handlers apply the defaults, the request is left as sent
+		SkipSettingDefaults: true,
+	}
+	responseOptions := &openapi3filter.Options{
+		IncludeResponseStatus: true,
+	}
+
+	return func(c *gin.Context) {
+		const op = "openapi.Middleware"
+
+		route, params := s.route(c)
+		if route == nil {
+			c.Next()
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: params,
+			Route:      route,
+			Options:    requestOptions,
+		}
+		if err := validateRequest(c.Request, input); err != nil {
+			// the message leaves out the schema dump the error carries
+			message := requestMessage(err)
+			_ = c.Error(errors.NewInvalidRequest(op, stderrors.New(message)))
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": message})
+			return
+		}
+
+		if !cfg.Responses || !jsonOnly(route.Operation) {
+			c.Next()
+			return
+		}
+
+		w := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		response := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 w.status,
+			Header:                 w.Header(),
+			Options:                responseOptions,
+		}
+		if err := openapi3filter.ValidateResponse(c.Request.Context(), response.SetBodyBytes(w.body.Bytes())); err != nil {
+			err = errors.NewInternal(op, fmt.Errorf("%s %s answered %d, which the OpenAPI document doesn't allow: %w",
+				c.Request.Method, c.FullPath(), w.status, err))
+			_ = c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Writer.WriteHeader(w.status)
+		_, _ = c.Writer.Write(w.body.Bytes())
+	}
+}
+
+// validateRequest checks the request against the document. The handlers
+// bind bodies as JSON whatever the Content-Type says, so bodies are checked
+// as JSON the same way.
+func validateRequest(r *http.Request, input *openapi3filter.RequestValidationInput) error {
+	if body := input.Route.Operation.RequestBody; body != nil && body.Value.Content.Get("application/json") != nil {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			sent, had := r.Header["Content-Type"]
+			r.Header.Set("Content-Type", "application/json")
+			defer func() {
+				if had {
+					r.Header["Content-Type"] = sent
+				} else {
+					r.Header.Del("Content-Type")
+				}
+			}()
+		}
+	}
+	return openapi3filter.ValidateRequest(r.Context(), input)
+}
+
+// requestMessage names the parameter or body field at fault and why
+func requestMessage(err error) string {
+	var reqErr *openapi3filter.RequestError
+	if !stderrors.As(err, &reqErr) {
+		return err.Error()
+	}
+	var schemaErr *openapi3.SchemaError
+	reason := reqErr.Reason
+	if stderrors.As(reqErr.Err, &schemaErr) {
+		reason = schemaErr.Reason
+		if path := schemaErr.JSONPointer(); len(path) > 0 {
+			reason = fmt.Sprintf("%s: %s", strings.Join(path, "."), reason)
+		}
+	} else if reqErr.Err != nil {
+		reason = strings.TrimSpace(reason + " " + reqErr.Err.Error())
+	}
+
+	switch {
+	case reqErr.Parameter != nil:
+		return fmt.Sprintf("invalid %s parameter %q: %s", reqErr.Parameter.In, reqErr.Parameter.Name, reason)
+	case reqErr.RequestBody != nil:
+		return "invalid request body: " + reason
+	default:
+		return reason
+	}
+}
+
+// route finds the operation gin matched. The document's paths are the full
+// route paths, with gin's :name parameters written {name}.
+func (s *Spec) route(c *gin.Context) (*routers.Route, map[string]string) {
+	path := c.FullPath()
+	if path == "" {
+		return nil, nil
+	}
+	item := s.doc.Paths.Find(openAPIPath(path))
+	if item == nil {
+		return nil, nil
+	}
+	operation := item.GetOperation(c.Request.Method)
+	if operation == nil {
+		return nil, nil
+	}
+
+	params := make(map[string]string, len(c.Params))
+	for _, p := range c.Params {
+		params[p.Key] = p.Value
+	}
+	return &routers.Route{
+		Spec:      s.doc,
+		Path:      openAPIPath(path),
+		PathItem:  item,
+		Method:    c.Request.Method,
+		Operation: operation,
+	}, params
+}
+
+var ginParam = regexp.MustCompile(`[:*]([^/]+)`)
+
+func openAPIPath(ginPath string) string {
+	return ginParam.ReplaceAllString(ginPath, "{$1}")
+}
+
+// Undocumented lists the routes under prefix the document doesn't describe
+func (s *Spec) Undocumented(routes gin.RoutesInfo, prefix string) []string {
+	var missing []string
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Path, prefix) {
+			continue
+		}
+		item := s.doc.Paths.Find(openAPIPath(r.Path))
+		if item == nil || item.GetOperation(r.Method) == nil {
+			missing = append(missing, r.Method+" "+r.Path)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// jsonOnly tells whether every response of the operation is JSON, streamed
+// responses such as statements and events aren't buffered
+func jsonOnly(operation *openapi3.Operation) bool {
+	for _, response := range operation.Responses.Map() {
+		for mediaType := range response.Value.Content {
+			if mediaType != "application/json" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// bufferedWriter holds the response back until it is checked
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.body.Len() > 0
+}
+
+func (w *bufferedWriter) Flush() {}
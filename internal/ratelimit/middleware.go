@@ -0,0 +1,153 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/auth"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// Route classes
+const (
+	ClassRead  = "read"
+	ClassWrite = "write"
+)
+
+// Headers of the IETF RateLimit header fields draft, describing the tighter
+// of the request's buckets
+const (
+	LimitHeader      = "RateLimit-Limit"
+	RemainingHeader  = "RateLimit-Remaining"
+	ResetHeader      = "RateLimit-Reset"
+	RetryAfterHeader = "Retry-After"
+)
+
+// Limits of one route class
+type Limits struct {
+	// User is the limit of each user
+	User Limit
+	// IP is the limit of each client IP, shared by the users behind it
+	IP Limit
+}
+
+type Config struct {
+	// Read limits GET and HEAD requests
+	Read Limits
+	// Write limits every other method, the requests that move money
+	Write Limits
+	// UserKey names the user a request acts for, "" when there is none. A
+	// name the request merely claims must be scoped to something it proved,
+	// or a client can spend someone else's tokens. The default is
+	// DefaultUserKey.
+	UserKey func(c *gin.Context) string
+}
+
+func (c Config) withDefaults() Config {
+	c.Read.User = orDefault(c.Read.User, Limit{Requests: 20, Period: time.Second})
+	c.Read.IP = orDefault(c.Read.IP, Limit{Requests: 100, Period: time.Second})
+	c.Write.User = orDefault(c.Write.User, Limit{Requests: 5, Period: time.Second})
+	c.Write.IP = orDefault(c.Write.IP, Limit{Requests: 25, Period: time.Second})
+	if c.UserKey == nil {
+		c.UserKey = DefaultUserKey
+	}
+	return c
+}
+
+// DefaultUserKey keys the user_id a request acts for on who vouches for it:
+// the caller that authenticated with auth.Middleware, e.g. a gateway acting
+// for many users, or else the client IP. Each user behind a caller gets a
+// bucket of their own, and a user_id an unauthenticated client claims only
+// spends the tokens of requests from the same IP. A caller acting for no
+// user is limited as itself.
+func DefaultUserKey(c *gin.Context) string {
+	user := c.Query("user_id")
+	if caller := auth.CallerFromContext(c.Request.Context()); caller != "" {
+		if user == "" {
+			return caller
+		}
+		return caller + ":" + user
+	}
+	if user == "" {
+		return ""
+	}
+	return c.ClientIP() + ":" + user
+}
+
+func orDefault(l, def Limit) Limit {
+	if l.Requests <= 0 || l.Period <= 0 {
+		return def
+	}
+	return l
+}
+
+// Middleware answers 429 with Retry-After to a request when its user or its
+// client IP has run out of tokens for the route class. Every request gets
+// the RateLimit headers. The user's bucket is taken from first, so a user
+// over the limit doesn't use up the IP's. A failing store lets requests
+// through, rate limiting doesn't take the API down with it.
+func Middleware(store Store, cfg Config) gin.HandlerFunc {
+	cfg = cfg.withDefaults()
+	return func(c *gin.Context) {
+		const op = "ratelimit.Middleware"
+		ctx := c.Request.Context()
+		now := time.Now()
+
+		class, limits := ClassWrite, cfg.Write
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			class, limits = ClassRead, cfg.Read
+		}
+		type check struct {
+			key, id string
+			limit   Limit
+		}
+		checks := []check{{"ip", c.ClientIP(), limits.IP}}
+		if user := cfg.UserKey(c); user != "" {
+			checks = append([]check{{"user", user, limits.User}}, checks...)
+		}
+
+		var (
+			tightest Result
+			limit    Limit
+			denied   string
+		)
+		for i, ch := range checks {
+			result, err := store.Take(ctx, class+":"+ch.key+":"+ch.id, ch.limit, now)
+			if err != nil {
+				logging.FromContext(ctx).Warn("rate limit store failed, request let through",
+					logging.KeyOp, op, logging.Err(err))
+				c.Next()
+				return
+			}
+			if i == 0 || !result.Allowed || result.Remaining < tightest.Remaining {
+				tightest, limit = result, ch.limit
+			}
+			if !result.Allowed {
+				denied = ch.key
+				break
+			}
+		}
+
+		c.Header(LimitHeader, strconv.Itoa(limit.Requests))
+		c.Header(RemainingHeader, strconv.Itoa(tightest.Remaining))
+		c.Header(ResetHeader, strconv.Itoa(ceilSeconds(tightest.Reset)))
+		if denied != "" {
+			retryAfter := max(ceilSeconds(tightest.RetryAfter), 1)
+			metrics.HTTPRateLimited.WithLabelValues(class, denied).Inc()
+			c.Header(RetryAfterHeader, strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": "rate limit of " + limit.String() + " per " + denied + " exceeded, retry in " + strconv.Itoa(retryAfter) + "s",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,101 @@
+// Package ratelimit throttles API clients with token buckets, one per user
+// and one per client IP for each class of routes.
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+)
+
+// Limit lets Requests through per Period. Up to Requests can be made at once
+// after a pause, the bucket refills at a steady Requests/Period rate.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit reads a limit written as requests/period, e.g. "20/s", "300/m"
+// or "50/10s"
+func ParseLimit(s string) (Limit, error) {
+	const op = "ratelimit.ParseLimit"
+	count, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	requests, err := strconv.Atoi(count)
+	if !ok || err != nil || requests < 1 {
+		return Limit{}, errors.NewInvalidInput(op, "limit", s)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	period, err := time.ParseDuration(per)
+	if err != nil || period <= 0 {
+		return Limit{}, errors.NewInvalidInput(op, "limit", s)
+	}
+	return Limit{Requests: requests, Period: period}, nil
+}
+
+// String writes the limit the way ParseLimit reads it
+func (l Limit) String() string {
+	per := l.Period.String()
+	switch l.Period {
+	case time.Second:
+		per = "s"
+	case time.Minute:
+		per = "m"
+	case time.Hour:
+		per = "h"
+	}
+	return strconv.Itoa(l.Requests) + "/" + per
+}
+
+// rate is the number of tokens refilled per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Result is the state of a bucket after a Take
+type Result struct {
+	Allowed bool
+	// Remaining is the number of whole tokens left
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next token, zero when Allowed
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets. MemoryStore serves a single instance; instances
+// behind a load balancer need a shared Store, e.g. on Redis, or a client gets
+// the limit once per instance.
+type Store interface {
+	// Take takes a token from key's bucket at now. A bucket that doesn't
+	// exist yet starts full.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// bucketState refills a bucket last seen with tokens at updated up to now
+// and takes a token when there is one. It returns the tokens left.
+func bucketState(tokens float64, updated, now time.Time, limit Limit) (float64, Result) {
+	burst := float64(limit.Requests)
+	if elapsed := now.Sub(updated); elapsed > 0 {
+		tokens = min(burst, tokens+elapsed.Seconds()*limit.rate())
+	}
+
+	var result Result
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / limit.rate())
+	}
+	result.Remaining = int(tokens)
+	result.Reset = seconds((burst - tokens) / limit.rate())
+	return tokens, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+)
+
+type AdjustmentRepository interface {
+	GetAdjustment(ctx context.Context, id uuid.UUID) (*model.Adjustment, error)
+	// ListAdjustments lists the adjustments with the status, every one when
+	// it is empty, newest first
+	ListAdjustments(ctx context.Context, status string, offset, limit int) ([]model.Adjustment, error)
+}
+
+// TxAdjustmentRepository writes adjustments inside the DB transaction
+// applying them
+type TxAdjustmentRepository interface {
+	CreateAdjustmentTx(ctx context.Context, tx *sqlx.Tx, adjustment *model.Adjustment) error
+	GetAdjustmentForUpdateTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID) (*model.Adjustment, error)
+	ResolveAdjustmentTx(ctx context.Context, tx *sqlx.Tx, adjustment *model.Adjustment) error
+	// UnreviewedAdjustmentTotalTx sums the absolute amounts of the wallet's
+	// adjustments asked for since then and applied without a review
+	UnreviewedAdjustmentTotalTx(ctx context.Context, tx *sqlx.Tx, walletID uuid.UUID, since time.Time) (decimal.Decimal, error)
+}
+
+type adjustmentRepo struct {
+	db *sqlx.DB
+}
+
+func NewAdjustmentRepository(db *sqlx.DB) AdjustmentRepository {
+	return &adjustmentRepo{db: db}
+}
+
+func (r *adjustmentRepo) GetAdjustment(ctx context.Context, id uuid.UUID) (*model.Adjustment, error) {
+	const op = "adjustment.Get"
+	var adjustment model.Adjustment
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "adjustments")
+
+	err := r.db.GetContext(ctx, &adjustment, `SELECT * FROM adjustments WHERE id = $1`, id)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "adjustment")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &adjustment, nil
+}
+
+func (r *adjustmentRepo) ListAdjustments(ctx context.Context, status string, offset, limit int) ([]model.Adjustment, error) {
+	const op = "adjustment.List"
+	var adjustments []model.Adjustment
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "adjustments")
+
+	err := r.db.SelectContext(ctx, &adjustments, `
+        SELECT * FROM adjustments
+        WHERE $1 = '' OR status = $1
+        ORDER BY created_at DESC
+        LIMIT $2 OFFSET $3`,
+		status, limit, offset)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return adjustments, nil
+}
+
+func (r *adjustmentRepo) CreateAdjustmentTx(ctx context.Context, tx *sqlx.Tx, adjustment *model.Adjustment) error {
+	const op = "adjustment.CreateTx"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "adjustments")
+
+	_, err := tx.NamedExecContext(ctx, `
+        INSERT INTO adjustments
+        (id, wallet_id, user_id, currency, amount, reason_code, note, requested_by, reviewed_by, status,
+         transaction_id, created_at, updated_at, resolved_at)
+        VALUES (:id, :wallet_id, :user_id, :currency, :amount, :reason_code, :note, :requested_by, :reviewed_by, :status,
+                :transaction_id, :created_at, :updated_at, :resolved_at)`,
+		adjustment)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+func (r *adjustmentRepo) GetAdjustmentForUpdateTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID) (*model.Adjustment, error) {
+	const op = "adjustment.GetForUpdateTx"
+	var adjustment model.Adjustment
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "adjustments")
+
+	err := tx.GetContext(ctx, &adjustment, `SELECT * FROM adjustments WHERE id = $1 FOR UPDATE`, id)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "adjustment")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &adjustment, nil
+}
+
+func (r *adjustmentRepo) ResolveAdjustmentTx(ctx context.Context, tx *sqlx.Tx, adjustment *model.Adjustment) error {
+	const op = "adjustment.ResolveTx"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "adjustments")
+
+	_, err := tx.NamedExecContext(ctx, `
+        UPDATE adjustments
+        SET status = :status, reviewed_by = :reviewed_by, transaction_id = :transaction_id,
+            resolved_at = :resolved_at, updated_at = NOW()
+        WHERE id = :id`,
+		adjustment)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+func (r *adjustmentRepo) UnreviewedAdjustmentTotalTx(ctx context.Context, tx *sqlx.Tx, walletID uuid.UUID, since time.Time) (decimal.Decimal, error) {
+	const op = "adjustment.UnreviewedTotalTx"
+	var total decimal.Decimal
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "adjustments")
+
+	err := tx.GetContext(ctx, &total, `
+        SELECT COALESCE(SUM(ABS(amount)), 0) FROM adjustments
+        WHERE wallet_id = $1 AND created_at >= $2 AND status = 'applied' AND reviewed_by IS NULL`,
+		walletID, since)
+	tracing.End(span, err)
+	if err != nil {
+		return decimal.Zero, errors.NewInternal(op, err)
+	}
+	return total, nil
+}
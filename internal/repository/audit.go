@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/jmoiron/sqlx"
+)
+
+type AuditRepository interface {
+	ListEvents(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error)
+	ListChain(ctx context.Context, afterID int64, limit int) ([]model.AuditEvent, error)
+	TxAuditRepository
+}
+
+type TxAuditRepository interface {
+	CreateAuditEventTx(ctx context.Context, tx *sqlx.Tx, event *model.AuditEvent) error
+}
+
+type auditRepo struct {
+	db *sqlx.DB
+}
+
+func NewAuditRepository(db *sqlx.DB) AuditRepository {
+	return &auditRepo{db: db}
+}
+
+func (r *auditRepo) CreateAuditEventTx(ctx context.Context, tx *sqlx.Tx, event *model.AuditEvent) error {
+	const op = "audit.CreateTx"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "audit_events")
+
+	err := r.appendTx(ctx, tx, event)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+func (r *auditRepo) appendTx(ctx context.Context, tx *sqlx.Tx, event *model.AuditEvent) error {
+	// serializes appends to the event's chain so each sees the chain's latest
+	// hash, held until the surrounding transaction commits or rolls back
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtextextended('audit_events:' || $1, 0))`, event.Chain); err != nil {
+		return err
+	}
+
+	prevHash := audit.GenesisHash
+	err := tx.GetContext(ctx, &prevHash, `SELECT hash FROM audit_events WHERE chain = $1 ORDER BY id DESC LIMIT 1`, event.Chain)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	event.PrevHash = prevHash
+	event.Hash = audit.ComputeHash(prevHash, event)
+
+	rows, err := sqlx.NamedQueryContext(ctx, tx, `
+        INSERT INTO audit_events
+        (event_id, actor, action, target_type, target_id, request_id, before_state, after_state,
+         client_ip, user_agent, prev_hash, hash, chain, created_at)
+        VALUES (:event_id, :actor, :action, :target_type, :target_id, :request_id, :before_state, :after_state,
+         :client_ip, :user_agent, :prev_hash, :hash, :chain, :created_at)
+        RETURNING id`, event)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&event.ID); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *auditRepo) ListEvents(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error) {
+	const op = "audit.List"
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "audit_events")
+
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.Actor != "" {
+		add("actor = $%d", filter.Actor)
+	}
+	if filter.TargetType != "" {
+		add("target_type = $%d", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		add("target_id = $%d", filter.TargetID)
+	}
+	if filter.From != nil {
+		add("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("created_at < $%d", *filter.To)
+	}
+
+	query := `SELECT * FROM audit_events`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, " AND ")
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+
+	var events []model.AuditEvent
+	err := r.db.SelectContext(ctx, &events, query, args...)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return events, nil
+}
+
+func (r *auditRepo) ListChain(ctx context.Context, afterID int64, limit int) ([]model.AuditEvent, error) {
+	const op = "audit.ListChain"
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "audit_events")
+
+	var events []model.AuditEvent
+	err := r.db.SelectContext(ctx, &events, `
+        SELECT * FROM audit_events
+        WHERE id > $1
+        ORDER BY id ASC
+        LIMIT $2`,
+		afterID, limit)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return events, nil
+}
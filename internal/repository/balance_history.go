@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+)
+
+// BalanceHistoryRepository derives past balances from transactions.balance_after
+// and keeps end-of-day snapshots of them.
+type BalanceHistoryRepository interface {
+	// GetBalanceAt returns the balance after the wallet's last transaction
+	// created at or before at, or its opening balance if it existed by then
+	GetBalanceAt(ctx context.Context, walletID uuid.UUID, at time.Time) (decimal.Decimal, error)
+	// CreateSnapshots stores every wallet's balance at the end of the UTC day,
+	// skipping wallets already snapshotted for it, and returns how many it wrote.
+	CreateSnapshots(ctx context.Context, day time.Time) (int64, error)
+	// GetDailyBalances returns the end-of-day balances from one UTC day to another
+	// inclusive, from snapshots where present and from transactions otherwise.
+	GetDailyBalances(ctx context.Context, walletID uuid.UUID, from, to time.Time) ([]model.DailyBalance, error)
+}
+
+type balanceHistoryRepo struct {
+	db *sqlx.DB
+}
+
+func NewBalanceHistoryRepository(db *sqlx.DB) BalanceHistoryRepository {
+	return &balanceHistoryRepo{db: db}
+}
+
+func (r *balanceHistoryRepo) GetBalanceAt(ctx context.Context, walletID uuid.UUID, at time.Time) (decimal.Decimal, error) {
+	const op = "balanceHistory.GetBalanceAt"
+	var balance decimal.Decimal
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "transactions")
+
+	err := r.db.GetContext(ctx, &balance, `
+        SELECT COALESCE(
+                   (SELECT t.balance_after FROM transactions t
+                    WHERE t.wallet_id = w.id AND t.created_at <= $2
+                    ORDER BY t.seq DESC
+                    LIMIT 1),
+                   CASE WHEN w.created_at <= $2 THEN w.opening_balance ELSE 0 END)
+        FROM wallets w
+        WHERE w.id = $1`,
+		walletID, at)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return decimal.Zero, nil
+		}
+		return decimal.Zero, errors.NewInternal(op, err)
+	}
+	return balance, nil
+}
+
+func (r *balanceHistoryRepo) CreateSnapshots(ctx context.Context, day time.Time) (int64, error) {
+	const op = "balanceHistory.CreateSnapshots"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "wallet_balance_snapshots")
+
+	// $2 is the start of the next day, created_at is the DB transaction's start time
+	res, err := r.db.ExecContext(ctx, `
+        INSERT INTO wallet_balance_snapshots (wallet_id, user_id, currency, snapshot_date, balance)
+        SELECT w.id, w.user_id, w.currency, $1::date,
+               COALESCE((SELECT t.balance_after FROM transactions t
+                         WHERE t.wallet_id = w.id AND t.created_at < $2
+                         ORDER BY t.seq DESC
+                         LIMIT 1), w.opening_balance)
+        FROM wallets w
+        WHERE w.created_at < $2
+        ON CONFLICT (wallet_id, snapshot_date) DO NOTHING`,
+		day.Format(time.DateOnly), day.AddDate(0, 0, 1))
+	if err != nil {
+		tracing.End(span, err)
+		return 0, errors.NewInternal(op, err)
+	}
+	rows, err := res.RowsAffected()
+	span.SetAttributes(tracing.AttrRowsAffected.Int64(rows))
+	tracing.End(span, err)
+	return rows, errors.IfInternalError(op, err)
+}
+
+func (r *balanceHistoryRepo) GetDailyBalances(ctx context.Context, walletID uuid.UUID, from, to time.Time) ([]model.DailyBalance, error) {
+	const op = "balanceHistory.GetDailyBalances"
+	var balances []model.DailyBalance
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "wallet_balance_snapshots")
+
+	err := r.db.SelectContext(ctx, &balances, `
+        SELECT d::date AS day,
+               COALESCE(s.balance,
+                        (SELECT t.balance_after FROM transactions t
+                         WHERE t.wallet_id = $1 AND t.created_at < (d + INTERVAL '1 day') AT TIME ZONE 'UTC'
+                         ORDER BY t.seq DESC
+                         LIMIT 1),
+                        CASE WHEN w.created_at < (d + INTERVAL '1 day') AT TIME ZONE 'UTC' THEN w.opening_balance END,
+                        0) AS balance
+        FROM generate_series($2::timestamp, $3::timestamp, INTERVAL '1 day') AS d
+        LEFT JOIN wallets w ON w.id = $1
+        LEFT JOIN wallet_balance_snapshots s ON s.wallet_id = $1 AND s.snapshot_date = d::date
+        ORDER BY d`,
+		walletID, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	span.SetAttributes(tracing.AttrRowsAffected.Int64(int64(len(balances))))
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return balances, nil
+}
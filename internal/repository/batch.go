@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type BatchRepository interface {
+	// CreateBatch inserts the batch unless the user already submitted one with
+	// the same idempotency key, in which case created is false.
+	CreateBatch(ctx context.Context, batch *model.Batch) (created bool, err error)
+	GetBatch(ctx context.Context, id uuid.UUID) (*model.Batch, error)
+	GetBatchByIdempotencyKey(ctx context.Context, userID uuid.UUID, key string) (*model.Batch, error)
+	// ClaimPendingBatch leases the oldest pending batch, or processing batch
+	// whose lease ran out, to the caller and returns it, or returns nil when
+	// there is none.
+	ClaimPendingBatch(ctx context.Context, lease time.Duration) (*model.Batch, error)
+	// RecordBatchProgress stores the batch's results so far and its item in
+	// flight, and renews its lease. held is false when the batch was claimed
+	// again since, the caller must stop running it.
+	RecordBatchProgress(ctx context.Context, batch *model.Batch, lease time.Duration) (held bool, err error)
+	CompleteBatch(ctx context.Context, batch *model.Batch) error
+}
+
+type batchRepo struct {
+	db *sqlx.DB
+}
+
+func NewBatchRepository(db *sqlx.DB) BatchRepository {
+	return &batchRepo{db: db}
+}
+
+func (r *batchRepo) CreateBatch(ctx context.Context, batch *model.Batch) (bool, error) {
+	const op = "batch.Create"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "batches")
+
+	result, err := r.db.NamedExecContext(ctx, `
+        INSERT INTO batches (id, user_id, idempotency_key, request_hash, mode, status, items, item_count,
+                             created_at, updated_at, locked_until, claims)
+        VALUES (:id, :user_id, :idempotency_key, :request_hash, :mode, :status, :items, :item_count,
+                :created_at, :updated_at, :locked_until, :claims)
+        ON CONFLICT (user_id, idempotency_key) DO NOTHING`,
+		batch)
+	var rows int64
+	if err == nil {
+		rows, err = result.RowsAffected()
+		span.SetAttributes(tracing.AttrRowsAffected.Int64(rows))
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return false, errors.NewInternal(op, err)
+	}
+	return rows == 1, nil
+}
+
+func (r *batchRepo) GetBatch(ctx context.Context, id uuid.UUID) (*model.Batch, error) {
+	const op = "batch.Get"
+	var batch model.Batch
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "batches")
+
+	err := r.db.GetContext(ctx, &batch, `SELECT * FROM batches WHERE id = $1`, id)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "batch")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &batch, nil
+}
+
+func (r *batchRepo) GetBatchByIdempotencyKey(ctx context.Context, userID uuid.UUID, key string) (*model.Batch, error) {
+	const op = "batch.GetByIdempotencyKey"
+	var batch model.Batch
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "batches")
+
+	err := r.db.GetContext(ctx, &batch,
+		`SELECT * FROM batches WHERE user_id = $1 AND idempotency_key = $2`, userID, key)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "batch")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &batch, nil
+}
+
+func (r *batchRepo) ClaimPendingBatch(ctx context.Context, lease time.Duration) (*model.Batch, error) {
+	const op = "batch.ClaimPending"
+	var batch model.Batch
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "batches")
+
+	err := r.db.GetContext(ctx, &batch, `
+        UPDATE batches
+        SET status = 'processing', claims = claims + 1,
+            locked_until = NOW() + $1 * INTERVAL '1 millisecond', updated_at = NOW()
+        WHERE id = (
+            SELECT id FROM batches
+            WHERE status = 'pending'
+               OR (status = 'processing' AND (locked_until IS NULL OR locked_until < NOW()))
+            ORDER BY created_at
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED)
+        RETURNING *`,
+		lease.Milliseconds())
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &batch, nil
+}
+
+func (r *batchRepo) RecordBatchProgress(ctx context.Context, batch *model.Batch, lease time.Duration) (bool, error) {
+	const op = "batch.RecordProgress"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "batches")
+
+	result, err := r.db.ExecContext(ctx, `
+        UPDATE batches
+        SET results = $3, succeeded = $4, failed = $5, in_flight = $6,
+            locked_until = NOW() + $7 * INTERVAL '1 millisecond', updated_at = NOW()
+        WHERE id = $1 AND claims = $2 AND status = 'processing'`,
+		batch.ID, batch.Claims, batch.Results, batch.Succeeded, batch.Failed, batch.InFlight, lease.Milliseconds())
+	var rows int64
+	if err == nil {
+		rows, err = result.RowsAffected()
+		span.SetAttributes(tracing.AttrRowsAffected.Int64(rows))
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return false, errors.NewInternal(op, err)
+	}
+	return rows == 1, nil
+}
+
+func (r *batchRepo) CompleteBatch(ctx context.Context, batch *model.Batch) error {
+	const op = "batch.Complete"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "batches")
+
+	_, err := r.db.NamedExecContext(ctx, `
+        UPDATE batches
+        SET status = :status, results = :results, succeeded = :succeeded, failed = :failed,
+            completed_at = :completed_at, locked_until = NULL, in_flight = NULL, updated_at = NOW()
+        WHERE id = :id`,
+		batch)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type EscrowRepository interface {
+	GetEscrow(ctx context.Context, id uuid.UUID) (*model.Escrow, error)
+	// ListEscrows lists the escrows the user is buyer, seller or arbiter of, newest first
+	ListEscrows(ctx context.Context, userID uuid.UUID, offset, limit int) ([]model.Escrow, error)
+	// ListDueEscrows returns held escrows whose deadline has passed, oldest deadline first
+	ListDueEscrows(ctx context.Context, limit int) ([]uuid.UUID, error)
+	TxEscrowRepository
+}
+
+// TxEscrowRepository writes escrows inside the DB transaction moving their funds
+type TxEscrowRepository interface {
+	CreateEscrowTx(ctx context.Context, tx *sqlx.Tx, escrow *model.Escrow) error
+	GetEscrowForUpdateTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID) (*model.Escrow, error)
+	ResolveEscrowTx(ctx context.Context, tx *sqlx.Tx, escrow *model.Escrow) error
+}
+
+type escrowRepo struct {
+	db *sqlx.DB
+}
+
+func NewEscrowRepository(db *sqlx.DB) EscrowRepository {
+	return &escrowRepo{db: db}
+}
+
+func (r *escrowRepo) GetEscrow(ctx context.Context, id uuid.UUID) (*model.Escrow, error) {
+	const op = "escrow.Get"
+	var escrow model.Escrow
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "escrows")
+
+	err := r.db.GetContext(ctx, &escrow, `SELECT * FROM escrows WHERE id = $1`, id)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "escrow")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &escrow, nil
+}
+
+func (r *escrowRepo) ListEscrows(ctx context.Context, userID uuid.UUID, offset, limit int) ([]model.Escrow, error) {
+	const op = "escrow.List"
+	var escrows []model.Escrow
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "escrows")
+
+	err := r.db.SelectContext(ctx, &escrows, `
+        SELECT * FROM escrows
+        WHERE buyer_id = $1 OR seller_id = $1 OR arbiter_id = $1
+        ORDER BY created_at DESC
+        LIMIT $2 OFFSET $3`,
+		userID, limit, offset)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return escrows, nil
+}
+
+func (r *escrowRepo) ListDueEscrows(ctx context.Context, limit int) ([]uuid.UUID, error) {
+	const op = "escrow.ListDue"
+	var ids []uuid.UUID
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "escrows")
+
+	err := r.db.SelectContext(ctx, &ids, `
+        SELECT id FROM escrows
+        WHERE status = 'held' AND deadline <= NOW()
+        ORDER BY deadline
+        LIMIT $1`,
+		limit)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return ids, nil
+}
+
+func (r *escrowRepo) CreateEscrowTx(ctx context.Context, tx *sqlx.Tx, escrow *model.Escrow) error {
+	const op = "escrow.CreateTx"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "escrows")
+
+	_, err := tx.NamedExecContext(ctx, `
+        INSERT INTO escrows
+        (id, buyer_id, seller_id, arbiter_id, wallet_id, amount, currency, reference, status,
+         deadline, deadline_action, created_at, updated_at)
+        VALUES (:id, :buyer_id, :seller_id, :arbiter_id, :wallet_id, :amount, :currency, :reference, :status,
+                :deadline, :deadline_action, :created_at, :updated_at)`,
+		escrow)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+func (r *escrowRepo) GetEscrowForUpdateTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID) (*model.Escrow, error) {
+	const op = "escrow.GetForUpdateTx"
+	var escrow model.Escrow
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "escrows")
+
+	err := tx.GetContext(ctx, &escrow, `SELECT * FROM escrows WHERE id = $1 FOR UPDATE`, id)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "escrow")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &escrow, nil
+}
+
+func (r *escrowRepo) ResolveEscrowTx(ctx context.Context, tx *sqlx.Tx, escrow *model.Escrow) error {
+	const op = "escrow.ResolveTx"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "escrows")
+
+	_, err := tx.NamedExecContext(ctx, `
+        UPDATE escrows
+        SET status = :status, released_amount = :released_amount, refunded_amount = :refunded_amount,
+            resolved_by = :resolved_by, resolved_at = :resolved_at, updated_at = NOW()
+        WHERE id = :id`,
+		escrow)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// lockTable hands out exclusive row locks, held by a transaction until it ends.
+// Waiting respects the context, and a wait that would close a cycle fails the
+// way Postgres reports a deadlock.
+type lockTable struct {
+	mu      sync.Mutex
+	holders map[string]*walletTx
+	waiting map[*walletTx]string
+	// released is closed and replaced whenever a lock is released, waking
+	// every waiter to try again
+	released chan struct{}
+}
+
+func newLockTable() *lockTable {
+	return &lockTable{
+		holders:  make(map[string]*walletTx),
+		waiting:  make(map[*walletTx]string),
+		released: make(chan struct{}),
+	}
+}
+
+// acquire blocks until owner holds the lock on key
+func (l *lockTable) acquire(ctx context.Context, owner *walletTx, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for {
+		holder, held := l.holders[key]
+		if holder == owner {
+			return nil
+		}
+		if !held {
+			l.holders[key] = owner
+			owner.locks = append(owner.locks, key)
+			return nil
+		}
+		if l.closesCycle(owner, holder) {
+			return fmt.Errorf("deadlock detected waiting for %s", key)
+		}
+
+		l.waiting[owner] = key
+		released := l.released
+		l.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+		}
+		l.mu.Lock()
+		delete(l.waiting, owner)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// closesCycle reports whether holder is, directly or through other waiters,
+// waiting for a lock owner holds
+func (l *lockTable) closesCycle(owner, holder *walletTx) bool {
+	for seen := 0; seen <= len(l.waiting); seen++ {
+		key, waits := l.waiting[holder]
+		if !waits {
+			return false
+		}
+		if holder = l.holders[key]; holder == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseAll drops every lock owner holds
+func (l *lockTable) releaseAll(owner *walletTx) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range owner.locks {
+		if l.holders[key] == owner {
+			delete(l.holders, key)
+		}
+	}
+	owner.locks = nil
+	close(l.released)
+	l.released = make(chan struct{})
+}
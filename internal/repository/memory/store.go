@@ -0,0 +1,256 @@
+// Package memory keeps wallets and their transactions in process, for tests
+// and local development without Postgres. Store implements the wallet and
+// transaction repositories and the TxManager with the semantics the services
+// rely on from Postgres at read committed: a transaction's writes are private
+// until it commits and dropped on rollback, row locks are held until it ends,
+// version checks see the latest committed row, and the schema's constraints
+// are enforced.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	_ repository.WalletRepository      = (*Store)(nil)
+	_ repository.TransactionRepository = (*Store)(nil)
+	_ repository.TxManager             = (*Store)(nil)
+	_ repository.AdjustmentRepository  = (*Store)(nil)
+)
+
+type Store struct {
+	locks *lockTable
+
+	// mu guards the committed state below
+	mu       sync.RWMutex
+	wallets  map[uuid.UUID]model.Wallet
+	txs      []transactionRow
+	seq      int64
+	audit    []model.AuditEvent
+	auditID  int64
+	chains   map[string]string // the hash of each audit chain's last event
+	outbox   []model.OutboxEvent
+	outboxID int64
+	escrows  map[uuid.UUID]model.Escrow
+	adjusts  map[uuid.UUID]model.Adjustment
+}
+
+// transactionRow keeps the insert time next to the formatted created_at, for ordering
+type transactionRow struct {
+	model.Transaction
+	createdAt time.Time
+}
+
+func NewStore() *Store {
+	return &Store{
+		locks:   newLockTable(),
+		wallets: make(map[uuid.UUID]model.Wallet),
+		chains:  make(map[string]string),
+		escrows: make(map[uuid.UUID]model.Escrow),
+		adjusts: make(map[uuid.UUID]model.Adjustment),
+	}
+}
+
+// autocommit runs fn as a single statement transaction, like a query outside BeginTx
+func (s *Store) autocommit(ctx context.Context, fn func(tx *walletTx) error) error {
+	tx := s.begin()
+	if err := fn(tx); err != nil {
+		tx.end(false)
+		return err
+	}
+	tx.end(true)
+	return nil
+}
+
+func (s *Store) CreateWallet(ctx context.Context, wallet *model.Wallet) error {
+	const op = "memory.CreateWallet"
+	return errors.IfInternalError(op, s.autocommit(ctx, func(tx *walletTx) error {
+		return tx.insertWallet(ctx, wallet)
+	}))
+}
+
+func (s *Store) GetWallet(ctx context.Context, id uuid.UUID) (*model.Wallet, error) {
+	const op = "memory.GetWallet"
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wallet, ok := s.wallets[id]
+	if !ok {
+		return nil, errors.NewNotFound(op, "wallet")
+	}
+	return &wallet, nil
+}
+
+func (s *Store) GetWalletByUserAndCurrency(ctx context.Context, userID uuid.UUID, currency string) (*model.Wallet, error) {
+	const op = "memory.GetWalletByUserAndCurrency"
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, wallet := range s.wallets {
+		if wallet.UserID == userID && wallet.Currency == currency && wallet.EscrowID == nil {
+			return &wallet, nil
+		}
+	}
+	return nil, errors.NewNotFound(op, "wallet")
+}
+
+// ListWalletsByUser returns the user's wallets, ordered like the Postgres query
+func (s *Store) ListWalletsByUser(ctx context.Context, userID uuid.UUID) ([]model.Wallet, error) {
+	s.mu.RLock()
+	var wallets []model.Wallet
+	for _, wallet := range s.wallets {
+		if wallet.UserID == userID {
+			wallets = append(wallets, wallet)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(wallets, func(i, j int) bool {
+		a, b := wallets[i], wallets[j]
+		if (a.EscrowID == nil) != (b.EscrowID == nil) {
+			return a.EscrowID == nil
+		}
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		return a.CreatedAt < b.CreatedAt
+	})
+	return wallets, nil
+}
+
+// GetWalletForUpdate outside a transaction waits for the row lock and
+// releases it straight away, as the statement commits on its own
+func (s *Store) GetWalletForUpdate(ctx context.Context, id uuid.UUID) (wallet *model.Wallet, err error) {
+	const op = "memory.GetWalletForUpdate"
+	err = s.autocommit(ctx, func(tx *walletTx) error {
+		wallet, err = tx.GetWalletForUpdate(ctx, id)
+		return err
+	})
+	return wallet, errors.WrapInternal(op, err)
+}
+
+func (s *Store) UpdateWalletBalance(ctx context.Context, id uuid.UUID, newBalance decimal.Decimal, version int) (rows int64, err error) {
+	const op = "memory.UpdateWalletBalance"
+	err = s.autocommit(ctx, func(tx *walletTx) error {
+		rows, err = tx.UpdateWalletBalanceWithVersionTx(ctx, id, newBalance, version)
+		return err
+	})
+	return rows, errors.WrapInternal(op, err)
+}
+
+func (s *Store) CreateTransaction(ctx context.Context, t *model.Transaction) error {
+	const op = "memory.CreateTransaction"
+	return errors.WrapInternal(op, s.autocommit(ctx, func(tx *walletTx) error {
+		return tx.CreateTransactionTx(ctx, t)
+	}))
+}
+
+// GetTransactions lists a wallet's transactions newest first
+func (s *Store) GetTransactions(ctx context.Context, walletID uuid.UUID, offset, limit int) ([]model.Transaction, error) {
+	return s.listTransactions(func(t *transactionRow) bool { return t.WalletID == walletID }, offset, limit), nil
+}
+
+// GetAllTransactions lists a user's transactions across wallets newest first
+func (s *Store) GetAllTransactions(ctx context.Context, userID uuid.UUID, offset, limit int) ([]model.Transaction, error) {
+	return s.listTransactions(func(t *transactionRow) bool { return t.UserID == userID }, offset, limit), nil
+}
+
+func (s *Store) listTransactions(match func(*transactionRow) bool, offset, limit int) []model.Transaction {
+	s.mu.RLock()
+	var rows []transactionRow
+	for i := range s.txs {
+		if match(&s.txs[i]) {
+			rows = append(rows, s.txs[i])
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].createdAt.Equal(rows[j].createdAt) {
+			return rows[i].createdAt.After(rows[j].createdAt)
+		}
+		return rows[i].Seq > rows[j].Seq
+	})
+	var txs []model.Transaction
+	for i := offset; i < len(rows) && len(txs) < limit; i++ {
+		txs = append(txs, rows[i].Transaction)
+	}
+	return txs
+}
+
+func (s *Store) GetAdjustment(ctx context.Context, id uuid.UUID) (*model.Adjustment, error) {
+	const op = "memory.GetAdjustment"
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.adjusts[id]
+	if !ok {
+		return nil, errors.NewNotFound(op, "adjustment")
+	}
+	return &a, nil
+}
+
+func (s *Store) ListAdjustments(ctx context.Context, status string, offset, limit int) ([]model.Adjustment, error) {
+	s.mu.RLock()
+	var adjustments []model.Adjustment
+	for _, a := range s.adjusts {
+		if status == "" || a.Status == status {
+			adjustments = append(adjustments, a)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(adjustments, func(i, j int) bool {
+		return adjustments[i].CreatedAt.After(adjustments[j].CreatedAt)
+	})
+	if offset >= len(adjustments) {
+		return nil, nil
+	}
+	return adjustments[offset:min(offset+limit, len(adjustments))], nil
+}
+
+// AuditEvents returns the committed audit events in commit order, which is
+// the order of every chain
+func (s *Store) AuditEvents() []model.AuditEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]model.AuditEvent(nil), s.audit...)
+}
+
+// OutboxEvents returns the committed outbox events in insertion order
+func (s *Store) OutboxEvents() []model.OutboxEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]model.OutboxEvent(nil), s.outbox...)
+}
+
+// nextAuditID hands out audit event ids, never reused like nextSeq's
+func (s *Store) nextAuditID() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auditID++
+	return s.auditID
+}
+
+// nextSeq hands out transaction sequence numbers, which like a Postgres
+// sequence are never reused, even when the inserting transaction rolls back
+func (s *Store) nextSeq() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	return s.seq
+}
+
+func constraintError(format string, args ...any) error {
+	return fmt.Errorf("constraint violated: "+format, args...)
+}
@@ -0,0 +1,475 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// auditChainLock serialises appends to an audit hash chain, like the
+// advisory lock the Postgres repository takes
+func auditChainLock(chain string) string { return "audit_events:" + chain }
+
+// walletTx buffers its writes until Commit. Reads of rows it wrote see its own
+// version, every other read sees the latest committed row.
+type walletTx struct {
+	s         *Store
+	startedAt time.Time
+	locks     []string
+	done      bool
+
+	wallets map[uuid.UUID]model.Wallet
+	txs     []transactionRow
+	audit   []model.AuditEvent
+	outbox  []model.OutboxEvent
+	escrows map[uuid.UUID]model.Escrow
+	adjusts map[uuid.UUID]model.Adjustment
+}
+
+var _ repository.WalletTx = (*walletTx)(nil)
+
+func (s *Store) BeginTx(ctx context.Context) (repository.WalletTx, error) {
+	return s.begin(), nil
+}
+
+func (s *Store) begin() *walletTx {
+	return &walletTx{
+		s:         s,
+		startedAt: time.Now().UTC(),
+		wallets:   make(map[uuid.UUID]model.Wallet),
+		escrows:   make(map[uuid.UUID]model.Escrow),
+		adjusts:   make(map[uuid.UUID]model.Adjustment),
+	}
+}
+
+func walletLock(id uuid.UUID) string     { return "wallets/" + id.String() }
+func escrowLock(id uuid.UUID) string     { return "escrows/" + id.String() }
+func adjustmentLock(id uuid.UUID) string { return "adjustments/" + id.String() }
+
+// walletOwnerLock stands in for the unique index on (user_id, currency), a
+// second insert of the pair waits for the first transaction to end
+func walletOwnerLock(userID uuid.UUID, currency string) string {
+	return "wallets/" + userID.String() + "/" + currency
+}
+
+func (tx *walletTx) check() error {
+	if tx.done {
+		return fmt.Errorf("transaction has already been committed or rolled back")
+	}
+	return nil
+}
+
+func (tx *walletTx) lock(ctx context.Context, key string) error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	return tx.s.locks.acquire(ctx, tx, key)
+}
+
+func (tx *walletTx) wallet(id uuid.UUID) (model.Wallet, bool) {
+	if w, ok := tx.wallets[id]; ok {
+		return w, true
+	}
+	tx.s.mu.RLock()
+	defer tx.s.mu.RUnlock()
+	w, ok := tx.s.wallets[id]
+	return w, ok
+}
+
+func (tx *walletTx) escrow(id uuid.UUID) (model.Escrow, bool) {
+	if e, ok := tx.escrows[id]; ok {
+		return e, true
+	}
+	tx.s.mu.RLock()
+	defer tx.s.mu.RUnlock()
+	e, ok := tx.s.escrows[id]
+	return e, ok
+}
+
+func (tx *walletTx) adjustment(id uuid.UUID) (model.Adjustment, bool) {
+	if a, ok := tx.adjusts[id]; ok {
+		return a, true
+	}
+	tx.s.mu.RLock()
+	defer tx.s.mu.RUnlock()
+	a, ok := tx.s.adjusts[id]
+	return a, ok
+}
+
+func (tx *walletTx) GetWalletForUpdate(ctx context.Context, id uuid.UUID) (*model.Wallet, error) {
+	const op = "memory.walletTx.GetWalletForUpdate"
+	if err := tx.lock(ctx, walletLock(id)); err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	w, ok := tx.wallet(id)
+	if !ok {
+		return nil, errors.NewNotFound(op, "wallet")
+	}
+	return &w, nil
+}
+
+// UpdateWalletBalanceTx sets the balance and moves the version, as the
+// Postgres statement does. Updating a missing wallet changes nothing.
+func (tx *walletTx) UpdateWalletBalanceTx(ctx context.Context, id uuid.UUID, newBalance decimal.Decimal) error {
+	const op = "memory.walletTx.UpdateWalletBalance"
+	if err := tx.lock(ctx, walletLock(id)); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	w, ok := tx.wallet(id)
+	if !ok {
+		return nil
+	}
+	if newBalance.IsNegative() {
+		return errors.NewInternal(op, constraintError("wallet balance %s is negative", newBalance))
+	}
+	w.Balance = newBalance
+	w.Version++
+	tx.wallets[id] = w
+	return nil
+}
+
+func (tx *walletTx) UpdateWalletBalanceWithVersionTx(ctx context.Context, id uuid.UUID, newBalance decimal.Decimal, version int) (int64, error) {
+	const op = "memory.walletTx.UpdateWalletBalanceWithVersion"
+	if err := tx.lock(ctx, walletLock(id)); err != nil {
+		return 0, errors.NewInternal(op, err)
+	}
+	w, ok := tx.wallet(id)
+	if !ok || w.Version != version {
+		return 0, nil
+	}
+	if newBalance.IsNegative() {
+		return 0, errors.NewInternal(op, constraintError("wallet balance %s is negative", newBalance))
+	}
+	w.Balance = newBalance
+	w.Version++
+	w.UpdatedAt = tx.startedAt.Format(time.RFC3339Nano)
+	tx.wallets[id] = w
+	return 1, nil
+}
+
+// SetWalletFrozenTx moves the version like the Postgres statement
+func (tx *walletTx) SetWalletFrozenTx(ctx context.Context, id uuid.UUID, frozenAt *time.Time, reason string) error {
+	const op = "memory.walletTx.SetWalletFrozen"
+	if err := tx.lock(ctx, walletLock(id)); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	w, ok := tx.wallet(id)
+	if !ok {
+		return nil
+	}
+	w.FrozenAt = frozenAt
+	w.FrozenReason = reason
+	w.Version++
+	w.UpdatedAt = tx.startedAt.Format(time.RFC3339Nano)
+	tx.wallets[id] = w
+	return nil
+}
+
+func (tx *walletTx) CreateWalletTx(ctx context.Context, wallet *model.Wallet) error {
+	const op = "memory.walletTx.CreateWallet"
+	return errors.IfInternalError(op, tx.insertWallet(ctx, wallet))
+}
+
+func (tx *walletTx) insertWallet(ctx context.Context, wallet *model.Wallet) error {
+	if wallet.EscrowID == nil {
+		if err := tx.lock(ctx, walletOwnerLock(wallet.UserID, wallet.Currency)); err != nil {
+			return err
+		}
+	}
+	if err := tx.lock(ctx, walletLock(wallet.ID)); err != nil {
+		return err
+	}
+	if _, exists := tx.wallet(wallet.ID); exists {
+		return constraintError("wallet %s already exists", wallet.ID)
+	}
+	if wallet.EscrowID == nil && tx.ownerHasWallet(wallet.UserID, wallet.Currency) {
+		return constraintError("user %s already has a %s wallet", wallet.UserID, wallet.Currency)
+	}
+	if wallet.Balance.IsNegative() {
+		return constraintError("wallet balance %s is negative", wallet.Balance)
+	}
+
+	w := *wallet
+	w.Version = 0
+	w.CreatedAt = tx.startedAt.Format(time.RFC3339Nano)
+	w.UpdatedAt = w.CreatedAt
+	tx.wallets[w.ID] = w
+	return nil
+}
+
+func (tx *walletTx) ownerHasWallet(userID uuid.UUID, currency string) bool {
+	owns := func(w model.Wallet) bool {
+		return w.UserID == userID && w.Currency == currency && w.EscrowID == nil
+	}
+	for _, w := range tx.wallets {
+		if owns(w) {
+			return true
+		}
+	}
+	tx.s.mu.RLock()
+	defer tx.s.mu.RUnlock()
+	for _, w := range tx.s.wallets {
+		if owns(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateTransactionTx records the row with created_at set to the start of the
+// transaction, like NOW(), and leaves t itself untouched
+func (tx *walletTx) CreateTransactionTx(ctx context.Context, t *model.Transaction) error {
+	const op = "memory.walletTx.CreateTransaction"
+	if err := tx.check(); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	if _, ok := tx.wallet(t.WalletID); !ok {
+		return errors.NewInternal(op, constraintError("wallet %s of transaction %s does not exist", t.WalletID, t.ID))
+	}
+	if t.Amount.IsZero() {
+		return errors.NewInternal(op, constraintError("transaction %s has a zero amount", t.ID))
+	}
+
+	row := transactionRow{Transaction: *t, createdAt: tx.startedAt}
+	row.Seq = tx.s.nextSeq()
+	row.CreatedAt = tx.startedAt.Format(time.RFC3339Nano)
+	tx.txs = append(tx.txs, row)
+	return nil
+}
+
+// CreateAuditEventTx links the event to the end of its chain and holds the
+// chain until the transaction ends
+func (tx *walletTx) CreateAuditEventTx(ctx context.Context, event *model.AuditEvent) error {
+	const op = "memory.walletTx.CreateAuditEvent"
+	if err := tx.lock(ctx, auditChainLock(event.Chain)); err != nil {
+		return errors.NewInternal(op, err)
+	}
+
+	prevHash, found := audit.GenesisHash, false
+	for i := len(tx.audit) - 1; i >= 0 && !found; i-- {
+		if tx.audit[i].Chain == event.Chain {
+			prevHash, found = tx.audit[i].Hash, true
+		}
+	}
+	if !found {
+		tx.s.mu.RLock()
+		if head, ok := tx.s.chains[event.Chain]; ok {
+			prevHash = head
+		}
+		tx.s.mu.RUnlock()
+	}
+	event.PrevHash = prevHash
+	event.Hash = audit.ComputeHash(prevHash, event)
+	event.ID = tx.s.nextAuditID()
+	tx.audit = append(tx.audit, *event)
+	return nil
+}
+
+func (tx *walletTx) CreateOutboxEventTx(ctx context.Context, event *model.OutboxEvent) error {
+	const op = "memory.walletTx.CreateOutboxEvent"
+	if err := tx.check(); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	e := *event
+	e.NextAttemptAt = tx.startedAt
+	e.CreatedAt = tx.startedAt
+	tx.outbox = append(tx.outbox, e)
+	return nil
+}
+
+func (tx *walletTx) CreateEscrowTx(ctx context.Context, escrow *model.Escrow) error {
+	const op = "memory.walletTx.CreateEscrow"
+	if err := tx.lock(ctx, escrowLock(escrow.ID)); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	if _, exists := tx.escrow(escrow.ID); exists {
+		return errors.NewInternal(op, constraintError("escrow %s already exists", escrow.ID))
+	}
+	if _, ok := tx.wallet(escrow.WalletID); !ok {
+		return errors.NewInternal(op, constraintError("wallet %s of escrow %s does not exist", escrow.WalletID, escrow.ID))
+	}
+	tx.escrows[escrow.ID] = *escrow
+	return nil
+}
+
+func (tx *walletTx) GetEscrowForUpdate(ctx context.Context, id uuid.UUID) (*model.Escrow, error) {
+	const op = "memory.walletTx.GetEscrowForUpdate"
+	if err := tx.lock(ctx, escrowLock(id)); err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	e, ok := tx.escrow(id)
+	if !ok {
+		return nil, errors.NewNotFound(op, "escrow")
+	}
+	return &e, nil
+}
+
+func (tx *walletTx) ResolveEscrowTx(ctx context.Context, escrow *model.Escrow) error {
+	const op = "memory.walletTx.ResolveEscrow"
+	if err := tx.lock(ctx, escrowLock(escrow.ID)); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	e, ok := tx.escrow(escrow.ID)
+	if !ok {
+		return nil
+	}
+	e.Status = escrow.Status
+	e.ReleasedAmount = escrow.ReleasedAmount
+	e.RefundedAmount = escrow.RefundedAmount
+	e.ResolvedBy = escrow.ResolvedBy
+	e.ResolvedAt = escrow.ResolvedAt
+	e.UpdatedAt = tx.startedAt
+	tx.escrows[e.ID] = e
+	return nil
+}
+
+func (tx *walletTx) CreateAdjustmentTx(ctx context.Context, adjustment *model.Adjustment) error {
+	const op = "memory.walletTx.CreateAdjustment"
+	if err := tx.lock(ctx, adjustmentLock(adjustment.ID)); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	if _, exists := tx.adjustment(adjustment.ID); exists {
+		return errors.NewInternal(op, constraintError("adjustment %s already exists", adjustment.ID))
+	}
+	if _, ok := tx.wallet(adjustment.WalletID); !ok {
+		return errors.NewInternal(op, constraintError("wallet %s of adjustment %s does not exist", adjustment.WalletID, adjustment.ID))
+	}
+	if err := checkAdjustment(adjustment); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	tx.adjusts[adjustment.ID] = *adjustment
+	return nil
+}
+
+func (tx *walletTx) GetAdjustmentForUpdate(ctx context.Context, id uuid.UUID) (*model.Adjustment, error) {
+	const op = "memory.walletTx.GetAdjustmentForUpdate"
+	if err := tx.lock(ctx, adjustmentLock(id)); err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	a, ok := tx.adjustment(id)
+	if !ok {
+		return nil, errors.NewNotFound(op, "adjustment")
+	}
+	return &a, nil
+}
+
+func (tx *walletTx) ResolveAdjustmentTx(ctx context.Context, adjustment *model.Adjustment) error {
+	const op = "memory.walletTx.ResolveAdjustment"
+	if err := tx.lock(ctx, adjustmentLock(adjustment.ID)); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	a, ok := tx.adjustment(adjustment.ID)
+	if !ok {
+		return nil
+	}
+	a.Status = adjustment.Status
+	a.ReviewedBy = adjustment.ReviewedBy
+	a.TransactionID = adjustment.TransactionID
+	a.ResolvedAt = adjustment.ResolvedAt
+	a.UpdatedAt = tx.startedAt
+	if err := checkAdjustment(&a); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	tx.adjusts[a.ID] = a
+	return nil
+}
+
+func (tx *walletTx) UnreviewedAdjustmentTotal(ctx context.Context, walletID uuid.UUID, since time.Time) (decimal.Decimal, error) {
+	const op = "memory.walletTx.UnreviewedAdjustmentTotal"
+	if err := tx.check(); err != nil {
+		return decimal.Zero, errors.NewInternal(op, err)
+	}
+	adjustments := maps.Clone(tx.adjusts)
+	tx.s.mu.RLock()
+	for id, a := range tx.s.adjusts {
+		if _, written := adjustments[id]; !written {
+			adjustments[id] = a
+		}
+	}
+	tx.s.mu.RUnlock()
+
+	total := decimal.Zero
+	for _, a := range adjustments {
+		if a.WalletID == walletID && !a.CreatedAt.Before(since) && a.Status == model.AdjustmentApplied && a.ReviewedBy == nil {
+			total = total.Add(a.Amount.Abs())
+		}
+	}
+	return total, nil
+}
+
+// checkAdjustment enforces the CHECK constraints of the adjustments table
+func checkAdjustment(a *model.Adjustment) error {
+	if a.Amount.IsZero() {
+		return constraintError("adjustment %s has a zero amount", a.ID)
+	}
+	if a.ReviewedBy != nil && *a.ReviewedBy == a.RequestedBy {
+		return constraintError("adjustment %s is reviewed by its requester", a.ID)
+	}
+	if (a.Status == model.AdjustmentApplied) != (a.TransactionID != nil) {
+		return constraintError("adjustment %s is %s with transaction %v", a.ID, a.Status, a.TransactionID)
+	}
+	return nil
+}
+
+func (tx *walletTx) Commit() error {
+	const op = "memory.walletTx.Commit"
+	if err := tx.check(); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	tx.end(true)
+	return nil
+}
+
+func (tx *walletTx) Rollback() error {
+	const op = "memory.walletTx.Rollback"
+	if err := tx.check(); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	tx.end(false)
+	return nil
+}
+
+// end publishes the writes when commit is set, then releases the locks, so
+// a waiter always reads what the lock holder committed
+func (tx *walletTx) end(commit bool) {
+	tx.done = true
+	if commit {
+		s := tx.s
+		s.mu.Lock()
+		for id, w := range tx.wallets {
+			s.wallets[id] = w
+		}
+		s.txs = append(s.txs, tx.txs...)
+		s.audit = append(s.audit, tx.audit...)
+		for _, e := range tx.audit {
+			s.chains[e.Chain] = e.Hash
+		}
+		for _, e := range tx.outbox {
+			s.outboxID++
+			e.ID = s.outboxID
+			s.outbox = append(s.outbox, e)
+		}
+		for id, e := range tx.escrows {
+			s.escrows[id] = e
+		}
+		for id, a := range tx.adjusts {
+			s.adjusts[id] = a
+		}
+		s.mu.Unlock()
+	}
+	tx.s.locks.releaseAll(tx)
+}
+
+func (tx *walletTx) TransitionPaymentRequestTx(ctx context.Context, id uuid.UUID, from, to string) (bool, error) {
+	const op = "memory.walletTx.TransitionPaymentRequest"
+	// payment requests need the database, the memory store keeps none
+	return false, errors.NewNotFound(op, "payment request")
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/jmoiron/sqlx"
+)
+
+type OutboxRepository interface {
+	ClaimBatch(ctx context.Context, limit int) (OutboxBatch, error)
+	TxOutboxRepository
+}
+
+type TxOutboxRepository interface {
+	CreateOutboxEventTx(ctx context.Context, tx *sqlx.Tx, event *model.OutboxEvent) error
+}
+
+// OutboxBatch holds row locks on claimed events until it is committed or rolled back
+type OutboxBatch interface {
+	Events() []model.OutboxEvent
+	MarkPublished(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, nextAttemptAt time.Time, reason string) error
+	Commit() error
+	Rollback() error
+}
+
+type outboxRepo struct {
+	db *sqlx.DB
+}
+
+func NewOutboxRepository(db *sqlx.DB) OutboxRepository {
+	return &outboxRepo{db: db}
+}
+
+func (r *outboxRepo) CreateOutboxEventTx(ctx context.Context, tx *sqlx.Tx, event *model.OutboxEvent) error {
+	const op = "outbox.CreateTx"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "outbox_events")
+
+	_, err := tx.NamedExecContext(ctx, `
+        INSERT INTO outbox_events
+        (event_id, aggregate_type, aggregate_id, event_type, payload)
+        VALUES (:event_id, :aggregate_type, :aggregate_id, :event_type, :payload)`,
+		event)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+// ClaimBatch locks the oldest unpublished event of each aggregate that is due.
+// Later events of an aggregate are not eligible until every earlier one is
+// published, which keeps delivery ordered per wallet across relay instances.
+func (r *outboxRepo) ClaimBatch(ctx context.Context, limit int) (OutboxBatch, error) {
+	const op = "outbox.ClaimBatch"
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "outbox_events")
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, errors.NewInternal(op, err)
+	}
+
+	var events []model.OutboxEvent
+	err = tx.SelectContext(ctx, &events, `
+        SELECT * FROM outbox_events o
+        WHERE o.published_at IS NULL
+          AND o.next_attempt_at <= NOW()
+          AND NOT EXISTS (
+              SELECT 1 FROM outbox_events p
+              WHERE p.aggregate_id = o.aggregate_id
+                AND p.published_at IS NULL
+                AND p.id < o.id)
+        ORDER BY o.id
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED`,
+		limit)
+	span.SetAttributes(tracing.AttrRowsAffected.Int64(int64(len(events))))
+	tracing.End(span, err)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.NewInternal(op, err)
+	}
+	return &outboxBatch{tx: tx, events: events}, nil
+}
+
+type outboxBatch struct {
+	tx     *sqlx.Tx
+	events []model.OutboxEvent
+}
+
+func (b *outboxBatch) Events() []model.OutboxEvent {
+	return b.events
+}
+
+func (b *outboxBatch) MarkPublished(ctx context.Context, id int64) error {
+	const op = "outbox.MarkPublished"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "outbox_events")
+
+	_, err := b.tx.ExecContext(ctx,
+		`UPDATE outbox_events SET published_at = NOW(), attempts = attempts + 1, last_error = NULL WHERE id = $1`,
+		id)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+func (b *outboxBatch) MarkFailed(ctx context.Context, id int64, nextAttemptAt time.Time, reason string) error {
+	const op = "outbox.MarkFailed"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "outbox_events")
+
+	_, err := b.tx.ExecContext(ctx,
+		`UPDATE outbox_events SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3 WHERE id = $1`,
+		id, nextAttemptAt, reason)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+func (b *outboxBatch) Commit() error {
+	const op = "outbox.Commit"
+	return errors.IfInternalError(op, b.tx.Commit())
+}
+
+func (b *outboxBatch) Rollback() error {
+	const op = "outbox.Rollback"
+	return errors.IfInternalError(op, b.tx.Rollback())
+}
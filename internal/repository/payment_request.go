@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type PaymentRequestRepository interface {
+	CreatePaymentRequest(ctx context.Context, req *model.PaymentRequest) error
+	GetPaymentRequest(ctx context.Context, id uuid.UUID) (*model.PaymentRequest, error)
+	// ListPaymentRequests lists the requests the user has to pay (incoming) or
+	// has sent (outgoing), newest first, optionally only those with status.
+	ListPaymentRequests(ctx context.Context, userID uuid.UUID, direction, status string, offset, limit int) ([]model.PaymentRequest, error)
+	// TransitionPaymentRequest moves the request from one status to another and
+	// reports whether it was in the from status. A pending request past its
+	// expiry no longer moves.
+	TransitionPaymentRequest(ctx context.Context, id uuid.UUID, from, to string) (bool, error)
+	// ExpirePaymentRequests marks pending requests past their expiry as expired
+	ExpirePaymentRequests(ctx context.Context) (int64, error)
+}
+
+// TxPaymentRequestRepository moves requests inside the DB transaction paying them
+type TxPaymentRequestRepository interface {
+	TransitionPaymentRequestTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID, from, to string) (bool, error)
+}
+
+type paymentRequestRepo struct {
+	db *sqlx.DB
+}
+
+func NewPaymentRequestRepository(db *sqlx.DB) PaymentRequestRepository {
+	return &paymentRequestRepo{db: db}
+}
+
+func (r *paymentRequestRepo) CreatePaymentRequest(ctx context.Context, req *model.PaymentRequest) error {
+	const op = "paymentRequest.Create"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "payment_requests")
+
+	_, err := r.db.NamedExecContext(ctx, `
+        INSERT INTO payment_requests (id, requester_id, payer_id, amount, currency, note, status, expires_at, created_at, updated_at)
+        VALUES (:id, :requester_id, :payer_id, :amount, :currency, :note, :status, :expires_at, :created_at, :updated_at)`,
+		req)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+func (r *paymentRequestRepo) GetPaymentRequest(ctx context.Context, id uuid.UUID) (*model.PaymentRequest, error) {
+	const op = "paymentRequest.Get"
+	var req model.PaymentRequest
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "payment_requests")
+
+	err := r.db.GetContext(ctx, &req, `SELECT * FROM payment_requests WHERE id = $1`, id)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "payment request")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &req, nil
+}
+
+func (r *paymentRequestRepo) ListPaymentRequests(ctx context.Context, userID uuid.UUID, direction, status string, offset, limit int) ([]model.PaymentRequest, error) {
+	const op = "paymentRequest.List"
+	var reqs []model.PaymentRequest
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "payment_requests")
+
+	column := "payer_id"
+	if direction == model.PaymentRequestsOutgoing {
+		column = "requester_id"
+	}
+	err := r.db.SelectContext(ctx, &reqs, `
+        SELECT * FROM payment_requests
+        WHERE `+column+` = $1 AND ($2::text = '' OR status = $2::text)
+        ORDER BY created_at DESC
+        LIMIT $3 OFFSET $4`,
+		userID, status, limit, offset)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return reqs, nil
+}
+
+func (r *paymentRequestRepo) TransitionPaymentRequest(ctx context.Context, id uuid.UUID, from, to string) (bool, error) {
+	return r.transition(ctx, r.db, "paymentRequest.Transition", id, from, to)
+}
+
+func (r *paymentRequestRepo) TransitionPaymentRequestTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID, from, to string) (bool, error) {
+	return r.transition(ctx, tx, "paymentRequest.TransitionTx", id, from, to)
+}
+
+func (r *paymentRequestRepo) transition(ctx context.Context, db sqlx.ExecerContext, op string, id uuid.UUID, from, to string) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "payment_requests")
+
+	// the status check makes this a compare-and-set, of two concurrent accepts
+	// only one matches, the other waits for its row lock and then doesn't
+	result, err := db.ExecContext(ctx, `
+        UPDATE payment_requests
+        SET status = $3::text,
+            resolved_at = CASE WHEN $3::text = 'pending' THEN NULL ELSE NOW() END,
+            updated_at = NOW()
+        WHERE id = $1 AND status = $2::text AND ($2::text <> 'pending' OR expires_at > NOW())`,
+		id, from, to)
+	var rows int64
+	if err == nil {
+		rows, err = result.RowsAffected()
+		span.SetAttributes(tracing.AttrRowsAffected.Int64(rows))
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return false, errors.NewInternal(op, err)
+	}
+	return rows == 1, nil
+}
+
+func (r *paymentRequestRepo) ExpirePaymentRequests(ctx context.Context) (int64, error) {
+	const op = "paymentRequest.Expire"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "payment_requests")
+
+	result, err := r.db.ExecContext(ctx, `
+        UPDATE payment_requests
+        SET status = 'expired', resolved_at = expires_at, updated_at = NOW()
+        WHERE status = 'pending' AND expires_at <= NOW()`)
+	var rows int64
+	if err == nil {
+		rows, err = result.RowsAffected()
+		span.SetAttributes(tracing.AttrRowsAffected.Int64(rows))
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return 0, errors.NewInternal(op, err)
+	}
+	return rows, nil
+}
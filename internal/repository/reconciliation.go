@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type ReconciliationRepository interface {
+	// Reconcile checks wallets against the transaction log on one consistent
+	// snapshot, listing at most limit discrepancies of each kind.
+	Reconcile(ctx context.Context, limit int) (*model.ReconciliationReport, error)
+}
+
+type reconciliationRepo struct {
+	db *sqlx.DB
+}
+
+func NewReconciliationRepository(db *sqlx.DB) ReconciliationRepository {
+	return &reconciliationRepo{db: db}
+}
+
+// reconciliationChecks select the wallet_id, transaction_id, currency, expected,
+// actual and detail of each discrepancy, with the count of all of them as total.
+// $1 is the row limit, $2 the linked transaction types where the check is linked.
+// A wallet's ledger starts from its opening_balance.
+var reconciliationChecks = []struct {
+	kind   string
+	linked bool
+	query  string
+}{
+	{model.DiscrepancyBalanceMismatch, false, `
+        SELECT w.id AS wallet_id, t.id AS transaction_id, w.currency,
+               COALESCE(t.balance_after, w.opening_balance) AS expected, w.balance AS actual,
+               CASE WHEN t.id IS NULL THEN 'wallet has no transactions and differs from its opening balance'
+                    ELSE 'wallet balance differs from the last balance_after' END AS detail,
+               COUNT(*) OVER () AS total
+        FROM wallets w
+        LEFT JOIN LATERAL (
+            SELECT id, balance_after FROM transactions
+            WHERE wallet_id = w.id
+            ORDER BY seq DESC
+            LIMIT 1
+        ) t ON TRUE
+        WHERE w.balance <> COALESCE(t.balance_after, w.opening_balance)
+        ORDER BY w.id
+        LIMIT $1`},
+	{model.DiscrepancyBrokenChain, false, `
+        SELECT wallet_id, id AS transaction_id, currency,
+               CASE WHEN balance_before <> previous THEN previous ELSE balance_before + amount END AS expected,
+               CASE WHEN balance_before <> previous THEN balance_before ELSE balance_after END AS actual,
+               CASE WHEN balance_before <> previous AND first THEN 'balance_before differs from the opening balance'
+                    WHEN balance_before <> previous THEN 'balance_before differs from the previous balance_after'
+                    ELSE 'balance_before + amount differs from balance_after' END AS detail,
+               COUNT(*) OVER () AS total
+        FROM (
+            SELECT t.id, t.wallet_id, COALESCE(t.currency, '') AS currency, t.amount, t.balance_before, t.balance_after, t.seq,
+                   LAG(t.balance_after, 1, w.opening_balance) OVER (PARTITION BY t.wallet_id ORDER BY t.seq) AS previous,
+                   LAG(t.seq) OVER (PARTITION BY t.wallet_id ORDER BY t.seq) IS NULL AS first
+            FROM transactions t
+            JOIN wallets w ON w.id = t.wallet_id
+        ) t
+        WHERE balance_before <> previous OR balance_before + amount <> balance_after
+        ORDER BY wallet_id, seq
+        LIMIT $1`},
+	{model.DiscrepancyUnpairedTransfer, true, `
+        SELECT wallet_id, id AS transaction_id, currency,
+               -amount AS expected, 0 AS actual, detail,
+               COUNT(*) OVER () AS total
+        FROM (
+            SELECT c.id, c.wallet_id, COALESCE(c.currency, '') AS currency, c.amount, c.seq,
+                   'credit leg has no debit leg' AS detail
+            FROM transactions c
+            LEFT JOIN transactions d ON d.id = c.related_tx_id
+            WHERE c.type = ANY($2) AND c.amount > 0 AND d.id IS NULL
+            UNION ALL
+            SELECT d.id, d.wallet_id, COALESCE(d.currency, ''), d.amount, d.seq,
+                   'debit leg has no credit leg'
+            FROM transactions d
+            WHERE d.type = ANY($2) AND d.amount < 0
+              AND NOT EXISTS (SELECT 1 FROM transactions c WHERE c.related_tx_id = d.id)
+        ) legs
+        ORDER BY seq
+        LIMIT $1`},
+	{model.DiscrepancyTransferImbalance, true, `
+        SELECT c.wallet_id, c.id AS transaction_id, COALESCE(c.currency, '') AS currency,
+               -d.amount AS expected, c.amount AS actual,
+               CASE WHEN c.currency IS DISTINCT FROM d.currency THEN 'legs are in different currencies'
+                    WHEN c.type <> d.type THEN 'legs have different types'
+                    ELSE 'legs don''t net to zero' END AS detail,
+               COUNT(*) OVER () AS total
+        FROM transactions c
+        JOIN transactions d ON d.id = c.related_tx_id
+        WHERE c.type = ANY($2) AND c.amount > 0
+          AND (c.amount + d.amount <> 0 OR c.currency IS DISTINCT FROM d.currency OR c.type <> d.type)
+        ORDER BY c.seq
+        LIMIT $1`},
+	{model.DiscrepancyCurrencyTotal, false, `
+        SELECT NULL::uuid AS wallet_id, NULL::uuid AS transaction_id, currency,
+               COALESCE(w.opening, 0) + COALESCE(t.total, 0) AS expected, COALESCE(w.total, 0) AS actual,
+               'wallet balances differ from the opening balances plus the net of all transactions' AS detail,
+               COUNT(*) OVER () AS total
+        FROM (SELECT currency, SUM(balance) AS total, SUM(opening_balance) AS opening FROM wallets GROUP BY currency) w
+        FULL JOIN (
+            SELECT COALESCE(currency, '') AS currency, SUM(amount) AS total
+            FROM transactions
+            GROUP BY 1
+        ) t USING (currency)
+        WHERE COALESCE(w.total, 0) <> COALESCE(w.opening, 0) + COALESCE(t.total, 0)
+        ORDER BY currency
+        LIMIT $1`},
+}
+
+type discrepancyRow struct {
+	model.Discrepancy
+	Total int64 `db:"total"`
+}
+
+func (r *reconciliationRepo) Reconcile(ctx context.Context, limit int) (_ *model.ReconciliationReport, err error) {
+	const op = "reconciliation.Reconcile"
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "transactions")
+	defer func() { tracing.End(span, err) }()
+
+	// one snapshot for all checks, so concurrent writes don't show up as drift
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	defer tx.Rollback()
+
+	report := &model.ReconciliationReport{
+		Counts:        make(map[string]int64, len(reconciliationChecks)),
+		Discrepancies: []model.Discrepancy{},
+	}
+	if err := tx.GetContext(ctx, &report.Wallets, `SELECT COUNT(*) FROM wallets`); err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	if err := tx.GetContext(ctx, &report.Transactions, `SELECT COUNT(*) FROM transactions`); err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+
+	for _, check := range reconciliationChecks {
+		args := []any{limit}
+		if check.linked {
+			args = append(args, pq.Array(model.LinkedTransactionTypes))
+		}
+		var rows []discrepancyRow
+		if err := tx.SelectContext(ctx, &rows, check.query, args...); err != nil {
+			return nil, errors.NewInternal(op+"."+check.kind, err)
+		}
+		report.Counts[check.kind] = 0
+		if len(rows) > 0 {
+			report.Counts[check.kind] = rows[0].Total
+			report.Truncated = report.Truncated || rows[0].Total > int64(len(rows))
+		}
+		for _, row := range rows {
+			row.Kind = check.kind
+			report.Discrepancies = append(report.Discrepancies, row.Discrepancy)
+		}
+	}
+	return report, nil
+}
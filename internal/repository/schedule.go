@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type ScheduleRepository interface {
+	CreateSchedule(ctx context.Context, schedule *model.TransferSchedule) error
+	GetSchedule(ctx context.Context, id uuid.UUID) (*model.TransferSchedule, error)
+	ListSchedules(ctx context.Context, userID uuid.UUID) ([]model.TransferSchedule, error)
+	// SetScheduleStatus moves the schedule to status if it is currently in one
+	// of from, reporting whether it was.
+	SetScheduleStatus(ctx context.Context, id uuid.UUID, from []string, status string, nextRunAt *time.Time) (bool, error)
+
+	ClaimDueSchedules(ctx context.Context, limit int, lease time.Duration) ([]model.ClaimedSchedule, error)
+	RecordRun(ctx context.Context, schedule *model.TransferSchedule, run *model.ScheduleRun) error
+	ListRuns(ctx context.Context, scheduleID uuid.UUID, offset, limit int) ([]model.ScheduleRun, error)
+}
+
+type scheduleRepo struct {
+	db *sqlx.DB
+}
+
+func NewScheduleRepository(db *sqlx.DB) ScheduleRepository {
+	return &scheduleRepo{db: db}
+}
+
+func (r *scheduleRepo) CreateSchedule(ctx context.Context, schedule *model.TransferSchedule) error {
+	const op = "schedule.Create"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "transfer_schedules")
+
+	_, err := r.db.NamedExecContext(ctx, `
+        INSERT INTO transfer_schedules
+        (id, user_id, to_user_id, amount, currency, reference, kind, cron_expr, interval_seconds, timezone,
+         start_at, end_at, status, next_run_at, max_retries, retry_interval_seconds, created_at, updated_at)
+        VALUES (:id, :user_id, :to_user_id, :amount, :currency, :reference, :kind, :cron_expr, :interval_seconds, :timezone,
+                :start_at, :end_at, :status, :next_run_at, :max_retries, :retry_interval_seconds, :created_at, :updated_at)`,
+		schedule)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+func (r *scheduleRepo) GetSchedule(ctx context.Context, id uuid.UUID) (*model.TransferSchedule, error) {
+	const op = "schedule.Get"
+	var schedule model.TransferSchedule
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "transfer_schedules")
+
+	err := r.db.GetContext(ctx, &schedule, `SELECT * FROM transfer_schedules WHERE id = $1`, id)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "transfer schedule")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &schedule, nil
+}
+
+func (r *scheduleRepo) ListSchedules(ctx context.Context, userID uuid.UUID) ([]model.TransferSchedule, error) {
+	const op = "schedule.List"
+	var schedules []model.TransferSchedule
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "transfer_schedules")
+
+	err := r.db.SelectContext(ctx, &schedules, `
+        SELECT * FROM transfer_schedules
+        WHERE user_id = $1
+        ORDER BY created_at`,
+		userID)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return schedules, nil
+}
+
+func (r *scheduleRepo) SetScheduleStatus(ctx context.Context, id uuid.UUID, from []string, status string, nextRunAt *time.Time) (bool, error) {
+	const op = "schedule.SetStatus"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "transfer_schedules")
+
+	result, err := r.db.ExecContext(ctx, `
+        UPDATE transfer_schedules
+        SET status = $3, next_run_at = $4, attempt = 0, updated_at = NOW()
+        WHERE id = $1 AND status = ANY($2)`,
+		id, pq.StringArray(from), status, nextRunAt)
+	var rows int64
+	if err == nil {
+		rows, err = result.RowsAffected()
+		span.SetAttributes(tracing.AttrRowsAffected.Int64(rows))
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return false, errors.NewInternal(op, err)
+	}
+	return rows == 1, nil
+}
+
+// ClaimDueSchedules leases due schedules to the caller. A schedule whose lease
+// ran out without a recorded run comes back with Interrupted set, since the
+// transfer may or may not have happened.
+func (r *scheduleRepo) ClaimDueSchedules(ctx context.Context, limit int, lease time.Duration) ([]model.ClaimedSchedule, error) {
+	const op = "schedule.ClaimDue"
+	var schedules []model.ClaimedSchedule
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "transfer_schedules")
+
+	err := r.db.SelectContext(ctx, &schedules, `
+        WITH due AS (
+            SELECT id, locked_until IS NOT NULL AS interrupted
+            FROM transfer_schedules
+            WHERE status = 'active' AND next_run_at <= NOW()
+              AND (locked_until IS NULL OR locked_until < NOW())
+            ORDER BY next_run_at
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED)
+        UPDATE transfer_schedules s
+        SET locked_until = NOW() + $2 * INTERVAL '1 millisecond'
+        FROM due
+        WHERE s.id = due.id
+        RETURNING s.*, due.interrupted`,
+		limit, lease.Milliseconds())
+	span.SetAttributes(tracing.AttrRowsAffected.Int64(int64(len(schedules))))
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return schedules, nil
+}
+
+// RecordRun appends to the run log, stores the schedule's new state and
+// releases its lease. A schedule paused or cancelled meanwhile keeps that status.
+func (r *scheduleRepo) RecordRun(ctx context.Context, schedule *model.TransferSchedule, run *model.ScheduleRun) error {
+	const op = "schedule.RecordRun"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "transfer_schedules")
+
+	err := r.recordRun(ctx, schedule, run)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+func (r *scheduleRepo) recordRun(ctx context.Context, schedule *model.TransferSchedule, run *model.ScheduleRun) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.NamedExecContext(ctx, `
+        INSERT INTO transfer_schedule_runs (schedule_id, scheduled_for, attempt, status, error, executed_at)
+        VALUES (:schedule_id, :scheduled_for, :attempt, :status, :error, :executed_at)`,
+		run); err != nil {
+		return err
+	}
+	if _, err := tx.NamedExecContext(ctx, `
+        UPDATE transfer_schedules
+        SET status = CASE WHEN status = 'active' THEN :status ELSE status END,
+            next_run_at = CASE WHEN status = 'active' THEN :next_run_at ELSE next_run_at END,
+            last_run_at = :last_run_at, runs = :runs, attempt = :attempt, last_error = :last_error,
+            locked_until = NULL, updated_at = NOW()
+        WHERE id = :id`,
+		schedule); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *scheduleRepo) ListRuns(ctx context.Context, scheduleID uuid.UUID, offset, limit int) ([]model.ScheduleRun, error) {
+	const op = "schedule.ListRuns"
+	var runs []model.ScheduleRun
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "transfer_schedule_runs")
+
+	err := r.db.SelectContext(ctx, &runs, `
+        SELECT * FROM transfer_schedule_runs
+        WHERE schedule_id = $1
+        ORDER BY id DESC
+        LIMIT $2 OFFSET $3`,
+		scheduleID, limit, offset)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return runs, nil
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// settlementLineChunk keeps one insert well below the 65535 parameter limit
+const settlementLineChunk = 1000
+
+type SettlementRepository interface {
+	GetSettlement(ctx context.Context, id uuid.UUID) (*model.Settlement, error)
+	GetSettlementByHash(ctx context.Context, fileHash string) (*model.Settlement, error)
+	ListSettlementLines(ctx context.Context, settlementID uuid.UUID, status string, offset, limit int) ([]model.SettlementLine, error)
+	// FindSettlementCandidates returns the deposits and withdrawals carrying
+	// any of the references, in ledger order
+	FindSettlementCandidates(ctx context.Context, references []string) ([]model.Transaction, error)
+	// CreateSettlement stores the settlement with its lines and marks the
+	// transactions of matched lines settled, all or nothing. It fails with a
+	// conflict when the file was imported before or a matched transaction was
+	// settled in the meantime.
+	CreateSettlement(ctx context.Context, settlement *model.Settlement, lines []model.SettlementLine) error
+}
+
+type settlementRepo struct {
+	db *sqlx.DB
+}
+
+func NewSettlementRepository(db *sqlx.DB) SettlementRepository {
+	return &settlementRepo{db: db}
+}
+
+func (r *settlementRepo) GetSettlement(ctx context.Context, id uuid.UUID) (*model.Settlement, error) {
+	const op = "settlement.Get"
+	var settlement model.Settlement
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "settlements")
+
+	err := r.db.GetContext(ctx, &settlement, `SELECT * FROM settlements WHERE id = $1`, id)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "settlement")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &settlement, nil
+}
+
+func (r *settlementRepo) GetSettlementByHash(ctx context.Context, fileHash string) (*model.Settlement, error) {
+	const op = "settlement.GetByHash"
+	var settlement model.Settlement
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "settlements")
+
+	err := r.db.GetContext(ctx, &settlement, `SELECT * FROM settlements WHERE file_hash = $1`, fileHash)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "settlement")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &settlement, nil
+}
+
+func (r *settlementRepo) ListSettlementLines(ctx context.Context, settlementID uuid.UUID, status string, offset, limit int) ([]model.SettlementLine, error) {
+	const op = "settlement.ListLines"
+	var lines []model.SettlementLine
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "settlement_lines")
+
+	err := r.db.SelectContext(ctx, &lines, `
+        SELECT * FROM settlement_lines
+        WHERE settlement_id = $1 AND ($2::text = '' OR status = $2::text)
+        ORDER BY position
+        OFFSET $3 LIMIT $4`,
+		settlementID, status, offset, limit)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return lines, nil
+}
+
+func (r *settlementRepo) FindSettlementCandidates(ctx context.Context, references []string) ([]model.Transaction, error) {
+	const op = "settlement.FindCandidates"
+	var txs []model.Transaction
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "transactions")
+
+	err := r.db.SelectContext(ctx, &txs, `
+        SELECT * FROM transactions
+        WHERE reference = ANY($1) AND type IN ('deposit', 'withdrawal')
+        ORDER BY seq`,
+		pq.Array(references))
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return txs, nil
+}
+
+func (r *settlementRepo) CreateSettlement(ctx context.Context, settlement *model.Settlement, lines []model.SettlementLine) (err error) {
+	const op = "settlement.Create"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "settlements")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.NewInternal(op, err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.NamedExecContext(ctx, `
+        INSERT INTO settlements (id, source, format, file_hash, line_count, matched, unmatched, mismatched, duplicate, created_at)
+        VALUES (:id, :source, :format, :file_hash, :line_count, :matched, :unmatched, :mismatched, :duplicate, :created_at)
+        ON CONFLICT (file_hash) DO NOTHING`,
+		settlement)
+	if err != nil {
+		return errors.NewInternal(op, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.NewInternal(op, err)
+	}
+	if rows != 1 {
+		return errors.NewConflict(op, "settlement file was already imported")
+	}
+
+	matched := []string{}
+	for start := 0; start < len(lines); start += settlementLineChunk {
+		chunk := lines[start:min(start+settlementLineChunk, len(lines))]
+		if _, err := tx.NamedExecContext(ctx, `
+            INSERT INTO settlement_lines (settlement_id, position, line_number, reference, direction, amount, currency,
+                                          value_date, status, transaction_id, detail)
+            VALUES (:settlement_id, :position, :line_number, :reference, :direction, :amount, :currency,
+                    :value_date, :status, :transaction_id, :detail)`,
+			chunk); err != nil {
+			return errors.NewInternal(op, err)
+		}
+		for _, line := range chunk {
+			if line.Status == model.SettlementMatched {
+				matched = append(matched, line.TransactionID.String())
+			}
+		}
+	}
+
+	// a transaction settled by a concurrent import since matching fails the check
+	result, err = tx.ExecContext(ctx, `
+        UPDATE transactions SET settled_at = $2, settlement_id = $1
+        WHERE id = ANY($3::uuid[]) AND settlement_id IS NULL`,
+		settlement.ID, settlement.CreatedAt, pq.Array(matched))
+	if err != nil {
+		return errors.NewInternal(op, err)
+	}
+	if rows, err = result.RowsAffected(); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	span.SetAttributes(tracing.AttrRowsAffected.Int64(rows))
+	if rows != int64(len(matched)) {
+		return errors.NewConflict(op, "transactions were settled while the file was matched")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type StatementRepository interface {
+	// OpenStatement reads the wallet's balances at from and to and its transactions
+	// created in [from, to) from one consistent snapshot. The caller must Close it.
+	OpenStatement(ctx context.Context, walletID uuid.UUID, from, to time.Time) (Statement, error)
+}
+
+// Statement is an open cursor over the transactions of a statement period
+type Statement interface {
+	OpeningBalance() decimal.Decimal
+	ClosingBalance() decimal.Decimal
+	// Next advances to the next transaction in ledger order
+	Next() bool
+	Transaction() (*model.Transaction, error)
+	Err() error
+	Close() error
+}
+
+type statementRepo struct {
+	db *sqlx.DB
+}
+
+func NewStatementRepository(db *sqlx.DB) StatementRepository {
+	return &statementRepo{db: db}
+}
+
+func (r *statementRepo) OpenStatement(ctx context.Context, walletID uuid.UUID, from, to time.Time) (_ Statement, err error) {
+	const op = "statement.Open"
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "transactions")
+
+	// repeatable read keeps both balances and the rows in between on one snapshot
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		tracing.End(span, err)
+		return nil, errors.NewInternal(op, err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			tracing.End(span, err)
+		}
+	}()
+
+	s := &statement{tx: tx, span: span}
+	if s.opening, err = balanceBefore(ctx, tx, walletID, from); err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	if s.closing, err = balanceBefore(ctx, tx, walletID, to); err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	s.rows, err = tx.QueryxContext(ctx, `
+        SELECT * FROM transactions
+        WHERE wallet_id = $1 AND created_at >= $2 AND created_at < $3
+        ORDER BY seq`,
+		walletID, from, to)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return s, nil
+}
+
+// balanceBefore returns the balance after the wallet's last transaction
+// created before t, or its opening balance if it existed by then
+func balanceBefore(ctx context.Context, tx *sqlx.Tx, walletID uuid.UUID, t time.Time) (decimal.Decimal, error) {
+	var balance decimal.Decimal
+	err := tx.GetContext(ctx, &balance, `
+        SELECT COALESCE(
+                   (SELECT tx.balance_after FROM transactions tx
+                    WHERE tx.wallet_id = w.id AND tx.created_at < $2
+                    ORDER BY tx.seq DESC
+                    LIMIT 1),
+                   CASE WHEN w.created_at < $2 THEN w.opening_balance ELSE 0 END)
+        FROM wallets w
+        WHERE w.id = $1`,
+		walletID, t)
+	if err == sql.ErrNoRows {
+		return decimal.Zero, nil
+	}
+	return balance, err
+}
+
+type statement struct {
+	tx      *sqlx.Tx
+	rows    *sqlx.Rows
+	span    trace.Span
+	opening decimal.Decimal
+	closing decimal.Decimal
+	count   int64
+}
+
+func (s *statement) OpeningBalance() decimal.Decimal { return s.opening }
+
+func (s *statement) ClosingBalance() decimal.Decimal { return s.closing }
+
+func (s *statement) Next() bool { return s.rows.Next() }
+
+func (s *statement) Transaction() (*model.Transaction, error) {
+	const op = "statement.Transaction"
+	var t model.Transaction
+	if err := s.rows.StructScan(&t); err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	s.count++
+	return &t, nil
+}
+
+func (s *statement) Err() error {
+	return errors.IfInternalError("statement.Rows", s.rows.Err())
+}
+
+// Close ends the read-only DB transaction and the query span
+func (s *statement) Close() error {
+	const op = "statement.Close"
+	err := s.rows.Close()
+	s.tx.Rollback()
+	s.span.SetAttributes(tracing.AttrRowsAffected.Int64(s.count))
+	tracing.End(s.span, s.rows.Err())
+	return errors.IfInternalError(op, err)
+}
@@ -3,8 +3,12 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"time"
+
 	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
 	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/shopspring/decimal"
@@ -14,7 +18,6 @@ type TransactionRepository interface {
 	CreateTransaction(ctx context.Context, tx *model.Transaction) error
 	GetTransactions(ctx context.Context, walletID uuid.UUID, offset, limit int) ([]model.Transaction, error)
 	GetAllTransactions(ctx context.Context, userID uuid.UUID, offset, limit int) ([]model.Transaction, error)
-	TxTransactionRepository
 }
 
 type transactionRepo struct {
@@ -27,18 +30,28 @@ func NewTransactionRepository(db *sqlx.DB) TransactionRepository {
 
 func (r *transactionRepo) CreateTransaction(ctx context.Context, tx *model.Transaction) error {
 	const op = "transaction.Create"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "transactions")
 
 	_, err := r.db.NamedExecContext(ctx, `
         INSERT INTO transactions 
         (id, wallet_id, amount, balance_before, balance_after, type, related_tx_id, reference, currency, user_id)
         VALUES (:id, :wallet_id, :amount, :balance_before, :balance_after, :type, :related_tx_id, :reference, :currency, :user_id)`,
 		tx)
+	tracing.End(span, err)
+	if err == nil {
+		logging.FromContext(ctx).Debug("transaction inserted",
+			logging.KeyOp, op,
+			logging.KeyWallet, tx.WalletID,
+			logging.KeyTxID, tx.ID,
+		)
+	}
 	return errors.IfInternalError(op, err)
 }
 
 func (r *transactionRepo) GetTransactions(ctx context.Context, walletID uuid.UUID, offset, limit int) ([]model.Transaction, error) {
 	const op = "transaction.GetByWallet"
 	var txs []model.Transaction
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "transactions")
 
 	err := r.db.SelectContext(ctx, &txs, `
         SELECT * FROM transactions 
@@ -46,6 +59,8 @@ func (r *transactionRepo) GetTransactions(ctx context.Context, walletID uuid.UUI
         ORDER BY created_at DESC 
         LIMIT $2 OFFSET $3`,
 		walletID, limit, offset)
+	span.SetAttributes(tracing.AttrRowsAffected.Int64(int64(len(txs))))
+	tracing.End(span, ignoreNoRows(err))
 	if err != nil && err != sql.ErrNoRows {
 		return nil, errors.NewInternal(op, err)
 	}
@@ -55,6 +70,7 @@ func (r *transactionRepo) GetTransactions(ctx context.Context, walletID uuid.UUI
 func (r *transactionRepo) GetAllTransactions(ctx context.Context, userId uuid.UUID, offset, limit int) ([]model.Transaction, error) {
 	const op = "transaction.GetByWallet"
 	var txs []model.Transaction
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "transactions")
 
 	err := r.db.SelectContext(ctx, &txs, `
         SELECT * FROM transactions 
@@ -62,24 +78,36 @@ func (r *transactionRepo) GetAllTransactions(ctx context.Context, userId uuid.UU
         ORDER BY created_at DESC 
         LIMIT $2 OFFSET $3`,
 		userId, limit, offset)
+	span.SetAttributes(tracing.AttrRowsAffected.Int64(int64(len(txs))))
+	tracing.End(span, ignoreNoRows(err))
 	if err != nil && err != sql.ErrNoRows {
 		return nil, errors.NewInternal(op, err)
 	}
 	return txs, nil
 }
 
+// TxTransactionRepository runs transaction statements on a Postgres transaction, for walletTx
 type TxTransactionRepository interface {
 	CreateTransactionTx(ctx context.Context, tx *sqlx.Tx, transaction *model.Transaction) error
 }
 
 func (r *transactionRepo) CreateTransactionTx(ctx context.Context, dbTx *sqlx.Tx, tx *model.Transaction) error {
 	const op = "transaction.CreateTx"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "transactions")
 
 	_, err := dbTx.NamedExecContext(ctx, `
         INSERT INTO transactions 
         (id, wallet_id, amount, balance_before, balance_after, type, related_tx_id, reference, currency, user_id)
         VALUES (:id, :wallet_id, :amount, :balance_before, :balance_after, :type, :related_tx_id, :reference, :currency, :user_id)`,
 		tx)
+	tracing.End(span, err)
+	if err == nil {
+		logging.FromContext(ctx).Debug("transaction inserted",
+			logging.KeyOp, op,
+			logging.KeyWallet, tx.WalletID,
+			logging.KeyTxID, tx.ID,
+		)
+	}
 	return errors.IfInternalError(op, err)
 }
 
@@ -92,36 +120,64 @@ type WalletTx interface {
 	Rollback() error
 	GetWalletForUpdate(ctx context.Context, id uuid.UUID) (*model.Wallet, error)
 	UpdateWalletBalanceTx(ctx context.Context, id uuid.UUID, newBalance decimal.Decimal) error
+	UpdateWalletBalanceWithVersionTx(ctx context.Context, id uuid.UUID, newBalance decimal.Decimal, version int) (int64, error)
 	CreateTransactionTx(ctx context.Context, tx *model.Transaction) error
+	CreateAuditEventTx(ctx context.Context, event *model.AuditEvent) error
+	CreateWalletTx(ctx context.Context, wallet *model.Wallet) error
+	SetWalletFrozenTx(ctx context.Context, id uuid.UUID, frozenAt *time.Time, reason string) error
+	CreateOutboxEventTx(ctx context.Context, event *model.OutboxEvent) error
+	CreateEscrowTx(ctx context.Context, escrow *model.Escrow) error
+	GetEscrowForUpdate(ctx context.Context, id uuid.UUID) (*model.Escrow, error)
+	ResolveEscrowTx(ctx context.Context, escrow *model.Escrow) error
+	CreateAdjustmentTx(ctx context.Context, adjustment *model.Adjustment) error
+	GetAdjustmentForUpdate(ctx context.Context, id uuid.UUID) (*model.Adjustment, error)
+	ResolveAdjustmentTx(ctx context.Context, adjustment *model.Adjustment) error
+	UnreviewedAdjustmentTotal(ctx context.Context, walletID uuid.UUID, since time.Time) (decimal.Decimal, error)
+	// TransitionPaymentRequestTx is TransitionPaymentRequest in the transaction
+	TransitionPaymentRequestTx(ctx context.Context, id uuid.UUID, from, to string) (bool, error)
 }
 
 type walletTx struct {
 	*sqlx.Tx
 	walletRepo      TxWalletRepository
 	transactionRepo TxTransactionRepository
+	auditRepo       TxAuditRepository
+	outboxRepo      TxOutboxRepository
+	escrowRepo      TxEscrowRepository
+	adjustmentRepo  TxAdjustmentRepository
+	requestRepo     TxPaymentRequestRepository
 }
 
 func (r *transactionRepo) BeginTx(ctx context.Context) (WalletTx, error) {
 	const op = "transaction.BeginTx"
+	ctx, span := tracing.StartQuery(ctx, op, "BEGIN", "")
 
 	tx, err := r.db.BeginTxx(ctx, nil)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, errors.NewInternal(op, err)
 	}
 
 	return &walletTx{
 		Tx:              tx,
-		walletRepo:      NewWalletRepository(r.db).(TxWalletRepository),
+		walletRepo:      &walletRepo{db: r.db},
 		transactionRepo: r,
+		auditRepo:       NewAuditRepository(r.db),
+		outboxRepo:      NewOutboxRepository(r.db),
+		escrowRepo:      NewEscrowRepository(r.db),
+		adjustmentRepo:  &adjustmentRepo{db: r.db},
+		requestRepo:     &paymentRequestRepo{db: r.db},
 	}, nil
 }
 
 func (wt *walletTx) GetWalletForUpdate(ctx context.Context, id uuid.UUID) (*model.Wallet, error) {
 	const op = "walletTx.GetForUpdate"
 	var wallet model.Wallet
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "wallets")
 
 	err := wt.Tx.GetContext(ctx, &wallet,
 		`SELECT * FROM wallets WHERE id = $1 FOR UPDATE`, id)
+	tracing.End(span, ignoreNoRows(err))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.NewNotFound(op, "wallet")
@@ -140,6 +196,134 @@ func (wt *walletTx) UpdateWalletBalanceTx(ctx context.Context, id uuid.UUID, new
 	return nil
 }
 
+func (wt *walletTx) UpdateWalletBalanceWithVersionTx(ctx context.Context, id uuid.UUID, newBalance decimal.Decimal, version int) (int64, error) {
+	const op = "walletTx.UpdateBalanceWithVersion"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "wallets")
+
+	result, err := wt.Tx.ExecContext(ctx,
+		`UPDATE wallets SET balance = $1, version = version + 1, updated_at = NOW()
+         WHERE id = $2 AND version = $3`,
+		newBalance, id, version)
+	if err != nil {
+		tracing.End(span, err)
+		return 0, errors.NewInternal(op, err)
+	}
+
+	rows, err := result.RowsAffected()
+	span.SetAttributes(tracing.AttrRowsAffected.Int64(rows))
+	tracing.End(span, err)
+	if err != nil {
+		return 0, errors.NewInternal(op, err)
+	}
+	logging.FromContext(ctx).Debug("wallet balance update executed",
+		logging.KeyOp, op,
+		logging.KeyWallet, id,
+		"version", version,
+		"rows_affected", rows,
+	)
+	return rows, nil
+}
+
+func (wt *walletTx) CreateAuditEventTx(ctx context.Context, event *model.AuditEvent) error {
+	const op = "walletTx.CreateAuditEvent"
+
+	if err := wt.auditRepo.CreateAuditEventTx(ctx, wt.Tx, event); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	return nil
+}
+
+func (wt *walletTx) CreateOutboxEventTx(ctx context.Context, event *model.OutboxEvent) error {
+	const op = "walletTx.CreateOutboxEvent"
+
+	if err := wt.outboxRepo.CreateOutboxEventTx(ctx, wt.Tx, event); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	return nil
+}
+
+func (wt *walletTx) CreateEscrowTx(ctx context.Context, escrow *model.Escrow) error {
+	const op = "walletTx.CreateEscrow"
+
+	if err := wt.escrowRepo.CreateEscrowTx(ctx, wt.Tx, escrow); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	return nil
+}
+
+func (wt *walletTx) GetEscrowForUpdate(ctx context.Context, id uuid.UUID) (*model.Escrow, error) {
+	const op = "walletTx.GetEscrowForUpdate"
+
+	escrow, err := wt.escrowRepo.GetEscrowForUpdateTx(ctx, wt.Tx, id)
+	return escrow, errors.WrapInternal(op, err)
+}
+
+func (wt *walletTx) ResolveEscrowTx(ctx context.Context, escrow *model.Escrow) error {
+	const op = "walletTx.ResolveEscrow"
+
+	if err := wt.escrowRepo.ResolveEscrowTx(ctx, wt.Tx, escrow); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	return nil
+}
+
+func (wt *walletTx) CreateAdjustmentTx(ctx context.Context, adjustment *model.Adjustment) error {
+	const op = "walletTx.CreateAdjustment"
+
+	if err := wt.adjustmentRepo.CreateAdjustmentTx(ctx, wt.Tx, adjustment); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	return nil
+}
+
+func (wt *walletTx) GetAdjustmentForUpdate(ctx context.Context, id uuid.UUID) (*model.Adjustment, error) {
+	const op = "walletTx.GetAdjustmentForUpdate"
+
+	adjustment, err := wt.adjustmentRepo.GetAdjustmentForUpdateTx(ctx, wt.Tx, id)
+	return adjustment, errors.WrapInternal(op, err)
+}
+
+func (wt *walletTx) ResolveAdjustmentTx(ctx context.Context, adjustment *model.Adjustment) error {
+	const op = "walletTx.ResolveAdjustment"
+
+	if err := wt.adjustmentRepo.ResolveAdjustmentTx(ctx, wt.Tx, adjustment); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	return nil
+}
+
+func (wt *walletTx) UnreviewedAdjustmentTotal(ctx context.Context, walletID uuid.UUID, since time.Time) (decimal.Decimal, error) {
+	const op = "walletTx.UnreviewedAdjustmentTotal"
+
+	total, err := wt.adjustmentRepo.UnreviewedAdjustmentTotalTx(ctx, wt.Tx, walletID, since)
+	return total, errors.WrapInternal(op, err)
+}
+
+func (wt *walletTx) TransitionPaymentRequestTx(ctx context.Context, id uuid.UUID, from, to string) (bool, error) {
+	const op = "walletTx.TransitionPaymentRequest"
+
+	ok, err := wt.requestRepo.TransitionPaymentRequestTx(ctx, wt.Tx, id, from, to)
+	return ok, errors.WrapInternal(op, err)
+}
+
+func (wt *walletTx) CreateWalletTx(ctx context.Context, wallet *model.Wallet) error {
+	const op = "walletTx.CreateWallet"
+
+	if err := wt.walletRepo.CreateWalletTx(ctx, wt.Tx, wallet); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	return nil
+}
+
+func (wt *walletTx) SetWalletFrozenTx(ctx context.Context, id uuid.UUID, frozenAt *time.Time, reason string) error {
+	const op = "walletTx.SetWalletFrozen"
+
+	if err := wt.walletRepo.SetWalletFrozenTx(ctx, wt.Tx, id, frozenAt, reason); err != nil {
+		return errors.NewInternal(op, err)
+	}
+	return nil
+}
+
 func (wt *walletTx) CreateTransactionTx(ctx context.Context, tx *model.Transaction) error {
 	const op = "walletTx.CreateTransaction"
 
@@ -3,10 +3,14 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"time"
+
 	"github.com/shopspring/decimal"
 
 	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
 	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
@@ -15,9 +19,10 @@ type WalletRepository interface {
 	CreateWallet(ctx context.Context, wallet *model.Wallet) error
 	GetWallet(ctx context.Context, id uuid.UUID) (*model.Wallet, error)
 	GetWalletByUserAndCurrency(ctx context.Context, userID uuid.UUID, currency string) (*model.Wallet, error)
+	// ListWalletsByUser returns the user's wallets, escrow wallets included
+	ListWalletsByUser(ctx context.Context, userID uuid.UUID) ([]model.Wallet, error)
 	GetWalletForUpdate(ctx context.Context, id uuid.UUID) (*model.Wallet, error)
 	UpdateWalletBalance(ctx context.Context, id uuid.UUID, newBalance decimal.Decimal, version int) (int64, error)
-	TxWalletRepository
 }
 
 type walletRepo struct {
@@ -30,11 +35,14 @@ func NewWalletRepository(db *sqlx.DB) WalletRepository {
 
 func (r *walletRepo) CreateWallet(ctx context.Context, wallet *model.Wallet) error {
 	const op = "wallet.Create"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "wallets")
 
-	query := `INSERT INTO wallets (id, user_id, currency, balance) 
-              VALUES (:id, :user_id, :currency, :balance)`
+	query := `INSERT INTO wallets (id, user_id, currency, balance, escrow_id) 
+              VALUES (:id, :user_id, :currency, :balance, :escrow_id)`
 
-	if _, err := r.db.NamedExecContext(ctx, query, wallet); err != nil {
+	_, err := r.db.NamedExecContext(ctx, query, wallet)
+	tracing.End(span, err)
+	if err != nil {
 		return errors.NewInsufficientBalance(op)
 	}
 	return nil
@@ -43,8 +51,10 @@ func (r *walletRepo) CreateWallet(ctx context.Context, wallet *model.Wallet) err
 func (r *walletRepo) GetWallet(ctx context.Context, id uuid.UUID) (*model.Wallet, error) {
 	const op = "wallet.GetByID"
 	var wallet model.Wallet
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "wallets")
 
 	err := r.db.GetContext(ctx, &wallet, `SELECT * FROM wallets WHERE id = $1`, id)
+	tracing.End(span, ignoreNoRows(err))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.NewNotFound(op, "wallet")
@@ -57,10 +67,12 @@ func (r *walletRepo) GetWallet(ctx context.Context, id uuid.UUID) (*model.Wallet
 func (r *walletRepo) GetWalletByUserAndCurrency(ctx context.Context, userID uuid.UUID, currency string) (*model.Wallet, error) {
 	const op = "wallet.GetByUserAndCurrency"
 	var wallet model.Wallet
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "wallets")
 
 	err := r.db.GetContext(ctx, &wallet,
-		`SELECT * FROM wallets WHERE user_id = $1 AND currency = $2`,
+		`SELECT * FROM wallets WHERE user_id = $1 AND currency = $2 AND escrow_id IS NULL`,
 		userID, currency)
+	tracing.End(span, ignoreNoRows(err))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.NewNotFound(op, "wallet")
@@ -70,12 +82,28 @@ func (r *walletRepo) GetWalletByUserAndCurrency(ctx context.Context, userID uuid
 	return &wallet, nil
 }
 
+func (r *walletRepo) ListWalletsByUser(ctx context.Context, userID uuid.UUID) ([]model.Wallet, error) {
+	const op = "wallet.ListByUser"
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "wallets")
+
+	var wallets []model.Wallet
+	err := r.db.SelectContext(ctx, &wallets,
+		`SELECT * FROM wallets WHERE user_id = $1 ORDER BY escrow_id IS NOT NULL, currency, created_at`, userID)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return wallets, nil
+}
+
 func (r *walletRepo) GetWalletForUpdate(ctx context.Context, id uuid.UUID) (*model.Wallet, error) {
 	const op = "wallet.GetForUpdate"
 	var wallet model.Wallet
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "wallets")
 
 	err := r.db.GetContext(ctx, &wallet,
 		`SELECT * FROM wallets WHERE id = $1 FOR UPDATE`, id)
+	tracing.End(span, ignoreNoRows(err))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.NewNotFound(op, "wallet")
@@ -87,33 +115,88 @@ func (r *walletRepo) GetWalletForUpdate(ctx context.Context, id uuid.UUID) (*mod
 
 func (r *walletRepo) UpdateWalletBalance(ctx context.Context, id uuid.UUID, newBalance decimal.Decimal, version int) (int64, error) {
 	const op = "wallet.UpdateBalance"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "wallets")
 
 	result, err := r.db.ExecContext(ctx,
 		`UPDATE wallets SET balance = $1, version = version + 1 
          WHERE id = $2 AND version = $3`,
 		newBalance, id, version)
 	if err != nil {
+		tracing.End(span, err)
 		return 0, errors.NewInternal(op, err)
 	}
 
 	rows, err := result.RowsAffected()
+	span.SetAttributes(tracing.AttrRowsAffected.Int64(rows))
+	tracing.End(span, err)
 	if err != nil {
 		return 0, errors.NewInternal(op, err)
 	}
+	logging.FromContext(ctx).Debug("wallet balance update executed",
+		logging.KeyOp, op,
+		logging.KeyWallet, id,
+		"version", version,
+		"rows_affected", rows,
+	)
 	return rows, nil
 }
 
+// TxWalletRepository runs wallet statements on a Postgres transaction, for walletTx
 type TxWalletRepository interface {
 	UpdateWalletBalanceTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID, newBalance decimal.Decimal) error
+	CreateWalletTx(ctx context.Context, tx *sqlx.Tx, wallet *model.Wallet) error
+	SetWalletFrozenTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID, frozenAt *time.Time, reason string) error
 }
 
+func (r *walletRepo) CreateWalletTx(ctx context.Context, tx *sqlx.Tx, wallet *model.Wallet) error {
+	const op = "wallet.CreateTx"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "wallets")
+
+	_, err := tx.NamedExecContext(ctx, `INSERT INTO wallets (id, user_id, currency, balance, escrow_id) 
+              VALUES (:id, :user_id, :currency, :balance, :escrow_id)`, wallet)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+// UpdateWalletBalanceTx sets the balance of a wallet the caller holds the row
+// lock on. The version still moves, so an optimistic update that read the
+// balance before this one fails its version check rather than overwriting it.
 func (r *walletRepo) UpdateWalletBalanceTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID, newBalance decimal.Decimal) error {
 	const op = "wallet.UpdateBalanceTx"
-
-	if _, err := tx.ExecContext(ctx,
-		`UPDATE wallets SET balance = $1 WHERE id = $2`,
-		newBalance, id); err != nil {
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "wallets")
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE wallets SET balance = $1, version = version + 1 WHERE id = $2`,
+		newBalance, id)
+	if err == nil {
+		rows, _ := result.RowsAffected()
+		span.SetAttributes(tracing.AttrRowsAffected.Int64(rows))
+	}
+	tracing.End(span, err)
+	if err != nil {
 		return errors.NewInternal(op, err)
 	}
 	return nil
 }
+
+// SetWalletFrozenTx freezes the wallet, or unfreezes it when frozenAt is nil.
+// The version moves, so a withdrawal that read the wallet before the freeze
+// fails its version check rather than going through.
+func (r *walletRepo) SetWalletFrozenTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID, frozenAt *time.Time, reason string) error {
+	const op = "wallet.SetFrozenTx"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "wallets")
+
+	_, err := tx.ExecContext(ctx,
+		`UPDATE wallets SET frozen_at = $1, frozen_reason = $2, version = version + 1, updated_at = NOW() WHERE id = $3`,
+		frozenAt, reason, id)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+// ignoreNoRows keeps "not found" lookups from being reported as failed spans
+func ignoreNoRows(err error) error {
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return err
+}
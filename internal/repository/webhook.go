@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type WebhookRepository interface {
+	CreateSubscription(ctx context.Context, sub *model.WebhookSubscription) error
+	GetSubscription(ctx context.Context, id uuid.UUID) (*model.WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context, userID uuid.UUID) ([]model.WebhookSubscription, error)
+	DeactivateSubscription(ctx context.Context, id uuid.UUID) error
+
+	EnqueueDeliveries(ctx context.Context, deliveries []model.WebhookDelivery) error
+	ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error)
+	// RecordAttempt stores the attempt and the delivery's new state. held is
+	// false when the delivery was claimed again or redelivered since this
+	// claim, nothing is recorded then.
+	RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery, attempt *model.WebhookAttempt) (held bool, err error)
+	GetDelivery(ctx context.Context, id uuid.UUID) (*model.WebhookDelivery, error)
+	ListDeliveries(ctx context.Context, subscriptionID uuid.UUID, offset, limit int) ([]model.WebhookDelivery, error)
+	ListAttempts(ctx context.Context, deliveryID uuid.UUID) ([]model.WebhookAttempt, error)
+	Redeliver(ctx context.Context, id uuid.UUID) error
+}
+
+type webhookRepo struct {
+	db *sqlx.DB
+}
+
+func NewWebhookRepository(db *sqlx.DB) WebhookRepository {
+	return &webhookRepo{db: db}
+}
+
+func (r *webhookRepo) CreateSubscription(ctx context.Context, sub *model.WebhookSubscription) error {
+	const op = "webhook.CreateSubscription"
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "webhook_subscriptions")
+
+	_, err := r.db.NamedExecContext(ctx, `
+        INSERT INTO webhook_subscriptions (id, user_id, url, event_types, secret, active, created_at)
+        VALUES (:id, :user_id, :url, :event_types, :secret, :active, :created_at)`,
+		sub)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+func (r *webhookRepo) GetSubscription(ctx context.Context, id uuid.UUID) (*model.WebhookSubscription, error) {
+	const op = "webhook.GetSubscription"
+	var sub model.WebhookSubscription
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "webhook_subscriptions")
+
+	err := r.db.GetContext(ctx, &sub, `SELECT * FROM webhook_subscriptions WHERE id = $1`, id)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "webhook subscription")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &sub, nil
+}
+
+func (r *webhookRepo) ListSubscriptions(ctx context.Context, userID uuid.UUID) ([]model.WebhookSubscription, error) {
+	const op = "webhook.ListSubscriptions"
+	var subs []model.WebhookSubscription
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "webhook_subscriptions")
+
+	err := r.db.SelectContext(ctx, &subs, `
+        SELECT * FROM webhook_subscriptions
+        WHERE user_id = $1 AND active
+        ORDER BY created_at`,
+		userID)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return subs, nil
+}
+
+func (r *webhookRepo) DeactivateSubscription(ctx context.Context, id uuid.UUID) error {
+	const op = "webhook.DeactivateSubscription"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "webhook_subscriptions")
+
+	_, err := r.db.ExecContext(ctx, `UPDATE webhook_subscriptions SET active = FALSE WHERE id = $1`, id)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+func (r *webhookRepo) EnqueueDeliveries(ctx context.Context, deliveries []model.WebhookDelivery) error {
+	const op = "webhook.EnqueueDeliveries"
+	if len(deliveries) == 0 {
+		return nil
+	}
+	ctx, span := tracing.StartQuery(ctx, op, "INSERT", "webhook_deliveries")
+
+	_, err := r.db.NamedExecContext(ctx, `
+        INSERT INTO webhook_deliveries (id, subscription_id, event_id, event_type, payload)
+        VALUES (:id, :subscription_id, :event_id, :event_type, :payload)
+        ON CONFLICT (subscription_id, event_id) DO NOTHING`,
+		deliveries)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
+
+// ClaimDueDeliveries leases due deliveries by pushing next_attempt_at out, so a
+// worker that dies mid-send lets another pick the delivery up after the lease.
+// Each claim bumps claims, which RecordAttempt checks.
+func (r *webhookRepo) ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	const op = "webhook.ClaimDueDeliveries"
+	var deliveries []model.WebhookDelivery
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "webhook_deliveries")
+
+	err := r.db.SelectContext(ctx, &deliveries, `
+        UPDATE webhook_deliveries
+        SET next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond', claims = claims + 1
+        WHERE id IN (
+            SELECT id FROM webhook_deliveries
+            WHERE status = 'pending' AND next_attempt_at <= NOW()
+            ORDER BY next_attempt_at
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED)
+        RETURNING *`,
+		limit, lease.Milliseconds())
+	span.SetAttributes(tracing.AttrRowsAffected.Int64(int64(len(deliveries))))
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt appends to the delivery log and stores the delivery's new
+// state, if the claim delivery came with is still the latest
+func (r *webhookRepo) RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery, attempt *model.WebhookAttempt) (bool, error) {
+	const op = "webhook.RecordAttempt"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "webhook_deliveries")
+
+	held, err := r.recordAttempt(ctx, delivery, attempt)
+	tracing.End(span, err)
+	if err != nil {
+		return false, errors.NewInternal(op, err)
+	}
+	return held, nil
+}
+
+func (r *webhookRepo) recordAttempt(ctx context.Context, delivery *model.WebhookDelivery, attempt *model.WebhookAttempt) (bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.NamedExecContext(ctx, `
+        UPDATE webhook_deliveries
+        SET status = :status, attempts = :attempts, next_attempt_at = :next_attempt_at,
+            last_status_code = :last_status_code, last_error = :last_error,
+            delivered_at = :delivered_at, updated_at = NOW()
+        WHERE id = :id AND claims = :claims AND status = 'pending'`,
+		delivery)
+	if err != nil {
+		return false, err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return false, err
+	}
+	if _, err := tx.NamedExecContext(ctx, `
+        INSERT INTO webhook_delivery_attempts (delivery_id, attempt, status_code, error, duration_ms)
+        VALUES (:delivery_id, :attempt, :status_code, :error, :duration_ms)`,
+		attempt); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (r *webhookRepo) GetDelivery(ctx context.Context, id uuid.UUID) (*model.WebhookDelivery, error) {
+	const op = "webhook.GetDelivery"
+	var delivery model.WebhookDelivery
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "webhook_deliveries")
+
+	err := r.db.GetContext(ctx, &delivery, `SELECT * FROM webhook_deliveries WHERE id = $1`, id)
+	tracing.End(span, ignoreNoRows(err))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFound(op, "webhook delivery")
+		}
+		return nil, errors.NewInternal(op, err)
+	}
+	return &delivery, nil
+}
+
+func (r *webhookRepo) ListDeliveries(ctx context.Context, subscriptionID uuid.UUID, offset, limit int) ([]model.WebhookDelivery, error) {
+	const op = "webhook.ListDeliveries"
+	var deliveries []model.WebhookDelivery
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "webhook_deliveries")
+
+	err := r.db.SelectContext(ctx, &deliveries, `
+        SELECT * FROM webhook_deliveries
+        WHERE subscription_id = $1
+        ORDER BY created_at DESC
+        LIMIT $2 OFFSET $3`,
+		subscriptionID, limit, offset)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return deliveries, nil
+}
+
+func (r *webhookRepo) ListAttempts(ctx context.Context, deliveryID uuid.UUID) ([]model.WebhookAttempt, error) {
+	const op = "webhook.ListAttempts"
+	var attempts []model.WebhookAttempt
+	ctx, span := tracing.StartQuery(ctx, op, "SELECT", "webhook_delivery_attempts")
+
+	err := r.db.SelectContext(ctx, &attempts, `
+        SELECT * FROM webhook_delivery_attempts
+        WHERE delivery_id = $1
+        ORDER BY id`,
+		deliveryID)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+	return attempts, nil
+}
+
+// Redeliver puts a delivery back in the queue with a fresh retry budget. An
+// attempt in flight can't record itself over it.
+func (r *webhookRepo) Redeliver(ctx context.Context, id uuid.UUID) error {
+	const op = "webhook.Redeliver"
+	ctx, span := tracing.StartQuery(ctx, op, "UPDATE", "webhook_deliveries")
+
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE webhook_deliveries
+        SET status = 'pending', attempts = 0, next_attempt_at = NOW(), claims = claims + 1, updated_at = NOW()
+        WHERE id = $1`,
+		id)
+	tracing.End(span, err)
+	return errors.IfInternalError(op, err)
+}
@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"context"
+	stderrors "errors"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Code maps the business error type in err's chain to a gRPC status code
+func Code(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Code()
+	}
+	switch {
+	case stderrors.Is(err, context.Canceled):
+		return codes.Canceled
+	case stderrors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	}
+	switch errors.TypeOf(err) {
+	case errors.InvalidRequest:
+		return codes.InvalidArgument
+	case errors.NotFound:
+		return codes.NotFound
+	case errors.InsufficientFund, errors.WalletFrozen:
+		return codes.FailedPrecondition
+	case errors.Forbidden:
+		return codes.PermissionDenied
+	case errors.Conflict:
+		return codes.Aborted
+	default:
+		return codes.Internal
+	}
+}
+
+// Status converts err into the status error answered to the caller. The
+// message is the business error's, an internal error's details stay in the log.
+func Status(err error) error {
+	if err == nil {
+		return nil
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Err()
+	}
+	code := Code(err)
+	switch code {
+	case codes.Canceled, codes.DeadlineExceeded:
+		return status.Error(code, err.Error())
+	}
+	return status.Error(code, errors.MessageOf(err))
+}
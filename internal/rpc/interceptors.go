@@ -0,0 +1,190 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/auth"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDMetadata carries the correlation id, like the X-Request-ID header
+const RequestIDMetadata = "x-request-id"
+
+const maxRequestIDLength = 128
+
+// healthPrefix is the method prefix of the health service, which load
+// balancers call without credentials
+const healthPrefix = "/grpc.health.v1.Health/"
+
+type authenticator struct {
+	tokens map[string]string
+}
+
+// authenticate checks the bearer token in the authorization metadata and
+// names the calling service in the request logger
+func (a *authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if strings.HasPrefix(method, healthPrefix) {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+
+	caller := auth.Lookup(a.tokens, token)
+	if caller == "" {
+		return nil, status.Error(codes.Unauthenticated, "invalid bearer token")
+	}
+	ctx = auth.WithCaller(ctx, caller)
+	return logging.WithContext(ctx, logging.FromContext(ctx).With("caller", caller)), nil
+}
+
+func (a *authenticator) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authenticator) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// contextStream replaces a stream's context, interceptors can't otherwise
+// hand a stream handler a derived one
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// withRequestID assigns every call a correlation id, honouring an incoming
+// x-request-id, and puts a logger carrying it into the context
+func withRequestID(ctx context.Context, base *slog.Logger) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIDMetadata); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if id == "" || len(id) > maxRequestIDLength {
+		id = uuid.NewString()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDMetadata, id))
+
+	ctx = logging.WithRequestID(ctx, id)
+	return logging.WithContext(ctx, base.With(logging.KeyRequestID, id))
+}
+
+// logCall writes one line per call, with the error's Type and Op chain when
+// it failed. It runs before the error becomes a status, which drops both.
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	logger := logging.FromContext(ctx)
+	code := Code(err)
+
+	level := slog.LevelInfo
+	switch code {
+	case codes.OK:
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+	attrs := []any{
+		"method", method,
+		"code", code.String(),
+		logging.KeyDuration, time.Since(start),
+	}
+	if err != nil {
+		attrs = append(attrs, logging.Err(err))
+	}
+	logger.Log(ctx, level, "rpc completed", attrs...)
+}
+
+func unaryLogging(base *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		ctx = withRequestID(ctx, base)
+		resp, err := handler(ctx, req)
+		logCall(ctx, info.FullMethod, start, err)
+		return resp, Status(err)
+	}
+}
+
+func streamLogging(base *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx := withRequestID(ss.Context(), base)
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		logCall(ctx, info.FullMethod, start, err)
+		return Status(err)
+	}
+}
+
+// recovered turns a panic into an internal error, like gin.Recovery
+func recovered(method string, p any) error {
+	return errors.NewInternal("rpc.recovery", fmt.Errorf("panic in %s: %v\n%s", method, p, debug.Stack()))
+}
+
+func unaryRecovery(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = recovered(info.FullMethod, p)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func streamRecovery(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = recovered(info.FullMethod, p)
+		}
+	}()
+	return handler(srv, ss)
+}
+
+func observe(method string, start time.Time, err error) {
+	code := status.Code(err).String()
+	metrics.GRPCRequests.WithLabelValues(method, code).Inc()
+	metrics.GRPCDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+}
+
+func unaryMetrics(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observe(info.FullMethod, start, err)
+	return resp, err
+}
+
+func streamMetrics(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observe(info.FullMethod, start, err)
+	return err
+}
@@ -0,0 +1,244 @@
+// Package rpc serves the wallet service over gRPC, as defined in
+// proto/wallet/v1/wallet.proto, next to the REST handlers in internal/api.
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/package/walletpb"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// historyPageSize is how many transactions ListTransactions reads at a time
+const historyPageSize = 100
+
+type Config struct {
+	// Tokens maps each accepted bearer token to the name of the service
+	// presenting it
+	Tokens map[string]string
+}
+
+// NewServer builds a gRPC server for the wallet service with the auth,
+// logging and metrics interceptors, and the standard health service
+func NewServer(walletService service.WalletService, cfg Config, logger *slog.Logger) *grpc.Server {
+	auth := &authenticator{tokens: cfg.Tokens}
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			unaryMetrics,
+			unaryLogging(logger),
+			unaryRecovery,
+			auth.unary,
+		),
+		grpc.ChainStreamInterceptor(
+			streamMetrics,
+			streamLogging(logger),
+			streamRecovery,
+			auth.stream,
+		),
+	)
+	walletpb.RegisterWalletServiceServer(srv, NewWalletServer(walletService))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	return srv
+}
+
+type WalletServer struct {
+	walletpb.UnimplementedWalletServiceServer
+	walletService service.WalletService
+}
+
+func NewWalletServer(walletService service.WalletService) *WalletServer {
+	return &WalletServer{walletService: walletService}
+}
+
+func (s *WalletServer) Deposit(ctx context.Context, req *walletpb.DepositRequest) (*walletpb.DepositResponse, error) {
+	const op = "rpc.Deposit"
+
+	userID, err := parseUserID(op, "user_id", req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := parseAmount(op, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCurrency(op, req.Currency); err != nil {
+		return nil, err
+	}
+
+	wallet, err := s.walletService.Deposit(ctx, userID, amount, req.Currency, req.Reference)
+	if err != nil {
+		return nil, err
+	}
+	return &walletpb.DepositResponse{Wallet: toWallet(wallet)}, nil
+}
+
+func (s *WalletServer) Withdraw(ctx context.Context, req *walletpb.WithdrawRequest) (*walletpb.WithdrawResponse, error) {
+	const op = "rpc.Withdraw"
+
+	userID, err := parseUserID(op, "user_id", req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := parseAmount(op, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCurrency(op, req.Currency); err != nil {
+		return nil, err
+	}
+
+	wallet, err := s.walletService.Withdraw(ctx, userID, amount, req.Currency, req.Reference)
+	if err != nil {
+		return nil, err
+	}
+	return &walletpb.WithdrawResponse{Wallet: toWallet(wallet)}, nil
+}
+
+func (s *WalletServer) Transfer(ctx context.Context, req *walletpb.TransferRequest) (*walletpb.TransferResponse, error) {
+	const op = "rpc.Transfer"
+
+	fromUserID, err := parseUserID(op, "from_user_id", req.FromUserId)
+	if err != nil {
+		return nil, err
+	}
+	toUserID, err := parseUserID(op, "to_user_id", req.ToUserId)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := parseAmount(op, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCurrency(op, req.Currency); err != nil {
+		return nil, err
+	}
+
+	wallet, err := s.walletService.Transfer(ctx, fromUserID, toUserID, amount, req.Currency, req.Reference)
+	if err != nil {
+		return nil, err
+	}
+	return &walletpb.TransferResponse{Wallet: toWallet(wallet)}, nil
+}
+
+func (s *WalletServer) GetBalance(ctx context.Context, req *walletpb.GetBalanceRequest) (*walletpb.GetBalanceResponse, error) {
+	const op = "rpc.GetBalance"
+
+	userID, err := parseUserID(op, "user_id", req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	currency := req.Currency
+	if currency == "" {
+		currency = "USD"
+	}
+
+	balance, err := s.walletService.GetBalance(ctx, userID, currency)
+	if err != nil {
+		return nil, err
+	}
+	return &walletpb.GetBalanceResponse{Balance: balance.String(), Currency: currency}, nil
+}
+
+// ListTransactions reads the history a page at a time and streams it. A
+// transaction committed meanwhile shifts the pages, so one already sent can
+// come round again and is skipped.
+func (s *WalletServer) ListTransactions(req *walletpb.ListTransactionsRequest, stream walletpb.WalletService_ListTransactionsServer) error {
+	const op = "rpc.ListTransactions"
+
+	userID, err := parseUserID(op, "user_id", req.UserId)
+	if err != nil {
+		return err
+	}
+	if req.Limit < 0 {
+		return errors.NewInvalidInput(op, "limit", req.Limit)
+	}
+
+	ctx := stream.Context()
+	sent := make(map[uuid.UUID]bool)
+	for page := 1; ; page++ {
+		transactions, err := s.walletService.GetTransactionHistory(ctx, userID, req.Currency, page, historyPageSize)
+		if err != nil {
+			return err
+		}
+		for i := range transactions {
+			tx := &transactions[i]
+			if sent[tx.ID] {
+				continue
+			}
+			if err := stream.Send(&walletpb.ListTransactionsResponse{Transaction: toTransaction(tx)}); err != nil {
+				return err
+			}
+			sent[tx.ID] = true
+			if req.Limit > 0 && len(sent) == int(req.Limit) {
+				return nil
+			}
+		}
+		if len(transactions) < historyPageSize {
+			return nil
+		}
+	}
+}
+
+func parseUserID(op, field, value string) (uuid.UUID, error) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, errors.NewInvalidInput(op, field, value)
+	}
+	return id, nil
+}
+
+func parseAmount(op, value string) (decimal.Decimal, error) {
+	amount, err := decimal.NewFromString(value)
+	if err != nil || !amount.IsPositive() {
+		return decimal.Zero, errors.NewInvalidInput(op, "amount", value)
+	}
+	return amount, nil
+}
+
+// checkCurrency applies the REST binding's rule, a three letter code
+func checkCurrency(op, currency string) error {
+	if len(currency) != 3 {
+		return errors.NewInvalidInput(op, "currency", currency)
+	}
+	return nil
+}
+
+func toWallet(w *model.WalletResponse) *walletpb.Wallet {
+	return &walletpb.Wallet{
+		Id:       w.ID.String(),
+		UserId:   w.UserID.String(),
+		Balance:  w.Balance.String(),
+		Currency: w.Currency,
+	}
+}
+
+func toTransaction(tx *model.Transaction) *walletpb.Transaction {
+	pb := &walletpb.Transaction{
+		Id:            tx.ID.String(),
+		WalletId:      tx.WalletID.String(),
+		Amount:        tx.Amount.String(),
+		Currency:      tx.Currency,
+		BalanceBefore: tx.BalanceBefore.String(),
+		BalanceAfter:  tx.BalanceAfter.String(),
+		Type:          tx.Type,
+		Reference:     tx.Reference,
+	}
+	// created_at is scanned into a string, which database/sql renders as RFC 3339
+	if createdAt, err := time.Parse(time.RFC3339Nano, tx.CreatedAt); err == nil {
+		pb.CreatedAt = timestamppb.New(createdAt)
+	}
+	if tx.SettledAt != nil {
+		pb.SettledAt = timestamppb.New(*tx.SettledAt)
+	}
+	return pb
+}
@@ -0,0 +1,89 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/robfig/cron/v3"
+)
+
+// ParseCron parses a standard five-field cron expression ("0 9 1 * *" is 09:00
+// on the first of every month) or a descriptor such as "@weekly".
+func ParseCron(expr string) (cron.Schedule, error) {
+	return cron.ParseStandard(expr)
+}
+
+// Location resolves an IANA time zone name, empty meaning UTC
+func Location(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// First returns the schedule's first run at or after now, or at start_at for a
+// one-off schedule, which runs straight away if start_at has passed.
+func First(s *model.TransferSchedule, now time.Time) (time.Time, bool, error) {
+	if s.Kind == model.ScheduleOnce {
+		if s.EndAt != nil && s.StartAt.After(*s.EndAt) {
+			return time.Time{}, false, nil
+		}
+		return s.StartAt, true, nil
+	}
+	from := s.StartAt
+	if now.After(from) {
+		from = now
+	}
+	// next is strictly after the given time, step back so from itself can match
+	return next(s, from.Add(-time.Nanosecond))
+}
+
+// Next returns the schedule's first run strictly after t. Occurrences missed
+// while the scheduler was down are skipped rather than caught up. ok is false
+// when the schedule has no runs left.
+func Next(s *model.TransferSchedule, t time.Time) (time.Time, bool, error) {
+	if s.Kind == model.ScheduleOnce {
+		return time.Time{}, false, nil
+	}
+	return next(s, t)
+}
+
+func next(s *model.TransferSchedule, t time.Time) (time.Time, bool, error) {
+	var at time.Time
+	switch s.Kind {
+	case model.ScheduleInterval:
+		if s.IntervalSeconds == nil || *s.IntervalSeconds <= 0 {
+			return time.Time{}, false, fmt.Errorf("schedule %s has no interval", s.ID)
+		}
+		// stay on the start_at grid so runs don't drift with execution delays
+		interval := time.Duration(*s.IntervalSeconds) * time.Second
+		at = s.StartAt
+		if !at.After(t) {
+			at = at.Add((t.Sub(at)/interval + 1) * interval)
+		}
+	case model.ScheduleCron:
+		if s.CronExpr == nil {
+			return time.Time{}, false, fmt.Errorf("schedule %s has no cron expression", s.ID)
+		}
+		sched, err := ParseCron(*s.CronExpr)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		loc, err := Location(s.Timezone)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		// evaluated in the schedule's zone, "0 9 * * *" stays at 09:00 local across DST
+		at = sched.Next(t.In(loc))
+		if at.IsZero() {
+			return time.Time{}, false, nil
+		}
+	default:
+		return time.Time{}, false, fmt.Errorf("unknown schedule kind %q", s.Kind)
+	}
+	if s.EndAt != nil && at.After(*s.EndAt) {
+		return time.Time{}, false, nil
+	}
+	return at.UTC(), true, nil
+}
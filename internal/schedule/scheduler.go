@@ -0,0 +1,207 @@
+package schedule
+
+import (
+	"context"
+	stderrors "errors"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Transferer executes a scheduled transfer, service.WalletService implements it
+type Transferer interface {
+	Transfer(ctx context.Context, fromUserID, toUserID uuid.UUID, amount decimal.Decimal, currency, reference string) (*model.WalletResponse, error)
+}
+
+type SchedulerConfig struct {
+	BatchSize    int
+	PollInterval time.Duration
+	// Lease is how long a claimed schedule is reserved for this instance
+	Lease time.Duration
+}
+
+func (c SchedulerConfig) withDefaults() SchedulerConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 50
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = 10 * time.Second
+	}
+	if c.Lease <= 0 {
+		c.Lease = 5 * time.Minute
+	}
+	return c
+}
+
+// Scheduler executes due transfer schedules
+type Scheduler struct {
+	repo      repository.ScheduleRepository
+	transfers Transferer
+	cfg       SchedulerConfig
+}
+
+func NewScheduler(repo repository.ScheduleRepository, transfers Transferer, cfg SchedulerConfig) *Scheduler {
+	return &Scheduler{repo: repo, transfers: transfers, cfg: cfg.withDefaults()}
+}
+
+// Run polls for due schedules until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	const op = "schedule.Scheduler.Run"
+
+	ticker := time.NewTicker(s.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := s.ProcessBatch(ctx)
+			if err != nil {
+				logging.FromContext(ctx).Error("scheduled transfers failed", logging.KeyOp, op, logging.Err(err))
+				break
+			}
+			if n < s.cfg.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessBatch executes one batch of due schedules and returns how many were
+// claimed. A schedule whose run can't be recorded doesn't hold up the rest of
+// the batch, the errors are returned together once every schedule was run.
+func (s *Scheduler) ProcessBatch(ctx context.Context) (int, error) {
+	const op = "schedule.Scheduler.ProcessBatch"
+
+	// claimed rows are leased to this instance, other instances skip them
+	schedules, err := s.repo.ClaimDueSchedules(ctx, s.cfg.BatchSize, s.cfg.Lease)
+	if err != nil {
+		return 0, errors.WrapInternal(op, err)
+	}
+	var errs []error
+	for i := range schedules {
+		if err := s.execute(ctx, &schedules[i]); err != nil {
+			// its lease runs out and the run is reported as interrupted, the
+			// transfer isn't repeated
+			logging.FromContext(ctx).Error("scheduled run not recorded",
+				logging.KeyOp, op, "schedule_id", schedules[i].ID, logging.Err(err))
+			errs = append(errs, err)
+		}
+	}
+	return len(schedules), errors.WrapInternal(op, stderrors.Join(errs...))
+}
+
+func (s *Scheduler) execute(ctx context.Context, claimed *model.ClaimedSchedule) error {
+	const op = "schedule.Scheduler.execute"
+	sched := &claimed.TransferSchedule
+	now := time.Now().UTC()
+
+	run := &model.ScheduleRun{
+		ScheduleID:   sched.ID,
+		ScheduledFor: *sched.NextRunAt,
+		Attempt:      sched.Attempt + 1,
+		ExecutedAt:   now,
+	}
+
+	var transferErr error
+	if claimed.Interrupted {
+		// an instance died mid-run, the transfer may have gone through so it is
+		// not repeated; the owner can check the transaction history
+		transferErr = errors.NewConflict(op, "run was interrupted before its outcome was recorded")
+	} else {
+		reference := sched.Reference
+		if reference == "" {
+			reference = "schedule " + sched.ID.String()
+		}
+		_, transferErr = s.transfers.Transfer(ctx, sched.UserID, sched.ToUserID, sched.Amount, sched.Currency, reference)
+	}
+
+	sched.LastRunAt = &now
+	sched.LastError = nil
+	switch {
+	case transferErr == nil:
+		run.Status = model.RunSucceeded
+		sched.Runs++
+		sched.Attempt = 0
+		advance(ctx, sched, now)
+	case !claimed.Interrupted && retryable(transferErr) && sched.Attempt < sched.MaxRetries:
+		run.Status = model.RunRetrying
+		sched.Attempt++
+		retryAt := now.Add(time.Duration(sched.RetryIntervalSeconds) * time.Second)
+		sched.NextRunAt = &retryAt
+	default:
+		run.Status = model.RunFailed
+		sched.Attempt = 0
+		if claimed.Interrupted || retryable(transferErr) {
+			// give up on this occurrence, recurring schedules carry on with the next
+			advance(ctx, sched, now)
+			if sched.Kind == model.ScheduleOnce {
+				sched.Status = model.ScheduleFailed
+			}
+		} else {
+			// e.g. a currency mismatch, every later run would fail the same way
+			sched.Status = model.ScheduleFailed
+			sched.NextRunAt = nil
+		}
+	}
+	if transferErr != nil {
+		msg := errors.MessageOf(transferErr)
+		run.Error = &msg
+		sched.LastError = &msg
+	}
+
+	metrics.ScheduledTransfers.WithLabelValues(run.Status).Inc()
+	log := logging.FromContext(ctx).With(
+		logging.KeyOp, op,
+		"schedule_id", sched.ID,
+		logging.KeyUser, sched.UserID,
+		"attempt", run.Attempt,
+		"outcome", run.Status,
+	)
+	if transferErr != nil {
+		log.Warn("scheduled transfer failed", logging.Err(transferErr))
+	} else {
+		log.Info("scheduled transfer executed")
+	}
+
+	return errors.WrapInternal(op, s.repo.RecordRun(ctx, sched, run))
+}
+
+// advance moves the schedule to its next occurrence, completing it when there is none
+func advance(ctx context.Context, sched *model.TransferSchedule, now time.Time) {
+	const op = "schedule.advance"
+
+	next, ok, err := Next(sched, now)
+	switch {
+	case err != nil:
+		// only reachable for rows that bypassed validation, don't retry them forever
+		logging.FromContext(ctx).Error("invalid schedule rule", logging.KeyOp, op, "schedule_id", sched.ID, logging.Err(err))
+		sched.Status = model.ScheduleFailed
+		sched.NextRunAt = nil
+	case !ok:
+		sched.Status = model.ScheduleCompleted
+		sched.NextRunAt = nil
+	default:
+		sched.NextRunAt = &next
+	}
+}
+
+// retryable reports whether a failed transfer may succeed later, e.g. once the
+// payer has been topped up
+func retryable(err error) bool {
+	switch errors.TypeOf(err) {
+	case errors.InsufficientFund, errors.Conflict, errors.Internal:
+		return true
+	default:
+		return false
+	}
+}
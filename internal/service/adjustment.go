@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+func (s *walletService) Adjust(ctx context.Context, req model.AdjustmentRequest) (adjustment *model.Adjustment, err error) {
+	const op = "service.Adjust"
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, op,
+		tracing.AttrOperation.String(model.TxAdjustment),
+		tracing.AttrUserID.String(req.UserID.String()),
+		tracing.AttrCurrency.String(req.Currency),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	req.Operator = strings.TrimSpace(req.Operator)
+	if req.Amount.IsZero() {
+		return nil, errors.NewInvalidInput(op, "amount", req.Amount)
+	}
+	if !slices.Contains(model.AdjustmentReasonCodes, req.ReasonCode) {
+		return nil, errors.NewInvalidInput(op, "reason_code", req.ReasonCode)
+	}
+	if req.Operator == "" {
+		return nil, errors.NewInvalidInput(op, "operator", "an operator is required")
+	}
+	ctx = operatorContext(ctx, req.Operator)
+
+	wallet, err := s.utils.GetOrCreateWallet(ctx, req.UserID, req.Currency)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	now := time.Now().UTC()
+	adjustment = &model.Adjustment{
+		ID:          uuid.New(),
+		WalletID:    wallet.ID,
+		UserID:      req.UserID,
+		Currency:    req.Currency,
+		Amount:      req.Amount,
+		ReasonCode:  req.ReasonCode,
+		Note:        strings.TrimSpace(req.Note),
+		RequestedBy: req.Operator,
+		Status:      model.AdjustmentPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	tx, err := s.utils.TxManager.BeginTx(ctx)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// the wallet's lock makes concurrent adjustments count each other, so a
+	// large one can't be split into several applied without approval
+	if _, err = tx.GetWalletForUpdate(ctx, wallet.ID); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	unreviewed, err := tx.UnreviewedAdjustmentTotal(ctx, wallet.ID, now.Truncate(24*time.Hour))
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	var recorded *model.Transaction
+	if unreviewed.Add(adjustment.Amount.Abs()).LessThanOrEqual(s.adjustmentApprovalThreshold(req.Currency)) {
+		if recorded, err = s.applyAdjustment(ctx, op, tx, adjustment, now); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.CreateAdjustmentTx(ctx, adjustment); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err = auditAdjustment(ctx, tx, audit.ActionAdjustmentRequest, nil, adjustment); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	if recorded != nil {
+		metrics.ObserveOperation(model.TxAdjustment, adjustment.Currency, adjustment.Amount, start, nil)
+		s.notifier.Notify(ctx, model.NewBalanceUpdate(recorded))
+	}
+	logAdjustment(ctx, op, adjustment)
+	return adjustment, nil
+}
+
+// adjustmentApprovalThreshold is how much a wallet in the currency is
+// adjusted per UTC day without a second operator's approval
+func (s *walletService) adjustmentApprovalThreshold(currency string) decimal.Decimal {
+	if threshold, ok := s.approvalThresholds[currency]; ok {
+		return threshold
+	}
+	return s.approvalThreshold
+}
+
+func (s *walletService) ApproveAdjustment(ctx context.Context, adjustmentID uuid.UUID, operator string) (*model.Adjustment, error) {
+	const op = "service.ApproveAdjustment"
+	return s.reviewAdjustment(ctx, op, adjustmentID, operator, true)
+}
+
+func (s *walletService) RejectAdjustment(ctx context.Context, adjustmentID uuid.UUID, operator string) (*model.Adjustment, error) {
+	const op = "service.RejectAdjustment"
+	return s.reviewAdjustment(ctx, op, adjustmentID, operator, false)
+}
+
+// reviewAdjustment resolves a pending adjustment under its row lock, so only
+// one of several concurrent reviews succeeds and the others are conflicts
+func (s *walletService) reviewAdjustment(ctx context.Context, op string, adjustmentID uuid.UUID, operator string, approve bool) (_ *model.Adjustment, err error) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, op, tracing.AttrOperation.String(model.TxAdjustment))
+	defer func() { tracing.End(span, err) }()
+
+	operator = strings.TrimSpace(operator)
+	if operator == "" {
+		return nil, errors.NewInvalidInput(op, "operator", "an operator is required")
+	}
+	ctx = operatorContext(ctx, operator)
+
+	tx, err := s.utils.TxManager.BeginTx(ctx)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	before, err := tx.GetAdjustmentForUpdate(ctx, adjustmentID)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if before.Status != model.AdjustmentPending {
+		return nil, errors.NewConflict(op, "adjustment is already "+before.Status)
+	}
+	if before.RequestedBy == operator {
+		return nil, errors.NewInvalidInput(op, "operator", "an adjustment is reviewed by another operator than the one who asked for it")
+	}
+
+	now := time.Now().UTC()
+	adjustment := *before
+	adjustment.ReviewedBy = &operator
+	action := audit.ActionAdjustmentReject
+	var recorded *model.Transaction
+	if approve {
+		action = audit.ActionAdjustmentApprove
+		if recorded, err = s.applyAdjustment(ctx, op, tx, &adjustment, now); err != nil {
+			return nil, err
+		}
+	} else {
+		adjustment.Status = model.AdjustmentRejected
+		adjustment.ResolvedAt = &now
+	}
+	adjustment.UpdatedAt = now
+	if err = tx.ResolveAdjustmentTx(ctx, &adjustment); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err = auditAdjustment(ctx, tx, action, before, &adjustment); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	if recorded != nil {
+		metrics.ObserveOperation(model.TxAdjustment, adjustment.Currency, adjustment.Amount, start, nil)
+		s.notifier.Notify(ctx, model.NewBalanceUpdate(recorded))
+	}
+	logAdjustment(ctx, op, &adjustment)
+	return &adjustment, nil
+}
+
+// applyAdjustment moves the wallet's balance by the adjustment's amount and
+// records the ledger entry. A frozen wallet is adjusted too, operators
+// correct balances whatever the wallet's state, but never below zero.
+func (s *walletService) applyAdjustment(ctx context.Context, op string, tx repository.WalletTx, adjustment *model.Adjustment, now time.Time) (*model.Transaction, error) {
+	wallet, err := tx.GetWalletForUpdate(ctx, adjustment.WalletID)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	newBalance := wallet.Balance.Add(adjustment.Amount)
+	if newBalance.IsNegative() {
+		return nil, errors.NewInsufficientBalance(op)
+	}
+	if err := tx.UpdateWalletBalanceTx(ctx, wallet.ID, newBalance); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	recorded, err := s.utils.RecordBalanceChange(ctx, tx, wallet, adjustment.Amount, newBalance, adjustment.Reference(), model.TxAdjustment)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	adjustment.Status = model.AdjustmentApplied
+	adjustment.TransactionID = &recorded.ID
+	adjustment.ResolvedAt = &now
+	return recorded, nil
+}
+
+func auditAdjustment(ctx context.Context, tx repository.WalletTx, action string, before, after *model.Adjustment) error {
+	var beforeState any
+	if before != nil {
+		beforeState = audit.SnapshotAdjustment(before)
+	}
+	event, err := audit.NewEvent(ctx, audit.OperatorActor(after.RequestedBy), action, audit.TargetAdjustment,
+		after.ID.String(), beforeState, audit.SnapshotAdjustment(after))
+	if err != nil {
+		return err
+	}
+	return tx.CreateAuditEventTx(ctx, event)
+}
+
+// operatorContext makes the operator the actor of the audit events written
+// with ctx, keeping the rest of the request's metadata
+func operatorContext(ctx context.Context, operator string) context.Context {
+	md := audit.MetadataFromContext(ctx)
+	md.Actor = audit.OperatorActor(operator)
+	return audit.WithMetadata(ctx, md)
+}
+
+func logAdjustment(ctx context.Context, op string, adjustment *model.Adjustment) {
+	attrs := []any{
+		logging.KeyOp, op,
+		logging.KeyUser, adjustment.UserID,
+		logging.KeyWallet, adjustment.WalletID,
+		logging.KeyCurrency, adjustment.Currency,
+		"adjustment", adjustment.ID,
+		"amount", adjustment.Amount,
+		"reason_code", adjustment.ReasonCode,
+		"status", adjustment.Status,
+		"requested_by", adjustment.RequestedBy,
+	}
+	if adjustment.ReviewedBy != nil {
+		attrs = append(attrs, "reviewed_by", *adjustment.ReviewedBy)
+	}
+	if adjustment.TransactionID != nil {
+		attrs = append(attrs, logging.KeyTxID, *adjustment.TransactionID)
+	}
+	logging.FromContext(ctx).Info("adjustment "+adjustment.Status, attrs...)
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+)
+
+const auditVerifyBatchSize = 500
+
+type AuditService interface {
+	ListEvents(ctx context.Context, filter model.AuditFilter, page, pageSize int) ([]model.AuditEvent, error)
+	VerifyChain(ctx context.Context) (*model.AuditVerification, error)
+}
+
+type auditService struct {
+	repo repository.AuditRepository
+}
+
+func NewAuditService(repo repository.AuditRepository) AuditService {
+	return &auditService{repo: repo}
+}
+
+func (s *auditService) ListEvents(ctx context.Context, filter model.AuditFilter, page, pageSize int) ([]model.AuditEvent, error) {
+	const op = "service.ListAuditEvents"
+
+	if page < 1 {
+		return nil, errors.NewInvalidInput(op, "page", page)
+	}
+	if pageSize < 1 || pageSize > 100 {
+		return nil, errors.NewInvalidInput(op, "pageSize", pageSize)
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return nil, errors.NewInvalidInput(op, "time range", "from must be before to")
+	}
+	filter.Offset = (page - 1) * pageSize
+	filter.Limit = pageSize
+
+	events, err := s.repo.ListEvents(ctx, filter)
+	return events, errors.WrapInternal(op, err)
+}
+
+// VerifyChain walks the whole audit log in insertion order and recomputes the hash chain
+func (s *auditService) VerifyChain(ctx context.Context) (*model.AuditVerification, error) {
+	const op = "service.VerifyAuditChain"
+
+	verifier := audit.NewVerifier()
+	var lastID int64
+	for {
+		events, err := s.repo.ListChain(ctx, lastID, auditVerifyBatchSize)
+		if err != nil {
+			return nil, errors.WrapInternal(op, err)
+		}
+		for i := range events {
+			if !verifier.Check(&events[i]) {
+				return verifier.Result(), nil
+			}
+			lastID = events[i].ID
+		}
+		if len(events) < auditVerifyBatchSize {
+			return verifier.Result(), nil
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// maxDailyBalanceDays caps the length of one daily balance series
+	maxDailyBalanceDays = 366
+	// snapshotGrace delays a day's snapshot so DB transactions started before
+	// midnight have committed by the time it is taken
+	snapshotGrace = 10 * time.Minute
+)
+
+type BalanceHistoryService interface {
+	// GetBalanceAt returns the user's balance in currency at the given time
+	GetBalanceAt(ctx context.Context, userID uuid.UUID, currency string, at time.Time) (decimal.Decimal, error)
+	// GetDailyBalances returns end-of-day balances for each UTC day from from to to inclusive
+	GetDailyBalances(ctx context.Context, userID uuid.UUID, currency string, from, to time.Time) ([]model.DailyBalance, error)
+	// SnapshotDay stores every wallet's balance at the end of the given UTC day
+	SnapshotDay(ctx context.Context, day time.Time) (int64, error)
+}
+
+type balanceHistoryService struct {
+	repo       repository.BalanceHistoryRepository
+	walletRepo repository.WalletRepository
+}
+
+func NewBalanceHistoryService(repo repository.BalanceHistoryRepository, walletRepo repository.WalletRepository) BalanceHistoryService {
+	return &balanceHistoryService{repo: repo, walletRepo: walletRepo}
+}
+
+func (s *balanceHistoryService) GetBalanceAt(ctx context.Context, userID uuid.UUID, currency string, at time.Time) (decimal.Decimal, error) {
+	const op = "service.GetBalanceAt"
+
+	if at.After(time.Now()) {
+		return decimal.Zero, errors.NewInvalidInput(op, "at", at)
+	}
+	wallet, err := s.walletRepo.GetWalletByUserAndCurrency(ctx, userID, currency)
+	if err != nil {
+		return decimal.Zero, errors.WrapInternal(op, err)
+	}
+
+	balance, err := s.repo.GetBalanceAt(ctx, wallet.ID, at)
+	return balance, errors.WrapInternal(op, err)
+}
+
+func (s *balanceHistoryService) GetDailyBalances(ctx context.Context, userID uuid.UUID, currency string, from, to time.Time) ([]model.DailyBalance, error) {
+	const op = "service.GetDailyBalances"
+
+	from, to = utcDay(from), utcDay(to)
+	if to.After(utcDay(time.Now())) {
+		return nil, errors.NewInvalidInput(op, "to", to.Format(time.DateOnly))
+	}
+	if from.After(to) {
+		return nil, errors.NewInvalidInput(op, "from", from.Format(time.DateOnly))
+	}
+	if to.Sub(from) >= maxDailyBalanceDays*24*time.Hour {
+		return nil, errors.NewInvalidInput(op, "from", from.Format(time.DateOnly))
+	}
+	wallet, err := s.walletRepo.GetWalletByUserAndCurrency(ctx, userID, currency)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	balances, err := s.repo.GetDailyBalances(ctx, wallet.ID, from, to)
+	return balances, errors.WrapInternal(op, err)
+}
+
+func (s *balanceHistoryService) SnapshotDay(ctx context.Context, day time.Time) (int64, error) {
+	const op = "service.SnapshotDay"
+
+	day = utcDay(day)
+	if day.AddDate(0, 0, 1).Add(snapshotGrace).After(time.Now()) {
+		return 0, errors.NewInvalidInput(op, "day", day.Format(time.DateOnly))
+	}
+
+	n, err := s.repo.CreateSnapshots(ctx, day)
+	if err != nil {
+		return 0, errors.WrapInternal(op, err)
+	}
+	metrics.BalanceSnapshots.Add(float64(n))
+	if n > 0 {
+		logging.FromContext(ctx).Info("balance snapshots written",
+			logging.KeyOp, op,
+			"day", day.Format(time.DateOnly),
+			"count", n,
+		)
+	}
+	return n, nil
+}
+
+// RunBalanceSnapshots snapshots the last completed UTC day every interval until
+// ctx is cancelled. Days already snapshotted are skipped, so instances can share the work.
+func RunBalanceSnapshots(ctx context.Context, s BalanceHistoryService, interval time.Duration) {
+	const op = "service.RunBalanceSnapshots"
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		day := utcDay(time.Now().Add(-snapshotGrace)).AddDate(0, 0, -1)
+		if _, err := s.SnapshotDay(ctx, day); err != nil {
+			logging.FromContext(ctx).Error("writing balance snapshots failed", logging.KeyOp, op, logging.Err(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// utcDay truncates t to the start of its UTC day
+func utcDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
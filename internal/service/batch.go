@@ -0,0 +1,509 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+type BatchService interface {
+	// Submit records the batch and runs it, or queues it when it is large or
+	// async was requested. Resubmitting an idempotency key returns the original
+	// batch with created false.
+	Submit(ctx context.Context, userID uuid.UUID, idempotencyKey string, req model.CreateBatchRequest) (batch *model.Batch, created bool, err error)
+	GetBatch(ctx context.Context, userID, batchID uuid.UUID) (*model.Batch, error)
+	// ProcessPending runs the oldest queued batch, or one whose worker died,
+	// and reports whether there was one
+	ProcessPending(ctx context.Context) (bool, error)
+}
+
+type BatchConfig struct {
+	// MaxItems caps the size of one batch
+	MaxItems int
+	// AsyncThreshold is the item count above which batches are always queued
+	AsyncThreshold int
+	// Lease is how long a batch stays with its worker without progress, after
+	// which the batch worker takes it over
+	Lease time.Duration
+}
+
+type batchService struct {
+	repo    repository.BatchRepository
+	wallets WalletService
+	cfg     BatchConfig
+}
+
+func NewBatchService(repo repository.BatchRepository, wallets WalletService, cfg BatchConfig) BatchService {
+	if cfg.MaxItems <= 0 {
+		cfg.MaxItems = 10000
+	}
+	if cfg.AsyncThreshold <= 0 {
+		cfg.AsyncThreshold = 100
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = 5 * time.Minute
+	}
+	return &batchService{repo: repo, wallets: wallets, cfg: cfg}
+}
+
+func (s *batchService) Submit(
+	ctx context.Context,
+	userID uuid.UUID,
+	idempotencyKey string,
+	req model.CreateBatchRequest,
+) (*model.Batch, bool, error) {
+	const op = "service.SubmitBatch"
+
+	if idempotencyKey == "" || len(idempotencyKey) > 255 {
+		return nil, false, errors.NewInvalidInput(op, "idempotency key", idempotencyKey)
+	}
+	if len(req.Items) == 0 || len(req.Items) > s.cfg.MaxItems {
+		return nil, false, errors.NewInvalidInput(op, "item count", len(req.Items))
+	}
+	if req.Mode == "" {
+		req.Mode = model.BatchAtomic
+	}
+	if req.Mode != model.BatchAtomic && req.Mode != model.BatchBestEffort {
+		return nil, false, errors.NewInvalidInput(op, "mode", req.Mode)
+	}
+	for i, item := range req.Items {
+		if err := validateBatchItem(op, i, item); err != nil {
+			return nil, false, err
+		}
+	}
+
+	items, err := json.Marshal(req.Items)
+	if err != nil {
+		return nil, false, errors.WrapInternal(op, err)
+	}
+	async := req.Async || len(req.Items) > s.cfg.AsyncThreshold
+	now := time.Now().UTC()
+	batch := &model.Batch{
+		ID:             uuid.New(),
+		UserID:         userID,
+		IdempotencyKey: idempotencyKey,
+		RequestHash:    batchHash(req.Mode, items),
+		Mode:           req.Mode,
+		Status:         model.BatchProcessing,
+		Items:          string(items),
+		ItemCount:      len(req.Items),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if async {
+		batch.Status = model.BatchPending
+	} else {
+		// leased to this request, the worker takes over if the instance dies
+		lockedUntil := now.Add(s.cfg.Lease)
+		batch.LockedUntil, batch.Claims = &lockedUntil, 1
+	}
+
+	created, err := s.repo.CreateBatch(ctx, batch)
+	if err != nil {
+		return nil, false, errors.WrapInternal(op, err)
+	}
+	if !created {
+		existing, err := s.repo.GetBatchByIdempotencyKey(ctx, userID, idempotencyKey)
+		if err != nil {
+			return nil, false, errors.WrapInternal(op, err)
+		}
+		if existing.RequestHash != batch.RequestHash {
+			return nil, false, errors.NewConflict(op, "idempotency key was already used for a different batch")
+		}
+		return existing, false, nil
+	}
+	if async {
+		return batch, true, nil
+	}
+
+	// the batch is recorded as processing, finish it even if the client goes away
+	if err := s.process(context.WithoutCancel(ctx), batch, req.Items); err != nil {
+		return nil, false, errors.WrapInternal(op, err)
+	}
+	return batch, true, nil
+}
+
+func validateBatchItem(op string, i int, item model.BatchItem) error {
+	field := func(name string) string { return fmt.Sprintf("items[%d].%s", i, name) }
+
+	if item.Amount.LessThanOrEqual(decimal.Zero) {
+		return errors.NewInvalidInput(op, field("amount"), item.Amount)
+	}
+	switch item.Type {
+	case model.BatchItemDeposit, model.BatchItemWithdraw:
+		if item.ToUserID != nil {
+			return errors.NewInvalidInput(op, field("to_user_id"), *item.ToUserID)
+		}
+	case model.BatchItemTransfer:
+		if item.ToUserID == nil || *item.ToUserID == item.UserID {
+			return errors.NewInvalidInput(op, field("to_user_id"), item.ToUserID)
+		}
+	default:
+		return errors.NewInvalidInput(op, field("type"), item.Type)
+	}
+	return nil
+}
+
+func batchHash(mode string, items []byte) string {
+	sum := sha256.Sum256(bytes.Join([][]byte{[]byte(mode), items}, []byte{'\n'}))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *batchService) GetBatch(ctx context.Context, userID, batchID uuid.UUID) (*model.Batch, error) {
+	const op = "service.GetBatch"
+
+	batch, err := s.repo.GetBatch(ctx, batchID)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	// other users' batches are reported as missing rather than forbidden
+	if batch.UserID != userID {
+		return nil, errors.NewNotFound(op, "batch")
+	}
+	return batch, nil
+}
+
+func (s *batchService) ProcessPending(ctx context.Context) (bool, error) {
+	const op = "service.ProcessPendingBatch"
+
+	batch, err := s.repo.ClaimPendingBatch(ctx, s.cfg.Lease)
+	if err != nil || batch == nil {
+		return false, errors.WrapInternal(op, err)
+	}
+	var items []model.BatchItem
+	if err := json.Unmarshal([]byte(batch.Items), &items); err != nil {
+		return true, errors.WrapInternal(op, err)
+	}
+	return true, errors.WrapInternal(op, s.process(ctx, batch, items))
+}
+
+// process runs the items and stores the outcome on batch. A batch taken over
+// from a worker that died carries that worker's progress: the items it
+// finished keep their outcomes and the one it was applying is interrupted,
+// it isn't applied again since it may have gone through.
+func (s *batchService) process(ctx context.Context, batch *model.Batch, items []model.BatchItem) (err error) {
+	const op = "service.processBatch"
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, op, tracing.AttrOperation.String("batch"))
+	defer func() { tracing.End(span, err) }()
+
+	var results []model.BatchItemResult
+	if batch.Results != nil {
+		if err := json.Unmarshal([]byte(*batch.Results), &results); err != nil {
+			return errors.WrapInternal(op, err)
+		}
+	}
+	interrupted := func(i int) model.BatchItemResult {
+		return model.BatchItemResult{Index: i, Status: model.BatchItemInterrupted, Error: "interrupted by a restart, check the transaction history"}
+	}
+
+	if batch.Mode == model.BatchAtomic {
+		if batch.InFlight != nil {
+			// the DB transaction may have committed before the worker died
+			results = make([]model.BatchItemResult, len(items))
+			for i := range results {
+				results[i] = interrupted(i)
+			}
+		} else {
+			if held, err := s.recordProgress(ctx, batch, nil, 0); err != nil || !held {
+				return err
+			}
+			var applyErr error
+			results, applyErr = s.wallets.ApplyAtomic(ctx, items)
+			if results == nil {
+				// failed before reaching any item, e.g. the DB transaction could not start
+				results = make([]model.BatchItemResult, len(items))
+				for i := range results {
+					results[i] = model.BatchItemResult{Index: i, Status: model.BatchItemFailed, Error: errors.MessageOf(applyErr)}
+				}
+			}
+		}
+	} else {
+		if batch.InFlight != nil {
+			results = append(results[:min(len(results), *batch.InFlight)], interrupted(*batch.InFlight))
+		}
+		for i := len(results); i < len(items); i++ {
+			if held, err := s.recordProgress(ctx, batch, results, i); err != nil || !held {
+				return err
+			}
+			results = append(results, s.applyItem(ctx, i, items[i]))
+		}
+	}
+
+	batch.Succeeded, batch.Failed = tally(results)
+	for _, r := range results {
+		metrics.BatchItems.WithLabelValues(batch.Mode, r.Status).Inc()
+	}
+	batch.Status = model.BatchCompleted
+	if batch.Mode == model.BatchAtomic && batch.Failed > 0 {
+		batch.Status = model.BatchFailed
+	}
+	encoded, err := json.Marshal(results)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	resultsJSON := string(encoded)
+	completedAt := time.Now().UTC()
+	batch.Results = &resultsJSON
+	batch.CompletedAt = &completedAt
+	batch.InFlight = nil
+
+	if err := s.repo.CompleteBatch(ctx, batch); err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	metrics.Batches.WithLabelValues(batch.Mode, batch.Status).Inc()
+	logging.FromContext(ctx).Info("batch processed",
+		logging.KeyOp, op,
+		"batch_id", batch.ID,
+		"mode", batch.Mode,
+		"status", batch.Status,
+		"succeeded", batch.Succeeded,
+		"failed", batch.Failed,
+		logging.KeyDuration, time.Since(start),
+	)
+	return nil
+}
+
+// recordProgress stores the results before item next, which is about to be
+// applied, and renews the lease. held is false when another worker took the
+// batch over, this one must leave it alone.
+func (s *batchService) recordProgress(ctx context.Context, batch *model.Batch, results []model.BatchItemResult, next int) (bool, error) {
+	const op = "service.recordBatchProgress"
+
+	var resultsJSON *string
+	if len(results) > 0 {
+		encoded, err := json.Marshal(results)
+		if err != nil {
+			return false, errors.WrapInternal(op, err)
+		}
+		resultsJSON = new(string)
+		*resultsJSON = string(encoded)
+	}
+	batch.Results, batch.InFlight = resultsJSON, &next
+	batch.Succeeded, batch.Failed = tally(results)
+
+	held, err := s.repo.RecordBatchProgress(ctx, batch, s.cfg.Lease)
+	if err != nil {
+		return false, errors.WrapInternal(op, err)
+	}
+	if !held {
+		logging.FromContext(ctx).Warn("batch lease lost, another worker took it over",
+			logging.KeyOp, op, "batch_id", batch.ID, "item", next)
+	}
+	return held, nil
+}
+
+func tally(results []model.BatchItemResult) (succeeded, failed int) {
+	for _, r := range results {
+		if r.Status == model.BatchItemSucceeded {
+			succeeded++
+		} else {
+			failed++
+		}
+	}
+	return succeeded, failed
+}
+
+// applyItem runs one best-effort item through the regular wallet operations
+func (s *batchService) applyItem(ctx context.Context, i int, item model.BatchItem) model.BatchItemResult {
+	var (
+		resp *model.WalletResponse
+		err  error
+	)
+	switch item.Type {
+	case model.BatchItemDeposit:
+		resp, err = s.wallets.Deposit(ctx, item.UserID, item.Amount, item.Currency, item.Reference)
+	case model.BatchItemWithdraw:
+		resp, err = s.wallets.Withdraw(ctx, item.UserID, item.Amount, item.Currency, item.Reference)
+	case model.BatchItemTransfer:
+		resp, err = s.wallets.Transfer(ctx, item.UserID, *item.ToUserID, item.Amount, item.Currency, item.Reference)
+	}
+	if err != nil {
+		return model.BatchItemResult{Index: i, Status: model.BatchItemFailed, Error: errors.MessageOf(err)}
+	}
+	return model.BatchItemResult{Index: i, Status: model.BatchItemSucceeded, Balance: &resp.Balance}
+}
+
+// RunBatchWorker processes queued batches until ctx is cancelled
+func RunBatchWorker(ctx context.Context, s BatchService, pollInterval time.Duration) {
+	const op = "service.RunBatchWorker"
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+
+	for {
+		processed, err := s.ProcessPending(ctx)
+		if err != nil {
+			logging.FromContext(ctx).Error("batch processing failed", logging.KeyOp, op, logging.Err(err))
+		}
+		if processed {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+type walletKey struct {
+	userID   uuid.UUID
+	currency string
+}
+
+func (s *walletService) ApplyAtomic(ctx context.Context, items []model.BatchItem) (results []model.BatchItemResult, err error) {
+	const op = "service.ApplyAtomic"
+	ctx, span := tracing.Start(ctx, op, tracing.AttrOperation.String("batch"))
+	defer func() { tracing.End(span, err) }()
+
+	// missing wallets are created up front, outside the batch's DB transaction
+	wallets := make(map[walletKey]*model.Wallet)
+	for _, item := range items {
+		userIDs := []uuid.UUID{item.UserID}
+		if item.ToUserID != nil {
+			userIDs = append(userIDs, *item.ToUserID)
+		}
+		for _, userID := range userIDs {
+			key := walletKey{userID, item.Currency}
+			if _, ok := wallets[key]; ok {
+				continue
+			}
+			if wallets[key], err = s.utils.GetOrCreateWallet(ctx, userID, item.Currency); err != nil {
+				return nil, errors.WrapInternal(op, err)
+			}
+		}
+	}
+
+	tx, err := s.utils.TxManager.BeginTx(ctx)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// lock in wallet id order so batches sharing wallets can't deadlock
+	keys := make([]walletKey, 0, len(wallets))
+	for key := range wallets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(wallets[keys[i]].ID[:], wallets[keys[j]].ID[:]) < 0
+	})
+	for _, key := range keys {
+		if wallets[key], err = tx.GetWalletForUpdate(ctx, wallets[key].ID); err != nil {
+			return nil, errors.WrapInternal(op, err)
+		}
+	}
+
+	results = make([]model.BatchItemResult, len(items))
+	var recorded []*model.Transaction
+	for i, item := range items {
+		itemTxs, itemErr := s.applyBatchItem(ctx, tx, wallets, item)
+		if itemErr != nil {
+			for j := range results {
+				results[j] = model.BatchItemResult{Index: j, Status: model.BatchItemRolledBack}
+			}
+			results[i] = model.BatchItemResult{Index: i, Status: model.BatchItemFailed, Error: errors.MessageOf(itemErr)}
+			return results, errors.WrapInternal(op, itemErr)
+		}
+		results[i] = model.BatchItemResult{
+			Index:         i,
+			Status:        model.BatchItemSucceeded,
+			TransactionID: &itemTxs[0].ID,
+			Balance:       &itemTxs[0].BalanceAfter,
+		}
+		recorded = append(recorded, itemTxs...)
+	}
+
+	// the rows are locked, so the version check only bumps the version for
+	// concurrent optimistic writers that read the wallet before the lock
+	for _, key := range keys {
+		w := wallets[key]
+		rows, err := tx.UpdateWalletBalanceWithVersionTx(ctx, w.ID, w.Balance, w.Version)
+		if err != nil {
+			return nil, errors.WrapInternal(op, err)
+		}
+		if rows != 1 {
+			return nil, errors.NewConflict(op, "wallet changed while locked")
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	for _, t := range recorded {
+		s.notifier.Notify(ctx, model.NewBalanceUpdate(t))
+	}
+	return results, nil
+}
+
+// applyBatchItem records one item against the locked wallets and updates their
+// balances in memory, the caller writes the final balances.
+func (s *walletService) applyBatchItem(
+	ctx context.Context,
+	tx repository.WalletTx,
+	wallets map[walletKey]*model.Wallet,
+	item model.BatchItem,
+) ([]*model.Transaction, error) {
+	const op = "service.applyBatchItem"
+	from := wallets[walletKey{item.UserID, item.Currency}]
+
+	switch item.Type {
+	case model.BatchItemDeposit, model.BatchItemWithdraw:
+		amount, txType := item.Amount, "deposit"
+		if item.Type == model.BatchItemWithdraw {
+			amount, txType = item.Amount.Neg(), "withdrawal"
+		}
+		if amount.IsNegative() && from.Frozen() {
+			return nil, errors.NewWalletFrozen(op)
+		}
+		newBalance := from.Balance.Add(amount)
+		if newBalance.IsNegative() {
+			return nil, errors.NewInsufficientBalance(op)
+		}
+		t, err := s.utils.RecordBalanceChange(ctx, tx, from, amount, newBalance, item.Reference, txType)
+		if err != nil {
+			return nil, errors.WrapInternal(op, err)
+		}
+		from.Balance = newBalance
+		return []*model.Transaction{t}, nil
+
+	case model.BatchItemTransfer:
+		to := wallets[walletKey{*item.ToUserID, item.Currency}]
+		if err := s.utils.ValidateTransfer(from, to, item.Amount); err != nil {
+			return nil, err
+		}
+		fromTx, toTx, err := s.utils.CreateTransferTransactions(ctx, tx, from, to, item.Amount, item.Reference)
+		if err != nil {
+			return nil, errors.WrapInternal(op, err)
+		}
+		if err := s.utils.AuditTransfer(ctx, tx, from, to, item.Amount); err != nil {
+			return nil, errors.WrapInternal(op, err)
+		}
+		if err := s.utils.RecordTransferEvents(ctx, tx, fromTx.ID, from, to, item.Amount, item.Reference); err != nil {
+			return nil, errors.WrapInternal(op, err)
+		}
+		from.Balance = from.Balance.Sub(item.Amount)
+		to.Balance = to.Balance.Add(item.Amount)
+		return []*model.Transaction{fromTx, toTx}, nil
+	}
+	return nil, errors.NewInvalidInput(op, "type", item.Type)
+}
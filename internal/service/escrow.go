@@ -0,0 +1,489 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/events"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/Jiang-hao/walletApiService/internal/util"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// escrowDeadlineBatch caps how many overdue escrows one ResolveDue call handles
+const escrowDeadlineBatch = 100
+
+type EscrowService interface {
+	// CreateEscrow moves the amount from the buyer's wallet into a new escrow wallet
+	CreateEscrow(ctx context.Context, buyerID uuid.UUID, req model.CreateEscrowRequest) (*model.Escrow, error)
+	// GetEscrow returns an escrow the user is buyer, seller or arbiter of
+	GetEscrow(ctx context.Context, userID, escrowID uuid.UUID) (*model.Escrow, error)
+	ListEscrows(ctx context.Context, userID uuid.UUID, page, pageSize int) ([]model.Escrow, error)
+	// Release pays the held funds to the seller, on the buyer's or arbiter's decision
+	Release(ctx context.Context, userID, escrowID uuid.UUID) (*model.Escrow, error)
+	// Refund returns the held funds to the buyer, on the seller's or arbiter's decision
+	Refund(ctx context.Context, userID, escrowID uuid.UUID) (*model.Escrow, error)
+	// Split pays sellerAmount to the seller and the rest back to the buyer, on the arbiter's decision
+	Split(ctx context.Context, userID, escrowID uuid.UUID, sellerAmount decimal.Decimal) (*model.Escrow, error)
+	// ResolveDue applies the deadline action of held escrows past their deadline
+	// and returns how many it resolved.
+	ResolveDue(ctx context.Context) (int, error)
+}
+
+type escrowService struct {
+	repo     repository.EscrowRepository
+	utils    *util.WalletUtil
+	notifier Notifier
+}
+
+func NewEscrowService(
+	repo repository.EscrowRepository,
+	walletRepo repository.WalletRepository,
+	transactionRepo repository.TransactionRepository,
+	txManager repository.TxManager,
+	notifier Notifier,
+) EscrowService {
+	if notifier == nil {
+		notifier = nopNotifier{}
+	}
+	return &escrowService{
+		repo:     repo,
+		utils:    util.NewWalletUtil(walletRepo, transactionRepo, txManager),
+		notifier: notifier,
+	}
+}
+
+func (s *escrowService) CreateEscrow(ctx context.Context, buyerID uuid.UUID, req model.CreateEscrowRequest) (escrow *model.Escrow, err error) {
+	const op = "service.CreateEscrow"
+	ctx, span := tracing.Start(ctx, op,
+		tracing.AttrOperation.String("escrow_hold"),
+		tracing.AttrUserID.String(buyerID.String()),
+		tracing.AttrCurrency.String(req.Currency),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		return nil, errors.NewInvalidInput(op, "amount", req.Amount)
+	}
+	if req.SellerID == buyerID {
+		return nil, errors.NewInvalidInput(op, "seller_id", req.SellerID)
+	}
+	if req.ArbiterID != nil && (*req.ArbiterID == buyerID || *req.ArbiterID == req.SellerID) {
+		return nil, errors.NewInvalidInput(op, "arbiter_id", *req.ArbiterID)
+	}
+	if req.Deadline != nil && !req.Deadline.After(time.Now()) {
+		return nil, errors.NewInvalidInput(op, "deadline", *req.Deadline)
+	}
+	if req.DeadlineAction == "" {
+		req.DeadlineAction = model.EscrowDeadlineRelease
+	}
+	if req.DeadlineAction != model.EscrowDeadlineRelease && req.DeadlineAction != model.EscrowDeadlineRefund {
+		return nil, errors.NewInvalidInput(op, "deadline_action", req.DeadlineAction)
+	}
+
+	buyerWallet, err := s.utils.GetOrCreateWallet(ctx, buyerID, req.Currency)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	now := time.Now().UTC()
+	escrow = &model.Escrow{
+		ID:             uuid.New(),
+		BuyerID:        buyerID,
+		SellerID:       req.SellerID,
+		ArbiterID:      req.ArbiterID,
+		Amount:         req.Amount,
+		Currency:       req.Currency,
+		Reference:      req.Reference,
+		Status:         model.EscrowHeld,
+		DeadlineAction: req.DeadlineAction,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if req.Deadline != nil {
+		deadline := req.Deadline.UTC()
+		escrow.Deadline = &deadline
+	}
+	escrowWallet := &model.Wallet{
+		ID:       uuid.New(),
+		UserID:   model.EscrowUserID,
+		Currency: req.Currency,
+		Balance:  decimal.Zero,
+		EscrowID: &escrow.ID,
+	}
+	escrow.WalletID = escrowWallet.ID
+
+	tx, err := s.utils.TxManager.BeginTx(ctx)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if buyerWallet, err = tx.GetWalletForUpdate(ctx, buyerWallet.ID); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if buyerWallet.Frozen() {
+		return nil, errors.NewWalletFrozen(op)
+	}
+	if buyerWallet.Balance.LessThan(req.Amount) {
+		return nil, errors.NewInsufficientBalance(op)
+	}
+
+	if err = tx.CreateWalletTx(ctx, escrowWallet); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	created, err := events.NewWalletCreated(escrowWallet)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err = tx.CreateOutboxEventTx(ctx, created); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err = tx.CreateEscrowTx(ctx, escrow); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	recorded, err := s.move(ctx, tx, escrow, buyerWallet, escrowWallet, req.Amount,
+		model.TxEscrowHold, audit.ActionEscrowHold, audit.UserActor(buyerID))
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err = writeBalances(ctx, op, tx, buyerWallet, escrowWallet); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	metrics.Escrows.WithLabelValues(model.EscrowHeld).Inc()
+	s.notify(ctx, recorded)
+	return escrow, nil
+}
+
+func (s *escrowService) GetEscrow(ctx context.Context, userID, escrowID uuid.UUID) (*model.Escrow, error) {
+	const op = "service.GetEscrow"
+
+	escrow, err := s.repo.GetEscrow(ctx, escrowID)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if !isEscrowParty(escrow, userID) {
+		return nil, errors.NewNotFound(op, "escrow")
+	}
+	return escrow, nil
+}
+
+func (s *escrowService) ListEscrows(ctx context.Context, userID uuid.UUID, page, pageSize int) ([]model.Escrow, error) {
+	const op = "service.ListEscrows"
+
+	if page < 1 {
+		return nil, errors.NewInvalidInput(op, "page", page)
+	}
+	if pageSize < 1 || pageSize > 100 {
+		return nil, errors.NewInvalidInput(op, "pageSize", pageSize)
+	}
+
+	escrows, err := s.repo.ListEscrows(ctx, userID, (page-1)*pageSize, pageSize)
+	return escrows, errors.WrapInternal(op, err)
+}
+
+func (s *escrowService) Release(ctx context.Context, userID, escrowID uuid.UUID) (*model.Escrow, error) {
+	const op = "service.ReleaseEscrow"
+
+	escrow, err := s.GetEscrow(ctx, userID, escrowID)
+	if err != nil {
+		return nil, err
+	}
+	if userID != escrow.BuyerID && !isArbiter(escrow, userID) {
+		return nil, errors.NewForbidden(op, "only the buyer or the arbiter can release an escrow")
+	}
+	return s.resolve(ctx, op, escrow, &userID, func(e *model.Escrow) (decimal.Decimal, error) {
+		return e.Amount, nil
+	})
+}
+
+func (s *escrowService) Refund(ctx context.Context, userID, escrowID uuid.UUID) (*model.Escrow, error) {
+	const op = "service.RefundEscrow"
+
+	escrow, err := s.GetEscrow(ctx, userID, escrowID)
+	if err != nil {
+		return nil, err
+	}
+	if userID != escrow.SellerID && !isArbiter(escrow, userID) {
+		return nil, errors.NewForbidden(op, "only the seller or the arbiter can refund an escrow")
+	}
+	return s.resolve(ctx, op, escrow, &userID, func(e *model.Escrow) (decimal.Decimal, error) {
+		return decimal.Zero, nil
+	})
+}
+
+func (s *escrowService) Split(ctx context.Context, userID, escrowID uuid.UUID, sellerAmount decimal.Decimal) (*model.Escrow, error) {
+	const op = "service.SplitEscrow"
+
+	escrow, err := s.GetEscrow(ctx, userID, escrowID)
+	if err != nil {
+		return nil, err
+	}
+	if !isArbiter(escrow, userID) {
+		return nil, errors.NewForbidden(op, "only the arbiter can split an escrow")
+	}
+	if !sellerAmount.IsPositive() || !sellerAmount.LessThan(escrow.Amount) {
+		return nil, errors.NewInvalidInput(op, "seller_amount", sellerAmount)
+	}
+	return s.resolve(ctx, op, escrow, &userID, func(e *model.Escrow) (decimal.Decimal, error) {
+		return sellerAmount, nil
+	})
+}
+
+func (s *escrowService) ResolveDue(ctx context.Context) (int, error) {
+	const op = "service.ResolveDueEscrows"
+
+	ids, err := s.repo.ListDueEscrows(ctx, escrowDeadlineBatch)
+	if err != nil {
+		return 0, errors.WrapInternal(op, err)
+	}
+
+	resolved := 0
+	for _, id := range ids {
+		escrow, err := s.repo.GetEscrow(ctx, id)
+		if err != nil {
+			return resolved, errors.WrapInternal(op, err)
+		}
+		_, err = s.resolve(ctx, op, escrow, nil, func(e *model.Escrow) (decimal.Decimal, error) {
+			// re-checked under the row lock, a party may have resolved it meanwhile
+			if e.Deadline == nil || e.Deadline.After(time.Now()) {
+				return decimal.Zero, errors.NewConflict(op, "escrow deadline has not passed")
+			}
+			if e.DeadlineAction == model.EscrowDeadlineRefund {
+				return decimal.Zero, nil
+			}
+			return e.Amount, nil
+		})
+		switch {
+		case err == nil:
+			resolved++
+		case errors.TypeOf(err) == errors.Conflict:
+			// resolved by one of the parties or another instance first
+		default:
+			logging.FromContext(ctx).Error("escrow deadline resolution failed",
+				logging.KeyOp, op, "escrow_id", id, logging.Err(err))
+		}
+	}
+	return resolved, nil
+}
+
+// RunEscrowDeadlines resolves overdue escrows every interval until ctx is cancelled
+func RunEscrowDeadlines(ctx context.Context, s EscrowService, interval time.Duration) {
+	const op = "service.RunEscrowDeadlines"
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := s.ResolveDue(ctx)
+			if err != nil {
+				logging.FromContext(ctx).Error("resolving escrow deadlines failed", logging.KeyOp, op, logging.Err(err))
+				break
+			}
+			if n < escrowDeadlineBatch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resolve pays out a held escrow, sellerShare going to the seller and the rest
+// back to the buyer, in one DB transaction. resolvedBy is nil for deadline runs.
+func (s *escrowService) resolve(
+	ctx context.Context,
+	op string,
+	escrow *model.Escrow,
+	resolvedBy *uuid.UUID,
+	sellerShare func(e *model.Escrow) (decimal.Decimal, error),
+) (_ *model.Escrow, err error) {
+	ctx, span := tracing.Start(ctx, op, tracing.AttrOperation.String("escrow_resolve"))
+	defer func() { tracing.End(span, err) }()
+
+	// payees' wallets may not exist yet, they are created in their own DB transaction
+	seller, err := s.utils.GetOrCreateWallet(ctx, escrow.SellerID, escrow.Currency)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	buyer, err := s.utils.GetOrCreateWallet(ctx, escrow.BuyerID, escrow.Currency)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	tx, err := s.utils.TxManager.BeginTx(ctx)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// the escrow row lock serializes concurrent decisions, the loser sees it resolved
+	if escrow, err = tx.GetEscrowForUpdate(ctx, escrow.ID); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if escrow.Status != model.EscrowHeld {
+		return nil, errors.NewConflict(op, "escrow is already "+escrow.Status)
+	}
+	release, err := sellerShare(escrow)
+	if err != nil {
+		return nil, err
+	}
+	refund := escrow.Amount.Sub(release)
+
+	wallets := []*model.Wallet{{ID: escrow.WalletID}, seller, buyer}
+	// lock in wallet id order so escrows sharing wallets can't deadlock
+	sort.Slice(wallets, func(i, j int) bool { return bytes.Compare(wallets[i].ID[:], wallets[j].ID[:]) < 0 })
+	locked := make(map[uuid.UUID]*model.Wallet, len(wallets))
+	for _, w := range wallets {
+		if locked[w.ID], err = tx.GetWalletForUpdate(ctx, w.ID); err != nil {
+			return nil, errors.WrapInternal(op, err)
+		}
+	}
+	escrowWallet, seller, buyer := locked[escrow.WalletID], locked[seller.ID], locked[buyer.ID]
+	if escrowWallet.Balance.LessThan(escrow.Amount) {
+		return nil, errors.NewInternal(op, fmt.Errorf("escrow wallet %s holds %s, less than the escrowed %s",
+			escrowWallet.ID, escrowWallet.Balance, escrow.Amount))
+	}
+
+	actor := audit.SystemActor("escrow-deadline")
+	if resolvedBy != nil {
+		actor = audit.UserActor(*resolvedBy)
+	}
+	var recorded []*model.Transaction
+	if release.IsPositive() {
+		txs, err := s.move(ctx, tx, escrow, escrowWallet, seller, release, model.TxEscrowRelease, audit.ActionEscrowRelease, actor)
+		if err != nil {
+			return nil, errors.WrapInternal(op, err)
+		}
+		recorded = append(recorded, txs...)
+	}
+	if refund.IsPositive() {
+		txs, err := s.move(ctx, tx, escrow, escrowWallet, buyer, refund, model.TxEscrowRefund, audit.ActionEscrowRefund, actor)
+		if err != nil {
+			return nil, errors.WrapInternal(op, err)
+		}
+		recorded = append(recorded, txs...)
+	}
+	if err = writeBalances(ctx, op, tx, escrowWallet, seller, buyer); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	escrow.ReleasedAmount = release
+	escrow.RefundedAmount = refund
+	escrow.ResolvedBy = resolvedBy
+	escrow.ResolvedAt = &now
+	switch {
+	case refund.IsZero():
+		escrow.Status = model.EscrowReleased
+	case release.IsZero():
+		escrow.Status = model.EscrowRefunded
+	default:
+		escrow.Status = model.EscrowSplit
+	}
+	if err = tx.ResolveEscrowTx(ctx, escrow); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	metrics.Escrows.WithLabelValues(escrow.Status).Inc()
+	logging.FromContext(ctx).Info("escrow resolved",
+		logging.KeyOp, op,
+		"escrow_id", escrow.ID,
+		"status", escrow.Status,
+		"actor", actor,
+	)
+	s.notify(ctx, recorded)
+	return escrow, nil
+}
+
+// move records amount leaving from for to as linked ledger entries with their
+// audit and domain events, and updates both balances in memory. The caller
+// writes the final balances.
+func (s *escrowService) move(
+	ctx context.Context,
+	tx repository.WalletTx,
+	escrow *model.Escrow,
+	from, to *model.Wallet,
+	amount decimal.Decimal,
+	txType, action, actor string,
+) ([]*model.Transaction, error) {
+	const op = "service.moveEscrowFunds"
+	reference := "escrow " + escrow.ID.String()
+
+	fromTx, toTx, err := s.utils.CreateLinkedTransactions(ctx, tx, from, to, amount, reference, txType)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err := s.utils.AuditMove(ctx, tx, action, actor, from, to, amount); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err := s.utils.RecordTransferEvents(ctx, tx, fromTx.ID, from, to, amount, reference); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	from.Balance = from.Balance.Sub(amount)
+	to.Balance = to.Balance.Add(amount)
+	return []*model.Transaction{fromTx, toTx}, nil
+}
+
+func (s *escrowService) notify(ctx context.Context, recorded []*model.Transaction) {
+	for _, t := range recorded {
+		if t.UserID != model.EscrowUserID {
+			s.notifier.Notify(ctx, model.NewBalanceUpdate(t))
+		}
+	}
+}
+
+// writeBalances stores the in-memory balances of locked wallets, the version
+// check only trips for optimistic writers that read a wallet before the lock
+func writeBalances(ctx context.Context, op string, tx repository.WalletTx, wallets ...*model.Wallet) error {
+	for _, w := range wallets {
+		rows, err := tx.UpdateWalletBalanceWithVersionTx(ctx, w.ID, w.Balance, w.Version)
+		if err != nil {
+			return errors.WrapInternal(op, err)
+		}
+		if rows != 1 {
+			return errors.NewConflict(op, "wallet changed while locked")
+		}
+	}
+	return nil
+}
+
+func isEscrowParty(e *model.Escrow, userID uuid.UUID) bool {
+	return userID == e.BuyerID || userID == e.SellerID || isArbiter(e, userID)
+}
+
+func isArbiter(e *model.Escrow, userID uuid.UUID) bool {
+	return e.ArbiterID != nil && *e.ArbiterID == userID
+}
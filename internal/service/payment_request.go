@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultPaymentRequestExpiry = 7 * 24 * time.Hour
+	minPaymentRequestExpiry     = time.Minute
+	maxPaymentRequestExpiry     = 30 * 24 * time.Hour
+)
+
+type PaymentRequestService interface {
+	CreatePaymentRequest(ctx context.Context, requesterID uuid.UUID, req model.CreatePaymentRequest) (*model.PaymentRequest, error)
+	// GetPaymentRequest returns a request the user sent or has to pay
+	GetPaymentRequest(ctx context.Context, userID, requestID uuid.UUID) (*model.PaymentRequest, error)
+	ListPaymentRequests(ctx context.Context, userID uuid.UUID, direction, status string, page, pageSize int) ([]model.PaymentRequest, error)
+	// AcceptPaymentRequest transfers the amount from the payer to the requester
+	// and marks the request accepted in the same DB transaction. Only one of
+	// several concurrent accepts succeeds, the others are conflicts.
+	AcceptPaymentRequest(ctx context.Context, payerID, requestID uuid.UUID) (*model.PaymentRequest, error)
+	DeclinePaymentRequest(ctx context.Context, payerID, requestID uuid.UUID) (*model.PaymentRequest, error)
+	CancelPaymentRequest(ctx context.Context, requesterID, requestID uuid.UUID) (*model.PaymentRequest, error)
+	// ExpirePaymentRequests marks overdue pending requests as expired
+	ExpirePaymentRequests(ctx context.Context) (int64, error)
+}
+
+type paymentRequestService struct {
+	repo    repository.PaymentRequestRepository
+	wallets WalletService
+}
+
+func NewPaymentRequestService(repo repository.PaymentRequestRepository, wallets WalletService) PaymentRequestService {
+	return &paymentRequestService{repo: repo, wallets: wallets}
+}
+
+func (s *paymentRequestService) CreatePaymentRequest(ctx context.Context, requesterID uuid.UUID, req model.CreatePaymentRequest) (*model.PaymentRequest, error) {
+	const op = "service.CreatePaymentRequest"
+
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		return nil, errors.NewInvalidInput(op, "amount", req.Amount)
+	}
+	if req.PayerID == requesterID {
+		return nil, errors.NewInvalidInput(op, "payer_id", req.PayerID)
+	}
+	expiry := defaultPaymentRequestExpiry
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d < minPaymentRequestExpiry || d > maxPaymentRequestExpiry {
+			return nil, errors.NewInvalidInput(op, "expires_in", req.ExpiresIn)
+		}
+		expiry = d
+	}
+
+	now := time.Now().UTC()
+	pr := &model.PaymentRequest{
+		ID:          uuid.New(),
+		RequesterID: requesterID,
+		PayerID:     req.PayerID,
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Note:        req.Note,
+		Status:      model.PaymentRequestPending,
+		ExpiresAt:   now.Add(expiry),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.repo.CreatePaymentRequest(ctx, pr); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	metrics.PaymentRequests.WithLabelValues(model.PaymentRequestPending).Inc()
+	return pr, nil
+}
+
+func (s *paymentRequestService) GetPaymentRequest(ctx context.Context, userID, requestID uuid.UUID) (*model.PaymentRequest, error) {
+	const op = "service.GetPaymentRequest"
+
+	pr, err := s.repo.GetPaymentRequest(ctx, requestID)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if pr.RequesterID != userID && pr.PayerID != userID {
+		return nil, errors.NewNotFound(op, "payment request")
+	}
+	return pr, nil
+}
+
+func (s *paymentRequestService) ListPaymentRequests(ctx context.Context, userID uuid.UUID, direction, status string, page, pageSize int) ([]model.PaymentRequest, error) {
+	const op = "service.ListPaymentRequests"
+
+	if direction != model.PaymentRequestsIncoming && direction != model.PaymentRequestsOutgoing {
+		return nil, errors.NewInvalidInput(op, "direction", direction)
+	}
+	switch status {
+	case "", model.PaymentRequestPending, model.PaymentRequestAccepted, model.PaymentRequestDeclined,
+		model.PaymentRequestCancelled, model.PaymentRequestExpired:
+	default:
+		return nil, errors.NewInvalidInput(op, "status", status)
+	}
+	if page < 1 {
+		return nil, errors.NewInvalidInput(op, "page", page)
+	}
+	if pageSize < 1 || pageSize > 100 {
+		return nil, errors.NewInvalidInput(op, "pageSize", pageSize)
+	}
+
+	reqs, err := s.repo.ListPaymentRequests(ctx, userID, direction, status, (page-1)*pageSize, pageSize)
+	return reqs, errors.WrapInternal(op, err)
+}
+
+func (s *paymentRequestService) AcceptPaymentRequest(ctx context.Context, payerID, requestID uuid.UUID) (*model.PaymentRequest, error) {
+	const op = "service.AcceptPaymentRequest"
+
+	pr, err := s.payerRequest(ctx, op, payerID, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	// accepted is claimed in the transfer's transaction, so a concurrent accept
+	// can't pay twice and a failed transfer leaves the request pending
+	_, err = s.wallets.TransferWithin(ctx, pr.PayerID, pr.RequesterID, pr.Amount, pr.Currency, "payment request "+pr.ID.String(),
+		func(ctx context.Context, tx repository.WalletTx) error {
+			ok, err := tx.TransitionPaymentRequestTx(ctx, pr.ID, model.PaymentRequestPending, model.PaymentRequestAccepted)
+			if err != nil {
+				return errors.WrapInternal(op, err)
+			}
+			if !ok {
+				return s.notMoved(ctx, op, pr)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	metrics.PaymentRequests.WithLabelValues(model.PaymentRequestAccepted).Inc()
+	return s.repo.GetPaymentRequest(ctx, pr.ID)
+}
+
+func (s *paymentRequestService) DeclinePaymentRequest(ctx context.Context, payerID, requestID uuid.UUID) (*model.PaymentRequest, error) {
+	const op = "service.DeclinePaymentRequest"
+
+	pr, err := s.payerRequest(ctx, op, payerID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.transition(ctx, op, pr, model.PaymentRequestPending, model.PaymentRequestDeclined); err != nil {
+		return nil, err
+	}
+	metrics.PaymentRequests.WithLabelValues(model.PaymentRequestDeclined).Inc()
+	return s.repo.GetPaymentRequest(ctx, pr.ID)
+}
+
+func (s *paymentRequestService) CancelPaymentRequest(ctx context.Context, requesterID, requestID uuid.UUID) (*model.PaymentRequest, error) {
+	const op = "service.CancelPaymentRequest"
+
+	pr, err := s.GetPaymentRequest(ctx, requesterID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if pr.RequesterID != requesterID {
+		return nil, errors.NewForbidden(op, "only the requester can cancel a payment request")
+	}
+	if err := s.transition(ctx, op, pr, model.PaymentRequestPending, model.PaymentRequestCancelled); err != nil {
+		return nil, err
+	}
+	metrics.PaymentRequests.WithLabelValues(model.PaymentRequestCancelled).Inc()
+	return s.repo.GetPaymentRequest(ctx, pr.ID)
+}
+
+func (s *paymentRequestService) ExpirePaymentRequests(ctx context.Context) (int64, error) {
+	const op = "service.ExpirePaymentRequests"
+
+	n, err := s.repo.ExpirePaymentRequests(ctx)
+	if err != nil {
+		return 0, errors.WrapInternal(op, err)
+	}
+	metrics.PaymentRequests.WithLabelValues(model.PaymentRequestExpired).Add(float64(n))
+	return n, nil
+}
+
+// RunPaymentRequestExpiry expires overdue payment requests every interval until ctx is cancelled
+func RunPaymentRequestExpiry(ctx context.Context, s PaymentRequestService, interval time.Duration) {
+	const op = "service.RunPaymentRequestExpiry"
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.ExpirePaymentRequests(ctx); err != nil {
+			logging.FromContext(ctx).Error("expiring payment requests failed", logging.KeyOp, op, logging.Err(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// payerRequest loads a request the user has to pay
+func (s *paymentRequestService) payerRequest(ctx context.Context, op string, payerID, requestID uuid.UUID) (*model.PaymentRequest, error) {
+	pr, err := s.GetPaymentRequest(ctx, payerID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if pr.PayerID != payerID {
+		return nil, errors.NewForbidden(op, "only the payer can accept or decline a payment request")
+	}
+	return pr, nil
+}
+
+// transition moves the request between statuses, reporting why it could not
+// when another request got there first or the request expired
+func (s *paymentRequestService) transition(ctx context.Context, op string, pr *model.PaymentRequest, from, to string) error {
+	ok, err := s.repo.TransitionPaymentRequest(ctx, pr.ID, from, to)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	if ok {
+		return nil
+	}
+	return s.notMoved(ctx, op, pr)
+}
+
+// notMoved reports why a pending request didn't move
+func (s *paymentRequestService) notMoved(ctx context.Context, op string, pr *model.PaymentRequest) error {
+	current, err := s.repo.GetPaymentRequest(ctx, pr.ID)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	if current.Status == model.PaymentRequestPending && !time.Now().Before(current.ExpiresAt) {
+		return errors.NewConflict(op, "payment request has expired")
+	}
+	return errors.NewConflict(op, "payment request is already "+current.Status)
+}
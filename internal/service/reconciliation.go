@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+)
+
+// defaultReconciliationLimit is how many discrepancies of each kind a report lists
+const defaultReconciliationLimit = 100
+
+type ReconciliationService interface {
+	// Reconcile checks every wallet against the transaction log and reports the discrepancies
+	Reconcile(ctx context.Context) (*model.ReconciliationReport, error)
+}
+
+type reconciliationService struct {
+	repo  repository.ReconciliationRepository
+	limit int
+}
+
+// NewReconciliationService lists up to limit discrepancies of each kind, 0 meaning the default
+func NewReconciliationService(repo repository.ReconciliationRepository, limit int) ReconciliationService {
+	if limit <= 0 {
+		limit = defaultReconciliationLimit
+	}
+	return &reconciliationService{repo: repo, limit: limit}
+}
+
+func (s *reconciliationService) Reconcile(ctx context.Context) (_ *model.ReconciliationReport, err error) {
+	const op = "service.Reconcile"
+	ctx, span := tracing.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	started := time.Now().UTC()
+	report, err := s.repo.Reconcile(ctx, s.limit)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	report.StartedAt = started
+	report.FinishedAt = time.Now().UTC()
+
+	for _, kind := range model.DiscrepancyKinds {
+		metrics.ReconciliationDiscrepancies.WithLabelValues(kind).Set(float64(report.Counts[kind]))
+	}
+	metrics.ReconciliationLastSuccess.Set(float64(report.FinishedAt.Unix()))
+
+	logger := logging.FromContext(ctx)
+	if report.Total() == 0 {
+		logger.Info("ledger reconciled",
+			logging.KeyOp, op,
+			"wallets", report.Wallets,
+			"transactions", report.Transactions,
+			"duration", report.FinishedAt.Sub(started),
+		)
+		return report, nil
+	}
+	for _, d := range report.Discrepancies {
+		logger.Warn("ledger discrepancy",
+			logging.KeyOp, op,
+			"kind", d.Kind,
+			logging.KeyWallet, d.WalletID,
+			logging.KeyTxID, d.TransactionID,
+			"currency", d.Currency,
+			"expected", d.Expected,
+			"actual", d.Actual,
+			"detail", d.Detail,
+		)
+	}
+	logger.Error("ledger reconciliation found discrepancies",
+		logging.KeyOp, op,
+		"wallets", report.Wallets,
+		"transactions", report.Transactions,
+		"discrepancies", report.Total(),
+		"truncated", report.Truncated,
+	)
+	return report, nil
+}
+
+// RunReconciliation reconciles the ledger every interval until ctx is cancelled
+func RunReconciliation(ctx context.Context, s ReconciliationService, interval time.Duration) {
+	const op = "service.RunReconciliation"
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Reconcile(ctx); err != nil {
+			logging.FromContext(ctx).Error("ledger reconciliation failed", logging.KeyOp, op, logging.Err(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/schedule"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultScheduleRetries       = 3
+	maxScheduleRetries           = 20
+	defaultScheduleRetryInterval = time.Hour
+	minScheduleInterval          = time.Minute
+)
+
+type ScheduleService interface {
+	CreateSchedule(ctx context.Context, userID uuid.UUID, req model.CreateScheduleRequest) (*model.TransferSchedule, error)
+	GetSchedule(ctx context.Context, userID, scheduleID uuid.UUID) (*model.TransferSchedule, error)
+	ListSchedules(ctx context.Context, userID uuid.UUID) ([]model.TransferSchedule, error)
+	PauseSchedule(ctx context.Context, userID, scheduleID uuid.UUID) error
+	// ResumeSchedule reactivates a paused schedule from its next occurrence,
+	// occurrences missed while paused are not made up.
+	ResumeSchedule(ctx context.Context, userID, scheduleID uuid.UUID) error
+	CancelSchedule(ctx context.Context, userID, scheduleID uuid.UUID) error
+	ListRuns(ctx context.Context, userID, scheduleID uuid.UUID, page, pageSize int) ([]model.ScheduleRun, error)
+}
+
+type scheduleService struct {
+	repo repository.ScheduleRepository
+}
+
+func NewScheduleService(repo repository.ScheduleRepository) ScheduleService {
+	return &scheduleService{repo: repo}
+}
+
+func (s *scheduleService) CreateSchedule(ctx context.Context, userID uuid.UUID, req model.CreateScheduleRequest) (*model.TransferSchedule, error) {
+	const op = "service.CreateSchedule"
+
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		return nil, errors.NewInvalidInput(op, "amount", req.Amount)
+	}
+	if req.ToUserID == userID {
+		return nil, errors.NewInvalidInput(op, "to_user_id", req.ToUserID)
+	}
+
+	now := time.Now().UTC()
+	sched := &model.TransferSchedule{
+		ID:                   uuid.New(),
+		UserID:               userID,
+		ToUserID:             req.ToUserID,
+		Amount:               req.Amount,
+		Currency:             req.Currency,
+		Reference:            req.Reference,
+		Timezone:             req.Timezone,
+		StartAt:              now,
+		EndAt:                req.EndAt,
+		Status:               model.ScheduleActive,
+		MaxRetries:           defaultScheduleRetries,
+		RetryIntervalSeconds: int64(defaultScheduleRetryInterval / time.Second),
+		CreatedAt:            now,
+		UpdatedAt:            now,
+	}
+	if req.RunAt != nil {
+		sched.StartAt = req.RunAt.UTC()
+	}
+	if sched.Timezone == "" {
+		sched.Timezone = "UTC"
+	}
+	if _, err := schedule.Location(sched.Timezone); err != nil {
+		return nil, errors.NewInvalidInput(op, "timezone", req.Timezone)
+	}
+
+	switch {
+	case req.Cron != "" && req.Interval != "":
+		return nil, errors.NewInvalidInput(op, "interval", "cron and interval are mutually exclusive")
+	case req.Cron != "":
+		if _, err := schedule.ParseCron(req.Cron); err != nil {
+			return nil, errors.NewInvalidInput(op, "cron", req.Cron)
+		}
+		sched.Kind = model.ScheduleCron
+		sched.CronExpr = &req.Cron
+	case req.Interval != "":
+		interval, err := time.ParseDuration(req.Interval)
+		if err != nil || interval < minScheduleInterval {
+			return nil, errors.NewInvalidInput(op, "interval", req.Interval)
+		}
+		seconds := int64(interval / time.Second)
+		sched.Kind = model.ScheduleInterval
+		sched.IntervalSeconds = &seconds
+	default:
+		if req.RunAt == nil {
+			return nil, errors.NewInvalidInput(op, "run_at", "required for a one-off transfer")
+		}
+		sched.Kind = model.ScheduleOnce
+	}
+
+	if req.MaxRetries != nil {
+		if *req.MaxRetries < 0 || *req.MaxRetries > maxScheduleRetries {
+			return nil, errors.NewInvalidInput(op, "max_retries", *req.MaxRetries)
+		}
+		sched.MaxRetries = *req.MaxRetries
+	}
+	if req.RetryInterval != "" {
+		retryInterval, err := time.ParseDuration(req.RetryInterval)
+		if err != nil || retryInterval < minScheduleInterval {
+			return nil, errors.NewInvalidInput(op, "retry_interval", req.RetryInterval)
+		}
+		sched.RetryIntervalSeconds = int64(retryInterval / time.Second)
+	}
+
+	next, ok, err := schedule.First(sched, now)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if !ok {
+		return nil, errors.NewInvalidInput(op, "end_at", "no run before end_at")
+	}
+	sched.NextRunAt = &next
+
+	if err := s.repo.CreateSchedule(ctx, sched); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	return sched, nil
+}
+
+func (s *scheduleService) GetSchedule(ctx context.Context, userID, scheduleID uuid.UUID) (*model.TransferSchedule, error) {
+	const op = "service.GetSchedule"
+	return s.ownSchedule(ctx, op, userID, scheduleID)
+}
+
+func (s *scheduleService) ListSchedules(ctx context.Context, userID uuid.UUID) ([]model.TransferSchedule, error) {
+	const op = "service.ListSchedules"
+
+	schedules, err := s.repo.ListSchedules(ctx, userID)
+	return schedules, errors.WrapInternal(op, err)
+}
+
+func (s *scheduleService) PauseSchedule(ctx context.Context, userID, scheduleID uuid.UUID) error {
+	const op = "service.PauseSchedule"
+
+	if _, err := s.ownSchedule(ctx, op, userID, scheduleID); err != nil {
+		return err
+	}
+	return s.setStatus(ctx, op, scheduleID, []string{model.ScheduleActive}, model.SchedulePaused, nil)
+}
+
+func (s *scheduleService) ResumeSchedule(ctx context.Context, userID, scheduleID uuid.UUID) error {
+	const op = "service.ResumeSchedule"
+
+	sched, err := s.ownSchedule(ctx, op, userID, scheduleID)
+	if err != nil {
+		return err
+	}
+	next, ok, err := schedule.First(sched, time.Now().UTC())
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	if !ok {
+		// end_at passed while paused
+		return s.setStatus(ctx, op, scheduleID, []string{model.SchedulePaused}, model.ScheduleCompleted, nil)
+	}
+	return s.setStatus(ctx, op, scheduleID, []string{model.SchedulePaused}, model.ScheduleActive, &next)
+}
+
+func (s *scheduleService) CancelSchedule(ctx context.Context, userID, scheduleID uuid.UUID) error {
+	const op = "service.CancelSchedule"
+
+	if _, err := s.ownSchedule(ctx, op, userID, scheduleID); err != nil {
+		return err
+	}
+	return s.setStatus(ctx, op, scheduleID, []string{model.ScheduleActive, model.SchedulePaused}, model.ScheduleCancelled, nil)
+}
+
+func (s *scheduleService) ListRuns(ctx context.Context, userID, scheduleID uuid.UUID, page, pageSize int) ([]model.ScheduleRun, error) {
+	const op = "service.ListScheduleRuns"
+
+	if page < 1 {
+		return nil, errors.NewInvalidInput(op, "page", page)
+	}
+	if pageSize < 1 || pageSize > 100 {
+		return nil, errors.NewInvalidInput(op, "pageSize", pageSize)
+	}
+	if _, err := s.ownSchedule(ctx, op, userID, scheduleID); err != nil {
+		return nil, err
+	}
+
+	runs, err := s.repo.ListRuns(ctx, scheduleID, (page-1)*pageSize, pageSize)
+	return runs, errors.WrapInternal(op, err)
+}
+
+// setStatus applies a status transition, a schedule not in one of from is a conflict
+func (s *scheduleService) setStatus(ctx context.Context, op string, scheduleID uuid.UUID, from []string, status string, nextRunAt *time.Time) error {
+	ok, err := s.repo.SetScheduleStatus(ctx, scheduleID, from, status, nextRunAt)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	if !ok {
+		return errors.NewConflict(op, "schedule cannot move to "+status+" from its current status")
+	}
+	return nil
+}
+
+// ownSchedule loads a schedule and reports other users' schedules as not found
+func (s *scheduleService) ownSchedule(ctx context.Context, op string, userID, scheduleID uuid.UUID) (*model.TransferSchedule, error) {
+	sched, err := s.repo.GetSchedule(ctx, scheduleID)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if sched.UserID != userID {
+		return nil, errors.NewNotFound(op, "transfer schedule")
+	}
+	return sched, nil
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/settlement"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+)
+
+type SettlementService interface {
+	// Import parses a settlement file, matches its lines to deposits and
+	// withdrawals by reference and amount, and marks the matched transactions
+	// settled. Importing the same file again returns the first import with
+	// created false.
+	Import(ctx context.Context, source, format string, file io.Reader) (s *model.Settlement, created bool, err error)
+	GetSettlement(ctx context.Context, id uuid.UUID) (*model.Settlement, error)
+	// ListLines pages through a settlement's lines, only those with the status
+	// unless it is empty
+	ListLines(ctx context.Context, id uuid.UUID, status string, page, pageSize int) ([]model.SettlementLine, error)
+}
+
+type settlementService struct {
+	repo repository.SettlementRepository
+}
+
+func NewSettlementService(repo repository.SettlementRepository) SettlementService {
+	return &settlementService{repo: repo}
+}
+
+func (s *settlementService) Import(ctx context.Context, source, format string, file io.Reader) (_ *model.Settlement, _ bool, err error) {
+	const op = "service.ImportSettlement"
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, op, tracing.AttrOperation.String("settlement"))
+	defer func() { tracing.End(span, err) }()
+
+	parser, err := settlement.NewParser(format)
+	if err != nil {
+		return nil, false, errors.NewInvalidInput(op, "format", format)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, false, errors.NewInvalidRequest(op, err)
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	existing, err := s.repo.GetSettlementByHash(ctx, hash)
+	if err == nil {
+		return existing, false, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, false, errors.WrapInternal(op, err)
+	}
+
+	lines, err := parser.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, errors.NewInvalidInput(op, "settlement file", err)
+	}
+	if len(lines) == 0 {
+		return nil, false, errors.NewInvalidInput(op, "file", "no settlement lines")
+	}
+
+	references := make([]string, 0, len(lines))
+	seen := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		if !seen[line.Reference] {
+			seen[line.Reference] = true
+			references = append(references, line.Reference)
+		}
+	}
+	candidates, err := s.repo.FindSettlementCandidates(ctx, references)
+	if err != nil {
+		return nil, false, errors.WrapInternal(op, err)
+	}
+
+	result := &model.Settlement{
+		ID:        uuid.New(),
+		Source:    source,
+		Format:    format,
+		FileHash:  hash,
+		LineCount: len(lines),
+		CreatedAt: time.Now().UTC(),
+	}
+	matched := matchSettlementLines(result, lines, candidates)
+
+	if err := s.repo.CreateSettlement(ctx, result, matched); err != nil {
+		if errors.TypeOf(err) == errors.Conflict {
+			// lost the race to an import of the same file
+			if existing, getErr := s.repo.GetSettlementByHash(ctx, hash); getErr == nil {
+				return existing, false, nil
+			}
+		}
+		return nil, false, errors.WrapInternal(op, err)
+	}
+
+	for _, line := range matched {
+		metrics.SettlementLines.WithLabelValues(format, line.Status).Inc()
+	}
+	logger := logging.FromContext(ctx).With(
+		logging.KeyOp, op,
+		"settlement_id", result.ID,
+		"source", source,
+		"format", format,
+		"lines", result.LineCount,
+		"matched", result.Matched,
+		"unmatched", result.Unmatched,
+		"mismatched", result.Mismatched,
+		"duplicate", result.Duplicate,
+		logging.KeyDuration, time.Since(start),
+	)
+	if result.Matched == result.LineCount {
+		logger.Info("settlement imported")
+	} else {
+		logger.Warn("settlement imported with exceptions")
+	}
+	return result, true, nil
+}
+
+// matchSettlementLines pairs each line with a deposit or withdrawal of the same
+// reference, counting the outcomes on s. A transaction settles at most one
+// line, the first unsettled exact match in ledger order.
+func matchSettlementLines(s *model.Settlement, lines []settlement.Line, candidates []model.Transaction) []model.SettlementLine {
+	byReference := make(map[string][]*model.Transaction)
+	for i := range candidates {
+		t := &candidates[i]
+		byReference[t.Reference] = append(byReference[t.Reference], t)
+	}
+	claimed := make(map[uuid.UUID]bool)
+
+	result := make([]model.SettlementLine, len(lines))
+	for i, line := range lines {
+		r := model.SettlementLine{
+			SettlementID: s.ID,
+			Position:     i + 1,
+			LineNumber:   line.Number,
+			Reference:    line.Reference,
+			Direction:    line.Direction,
+			Amount:       line.Amount,
+			Currency:     line.Currency,
+		}
+		if !line.ValueDate.IsZero() {
+			r.ValueDate = &line.ValueDate
+		}
+
+		var exact, settled, other *model.Transaction
+		for _, t := range byReference[line.Reference] {
+			isSettled := t.SettlementID != nil || claimed[t.ID]
+			switch {
+			case t.Currency == line.Currency && t.Amount.Equal(line.SignedAmount()):
+				if !isSettled && exact == nil {
+					exact = t
+				} else if isSettled && settled == nil {
+					settled = t
+				}
+			case other == nil:
+				other = t
+			}
+		}
+
+		switch {
+		case exact != nil:
+			claimed[exact.ID] = true
+			r.Status, r.TransactionID = model.SettlementMatched, &exact.ID
+			s.Matched++
+		case settled != nil:
+			r.Status, r.TransactionID = model.SettlementDuplicate, &settled.ID
+			r.Detail = "transaction is already settled"
+			if settled.SettlementID != nil {
+				r.Detail = fmt.Sprintf("transaction was settled by settlement %s", settled.SettlementID)
+			}
+			s.Duplicate++
+		case other != nil:
+			r.Status, r.TransactionID = model.SettlementMismatched, &other.ID
+			r.Detail = fmt.Sprintf("expected %s, the transaction is %s", describeLine(line), describeTransaction(other))
+			s.Mismatched++
+		default:
+			r.Status = model.SettlementUnmatched
+			r.Detail = "no deposit or withdrawal has this reference"
+			s.Unmatched++
+		}
+		result[i] = r
+	}
+	return result
+}
+
+func describeLine(line settlement.Line) string {
+	txType := "deposit"
+	if line.Direction == settlement.DirectionDebit {
+		txType = "withdrawal"
+	}
+	return fmt.Sprintf("a %s of %s %s", txType, line.Amount, line.Currency)
+}
+
+func describeTransaction(t *model.Transaction) string {
+	return fmt.Sprintf("a %s of %s %s", t.Type, t.Amount.Abs(), t.Currency)
+}
+
+func (s *settlementService) GetSettlement(ctx context.Context, id uuid.UUID) (*model.Settlement, error) {
+	const op = "service.GetSettlement"
+
+	result, err := s.repo.GetSettlement(ctx, id)
+	return result, errors.WrapInternal(op, err)
+}
+
+func (s *settlementService) ListLines(ctx context.Context, id uuid.UUID, status string, page, pageSize int) ([]model.SettlementLine, error) {
+	const op = "service.ListSettlementLines"
+
+	switch status {
+	case "", model.SettlementMatched, model.SettlementUnmatched, model.SettlementMismatched, model.SettlementDuplicate:
+	default:
+		return nil, errors.NewInvalidInput(op, "status", status)
+	}
+	if page < 1 {
+		return nil, errors.NewInvalidInput(op, "page", page)
+	}
+	if pageSize < 1 || pageSize > 100 {
+		return nil, errors.NewInvalidInput(op, "pageSize", pageSize)
+	}
+
+	// a missing settlement is a 404 rather than an empty page
+	if _, err := s.repo.GetSettlement(ctx, id); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	lines, err := s.repo.ListSettlementLines(ctx, id, status, (page-1)*pageSize, pageSize)
+	return lines, errors.WrapInternal(op, err)
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/statement"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/google/uuid"
+)
+
+type StatementService interface {
+	// WriteStatement renders the user's statement for [from, to) in the given
+	// format to out. Nothing is written to out when it fails validation.
+	WriteStatement(ctx context.Context, userID uuid.UUID, currency string, from, to time.Time, format string, out io.Writer) (statement.Summary, error)
+}
+
+type statementService struct {
+	repo       repository.StatementRepository
+	walletRepo repository.WalletRepository
+}
+
+func NewStatementService(repo repository.StatementRepository, walletRepo repository.WalletRepository) StatementService {
+	return &statementService{repo: repo, walletRepo: walletRepo}
+}
+
+func (s *statementService) WriteStatement(
+	ctx context.Context,
+	userID uuid.UUID,
+	currency string,
+	from, to time.Time,
+	format string,
+	out io.Writer,
+) (_ statement.Summary, err error) {
+	const op = "service.WriteStatement"
+	ctx, span := tracing.Start(ctx, op,
+		tracing.AttrUserID.String(userID.String()),
+		tracing.AttrCurrency.String(currency),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	// the closing balance of a period that hasn't ended is the balance so far
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+	if !from.Before(to) {
+		return statement.Summary{}, errors.NewInvalidInput(op, "from", from)
+	}
+	w, err := statement.NewWriter(format, out)
+	if err != nil {
+		return statement.Summary{}, errors.NewInvalidInput(op, "format", format)
+	}
+	wallet, err := s.walletRepo.GetWalletByUserAndCurrency(ctx, userID, currency)
+	if err != nil {
+		return statement.Summary{}, errors.WrapInternal(op, err)
+	}
+
+	rows, err := s.repo.OpenStatement(ctx, wallet.ID, from, to)
+	if err != nil {
+		return statement.Summary{}, errors.WrapInternal(op, err)
+	}
+	defer rows.Close()
+
+	header := statement.Header{
+		UserID:         userID,
+		WalletID:       wallet.ID,
+		Currency:       currency,
+		From:           from,
+		To:             to,
+		OpeningBalance: rows.OpeningBalance(),
+		ClosingBalance: rows.ClosingBalance(),
+	}
+	summary, err := statement.Write(w, header, rows)
+	if err != nil {
+		return summary, errors.WrapInternal(op, err)
+	}
+
+	check := "ok"
+	if !summary.Consistent {
+		check = "mismatch"
+		logging.FromContext(ctx).Error("statement balances don't add up",
+			logging.KeyOp, op,
+			logging.KeyWallet, wallet.ID,
+			"from", from,
+			"to", to,
+			"opening", header.OpeningBalance,
+			"movements", summary.Movements,
+			"closing", header.ClosingBalance,
+		)
+	}
+	metrics.Statements.WithLabelValues(format, check).Inc()
+	return summary, nil
+}
@@ -1,15 +1,18 @@
 package service
 
 import (
+	"bytes"
 	"context"
 
 	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
 	"github.com/Jiang-hao/walletApiService/internal/model"
 	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
 	"github.com/Jiang-hao/walletApiService/internal/util"
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
-	"log"
 	"time"
 )
 
@@ -17,31 +20,109 @@ type WalletService interface {
 	Deposit(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency, reference string) (*model.WalletResponse, error)
 	Withdraw(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency, reference string) (*model.WalletResponse, error)
 	Transfer(ctx context.Context, fromUserID, toUserID uuid.UUID, amount decimal.Decimal, currency, reference string) (*model.WalletResponse, error)
+	// TransferWithin is Transfer that first runs within in the transfer's DB
+	// transaction. An error from within rolls the transfer back and is returned.
+	TransferWithin(ctx context.Context, fromUserID, toUserID uuid.UUID, amount decimal.Decimal, currency, reference string, within func(ctx context.Context, tx repository.WalletTx) error) (*model.WalletResponse, error)
 	GetBalance(ctx context.Context, userID uuid.UUID, currency string) (decimal.Decimal, error)
+	// ApplyAtomic applies all items in one DB transaction. When an item fails
+	// nothing is written and the results say which item it was.
+	ApplyAtomic(ctx context.Context, items []model.BatchItem) ([]model.BatchItemResult, error)
 	GetTransactionHistory(ctx context.Context, userID uuid.UUID, currency string, page, pageSize int) ([]model.Transaction, error)
+	// Adjust credits or debits a wallet on an operator's decision. One taking
+	// the wallet's adjustments applied without review that UTC day above the
+	// currency's approval threshold is stored pending until another operator
+	// approves it.
+	Adjust(ctx context.Context, req model.AdjustmentRequest) (*model.Adjustment, error)
+	// ApproveAdjustment applies a pending adjustment. The approver can't be
+	// the operator who asked for it.
+	ApproveAdjustment(ctx context.Context, adjustmentID uuid.UUID, operator string) (*model.Adjustment, error)
+	RejectAdjustment(ctx context.Context, adjustmentID uuid.UUID, operator string) (*model.Adjustment, error)
 }
 
+// Notifier is told about every committed balance change, e.g. to push it to streaming clients
+type Notifier interface {
+	Notify(ctx context.Context, update model.BalanceUpdate)
+}
+
+type nopNotifier struct{}
+
+func (nopNotifier) Notify(context.Context, model.BalanceUpdate) {}
+
 type walletService struct {
-	utils *util.WalletUtil
+	utils    *util.WalletUtil
+	notifier Notifier
+	// adjustments taking a wallet's unreviewed adjustments of the day above
+	// this need a second operator's approval
+	approvalThreshold decimal.Decimal
+	// approvalThresholds overrides approvalThreshold per currency
+	approvalThresholds map[string]decimal.Decimal
+}
+
+type Option func(*walletService)
+
+// DefaultAdjustmentApprovalThreshold is how much a wallet is adjusted per day
+// without a second operator's approval, in a currency without a threshold of
+// its own
+var DefaultAdjustmentApprovalThreshold = decimal.NewFromInt(1000)
+
+func WithNotifier(n Notifier) Option {
+	return func(s *walletService) {
+		s.notifier = n
+	}
+}
+
+// WithAdjustmentApprovalThreshold sets how much, in absolute value, a wallet
+// is adjusted per day without approval. Zero makes every adjustment wait for one.
+func WithAdjustmentApprovalThreshold(threshold decimal.Decimal) Option {
+	return func(s *walletService) {
+		s.approvalThreshold = threshold.Abs()
+	}
+}
+
+// WithAdjustmentApprovalThresholds sets the threshold of each currency given,
+// the others keep the one of WithAdjustmentApprovalThreshold
+func WithAdjustmentApprovalThresholds(thresholds map[string]decimal.Decimal) Option {
+	return func(s *walletService) {
+		s.approvalThresholds = make(map[string]decimal.Decimal, len(thresholds))
+		for currency, threshold := range thresholds {
+			s.approvalThresholds[currency] = threshold.Abs()
+		}
+	}
 }
 
 func NewWalletService(
 	walletRepo repository.WalletRepository,
 	transactionRepo repository.TransactionRepository,
 	txManager repository.TxManager,
+	opts ...Option,
 ) WalletService {
-	return &walletService{
-		utils: util.NewWalletUtil(walletRepo, transactionRepo, txManager),
+	s := &walletService{
+		utils:    util.NewWalletUtil(walletRepo, transactionRepo, txManager),
+		notifier: nopNotifier{},
+
+		approvalThreshold: DefaultAdjustmentApprovalThreshold,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
-func (s *walletService) Deposit(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency, reference string) (*model.WalletResponse, error) {
+func (s *walletService) Deposit(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency, reference string) (resp *model.WalletResponse, err error) {
 	const op = "service.Deposit"
 	start := time.Now()
 	defer func() {
-		log.Printf("[%s] completed in %v", op, time.Since(start))
+		metrics.ObserveOperation("deposit", currency, amount, start, err)
+		logOperation(ctx, op, userID, currency, resp, start, err)
 	}()
 
+	ctx, span := tracing.Start(ctx, op,
+		tracing.AttrOperation.String("deposit"),
+		tracing.AttrUserID.String(userID.String()),
+		tracing.AttrCurrency.String(currency),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	if amount.LessThanOrEqual(decimal.Zero) {
 		return nil, errors.NewInvalidInput(op, "amount", amount)
 	}
@@ -51,16 +132,29 @@ func (s *walletService) Deposit(ctx context.Context, userID uuid.UUID, amount de
 		return nil, errors.WrapInternal(op, err)
 	}
 
-	return s.utils.UpdateBalanceWithRetry(ctx, wallet, amount, reference, "deposit", 3)
+	resp, tx, err := s.utils.UpdateBalanceWithRetry(ctx, wallet, amount, reference, "deposit", 3)
+	if err != nil {
+		return nil, err
+	}
+	s.notifier.Notify(ctx, model.NewBalanceUpdate(tx))
+	return resp, nil
 }
 
-func (s *walletService) Withdraw(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency, reference string) (*model.WalletResponse, error) {
+func (s *walletService) Withdraw(ctx context.Context, userID uuid.UUID, amount decimal.Decimal, currency, reference string) (resp *model.WalletResponse, err error) {
 	const op = "service.Withdraw"
 	start := time.Now()
 	defer func() {
-		log.Printf("[%s] completed in %v", op, time.Since(start))
+		metrics.ObserveOperation("withdraw", currency, amount, start, err)
+		logOperation(ctx, op, userID, currency, resp, start, err)
 	}()
 
+	ctx, span := tracing.Start(ctx, op,
+		tracing.AttrOperation.String("withdraw"),
+		tracing.AttrUserID.String(userID.String()),
+		tracing.AttrCurrency.String(currency),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	if amount.LessThanOrEqual(decimal.Zero) {
 		return nil, errors.NewInvalidInput(op, "amount", amount)
 	}
@@ -70,7 +164,12 @@ func (s *walletService) Withdraw(ctx context.Context, userID uuid.UUID, amount d
 		return nil, errors.WrapInternal(op, err)
 	}
 
-	return s.utils.UpdateBalanceWithRetry(ctx, wallet, amount.Neg(), reference, "withdrawal", 3)
+	resp, tx, err := s.utils.UpdateBalanceWithRetry(ctx, wallet, amount.Neg(), reference, "withdrawal", 3)
+	if err != nil {
+		return nil, err
+	}
+	s.notifier.Notify(ctx, model.NewBalanceUpdate(tx))
+	return resp, nil
 }
 
 func (s *walletService) Transfer(
@@ -79,15 +178,36 @@ func (s *walletService) Transfer(
 	amount decimal.Decimal,
 	currency, reference string,
 ) (*model.WalletResponse, error) {
+	return s.TransferWithin(ctx, fromUserID, toUserID, amount, currency, reference, nil)
+}
+
+func (s *walletService) TransferWithin(
+	ctx context.Context,
+	fromUserID, toUserID uuid.UUID,
+	amount decimal.Decimal,
+	currency, reference string,
+	within func(ctx context.Context, tx repository.WalletTx) error,
+) (resp *model.WalletResponse, err error) {
 	const op = "service.Transfer"
 	start := time.Now()
 	defer func() {
-		log.Printf("[%s] completed in %v", op, time.Since(start))
+		metrics.ObserveOperation("transfer", currency, amount, start, err)
+		logOperation(ctx, op, fromUserID, currency, resp, start, err)
 	}()
 
+	ctx, span := tracing.Start(ctx, op,
+		tracing.AttrOperation.String("transfer"),
+		tracing.AttrUserID.String(fromUserID.String()),
+		tracing.AttrCurrency.String(currency),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	if amount.LessThanOrEqual(decimal.Zero) {
 		return nil, errors.NewInvalidInput(op, "amount", amount)
 	}
+	if fromUserID == toUserID {
+		return nil, errors.NewInvalidInput(op, "to_user_id", toUserID)
+	}
 
 	tx, err := s.utils.TxManager.BeginTx(ctx)
 	if err != nil {
@@ -98,6 +218,11 @@ func (s *walletService) Transfer(
 			tx.Rollback()
 		}
 	}()
+	if within != nil {
+		if err = within(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
 	var fromWallet, toWallet *model.Wallet
 	fromWallet, err = s.utils.GetOrCreateWallet(ctx, fromUserID, currency)
 	if err != nil {
@@ -109,6 +234,24 @@ func (s *walletService) Transfer(
 		return nil, errors.WrapInternal(op, err)
 	}
 
+	// re-read both balances under row locks, taken in wallet id order so
+	// opposite transfers between the same wallets can't deadlock
+	first, second := fromWallet, toWallet
+	if bytes.Compare(first.ID[:], second.ID[:]) > 0 {
+		first, second = second, first
+	}
+	if first, err = tx.GetWalletForUpdate(ctx, first.ID); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if second, err = tx.GetWalletForUpdate(ctx, second.ID); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if first.ID == fromWallet.ID {
+		fromWallet, toWallet = first, second
+	} else {
+		fromWallet, toWallet = second, first
+	}
+
 	if err = s.utils.ValidateTransfer(fromWallet, toWallet, amount); err != nil {
 		return nil, err
 	}
@@ -126,13 +269,24 @@ func (s *walletService) Transfer(
 	}
 
 	// update FROM - TO transaction (2 directions)
-	if err = s.utils.CreateTransferTransactions(ctx, tx, fromWallet, toWallet, amount, reference); err != nil {
+	fromTx, toTx, err := s.utils.CreateTransferTransactions(ctx, tx, fromWallet, toWallet, amount, reference)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	if err = s.utils.AuditTransfer(ctx, tx, fromWallet, toWallet, amount); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	if err = s.utils.RecordTransferEvents(ctx, tx, fromTx.ID, fromWallet, toWallet, amount, reference); err != nil {
 		return nil, errors.WrapInternal(op, err)
 	}
 
 	if err = tx.Commit(); err != nil {
 		return nil, errors.WrapInternal(op, err)
 	}
+	s.notifier.Notify(ctx, model.NewBalanceUpdate(fromTx))
+	s.notifier.Notify(ctx, model.NewBalanceUpdate(toTx))
 
 	return &model.WalletResponse{
 		ID:       fromWallet.ID,
@@ -142,8 +296,13 @@ func (s *walletService) Transfer(
 	}, nil
 }
 
-func (s *walletService) GetBalance(ctx context.Context, userID uuid.UUID, currency string) (decimal.Decimal, error) {
+func (s *walletService) GetBalance(ctx context.Context, userID uuid.UUID, currency string) (balance decimal.Decimal, err error) {
 	const op = "service.GetBalance"
+	ctx, span := tracing.Start(ctx, op,
+		tracing.AttrUserID.String(userID.String()),
+		tracing.AttrCurrency.String(currency),
+	)
+	defer func() { tracing.End(span, err) }()
 
 	wallet, err := s.utils.GetOrCreateWallet(ctx, userID, currency)
 	if err != nil {
@@ -157,8 +316,13 @@ func (s *walletService) GetTransactionHistory(
 	userID uuid.UUID,
 	currency string,
 	page, pageSize int,
-) ([]model.Transaction, error) {
+) (transactions []model.Transaction, err error) {
 	const op = "service.GetTransactionHistory"
+	ctx, span := tracing.Start(ctx, op,
+		tracing.AttrUserID.String(userID.String()),
+		tracing.AttrCurrency.String(currency),
+	)
+	defer func() { tracing.End(span, err) }()
 
 	if page < 1 {
 		return nil, errors.NewInvalidInput(op, "page", page)
@@ -179,3 +343,17 @@ func (s *walletService) GetTransactionHistory(
 	}
 
 }
+
+func logOperation(ctx context.Context, op string, userID uuid.UUID, currency string, resp *model.WalletResponse, start time.Time, err error) {
+	attrs := []any{
+		logging.KeyOp, op,
+		logging.KeyUser, userID,
+		logging.KeyCurrency, currency,
+		"outcome", metrics.Outcome(err),
+		logging.KeyDuration, time.Since(start),
+	}
+	if resp != nil {
+		attrs = append(attrs, logging.KeyWallet, resp.ID)
+	}
+	logging.FromContext(ctx).Info("wallet operation completed", attrs...)
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/util"
+	"github.com/google/uuid"
+)
+
+// WalletAdminService backs support and back-office tooling. Its changes are
+// audited with the actor in ctx's audit metadata, e.g. audit.OperatorActor.
+type WalletAdminService interface {
+	ListWallets(ctx context.Context, userID uuid.UUID) ([]model.Wallet, error)
+	// Freeze stops money leaving the user's wallet in currency, deposits and
+	// incoming transfers still go through
+	Freeze(ctx context.Context, userID uuid.UUID, currency, reason string) (*model.Wallet, error)
+	Unfreeze(ctx context.Context, userID uuid.UUID, currency, reason string) (*model.Wallet, error)
+	// ListAdjustments lists the adjustments with the status, every one when
+	// it is empty, newest first
+	ListAdjustments(ctx context.Context, status string, page, pageSize int) ([]model.Adjustment, error)
+}
+
+type walletAdminService struct {
+	adjustments repository.AdjustmentRepository
+	utils       *util.WalletUtil
+}
+
+func NewWalletAdminService(
+	adjustmentRepo repository.AdjustmentRepository,
+	walletRepo repository.WalletRepository,
+	transactionRepo repository.TransactionRepository,
+	txManager repository.TxManager,
+) WalletAdminService {
+	return &walletAdminService{
+		adjustments: adjustmentRepo,
+		utils:       util.NewWalletUtil(walletRepo, transactionRepo, txManager),
+	}
+}
+
+func (s *walletAdminService) ListWallets(ctx context.Context, userID uuid.UUID) ([]model.Wallet, error) {
+	const op = "service.ListWallets"
+	wallets, err := s.utils.WalletRepo.ListWalletsByUser(ctx, userID)
+	return wallets, errors.WrapInternal(op, err)
+}
+
+func (s *walletAdminService) ListAdjustments(ctx context.Context, status string, page, pageSize int) ([]model.Adjustment, error) {
+	const op = "service.ListAdjustments"
+
+	switch status {
+	case "", model.AdjustmentPending, model.AdjustmentApplied, model.AdjustmentRejected:
+	default:
+		return nil, errors.NewInvalidInput(op, "status", status)
+	}
+	if page < 1 {
+		return nil, errors.NewInvalidInput(op, "page", page)
+	}
+	if pageSize < 1 || pageSize > 100 {
+		return nil, errors.NewInvalidInput(op, "pageSize", pageSize)
+	}
+
+	adjustments, err := s.adjustments.ListAdjustments(ctx, status, (page-1)*pageSize, pageSize)
+	return adjustments, errors.WrapInternal(op, err)
+}
+
+func (s *walletAdminService) Freeze(ctx context.Context, userID uuid.UUID, currency, reason string) (*model.Wallet, error) {
+	const op = "service.Freeze"
+	return s.setFrozen(ctx, op, userID, currency, reason, true)
+}
+
+func (s *walletAdminService) Unfreeze(ctx context.Context, userID uuid.UUID, currency, reason string) (*model.Wallet, error) {
+	const op = "service.Unfreeze"
+	return s.setFrozen(ctx, op, userID, currency, reason, false)
+}
+
+// setFrozen changes the wallet's state under its row lock and audits the
+// change with the reason in the same DB transaction
+func (s *walletAdminService) setFrozen(ctx context.Context, op string, userID uuid.UUID, currency, reason string, freeze bool) (_ *model.Wallet, err error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, errors.NewInvalidInput(op, "reason", "a reason is required")
+	}
+	wallet, err := s.utils.WalletRepo.GetWalletByUserAndCurrency(ctx, userID, currency)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	tx, err := s.utils.TxManager.BeginTx(ctx)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	before, err := tx.GetWalletForUpdate(ctx, wallet.ID)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if before.Frozen() == freeze {
+		if freeze {
+			return nil, errors.NewConflict(op, "wallet is already frozen")
+		}
+		return nil, errors.NewConflict(op, "wallet isn't frozen")
+	}
+
+	after := *before
+	after.Version++
+	action := audit.ActionUnfreeze
+	after.FrozenAt, after.FrozenReason = nil, ""
+	if freeze {
+		// postgres keeps microseconds
+		frozenAt := time.Now().UTC().Truncate(time.Microsecond)
+		action = audit.ActionFreeze
+		after.FrozenAt, after.FrozenReason = &frozenAt, reason
+	}
+	if err = tx.SetWalletFrozenTx(ctx, after.ID, after.FrozenAt, after.FrozenReason); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	type snapshot struct {
+		audit.WalletSnapshot
+		Reason string `json:"reason,omitempty"`
+	}
+	event, err := audit.NewEvent(ctx, audit.SystemActor("admin"), action, audit.TargetWallet, after.ID.String(),
+		snapshot{WalletSnapshot: audit.SnapshotWallet(before)},
+		snapshot{WalletSnapshot: audit.SnapshotWallet(&after), Reason: reason})
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err = tx.CreateAuditEventTx(ctx, event); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	logging.FromContext(ctx).Info("wallet "+strings.TrimPrefix(action, "wallet."),
+		logging.KeyOp, op,
+		logging.KeyWallet, after.ID,
+		"actor", event.Actor,
+		"reason", reason,
+	)
+	return &after, nil
+}
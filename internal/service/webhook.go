@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/events"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/webhook"
+	"github.com/google/uuid"
+)
+
+type WebhookService interface {
+	CreateSubscription(ctx context.Context, userID uuid.UUID, endpoint string, eventTypes []string, secret string) (*model.WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context, userID uuid.UUID) ([]model.WebhookSubscription, error)
+	DeleteSubscription(ctx context.Context, userID, subscriptionID uuid.UUID) error
+	ListDeliveries(ctx context.Context, userID, subscriptionID uuid.UUID, page, pageSize int) ([]model.WebhookDelivery, error)
+	ListAttempts(ctx context.Context, userID, deliveryID uuid.UUID) ([]model.WebhookAttempt, error)
+	Redeliver(ctx context.Context, userID, deliveryID uuid.UUID) error
+}
+
+type webhookService struct {
+	repo     repository.WebhookRepository
+	resolver webhook.Resolver
+}
+
+type WebhookOption func(*webhookService)
+
+// WithWebhookResolver sets the resolver subscription hosts are checked with,
+// net.DefaultResolver by default
+func WithWebhookResolver(r webhook.Resolver) WebhookOption {
+	return func(s *webhookService) {
+		s.resolver = r
+	}
+}
+
+func NewWebhookService(repo repository.WebhookRepository, opts ...WebhookOption) WebhookService {
+	s := &webhookService{repo: repo, resolver: net.DefaultResolver}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *webhookService) CreateSubscription(
+	ctx context.Context,
+	userID uuid.UUID,
+	endpoint string,
+	eventTypes []string,
+	secret string,
+) (*model.WebhookSubscription, error) {
+	const op = "service.CreateWebhookSubscription"
+
+	// the deliverer checks the addresses again when it connects
+	if err := webhook.CheckEndpoint(ctx, s.resolver, endpoint); err != nil {
+		return nil, errors.NewInvalidInput(op, "url", err)
+	}
+	for _, t := range eventTypes {
+		if !knownEventType(t) {
+			return nil, errors.NewInvalidInput(op, "event type", t)
+		}
+	}
+	if eventTypes == nil {
+		// empty means every event type
+		eventTypes = []string{}
+	}
+	if secret == "" {
+		var err error
+		if secret, err = webhook.NewSecret(); err != nil {
+			return nil, errors.WrapInternal(op, err)
+		}
+	}
+
+	sub := &model.WebhookSubscription{
+		ID:         uuid.New(),
+		UserID:     userID,
+		URL:        endpoint,
+		EventTypes: eventTypes,
+		Secret:     secret,
+		Active:     true,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := s.repo.CreateSubscription(ctx, sub); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	return sub, nil
+}
+
+func knownEventType(t string) bool {
+	for _, known := range events.Types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *webhookService) ListSubscriptions(ctx context.Context, userID uuid.UUID) ([]model.WebhookSubscription, error) {
+	const op = "service.ListWebhookSubscriptions"
+
+	subs, err := s.repo.ListSubscriptions(ctx, userID)
+	return subs, errors.WrapInternal(op, err)
+}
+
+func (s *webhookService) DeleteSubscription(ctx context.Context, userID, subscriptionID uuid.UUID) error {
+	const op = "service.DeleteWebhookSubscription"
+
+	if _, err := s.ownSubscription(ctx, op, userID, subscriptionID); err != nil {
+		return err
+	}
+	return errors.WrapInternal(op, s.repo.DeactivateSubscription(ctx, subscriptionID))
+}
+
+func (s *webhookService) ListDeliveries(ctx context.Context, userID, subscriptionID uuid.UUID, page, pageSize int) ([]model.WebhookDelivery, error) {
+	const op = "service.ListWebhookDeliveries"
+
+	if page < 1 {
+		return nil, errors.NewInvalidInput(op, "page", page)
+	}
+	if pageSize < 1 || pageSize > 100 {
+		return nil, errors.NewInvalidInput(op, "pageSize", pageSize)
+	}
+	if _, err := s.ownSubscription(ctx, op, userID, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.repo.ListDeliveries(ctx, subscriptionID, (page-1)*pageSize, pageSize)
+	return deliveries, errors.WrapInternal(op, err)
+}
+
+func (s *webhookService) ListAttempts(ctx context.Context, userID, deliveryID uuid.UUID) ([]model.WebhookAttempt, error) {
+	const op = "service.ListWebhookAttempts"
+
+	if _, err := s.ownDelivery(ctx, op, userID, deliveryID); err != nil {
+		return nil, err
+	}
+	attempts, err := s.repo.ListAttempts(ctx, deliveryID)
+	return attempts, errors.WrapInternal(op, err)
+}
+
+// Redeliver re-queues a delivery, typically one that went dead, with a fresh retry budget
+func (s *webhookService) Redeliver(ctx context.Context, userID, deliveryID uuid.UUID) error {
+	const op = "service.RedeliverWebhook"
+
+	delivery, err := s.ownDelivery(ctx, op, userID, deliveryID)
+	if err != nil {
+		return err
+	}
+	if delivery.Status == model.WebhookPending {
+		return errors.NewConflict(op, "delivery is already pending")
+	}
+	return errors.WrapInternal(op, s.repo.Redeliver(ctx, deliveryID))
+}
+
+// ownSubscription loads a subscription and reports other users' subscriptions as not found
+func (s *webhookService) ownSubscription(ctx context.Context, op string, userID, subscriptionID uuid.UUID) (*model.WebhookSubscription, error) {
+	sub, err := s.repo.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if sub.UserID != userID {
+		return nil, errors.NewNotFound(op, "webhook subscription")
+	}
+	return sub, nil
+}
+
+func (s *webhookService) ownDelivery(ctx context.Context, op string, userID, deliveryID uuid.UUID) (*model.WebhookDelivery, error) {
+	delivery, err := s.repo.GetDelivery(ctx, deliveryID)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if _, err := s.ownSubscription(ctx, op, userID, delivery.SubscriptionID); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
@@ -0,0 +1,149 @@
+package settlement
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// camt053Parser reads an ISO 20022 camt.053 bank to customer statement. Every
+// booked entry becomes a line, or one line per transaction for a batched
+// entry. The reference is the end-to-end ID we sent with the payment, falling
+// back to the entry and servicer references. Pending entries are skipped.
+type camt053Parser struct{}
+
+type camtDocument struct {
+	Statements []struct {
+		Entries []camtEntry `xml:"Ntry"`
+	} `xml:"BkToCstmrStmt>Stmt"`
+}
+
+type camtEntry struct {
+	Amount          camtAmount `xml:"Amt"`
+	CreditDebit     string     `xml:"CdtDbtInd"`
+	Status          camtStatus `xml:"Sts"`
+	BookingDate     camtDate   `xml:"BookgDt"`
+	ValueDate       camtDate   `xml:"ValDt"`
+	EntryReference  string     `xml:"NtryRef"`
+	ServicerRef     string     `xml:"AcctSvcrRef"`
+	TransactionDtls []struct {
+		Amount      camtAmount `xml:"Amt"`
+		AmountDtls  camtAmount `xml:"AmtDtls>TxAmt>Amt"`
+		CreditDebit string     `xml:"CdtDbtInd"`
+		EndToEndID  string     `xml:"Refs>EndToEndId"`
+		ServicerRef string     `xml:"Refs>AcctSvcrRef"`
+	} `xml:"NtryDtls>TxDtls"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+// camtStatus is a code, or a Cd element holding it since camt.053.001.08
+type camtStatus struct {
+	Value string `xml:",chardata"`
+	Code  string `xml:"Cd"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+func (camt053Parser) Parse(r io.Reader) ([]Line, error) {
+	var doc camtDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid camt.053 document: %w", err)
+	}
+
+	var lines []Line
+	number := 0
+	for _, stmt := range doc.Statements {
+		for _, entry := range stmt.Entries {
+			number++
+			status := strings.TrimSpace(entry.Status.Code + entry.Status.Value)
+			if status != "" && status != "BOOK" {
+				continue
+			}
+
+			entryLines, err := camtLines(entry)
+			for i := range entryLines {
+				if err == nil {
+					err = entryLines[i].validate()
+				}
+				entryLines[i].Number = number
+			}
+			if err != nil {
+				return nil, &ParseError{Line: number, Err: err}
+			}
+			lines = append(lines, entryLines...)
+		}
+	}
+	return lines, nil
+}
+
+func camtLines(entry camtEntry) ([]Line, error) {
+	base := Line{Reference: firstOf(entry.EntryReference, entry.ServicerRef)}
+	if raw := firstOf(entry.ValueDate.Date, entry.ValueDate.DateTime, entry.BookingDate.Date, entry.BookingDate.DateTime); raw != "" {
+		t, err := parseDate(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", raw)
+		}
+		base.ValueDate = t
+	}
+
+	// a single transaction settles the entry's whole amount
+	if len(entry.TransactionDtls) <= 1 {
+		line := base
+		if len(entry.TransactionDtls) == 1 {
+			tx := entry.TransactionDtls[0]
+			line.Reference = firstOf(tx.EndToEndID, base.Reference, tx.ServicerRef)
+		}
+		if err := line.setAmount(entry.Amount, entry.CreditDebit); err != nil {
+			return nil, err
+		}
+		return []Line{line}, nil
+	}
+
+	lines := make([]Line, 0, len(entry.TransactionDtls))
+	for _, tx := range entry.TransactionDtls {
+		line := base
+		line.Reference = firstOf(tx.EndToEndID, tx.ServicerRef)
+		amount := tx.Amount
+		if amount.Value == "" {
+			amount = tx.AmountDtls
+		}
+		if err := line.setAmount(amount, firstOf(tx.CreditDebit, entry.CreditDebit)); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+func (l *Line) setAmount(amount camtAmount, creditDebit string) error {
+	value, err := decimal.NewFromString(strings.TrimSpace(amount.Value))
+	if err != nil {
+		return fmt.Errorf("invalid amount %q", amount.Value)
+	}
+	if l.Direction, err = parseDirection(strings.TrimSpace(creditDebit)); err != nil {
+		return err
+	}
+	l.Amount = value
+	l.Currency = amount.Currency
+	return nil
+}
+
+// firstOf returns the first non-blank value
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" && v != "NOTPROVIDED" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package settlement
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// csvParser reads a CSV file with a header row naming its columns. reference,
+// amount and currency are required. Without a direction column the sign of
+// the amount gives the direction. value_date is optional.
+//
+//	reference,direction,amount,currency,value_date
+//	dep-1042,credit,250.00,USD,2025-06-30
+type csvParser struct{}
+
+func (csvParser) Parse(r io.Reader) ([]Line, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, &ParseError{Line: 1, Err: errors.New("missing header row")}
+	}
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		cols[name] = i
+	}
+	for _, name := range []string{"reference", "amount", "currency"} {
+		if _, ok := cols[name]; !ok {
+			return nil, &ParseError{Line: 1, Err: fmt.Errorf("missing %s column", name)}
+		}
+	}
+
+	var lines []Line
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		number, _ := cr.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		line, err := csvLine(field)
+		if err == nil {
+			err = line.validate()
+		}
+		if err != nil {
+			return nil, &ParseError{Line: number, Err: err}
+		}
+		line.Number = number
+		lines = append(lines, line)
+	}
+}
+
+func csvLine(field func(string) string) (Line, error) {
+	line := Line{Reference: field("reference"), Currency: field("currency")}
+
+	amount, err := decimal.NewFromString(field("amount"))
+	if err != nil {
+		return line, fmt.Errorf("invalid amount %q", field("amount"))
+	}
+	if raw := field("direction"); raw != "" {
+		if amount.IsNegative() {
+			return line, fmt.Errorf("amount %s is negative while direction is given", amount)
+		}
+		if line.Direction, err = parseDirection(raw); err != nil {
+			return line, err
+		}
+	} else if amount.IsNegative() {
+		line.Direction = DirectionDebit
+	} else {
+		line.Direction = DirectionCredit
+	}
+	line.Amount = amount.Abs()
+
+	if raw := field("value_date"); raw != "" {
+		if line.ValueDate, err = parseDate(raw); err != nil {
+			return line, fmt.Errorf("invalid value_date %q", raw)
+		}
+	}
+	return line, nil
+}
+
+func parseDirection(raw string) (string, error) {
+	switch strings.ToLower(raw) {
+	case "credit", "crdt", "cr", "c":
+		return DirectionCredit, nil
+	case "debit", "dbit", "dr", "d":
+		return DirectionDebit, nil
+	}
+	return "", fmt.Errorf("unknown direction %q", raw)
+}
+
+func parseDate(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
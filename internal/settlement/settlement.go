@@ -0,0 +1,127 @@
+// Package settlement reads the settlement files the bank sends for deposits and
+// withdrawals that went through external rails. Each format has a Parser that
+// turns a file into Lines, which the settlement service matches against the
+// ledger.
+package settlement
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Settlement file formats
+const (
+	FormatCSV     = "csv"
+	FormatCamt053 = "camt053"
+)
+
+// Line directions, as seen from our account at the bank
+const (
+	// DirectionCredit is money received, settling a deposit
+	DirectionCredit = "credit"
+	// DirectionDebit is money paid out, settling a withdrawal
+	DirectionDebit = "debit"
+)
+
+// Line is one settled movement
+type Line struct {
+	// Number is the 1-based position of the line in the file
+	Number    int
+	Reference string
+	Direction string
+	// Amount is always positive, Direction gives the sign
+	Amount   decimal.Decimal
+	Currency string
+	// ValueDate is zero when the file doesn't carry one
+	ValueDate time.Time
+}
+
+// SignedAmount is the amount as recorded on the matching transaction, negative
+// for withdrawals
+func (l Line) SignedAmount() decimal.Decimal {
+	if l.Direction == DirectionDebit {
+		return l.Amount.Neg()
+	}
+	return l.Amount
+}
+
+// Parser reads a whole settlement file
+type Parser interface {
+	Parse(r io.Reader) ([]Line, error)
+}
+
+// ParseError reports a malformed line of a settlement file
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	mu      sync.RWMutex
+	parsers = map[string]Parser{
+		FormatCSV:     csvParser{},
+		FormatCamt053: camt053Parser{},
+	}
+)
+
+// Register makes a parser available under format, replacing any parser
+// registered for it before
+func Register(format string, p Parser) {
+	mu.Lock()
+	defer mu.Unlock()
+	parsers[format] = p
+}
+
+// NewParser returns the parser registered for format
+func NewParser(format string) (Parser, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := parsers[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown settlement format %q", format)
+	}
+	return p, nil
+}
+
+// Formats lists the registered formats in order
+func Formats() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	formats := make([]string, 0, len(parsers))
+	for format := range parsers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// validate checks the fields every parser must fill and normalises the currency
+func (l *Line) validate() error {
+	l.Reference = strings.TrimSpace(l.Reference)
+	l.Currency = strings.ToUpper(strings.TrimSpace(l.Currency))
+	switch {
+	case l.Reference == "":
+		return fmt.Errorf("reference is empty")
+	case !l.Amount.IsPositive():
+		return fmt.Errorf("amount %s is not positive", l.Amount)
+	case len(l.Currency) != 3:
+		return fmt.Errorf("currency %q is not a 3-letter code", l.Currency)
+	case l.Direction != DirectionCredit && l.Direction != DirectionDebit:
+		return fmt.Errorf("direction %q is neither credit nor debit", l.Direction)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package statement
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/Jiang-hao/walletApiService/internal/model"
+)
+
+// csvFlushEvery bounds how many rows the CSV writer buffers
+const csvFlushEvery = 100
+
+// csvWriter writes one record per line, the record column telling opening
+// balance, transaction, total, closing balance and check lines apart.
+type csvWriter struct {
+	w    *csv.Writer
+	rows int
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) Begin(h Header) error {
+	c.w.Write([]string{"record", "date", "transaction_id", "type", "reference", "count", "amount", "balance"})
+	c.w.Write([]string{"opening_balance", formatTime(h.From), "", "", "", "", "", h.OpeningBalance.String()})
+	return c.flush()
+}
+
+func (c *csvWriter) Transaction(t *model.Transaction) error {
+	c.w.Write([]string{"transaction", t.CreatedAt, t.ID.String(), t.Type, t.Reference, "", t.Amount.String(), t.BalanceAfter.String()})
+	c.rows++
+	if c.rows%csvFlushEvery == 0 {
+		return c.flush()
+	}
+	return nil
+}
+
+func (c *csvWriter) End(h Header, s Summary) error {
+	for _, total := range s.Totals {
+		c.w.Write([]string{"total", "", "", total.Type, "", strconv.Itoa(total.Count), total.Amount.String(), ""})
+	}
+	c.w.Write([]string{"closing_balance", formatTime(h.To), "", "", "", strconv.Itoa(s.Count), s.Movements.String(), h.ClosingBalance.String()})
+	c.w.Write([]string{"check", "", "", "", checkResult(s), "", s.Expected(h).String(), h.ClosingBalance.String()})
+	return c.flush()
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func checkResult(s Summary) string {
+	if s.Consistent {
+		return "ok"
+	}
+	return "mismatch"
+}
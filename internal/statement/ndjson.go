@@ -0,0 +1,83 @@
+package statement
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// ndjsonRecord is one line of an NDJSON statement, Record naming its kind
+type ndjsonRecord struct {
+	Record        string           `json:"record"`
+	WalletID      *uuid.UUID       `json:"wallet_id,omitempty"`
+	Currency      string           `json:"currency,omitempty"`
+	From          string           `json:"from,omitempty"`
+	To            string           `json:"to,omitempty"`
+	TransactionID *uuid.UUID       `json:"transaction_id,omitempty"`
+	CreatedAt     string           `json:"created_at,omitempty"`
+	Type          string           `json:"type,omitempty"`
+	Reference     string           `json:"reference,omitempty"`
+	Count         *int             `json:"count,omitempty"`
+	Amount        *decimal.Decimal `json:"amount,omitempty"`
+	Balance       *decimal.Decimal `json:"balance,omitempty"`
+	Expected      *decimal.Decimal `json:"expected_balance,omitempty"`
+	Consistent    *bool            `json:"consistent,omitempty"`
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	return &ndjsonWriter{enc: json.NewEncoder(w)}
+}
+
+func (n *ndjsonWriter) Begin(h Header) error {
+	return n.enc.Encode(ndjsonRecord{
+		Record:   "opening_balance",
+		WalletID: &h.WalletID,
+		Currency: h.Currency,
+		From:     formatTime(h.From),
+		To:       formatTime(h.To),
+		Balance:  &h.OpeningBalance,
+	})
+}
+
+func (n *ndjsonWriter) Transaction(t *model.Transaction) error {
+	return n.enc.Encode(ndjsonRecord{
+		Record:        "transaction",
+		TransactionID: &t.ID,
+		CreatedAt:     t.CreatedAt,
+		Type:          t.Type,
+		Reference:     t.Reference,
+		Amount:        &t.Amount,
+		Balance:       &t.BalanceAfter,
+	})
+}
+
+func (n *ndjsonWriter) End(h Header, s Summary) error {
+	for i := range s.Totals {
+		total := &s.Totals[i]
+		if err := n.enc.Encode(ndjsonRecord{
+			Record: "total",
+			Type:   total.Type,
+			Count:  &total.Count,
+			Amount: &total.Amount,
+		}); err != nil {
+			return err
+		}
+	}
+	expected := s.Expected(h)
+	return n.enc.Encode(ndjsonRecord{
+		Record:     "closing_balance",
+		To:         formatTime(h.To),
+		Count:      &s.Count,
+		Amount:     &s.Movements,
+		Balance:    &h.ClosingBalance,
+		Expected:   &expected,
+		Consistent: &s.Consistent,
+	})
+}
@@ -0,0 +1,165 @@
+package statement
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/go-pdf/fpdf"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	pdfLineHeight   = 6
+	pdfMaxReference = 38
+)
+
+// pdfColumns are the transaction table's columns, 190mm wide on A4
+var pdfColumns = []struct {
+	title string
+	width float64
+	align string
+}{
+	{"Date (UTC)", 38, "L"},
+	{"Type", 30, "L"},
+	{"Reference", 62, "L"},
+	{"Amount", 30, "R"},
+	{"Balance", 30, "R"},
+}
+
+// pdfWriter lays the statement out page by page. A PDF can only be written
+// once complete, so unlike the other formats it is held in memory until End.
+type pdfWriter struct {
+	out   io.Writer
+	doc   *fpdf.Fpdf
+	text  func(string) string
+	table bool
+}
+
+func newPDFWriter(w io.Writer) *pdfWriter {
+	doc := fpdf.New("P", "mm", "A4", "")
+	return &pdfWriter{
+		out: w,
+		doc: doc,
+		// core fonts are cp1252, translate what they can show
+		text: doc.UnicodeTranslatorFromDescriptor(""),
+	}
+}
+
+func (p *pdfWriter) Begin(h Header) error {
+	doc := p.doc
+	doc.SetTitle(fmt.Sprintf("Statement %s %s", h.Currency, h.From.UTC().Format(time.DateOnly)), false)
+	doc.SetCreator("walletApiService", false)
+	doc.SetAutoPageBreak(true, 15)
+	doc.AliasNbPages("")
+	doc.SetHeaderFunc(func() {
+		// repeat the column titles on every page of the table
+		if p.table {
+			p.columnTitles()
+		}
+	})
+	doc.SetFooterFunc(func() {
+		doc.SetY(-12)
+		doc.SetFont("Helvetica", "I", 8)
+		doc.CellFormat(0, 5, fmt.Sprintf("Page %d/{nb}", doc.PageNo()), "", 0, "C", false, 0, "")
+	})
+	doc.AddPage()
+
+	doc.SetFont("Helvetica", "B", 16)
+	doc.CellFormat(0, 10, "Account statement", "", 1, "L", false, 0, "")
+	doc.SetFont("Helvetica", "", 10)
+	p.line("Wallet", h.WalletID.String())
+	p.line("Currency", h.Currency)
+	p.line("Period", formatTime(h.From)+" to "+formatTime(h.To))
+	p.line("Opening balance", h.OpeningBalance.StringFixed(2))
+	doc.Ln(4)
+
+	p.table = true
+	p.columnTitles()
+	return doc.Error()
+}
+
+func (p *pdfWriter) Transaction(t *model.Transaction) error {
+	values := []string{
+		pdfTime(t.CreatedAt),
+		t.Type,
+		truncate(t.Reference, pdfMaxReference),
+		t.Amount.StringFixed(2),
+		t.BalanceAfter.StringFixed(2),
+	}
+	p.doc.SetFont("Helvetica", "", 9)
+	for i, col := range pdfColumns {
+		p.doc.CellFormat(col.width, pdfLineHeight, p.text(values[i]), "B", 0, col.align, false, 0, "")
+	}
+	p.doc.Ln(-1)
+	return p.doc.Error()
+}
+
+func (p *pdfWriter) End(h Header, s Summary) error {
+	doc := p.doc
+	p.table = false
+	doc.Ln(6)
+
+	doc.SetFont("Helvetica", "B", 11)
+	doc.CellFormat(0, 8, "Totals by type", "", 1, "L", false, 0, "")
+	doc.SetFont("Helvetica", "", 10)
+	for _, total := range s.Totals {
+		p.amountLine(fmt.Sprintf("%s (%d)", total.Type, total.Count), total.Amount)
+	}
+	p.amountLine(fmt.Sprintf("Net movements (%d)", s.Count), s.Movements)
+	doc.Ln(2)
+
+	doc.SetFont("Helvetica", "B", 10)
+	p.amountLine("Opening balance", h.OpeningBalance)
+	p.amountLine("Closing balance", h.ClosingBalance)
+	doc.SetFont("Helvetica", "", 10)
+	if s.Consistent {
+		p.line("Check", "opening balance + movements = closing balance")
+	} else {
+		doc.SetTextColor(200, 0, 0)
+		p.line("Check", fmt.Sprintf("MISMATCH, opening balance + movements = %s", s.Expected(h).StringFixed(2)))
+		doc.SetTextColor(0, 0, 0)
+	}
+
+	if err := doc.Error(); err != nil {
+		return err
+	}
+	return doc.Output(p.out)
+}
+
+func (p *pdfWriter) columnTitles() {
+	p.doc.SetFont("Helvetica", "B", 9)
+	p.doc.SetFillColor(230, 230, 230)
+	for _, col := range pdfColumns {
+		p.doc.CellFormat(col.width, pdfLineHeight+1, col.title, "1", 0, col.align, true, 0, "")
+	}
+	p.doc.Ln(-1)
+}
+
+func (p *pdfWriter) line(label, value string) {
+	p.doc.CellFormat(40, pdfLineHeight, label+":", "", 0, "L", false, 0, "")
+	p.doc.CellFormat(0, pdfLineHeight, p.text(value), "", 1, "L", false, 0, "")
+}
+
+func (p *pdfWriter) amountLine(label string, amount decimal.Decimal) {
+	p.doc.CellFormat(60, pdfLineHeight, p.text(label), "", 0, "L", false, 0, "")
+	p.doc.CellFormat(40, pdfLineHeight, amount.StringFixed(2), "", 1, "R", false, 0, "")
+}
+
+// pdfTime shortens a transaction's RFC 3339 timestamp to fit its column
+func pdfTime(createdAt string) string {
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return createdAt
+	}
+	return t.UTC().Format(time.DateTime)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,142 @@
+// Package statement renders account statements: an opening balance, the
+// wallet's transactions in a period, totals per transaction type and a closing
+// balance, as CSV, NDJSON or PDF.
+package statement
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Statement formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatPDF    = "pdf"
+)
+
+// Header describes the statement period. Both balances are read from the
+// ledger independently of the transactions in between.
+type Header struct {
+	UserID         uuid.UUID
+	WalletID       uuid.UUID
+	Currency       string
+	From           time.Time
+	To             time.Time
+	OpeningBalance decimal.Decimal
+	ClosingBalance decimal.Decimal
+}
+
+type TypeTotal struct {
+	Type   string
+	Count  int
+	Amount decimal.Decimal
+}
+
+type Summary struct {
+	Count     int
+	Movements decimal.Decimal
+	// Totals are ordered by transaction type
+	Totals []TypeTotal
+	// Consistent reports whether opening balance + movements = closing balance
+	Consistent bool
+}
+
+// Expected is the closing balance the movements add up to
+func (s Summary) Expected(h Header) decimal.Decimal {
+	return h.OpeningBalance.Add(s.Movements)
+}
+
+// Writer renders one statement. Begin is called once, then Transaction for
+// every transaction in ledger order, then End.
+type Writer interface {
+	Begin(h Header) error
+	Transaction(t *model.Transaction) error
+	End(h Header, s Summary) error
+}
+
+// Rows iterates over the transactions of the period
+type Rows interface {
+	Next() bool
+	Transaction() (*model.Transaction, error)
+	Err() error
+}
+
+// NewWriter returns a writer of the given format rendering to w
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w), nil
+	case FormatNDJSON:
+		return newNDJSONWriter(w), nil
+	case FormatPDF:
+		return newPDFWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unknown statement format %q", format)
+	}
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatPDF:
+		return "application/pdf"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Write streams the rows through w, one transaction at a time, and returns the
+// totals it rendered at the end.
+func Write(w Writer, h Header, rows Rows) (Summary, error) {
+	summary := Summary{Movements: decimal.Zero}
+	totals := make(map[string]*TypeTotal)
+
+	if err := w.Begin(h); err != nil {
+		return summary, err
+	}
+	for rows.Next() {
+		t, err := rows.Transaction()
+		if err != nil {
+			return summary, err
+		}
+		if err := w.Transaction(t); err != nil {
+			return summary, err
+		}
+
+		summary.Count++
+		summary.Movements = summary.Movements.Add(t.Amount)
+		total, ok := totals[t.Type]
+		if !ok {
+			total = &TypeTotal{Type: t.Type, Amount: decimal.Zero}
+			totals[t.Type] = total
+		}
+		total.Count++
+		total.Amount = total.Amount.Add(t.Amount)
+	}
+	if err := rows.Err(); err != nil {
+		return summary, err
+	}
+
+	for _, total := range totals {
+		summary.Totals = append(summary.Totals, *total)
+	}
+	sort.Slice(summary.Totals, func(i, j int) bool { return summary.Totals[i].Type < summary.Totals[j].Type })
+	summary.Consistent = summary.Expected(h).Equal(h.ClosingBalance)
+
+	return summary, w.End(h, summary)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
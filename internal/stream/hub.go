@@ -0,0 +1,189 @@
+package stream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/google/uuid"
+)
+
+// Event is a balance update with its position in the hub's stream. IDs count
+// a hub's events, they mean nothing to another instance or after a restart,
+// so clients are given them with the hub's epoch, see FormatEventID.
+type Event struct {
+	ID     uint64
+	Update model.BalanceUpdate
+}
+
+type HubConfig struct {
+	// History is how many recent events are kept for clients resuming with Last-Event-ID
+	History int
+	// ClientBuffer is how many events may queue for one client before it is dropped
+	ClientBuffer int
+}
+
+// Hub fans committed balance updates out to the subscribers of each user.
+// It implements service.Notifier.
+type Hub struct {
+	mu      sync.Mutex
+	cfg     HubConfig
+	epoch   string
+	seq     uint64
+	history []Event // ring buffer, history[seq % len] holds event seq
+	subs    map[uuid.UUID]map[*Subscription]struct{}
+}
+
+func NewHub(cfg HubConfig) *Hub {
+	if cfg.History <= 0 {
+		cfg.History = 1024
+	}
+	if cfg.ClientBuffer <= 0 {
+		cfg.ClientBuffer = 64
+	}
+	return &Hub{
+		cfg:     cfg,
+		epoch:   newEpoch(),
+		history: make([]Event, cfg.History),
+		subs:    make(map[uuid.UUID]map[*Subscription]struct{}),
+	}
+}
+
+func newEpoch() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Epoch names this hub, a new one every time the process starts
+func (h *Hub) Epoch() string {
+	return h.epoch
+}
+
+// FormatEventID writes the id of a hub's event as "<epoch>-<id>"
+func FormatEventID(epoch string, id uint64) string {
+	return epoch + "-" + strconv.FormatUint(id, 10)
+}
+
+// ParseEventID reads an id written by FormatEventID. A bare number, the ids
+// of before epochs, has the empty epoch, which no hub has.
+func ParseEventID(s string) (epoch string, id uint64, err error) {
+	seq := s
+	if i := strings.LastIndexByte(s, '-'); i >= 0 {
+		epoch, seq = s[:i], s[i+1:]
+		if epoch == "" {
+			return "", 0, fmt.Errorf("event id %q has no epoch", s)
+		}
+	}
+	if id, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return "", 0, fmt.Errorf("event id %q: %w", s, err)
+	}
+	return epoch, id, nil
+}
+
+// Subscription receives a user's events on C. C is closed when the
+// subscription is cancelled or the client fell too far behind (Dropped).
+type Subscription struct {
+	C <-chan Event
+	// Head is the id of the newest event published before the subscription started
+	Head    uint64
+	ch      chan Event
+	userID  uuid.UUID
+	hub     *Hub
+	dropped bool
+}
+
+// Dropped reports whether the hub closed the subscription because the client was too slow
+func (s *Subscription) Dropped() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.dropped
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.removeLocked(s)
+}
+
+func (h *Hub) Notify(ctx context.Context, update model.BalanceUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e := Event{ID: h.seq, Update: update}
+	h.history[h.seq%uint64(len(h.history))] = e
+
+	for sub := range h.subs[update.UserID] {
+		select {
+		case sub.ch <- e:
+		default:
+			// don't let one slow client hold up the rest, it can resume from history
+			sub.dropped = true
+			h.removeLocked(sub)
+			metrics.StreamDropped.Inc()
+		}
+	}
+}
+
+// Subscribe registers a subscriber for userID. With resume set it also returns
+// the user's events after lastEventID of epoch; complete is false when some of
+// those are no longer in history, or the id is another hub's, and the client
+// should refetch its balances instead.
+func (h *Hub) Subscribe(userID uuid.UUID, epoch string, lastEventID uint64, resume bool) (sub *Subscription, backlog []Event, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	complete = true
+	if resume && (epoch != h.epoch || lastEventID > h.seq) {
+		// the id is from before a restart or from another instance, the
+		// client may have missed anything
+		complete = false
+		lastEventID = 0
+	}
+	if resume && lastEventID < h.seq {
+		oldest := uint64(1)
+		if h.seq > uint64(len(h.history)) {
+			oldest = h.seq - uint64(len(h.history)) + 1
+		}
+		if lastEventID+1 < oldest {
+			complete = false
+		}
+		for id := max(lastEventID+1, oldest); id <= h.seq; id++ {
+			if e := h.history[id%uint64(len(h.history))]; e.Update.UserID == userID {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+
+	ch := make(chan Event, h.cfg.ClientBuffer)
+	sub = &Subscription{C: ch, Head: h.seq, ch: ch, userID: userID, hub: h}
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[*Subscription]struct{})
+	}
+	h.subs[userID][sub] = struct{}{}
+	metrics.StreamSubscribers.Inc()
+	return sub, backlog, complete
+}
+
+func (h *Hub) removeLocked(sub *Subscription) {
+	subs, ok := h.subs[sub.userID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subs, sub.userID)
+	}
+	close(sub.ch)
+	metrics.StreamSubscribers.Dec()
+}
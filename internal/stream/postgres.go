@@ -0,0 +1,75 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Channel is the Postgres NOTIFY channel balance updates are broadcast on
+const Channel = "wallet_balance_updates"
+
+// PGNotifier broadcasts updates with pg_notify so every instance's hub sees
+// them, for deployments where clients may be connected to another instance.
+type PGNotifier struct {
+	db *sqlx.DB
+}
+
+func NewPGNotifier(db *sqlx.DB) *PGNotifier {
+	return &PGNotifier{db: db}
+}
+
+func (n *PGNotifier) Notify(ctx context.Context, update model.BalanceUpdate) {
+	const op = "stream.PGNotifier.Notify"
+
+	payload, err := json.Marshal(update)
+	if err == nil {
+		_, err = n.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, Channel, string(payload))
+	}
+	if err != nil {
+		// the change is committed, a missed notification only delays clients until they refetch
+		logging.FromContext(ctx).Warn("balance notification failed", logging.KeyOp, op, logging.Err(err))
+	}
+}
+
+// Listen forwards notifications from Channel to hub until ctx is cancelled
+func Listen(ctx context.Context, dsn string, hub *Hub) error {
+	const op = "stream.Listen"
+	log := logging.FromContext(ctx)
+
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn("balance listener connection event", logging.KeyOp, op, "event", ev, logging.Err(err))
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(Channel); err != nil {
+		return errors.NewInternal(op, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			// nil after a reconnect, notifications sent meanwhile are lost
+			if n == nil {
+				continue
+			}
+			var update model.BalanceUpdate
+			if err := json.Unmarshal([]byte(n.Extra), &update); err != nil {
+				log.Warn("invalid balance notification", logging.KeyOp, op, logging.Err(err))
+				continue
+			}
+			hub.Notify(ctx, update)
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
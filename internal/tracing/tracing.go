@@ -0,0 +1,170 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const instrumentationName = "github.com/Jiang-hao/walletApiService"
+
+// Exporters
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Common span attributes
+var (
+	AttrOperation    = attribute.Key("wallet.operation")
+	AttrCurrency     = attribute.Key("wallet.currency")
+	AttrUserID       = attribute.Key("wallet.user_id")
+	AttrWalletID     = attribute.Key("wallet.id")
+	AttrTxType       = attribute.Key("wallet.tx_type")
+	AttrRetryAttempt = attribute.Key("wallet.retry_attempt")
+	AttrRowsAffected = attribute.Key("db.rows_affected")
+	AttrErrorType    = attribute.Key("error.type")
+)
+
+type Config struct {
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	ServiceName string
+	// SampleRatio is the share of new traces sampled, 0 samples none and 1
+	// every one. Unset or negative samples every trace. Traces started by a
+	// caller follow the caller's decision.
+	SampleRatio *float64
+	// Writer is where the stdout exporter writes, defaults to os.Stdout
+	Writer io.Writer
+}
+
+var tracer atomic.Pointer[trace.Tracer]
+
+// Init installs a tracer provider built from cfg and returns its shutdown function.
+// With the "none" exporter tracing stays disabled and Start is a no-op.
+func Init(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	const op = "tracing.Init"
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w := cfg.Writer
+		if w == nil {
+			w = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, errors.NewInvalidInput(op, "exporter", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "wallet-api"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, errors.NewInternal(op, err)
+	}
+
+	sampler := sdktrace.ParentBased(sdktrace.AlwaysSample())
+	if ratio := cfg.SampleRatio; ratio != nil && *ratio >= 0 && *ratio < 1 {
+		// TraceIDRatioBased samples nothing at 0
+		sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*ratio))
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+	)
+	Enable(tp)
+	return tp.Shutdown, nil
+}
+
+// Enable installs tp as the global tracer provider together with the W3C
+// trace context propagator. Tests use it with an in-memory span recorder.
+func Enable(tp trace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	t := tp.Tracer(instrumentationName)
+	tracer.Store(&t)
+}
+
+// Disable turns tracing off again
+func Disable() {
+	tracer.Store(nil)
+}
+
+func Enabled() bool {
+	return tracer.Load() != nil
+}
+
+// Start starts a span when tracing is enabled. When disabled the context is
+// returned unchanged together with a no-op span.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	t := tracer.Load()
+	if t == nil {
+		return ctx, noop.Span{}
+	}
+	return (*t).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartQuery starts a client span for a single repository query
+func StartQuery(ctx context.Context, name, operation, table string) (context.Context, trace.Span) {
+	t := tracer.Load()
+	if t == nil {
+		return ctx, noop.Span{}
+	}
+	attrs := []attribute.KeyValue{semconv.DBSystemPostgreSQL, semconv.DBOperationName(operation)}
+	if table != "" {
+		attrs = append(attrs, semconv.DBCollectionName(table))
+	}
+	return (*t).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// End records err on the span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(AttrErrorType.String(string(errors.TypeOf(err))))
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -4,9 +4,14 @@ import (
 	"context"
 	"time"
 
+	"github.com/Jiang-hao/walletApiService/internal/audit"
 	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/events"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
 	"github.com/Jiang-hao/walletApiService/internal/model"
 	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 )
@@ -29,8 +34,13 @@ func NewWalletUtil(
 	}
 }
 
-func (u *WalletUtil) GetOrCreateWallet(ctx context.Context, userID uuid.UUID, currency string) (*model.Wallet, error) {
+func (u *WalletUtil) GetOrCreateWallet(ctx context.Context, userID uuid.UUID, currency string) (_ *model.Wallet, err error) {
 	const op = "utils.GetOrCreateWallet"
+	ctx, span := tracing.Start(ctx, op,
+		tracing.AttrUserID.String(userID.String()),
+		tracing.AttrCurrency.String(currency),
+	)
+	defer func() { tracing.End(span, err) }()
 
 	wallet, err := u.WalletRepo.GetWalletByUserAndCurrency(ctx, userID, currency)
 	if err == nil {
@@ -44,7 +54,7 @@ func (u *WalletUtil) GetOrCreateWallet(ctx context.Context, userID uuid.UUID, cu
 			Currency: currency,
 			Balance:  decimal.Zero,
 		}
-		if err := u.WalletRepo.CreateWallet(ctx, wallet); err != nil {
+		if err := u.createWallet(ctx, wallet); err != nil {
 			return nil, errors.WrapInternal(op, err)
 		}
 		return wallet, nil
@@ -53,6 +63,39 @@ func (u *WalletUtil) GetOrCreateWallet(ctx context.Context, userID uuid.UUID, cu
 	return nil, errors.WrapInternal(op, err)
 }
 
+// createWallet inserts the wallet and its WalletCreated event in one DB transaction
+func (u *WalletUtil) createWallet(ctx context.Context, wallet *model.Wallet) error {
+	const op = "utils.createWallet"
+
+	event, err := events.NewWalletCreated(wallet)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+
+	dbTx, err := u.TxManager.BeginTx(ctx)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			dbTx.Rollback()
+		}
+	}()
+
+	if err := dbTx.CreateWalletTx(ctx, wallet); err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	if err := dbTx.CreateOutboxEventTx(ctx, event); err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	if err := dbTx.Commit(); err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	committed = true
+	return nil
+}
+
 func (u *WalletUtil) UpdateBalanceWithRetry(
 	ctx context.Context,
 	wallet *model.Wallet,
@@ -60,48 +103,169 @@ func (u *WalletUtil) UpdateBalanceWithRetry(
 	reference string,
 	txType string,
 	maxRetries int,
-) (*model.WalletResponse, error) {
+) (_ *model.WalletResponse, _ *model.Transaction, err error) {
 	const op = "utils.UpdateBalanceWithRetry"
+	ctx, span := tracing.Start(ctx, op,
+		tracing.AttrWalletID.String(wallet.ID.String()),
+		tracing.AttrTxType.String(txType),
+		tracing.AttrCurrency.String(wallet.Currency),
+	)
+	defer func() { tracing.End(span, err) }()
 
-	var newBalance decimal.Decimal
-	newBalance = wallet.Balance.Add(amount)
-	if newBalance.IsNegative() {
-		return nil, errors.NewInsufficientBalance(op)
-	}
+	for i := 0; ; i++ {
+		// checked again on every attempt, against the wallet as last read
+		if amount.IsNegative() && wallet.Frozen() {
+			return nil, nil, errors.NewWalletFrozen(op)
+		}
+		newBalance := wallet.Balance.Add(amount)
+		if newBalance.IsNegative() {
+			return nil, nil, errors.NewInsufficientBalance(op)
+		}
 
-	for i := 0; i < maxRetries; i++ {
-		rows, err := u.WalletRepo.UpdateWalletBalance(ctx, wallet.ID, newBalance, wallet.Version)
+		span.SetAttributes(tracing.AttrRetryAttempt.Int(i))
+		tx, err := u.applyBalanceChange(ctx, wallet, amount, newBalance, reference, txType)
 		if err != nil {
-			return nil, errors.WrapInternal(op, err)
+			return nil, nil, errors.WrapInternal(op, err)
 		}
 
-		if rows == 1 {
-			tx := &model.Transaction{
-				ID:            uuid.New(),
-				UserID:        wallet.UserID,
-				Currency:      wallet.Currency,
-				WalletID:      wallet.ID,
-				Amount:        amount,
-				BalanceBefore: wallet.Balance,
-				BalanceAfter:  newBalance,
-				Type:          txType,
-				Reference:     reference,
-			}
-			if err := u.TransactionRepo.CreateTransaction(ctx, tx); err != nil {
-				return nil, errors.WrapInternal(op, err)
-			}
+		if tx != nil {
+			logging.FromContext(ctx).Debug("balance updated",
+				logging.KeyOp, op,
+				logging.KeyWallet, wallet.ID,
+				logging.KeyTxID, tx.ID,
+				"attempt", i,
+			)
 
 			return &model.WalletResponse{
 				ID:       wallet.ID,
 				UserID:   wallet.UserID,
 				Balance:  newBalance,
 				Currency: wallet.Currency,
-			}, nil
+			}, tx, nil
 		}
+		if i+1 >= maxRetries {
+			return nil, nil, errors.NewConflict(op, "optimistic lock conflict")
+		}
+
+		metrics.OptimisticLockRetries.WithLabelValues(txType).Inc()
+		logging.FromContext(ctx).Debug("optimistic lock conflict, retrying",
+			logging.KeyOp, op,
+			logging.KeyWallet, wallet.ID,
+			"attempt", i,
+		)
+		_, backoff := tracing.Start(ctx, op+".backoff", tracing.AttrRetryAttempt.Int(i))
 		time.Sleep(100 * time.Millisecond)
+		backoff.End()
+
+		// the version check failed because the wallet changed, the next
+		// attempt starts from its current version and balance
+		if wallet, err = u.WalletRepo.GetWallet(ctx, wallet.ID); err != nil {
+			return nil, nil, errors.WrapInternal(op, err)
+		}
 	}
+}
 
-	return nil, errors.NewConflict(op, "optimistic lock conflict")
+// applyBalanceChange writes the version-checked balance update, its transaction
+// record, audit event and domain event in one DB transaction. A nil transaction
+// with a nil error means the version check lost the race and nothing was written.
+func (u *WalletUtil) applyBalanceChange(
+	ctx context.Context,
+	wallet *model.Wallet,
+	amount, newBalance decimal.Decimal,
+	reference string,
+	txType string,
+) (*model.Transaction, error) {
+	const op = "utils.applyBalanceChange"
+
+	dbTx, err := u.TxManager.BeginTx(ctx)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			dbTx.Rollback()
+		}
+	}()
+
+	rows, err := dbTx.UpdateWalletBalanceWithVersionTx(ctx, wallet.ID, newBalance, wallet.Version)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if rows != 1 {
+		return nil, nil
+	}
+
+	tx, err := u.RecordBalanceChange(ctx, dbTx, wallet, amount, newBalance, reference, txType)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	committed = true
+	return tx, nil
+}
+
+// RecordBalanceChange writes the transaction record, audit event and domain
+// event of a deposit or withdrawal whose balance update the caller makes in dbTx.
+func (u *WalletUtil) RecordBalanceChange(
+	ctx context.Context,
+	dbTx repository.WalletTx,
+	wallet *model.Wallet,
+	amount, newBalance decimal.Decimal,
+	reference string,
+	txType string,
+) (*model.Transaction, error) {
+	const op = "utils.RecordBalanceChange"
+
+	tx := &model.Transaction{
+		ID:            uuid.New(),
+		UserID:        wallet.UserID,
+		Currency:      wallet.Currency,
+		WalletID:      wallet.ID,
+		Amount:        amount,
+		BalanceBefore: wallet.Balance,
+		BalanceAfter:  newBalance,
+		Type:          txType,
+		Reference:     reference,
+	}
+	if err := dbTx.CreateTransactionTx(ctx, tx); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	after := *wallet
+	after.Balance = newBalance
+	after.Version++
+	event, err := audit.NewEvent(ctx, audit.UserActor(wallet.UserID), auditAction(txType),
+		audit.TargetWallet, wallet.ID.String(), audit.SnapshotWallet(wallet), audit.SnapshotWallet(&after))
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err := dbTx.CreateAuditEventTx(ctx, event); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+
+	outboxEvent, err := events.NewFundsMoved(tx)
+	if err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	if err := dbTx.CreateOutboxEventTx(ctx, outboxEvent); err != nil {
+		return nil, errors.WrapInternal(op, err)
+	}
+	return tx, nil
+}
+
+func auditAction(txType string) string {
+	switch txType {
+	case "deposit":
+		return audit.ActionDeposit
+	case "withdrawal":
+		return audit.ActionWithdrawal
+	default:
+		return "wallet." + txType
+	}
 }
 
 func (u *WalletUtil) ValidateTransfer(from, to *model.Wallet, amount decimal.Decimal) error {
@@ -110,6 +274,9 @@ func (u *WalletUtil) ValidateTransfer(from, to *model.Wallet, amount decimal.Dec
 	if from.Currency != to.Currency {
 		return errors.NewCurrencyMismatch(op)
 	}
+	if from.Frozen() {
+		return errors.NewWalletFrozen(op)
+	}
 	if from.Balance.LessThan(amount) {
 		return errors.NewInsufficientBalance(op)
 	}
@@ -122,11 +289,29 @@ func (u *WalletUtil) CreateTransferTransactions(
 	from, to *model.Wallet,
 	amount decimal.Decimal,
 	reference string,
-) error {
-	const op = "utils.CreateTransferTransactions"
+) (fromTx, toTx *model.Transaction, err error) {
+	return u.CreateLinkedTransactions(ctx, tx, from, to, amount, reference, "transfer")
+}
+
+// CreateLinkedTransactions records a move between two wallets as a debit and
+// a credit entry of txType, the credit pointing back at the debit.
+func (u *WalletUtil) CreateLinkedTransactions(
+	ctx context.Context,
+	tx repository.WalletTx,
+	from, to *model.Wallet,
+	amount decimal.Decimal,
+	reference string,
+	txType string,
+) (fromTx, toTx *model.Transaction, err error) {
+	const op = "utils.CreateLinkedTransactions"
+	ctx, span := tracing.Start(ctx, op,
+		tracing.AttrTxType.String(txType),
+		tracing.AttrCurrency.String(from.Currency),
+	)
+	defer func() { tracing.End(span, err) }()
 
 	txID := uuid.New()
-	fromTx := &model.Transaction{
+	fromTx = &model.Transaction{
 		ID:            txID,
 		WalletID:      from.ID,
 		UserID:        from.UserID,
@@ -134,14 +319,14 @@ func (u *WalletUtil) CreateTransferTransactions(
 		Amount:        amount.Neg(),
 		BalanceBefore: from.Balance,
 		BalanceAfter:  from.Balance.Sub(amount),
-		Type:          "transfer",
+		Type:          txType,
 		Reference:     reference,
 	}
 	if err := tx.CreateTransactionTx(ctx, fromTx); err != nil {
-		return errors.WrapInternal(op, err)
+		return nil, nil, errors.WrapInternal(op, err)
 	}
 
-	toTx := &model.Transaction{
+	toTx = &model.Transaction{
 		ID:            uuid.New(),
 		WalletID:      to.ID,
 		UserID:        to.UserID,
@@ -149,11 +334,77 @@ func (u *WalletUtil) CreateTransferTransactions(
 		Amount:        amount,
 		BalanceBefore: to.Balance,
 		BalanceAfter:  to.Balance.Add(amount),
-		Type:          "transfer",
+		Type:          txType,
 		RelatedTxID:   &txID,
 		Reference:     reference,
 	}
-	return errors.WrapInternal(op, tx.CreateTransactionTx(ctx, toTx))
+	if err := tx.CreateTransactionTx(ctx, toTx); err != nil {
+		return nil, nil, errors.WrapInternal(op, err)
+	}
+	logging.FromContext(ctx).Debug("transfer recorded",
+		logging.KeyOp, op,
+		logging.KeyTxID, txID,
+		"related_tx_id", toTx.ID,
+	)
+	return fromTx, toTx, nil
+}
+
+// AuditTransfer records the transfer in the audit log inside the transfer's DB transaction
+func (u *WalletUtil) AuditTransfer(
+	ctx context.Context,
+	tx repository.WalletTx,
+	from, to *model.Wallet,
+	amount decimal.Decimal,
+) error {
+	return u.AuditMove(ctx, tx, audit.ActionTransfer, audit.UserActor(from.UserID), from, to, amount)
+}
+
+// AuditMove records a move between two wallets in the audit log inside the move's DB transaction
+func (u *WalletUtil) AuditMove(
+	ctx context.Context,
+	tx repository.WalletTx,
+	action, actor string,
+	from, to *model.Wallet,
+	amount decimal.Decimal,
+) error {
+	const op = "utils.AuditMove"
+
+	fromAfter, toAfter := *from, *to
+	fromAfter.Balance = from.Balance.Sub(amount)
+	toAfter.Balance = to.Balance.Add(amount)
+
+	event, err := audit.NewEvent(ctx, actor, action,
+		audit.TargetWallet, from.ID.String(),
+		map[string]audit.WalletSnapshot{"from": audit.SnapshotWallet(from), "to": audit.SnapshotWallet(to)},
+		map[string]audit.WalletSnapshot{"from": audit.SnapshotWallet(&fromAfter), "to": audit.SnapshotWallet(&toAfter)},
+	)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	return errors.WrapInternal(op, tx.CreateAuditEventTx(ctx, event))
+}
+
+// RecordTransferEvents writes the transfer's domain events inside the transfer's DB transaction
+func (u *WalletUtil) RecordTransferEvents(
+	ctx context.Context,
+	tx repository.WalletTx,
+	txID uuid.UUID,
+	from, to *model.Wallet,
+	amount decimal.Decimal,
+	reference string,
+) error {
+	const op = "utils.RecordTransferEvents"
+
+	outboxEvents, err := events.NewTransferEvents(txID, from, to, amount, reference)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	for _, e := range outboxEvents {
+		if err := tx.CreateOutboxEventTx(ctx, e); err != nil {
+			return errors.WrapInternal(op, err)
+		}
+	}
+	return nil
 }
 
 func (u *WalletUtil) GetTransactions(
@@ -0,0 +1,228 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+)
+
+type DelivererConfig struct {
+	BatchSize    int
+	PollInterval time.Duration
+	Timeout      time.Duration
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	// MaxAttempts failed attempts move a delivery to the dead state
+	MaxAttempts int
+}
+
+func (c DelivererConfig) withDefaults() DelivererConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = 50
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = 30 * time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 6 * time.Hour
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 10
+	}
+	return c
+}
+
+// Deliverer sends queued deliveries to subscriber endpoints
+type Deliverer struct {
+	repo   repository.WebhookRepository
+	client *http.Client
+	cfg    DelivererConfig
+}
+
+// NewDeliverer sends deliveries with client. A nil client gets NewClient's,
+// which only connects to public addresses.
+func NewDeliverer(repo repository.WebhookRepository, client *http.Client, cfg DelivererConfig) *Deliverer {
+	cfg = cfg.withDefaults()
+	if client == nil {
+		client = NewClient(cfg.Timeout)
+	}
+	return &Deliverer{repo: repo, client: client, cfg: cfg}
+}
+
+// Run polls for due deliveries until ctx is cancelled
+func (d *Deliverer) Run(ctx context.Context) {
+	const op = "webhook.Deliverer.Run"
+
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := d.ProcessBatch(ctx)
+			if err != nil {
+				logging.FromContext(ctx).Error("webhook delivery failed", logging.KeyOp, op, logging.Err(err))
+				break
+			}
+			if n < d.cfg.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessBatch attempts up to BatchSize due deliveries and returns how many
+// were claimed. Each is claimed right before it is sent, so its lease only has
+// to cover its own send: Timeout, and as much again for reading the
+// subscription and recording the attempt. An attempt outliving its lease is
+// sent twice, but only one of the two is recorded.
+func (d *Deliverer) ProcessBatch(ctx context.Context) (int, error) {
+	const op = "webhook.Deliverer.ProcessBatch"
+
+	for n := 0; n < d.cfg.BatchSize; n++ {
+		deliveries, err := d.repo.ClaimDueDeliveries(ctx, 1, 2*d.cfg.Timeout)
+		if err != nil {
+			return n, errors.WrapInternal(op, err)
+		}
+		if len(deliveries) == 0 {
+			return n, nil
+		}
+		if err := d.deliver(ctx, &deliveries[0]); err != nil {
+			return n, errors.WrapInternal(op, err)
+		}
+	}
+	return d.cfg.BatchSize, nil
+}
+
+func (d *Deliverer) deliver(ctx context.Context, delivery *model.WebhookDelivery) error {
+	const op = "webhook.Deliverer.deliver"
+
+	sub, err := d.repo.GetSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+
+	start := time.Now()
+	var statusCode int
+	var sendErr error
+	if sub.Active {
+		statusCode, sendErr = d.send(ctx, sub, delivery)
+	} else {
+		sendErr = fmt.Errorf("subscription is inactive")
+	}
+
+	delivery.Attempts++
+	attempt := &model.WebhookAttempt{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts,
+		DurationMs: int(time.Since(start).Milliseconds()),
+	}
+	delivery.LastStatusCode = nil
+	if statusCode != 0 {
+		attempt.StatusCode = &statusCode
+		delivery.LastStatusCode = &statusCode
+	}
+
+	outcome := model.WebhookSucceeded
+	switch {
+	case sendErr == nil:
+		now := time.Now()
+		delivery.Status = model.WebhookSucceeded
+		delivery.DeliveredAt = &now
+		delivery.LastError = nil
+	case delivery.Attempts >= d.cfg.MaxAttempts || !sub.Active:
+		outcome = model.WebhookDead
+		delivery.Status = model.WebhookDead
+	default:
+		outcome = "retry"
+		delivery.Status = model.WebhookPending
+		delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts))
+	}
+	if sendErr != nil {
+		msg := sendErr.Error()
+		attempt.Error = &msg
+		delivery.LastError = &msg
+	}
+
+	metrics.WebhookDeliveries.WithLabelValues(outcome).Inc()
+	log := logging.FromContext(ctx).With(
+		logging.KeyOp, op,
+		"delivery_id", delivery.ID,
+		"subscription_id", sub.ID,
+		"attempt", delivery.Attempts,
+		"status_code", statusCode,
+	)
+	if sendErr != nil {
+		log.Warn("webhook attempt failed", "outcome", outcome, "error", sendErr.Error())
+	} else {
+		log.Debug("webhook delivered")
+	}
+
+	held, err := d.repo.RecordAttempt(ctx, delivery, attempt)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	if !held {
+		log.Warn("webhook lease lost, attempt not recorded")
+	}
+	return nil
+}
+
+// send POSTs the delivery and returns the response status, or 0 if there was none
+func (d *Deliverer) send(ctx context.Context, sub *model.WebhookSubscription, delivery *model.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, time.Now(), body))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID.String())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff doubles per failed attempt up to MaxBackoff
+func (d *Deliverer) backoff(attempts int) time.Duration {
+	b := d.cfg.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		b *= 2
+		if b >= d.cfg.MaxBackoff {
+			return d.cfg.MaxBackoff
+		}
+	}
+	return b
+}
@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/events"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/google/uuid"
+)
+
+// Dispatcher is an events.Publisher that queues a delivery for every active
+// subscription of the wallet's owner that wants the event type. Sending is
+// left to the Deliverer so a slow endpoint never holds up the outbox.
+type Dispatcher struct {
+	repo repository.WebhookRepository
+}
+
+func NewDispatcher(repo repository.WebhookRepository) *Dispatcher {
+	return &Dispatcher{repo: repo}
+}
+
+func (d *Dispatcher) Publish(ctx context.Context, msg events.Message) error {
+	const op = "webhook.Dispatcher.Publish"
+
+	owner, err := ownerOf(msg)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+	subs, err := d.repo.ListSubscriptions(ctx, owner)
+	if err != nil {
+		return errors.WrapInternal(op, err)
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return errors.NewInternal(op, err)
+	}
+
+	var deliveries []model.WebhookDelivery
+	for i := range subs {
+		if !subs[i].Matches(msg.Type) {
+			continue
+		}
+		deliveries = append(deliveries, model.WebhookDelivery{
+			ID:             uuid.New(),
+			SubscriptionID: subs[i].ID,
+			EventID:        msg.ID,
+			EventType:      msg.Type,
+			Payload:        string(body),
+		})
+	}
+	return errors.WrapInternal(op, d.repo.EnqueueDeliveries(ctx, deliveries))
+}
+
+// ownerOf returns the user whose wallet the event belongs to
+func ownerOf(msg events.Message) (uuid.UUID, error) {
+	const op = "webhook.ownerOf"
+
+	var p struct {
+		UserID     uuid.UUID `json:"user_id"`
+		FromUserID uuid.UUID `json:"from_user_id"`
+		ToUserID   uuid.UUID `json:"to_user_id"`
+	}
+	if err := json.Unmarshal(msg.Payload, &p); err != nil {
+		return uuid.Nil, errors.NewInternal(op, err)
+	}
+	switch msg.Type {
+	case events.TransferCompleted:
+		return p.FromUserID, nil
+	case events.TransferReceived:
+		return p.ToUserID, nil
+	default:
+		return p.UserID, nil
+	}
+}
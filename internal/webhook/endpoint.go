@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Resolver looks up a host's addresses, net.DefaultResolver is one
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// nonPublic lists the ranges IsGlobalUnicast lets through that still don't
+// belong to the public internet
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	// NAT64 maps IPv4 addresses, private ones included, into IPv6
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// PublicAddr reports whether addr is a public unicast address. Deliveries go
+// to nothing else, so a subscription can't make the server call loopback,
+// link-local (cloud metadata) or private network services.
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckEndpoint returns why a subscription can't use the endpoint URL, nil
+// when it can: it must be http or https and its host resolve to public
+// addresses only
+func CheckEndpoint(ctx context.Context, resolver Resolver, endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("not an http or https URL")
+	}
+	host := u.Hostname()
+	addrs := []netip.Addr{}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, addr)
+	} else if addrs, err = resolver.LookupNetIP(ctx, "ip", host); err != nil || len(addrs) == 0 {
+		return fmt.Errorf("host %s doesn't resolve", host)
+	}
+	for _, addr := range addrs {
+		if !PublicAddr(addr) {
+			return fmt.Errorf("host %s has the non-public address %s", host, addr)
+		}
+	}
+	return nil
+}
+
+// NewClient returns the client deliveries are sent with. It refuses to
+// connect to non-public addresses when it dials, so a host that resolved to
+// a public address when the subscription was made can't be pointed at the
+// internal network later, and neither can a redirect.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !PublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("refusing to deliver to the non-public address %s", addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the endpoint, and checked instead of it
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
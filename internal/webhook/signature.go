@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+)
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "X-Wallet-Signature"
+	EventHeader     = "X-Wallet-Event"
+	DeliveryHeader  = "X-Wallet-Delivery"
+)
+
+// Sign returns the signature header value for body sent at ts:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">". The timestamp is
+// part of the signed content so receivers can reject replays.
+func Sign(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	return "t=" + t + ",v1=" + mac(secret, t, body)
+}
+
+func mac(secret, t string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(t))
+	h.Write([]byte{'.'})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks a signature header against body, rejecting timestamps further
+// than tolerance from now. Receivers in Go can use it directly.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	const op = "webhook.Verify"
+
+	var t, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			t = v
+		case "v1":
+			sig = v
+		}
+	}
+	if t == "" || sig == "" {
+		return errors.NewInvalidInput(op, "signature header", header)
+	}
+
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return errors.NewInvalidInput(op, "signature timestamp", t)
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return errors.NewInvalidInput(op, "signature timestamp", "outside tolerance")
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(secret, t, body))) {
+		return errors.NewInvalidInput(op, "signature", "mismatch")
+	}
+	return nil
+}
+
+// NewSecret generates a random signing secret
+func NewSecret() (string, error) {
+	const op = "webhook.NewSecret"
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.NewInternal(op, err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,36 @@
+CREATE TABLE audit_events (
+                              id BIGSERIAL PRIMARY KEY,
+                              event_id UUID NOT NULL UNIQUE,
+                              actor VARCHAR(100) NOT NULL,
+                              action VARCHAR(50) NOT NULL,
+                              target_type VARCHAR(30) NOT NULL,
+                              target_id VARCHAR(100) NOT NULL,
+                              request_id VARCHAR(128) NOT NULL DEFAULT '',
+    -- JSON (not JSONB) keeps the exact bytes that were hashed
+    before_state JSON,
+    after_state JSON,
+    client_ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_audit_actor ON audit_events(actor, created_at);
+CREATE INDEX idx_audit_target ON audit_events(target_type, target_id, created_at);
+CREATE INDEX idx_audit_created ON audit_events(created_at);
+
+-- append only
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_modify
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
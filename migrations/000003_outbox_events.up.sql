@@ -0,0 +1,17 @@
+CREATE TABLE outbox_events (
+                               id BIGSERIAL PRIMARY KEY,
+                               event_id UUID NOT NULL UNIQUE,
+                               aggregate_type VARCHAR(30) NOT NULL,
+                               aggregate_id UUID NOT NULL,
+                               event_type VARCHAR(50) NOT NULL,
+                               payload JSONB NOT NULL,
+                               attempts INTEGER NOT NULL DEFAULT 0,
+                               next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    published_at TIMESTAMPTZ
+);
+
+-- the relay only ever scans unpublished events
+CREATE INDEX idx_outbox_pending ON outbox_events(id) WHERE published_at IS NULL;
+CREATE INDEX idx_outbox_aggregate_pending ON outbox_events(aggregate_id, id) WHERE published_at IS NULL;
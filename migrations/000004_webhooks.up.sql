@@ -0,0 +1,45 @@
+CREATE TABLE webhook_subscriptions (
+                                       id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                                       user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                       url TEXT NOT NULL,
+    -- empty means every event type
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    secret TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webhook_subscriptions_user ON webhook_subscriptions(user_id) WHERE active;
+
+CREATE TABLE webhook_deliveries (
+                                    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                                    subscription_id UUID NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+                                    event_id UUID NOT NULL,
+                                    event_type VARCHAR(50) NOT NULL,
+                                    payload JSONB NOT NULL,
+                                    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_status_code INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMPTZ,
+    -- the outbox relay is at-least-once, an event is queued once per subscription
+    UNIQUE(subscription_id, event_id)
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_subscription ON webhook_deliveries(subscription_id, created_at DESC);
+
+CREATE TABLE webhook_delivery_attempts (
+                                           id BIGSERIAL PRIMARY KEY,
+                                           delivery_id UUID NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+                                           attempt INTEGER NOT NULL,
+                                           status_code INTEGER,
+                                           error TEXT,
+                                           duration_ms INTEGER NOT NULL,
+                                           attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_webhook_attempts_delivery ON webhook_delivery_attempts(delivery_id, id);
@@ -0,0 +1,20 @@
+CREATE TABLE batches (
+                         id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                         user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                         idempotency_key VARCHAR(255) NOT NULL,
+    -- sha256 of mode and items, a reused key must carry the same batch
+                         request_hash CHAR(64) NOT NULL,
+                         mode VARCHAR(20) NOT NULL CHECK (mode IN ('atomic', 'best_effort')),
+                         status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'processing', 'completed', 'failed')),
+    items JSONB NOT NULL,
+    item_count INTEGER NOT NULL,
+    results JSONB,
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ,
+    UNIQUE(user_id, idempotency_key)
+);
+
+CREATE INDEX idx_batches_pending ON batches(created_at) WHERE status = 'pending';
@@ -0,0 +1,42 @@
+CREATE TABLE transfer_schedules (
+                                    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                                    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                    to_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                    amount DECIMAL(19,4) NOT NULL CHECK (amount > 0),
+                                    currency VARCHAR(3) NOT NULL,
+                                    reference TEXT NOT NULL DEFAULT '',
+                                    kind VARCHAR(20) NOT NULL CHECK (kind IN ('once', 'interval', 'cron')),
+    cron_expr TEXT,
+    interval_seconds BIGINT,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    start_at TIMESTAMPTZ NOT NULL,
+    end_at TIMESTAMPTZ,
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'paused', 'completed', 'cancelled', 'failed')),
+    next_run_at TIMESTAMPTZ,
+    last_run_at TIMESTAMPTZ,
+    runs INTEGER NOT NULL DEFAULT 0,
+    -- failed attempts of the current occurrence
+    attempt INTEGER NOT NULL DEFAULT 0,
+    max_retries INTEGER NOT NULL DEFAULT 3,
+    retry_interval_seconds BIGINT NOT NULL DEFAULT 3600,
+    last_error TEXT,
+    -- set while a scheduler instance is executing the schedule
+    locked_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_transfer_schedules_user ON transfer_schedules(user_id);
+CREATE INDEX idx_transfer_schedules_due ON transfer_schedules(next_run_at) WHERE status = 'active';
+
+CREATE TABLE transfer_schedule_runs (
+                                        id BIGSERIAL PRIMARY KEY,
+                                        schedule_id UUID NOT NULL REFERENCES transfer_schedules(id) ON DELETE CASCADE,
+                                        scheduled_for TIMESTAMPTZ NOT NULL,
+                                        attempt INTEGER NOT NULL,
+                                        status VARCHAR(20) NOT NULL CHECK (status IN ('succeeded', 'retrying', 'failed')),
+    error TEXT,
+    executed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_transfer_schedule_runs_schedule ON transfer_schedule_runs(schedule_id, id);
@@ -0,0 +1,18 @@
+CREATE TABLE payment_requests (
+                                  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                                  requester_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                  payer_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                  amount DECIMAL(19,4) NOT NULL CHECK (amount > 0),
+                                  currency VARCHAR(3) NOT NULL,
+                                  note VARCHAR(255) NOT NULL DEFAULT '',
+                                  status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'accepted', 'declined', 'cancelled', 'expired')),
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMPTZ,
+    CHECK (requester_id <> payer_id)
+);
+
+CREATE INDEX idx_payment_requests_payer ON payment_requests(payer_id, created_at DESC);
+CREATE INDEX idx_payment_requests_requester ON payment_requests(requester_id, created_at DESC);
+CREATE INDEX idx_payment_requests_expiry ON payment_requests(expires_at) WHERE status = 'pending';
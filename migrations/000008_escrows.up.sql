@@ -0,0 +1,40 @@
+-- escrow wallets belong to the system escrow user, one per agreement
+INSERT INTO users (id, username, email) VALUES
+    ('00000000-0000-0000-0000-00000000e5c0', 'escrow', 'escrow@system.local');
+
+ALTER TABLE wallets ADD COLUMN escrow_id UUID;
+ALTER TABLE wallets DROP CONSTRAINT wallets_user_id_currency_key;
+CREATE UNIQUE INDEX idx_wallets_user_currency ON wallets(user_id, currency) WHERE escrow_id IS NULL;
+CREATE UNIQUE INDEX idx_wallets_escrow ON wallets(escrow_id) WHERE escrow_id IS NOT NULL;
+
+ALTER TABLE transactions DROP CONSTRAINT transactions_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_type_check
+    CHECK (type IN ('deposit', 'withdrawal', 'transfer', 'escrow_hold', 'escrow_release', 'escrow_refund'));
+
+CREATE TABLE escrows (
+                         id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                         buyer_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                         seller_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- decides disputes, may release, refund or split
+                         arbiter_id UUID REFERENCES users(id) ON DELETE SET NULL,
+                         wallet_id UUID NOT NULL REFERENCES wallets(id),
+                         amount DECIMAL(19,4) NOT NULL CHECK (amount > 0),
+                         currency VARCHAR(3) NOT NULL,
+                         reference TEXT NOT NULL DEFAULT '',
+                         status VARCHAR(20) NOT NULL CHECK (status IN ('held', 'released', 'refunded', 'split')),
+    deadline TIMESTAMPTZ,
+    deadline_action VARCHAR(20) NOT NULL CHECK (deadline_action IN ('release', 'refund')),
+    released_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    refunded_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    -- NULL when the deadline resolved it
+    resolved_by UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMPTZ,
+    CHECK (buyer_id <> seller_id)
+);
+
+CREATE INDEX idx_escrows_buyer ON escrows(buyer_id, created_at DESC);
+CREATE INDEX idx_escrows_seller ON escrows(seller_id, created_at DESC);
+CREATE INDEX idx_escrows_arbiter ON escrows(arbiter_id, created_at DESC);
+CREATE INDEX idx_escrows_deadline ON escrows(deadline) WHERE status = 'held';
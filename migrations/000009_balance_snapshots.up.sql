@@ -0,0 +1,18 @@
+-- seq orders a wallet's transactions as written: rows of one DB transaction share
+-- created_at, and wallet row locks make insertion order the balance_after chain order.
+-- Existing rows are numbered in storage order.
+ALTER TABLE transactions ADD COLUMN seq BIGSERIAL;
+
+CREATE INDEX idx_tx_wallet_seq ON transactions(wallet_id, seq DESC);
+
+CREATE TABLE wallet_balance_snapshots (
+                                          wallet_id UUID NOT NULL REFERENCES wallets(id) ON DELETE CASCADE,
+                                          user_id UUID NOT NULL,
+                                          currency VARCHAR(3) NOT NULL,
+                                          snapshot_date DATE NOT NULL,
+                                          balance DECIMAL(19,4) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (wallet_id, snapshot_date)
+);
+
+CREATE INDEX idx_balance_snapshots_user ON wallet_balance_snapshots(user_id, currency, snapshot_date);
@@ -0,0 +1,2 @@
+-- reconciliation looks up the credit leg of every debit
+CREATE INDEX idx_tx_related ON transactions(related_tx_id) WHERE related_tx_id IS NOT NULL;
@@ -0,0 +1,37 @@
+CREATE TABLE settlements (
+                             id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    -- where the file came from, e.g. its name
+                             source TEXT NOT NULL DEFAULT '',
+                             format VARCHAR(20) NOT NULL,
+    -- sha256 of the file, importing it again returns the first import
+                             file_hash CHAR(64) NOT NULL UNIQUE,
+                             line_count INTEGER NOT NULL,
+                             matched INTEGER NOT NULL DEFAULT 0,
+                             unmatched INTEGER NOT NULL DEFAULT 0,
+                             mismatched INTEGER NOT NULL DEFAULT 0,
+                             duplicate INTEGER NOT NULL DEFAULT 0,
+                             created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE settlement_lines (
+                                  settlement_id UUID NOT NULL REFERENCES settlements(id) ON DELETE CASCADE,
+                                  line_number INTEGER NOT NULL,
+                                  reference TEXT NOT NULL,
+                                  direction VARCHAR(10) NOT NULL CHECK (direction IN ('credit', 'debit')),
+                                  amount DECIMAL(19,4) NOT NULL,
+                                  currency VARCHAR(3) NOT NULL,
+                                  value_date DATE,
+                                  status VARCHAR(20) NOT NULL CHECK (status IN ('matched', 'unmatched', 'mismatched', 'duplicate')),
+                                  transaction_id UUID REFERENCES transactions(id) ON DELETE SET NULL,
+                                  detail TEXT NOT NULL DEFAULT '',
+    -- a batched camt.053 entry yields several lines with the same number
+                                  position INTEGER NOT NULL,
+                                  PRIMARY KEY (settlement_id, position)
+);
+
+CREATE INDEX idx_settlement_lines_status ON settlement_lines(settlement_id, status, position);
+
+ALTER TABLE transactions ADD COLUMN settled_at TIMESTAMPTZ;
+ALTER TABLE transactions ADD COLUMN settlement_id UUID REFERENCES settlements(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_tx_settlement_reference ON transactions(reference) WHERE type IN ('deposit', 'withdrawal');
@@ -0,0 +1,3 @@
+-- a frozen wallet takes money in but doesn't let any out
+ALTER TABLE wallets ADD COLUMN frozen_at TIMESTAMPTZ;
+ALTER TABLE wallets ADD COLUMN frozen_reason TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,28 @@
+ALTER TABLE transactions DROP CONSTRAINT transactions_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_type_check
+    CHECK (type IN ('deposit', 'withdrawal', 'transfer', 'escrow_hold', 'escrow_release', 'escrow_refund', 'adjustment'));
+
+-- operators' balance corrections, those above the approval threshold wait
+-- for a second operator
+CREATE TABLE adjustments (
+                             id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+                             wallet_id UUID NOT NULL REFERENCES wallets(id),
+                             user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                             currency VARCHAR(3) NOT NULL,
+                             amount DECIMAL(19,4) NOT NULL CHECK (amount != 0),
+                             reason_code VARCHAR(20) NOT NULL
+                                 CHECK (reason_code IN ('incident', 'duplicate', 'chargeback', 'fee_refund', 'goodwill')),
+                             note TEXT NOT NULL DEFAULT '',
+                             requested_by TEXT NOT NULL,
+                             reviewed_by TEXT,
+                             status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'applied', 'rejected')),
+                             transaction_id UUID REFERENCES transactions(id),
+                             created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                             updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                             resolved_at TIMESTAMPTZ,
+                             CHECK (reviewed_by IS NULL OR reviewed_by <> requested_by),
+                             CHECK ((status = 'applied') = (transaction_id IS NOT NULL))
+);
+
+CREATE INDEX idx_adjustments_status ON adjustments(status, created_at DESC);
+CREATE INDEX idx_adjustments_wallet ON adjustments(wallet_id, created_at DESC);
@@ -0,0 +1,6 @@
+-- Every target gets a hash chain of its own, so an append only waits for
+-- appends to the same target instead of every other money movement. The
+-- events written before stay on the single chain they were linked in, ''.
+ALTER TABLE audit_events ADD COLUMN chain VARCHAR(140) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_audit_chain ON audit_events(chain, id);
@@ -0,0 +1,10 @@
+-- A processing batch is leased to the instance running it. A batch whose
+-- lease ran out, its instance having died, is claimed again. claims counts
+-- the claims, an instance that lost its lease can't record any more progress.
+ALTER TABLE batches ADD COLUMN locked_until TIMESTAMPTZ;
+ALTER TABLE batches ADD COLUMN claims INTEGER NOT NULL DEFAULT 0;
+-- the item being applied; results holds the outcomes of the items before it
+ALTER TABLE batches ADD COLUMN in_flight INTEGER;
+
+DROP INDEX idx_batches_pending;
+CREATE INDEX idx_batches_claimable ON batches(created_at) WHERE status IN ('pending', 'processing');
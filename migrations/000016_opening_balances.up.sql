@@ -0,0 +1,13 @@
+-- Wallets created with a balance, like the seed wallets of 000001, have no
+-- transaction explaining it. opening_balance is the balance a wallet's ledger
+-- starts from: the balance_before of its first transaction, or the balance of
+-- a wallet without any. Wallets created from now on open at 0.
+ALTER TABLE wallets ADD COLUMN opening_balance DECIMAL(19,4) NOT NULL DEFAULT 0;
+
+UPDATE wallets w
+SET opening_balance = COALESCE(
+        (SELECT t.balance_before FROM transactions t
+         WHERE t.wallet_id = w.id
+         ORDER BY t.seq
+         LIMIT 1),
+        w.balance);
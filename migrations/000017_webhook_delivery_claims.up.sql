@@ -0,0 +1,4 @@
+-- A delivery is leased to the worker sending it by pushing next_attempt_at
+-- out. claims counts the claims, a worker whose lease ran out and whose
+-- delivery was claimed again, or redelivered, can't record its attempt.
+ALTER TABLE webhook_deliveries ADD COLUMN claims INTEGER NOT NULL DEFAULT 0;
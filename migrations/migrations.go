@@ -0,0 +1,7 @@
+// Package migrations embeds the SQL migrations, applied in file name order
+package migrations
+
+import "embed"
+
+//go:embed *.up.sql
+var FS embed.FS
@@ -0,0 +1,241 @@
+// Command loadtest drives a mix of deposits, withdrawals, transfers and
+// balance reads against the wallet service and reports throughput, latency
+// percentiles and errors by type. The run ends by checking every user's
+// balance against what the successful operations add up to; it exits 1 when
+// they disagree and 2 when it fails.
+//
+// With -target it calls a running server over HTTP, otherwise it calls the
+// wallet service directly against Postgres, configured like the server, or
+// against an in-memory store.
+//
+//	loadtest [-target http://localhost:8080] [-storage postgres|memory]
+//	         [-users 100] [-skew 1.2] [-mix deposit=20,withdraw=10,transfer=50,read=20]
+//	         [-workers 16] [-duration 30s] [-requests 0] [-format text|json]
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/loadtest"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/repository/memory"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/package/database"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+)
+
+func main() {
+	target := flag.String("target", "", "base URL of a running server, empty calls the service directly")
+	storage := flag.String("storage", "postgres", "storage the service uses without -target, postgres or memory")
+	users := flag.Int("users", 100, "users taking part")
+	usersFile := flag.String("users-file", "", "file of existing user ids, one per line, instead of creating users")
+	currency := flag.String("currency", "USD", "currency of every operation")
+	initial := flag.String("initial", "1000", "balance each user is funded with first")
+	maxAmount := flag.Int64("max-amount", 100, "largest amount of one operation, in whole units")
+	mix := flag.String("mix", "deposit=20,withdraw=10,transfer=50,read=20", "weights of the operations")
+	skew := flag.Float64("skew", 0, "Zipf exponent above 1 concentrating load on hot users, 0 spreads it evenly")
+	workers := flag.Int("workers", 16, "concurrent workers")
+	duration := flag.Duration("duration", 30*time.Second, "how long to apply load, 0 for no limit")
+	requests := flag.Int("requests", 0, "how many requests to send, 0 for no limit")
+	seed := flag.Uint64("seed", uint64(time.Now().UnixNano()), "seed of the random operations")
+	timeout := flag.Duration("http-timeout", 10*time.Second, "timeout of one HTTP request")
+	format := flag.String("format", "text", "report format, text or json")
+	flag.Parse()
+	if *format != "text" && *format != "json" {
+		log.Fatalf("unknown format %q", *format)
+	}
+
+	// logs go to stderr, the report to stdout
+	logger, err := logging.New(logging.Config{
+		Format: getEnv("LOG_FORMAT", logging.FormatText),
+		Level:  getEnv("LOG_LEVEL", "error"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	cfg := loadtest.Config{
+		Currency:  *currency,
+		MaxAmount: *maxAmount,
+		Skew:      *skew,
+		Workers:   *workers,
+		Duration:  *duration,
+		Requests:  *requests,
+		Seed:      *seed,
+	}
+	if cfg.InitialBalance, err = decimal.NewFromString(*initial); err != nil {
+		log.Fatalf("invalid initial balance %q", *initial)
+	}
+	if cfg.Mix, err = parseMix(*mix); err != nil {
+		log.Fatalf("invalid mix: %v", err)
+	}
+	if *usersFile != "" {
+		if cfg.Users, err = readUsers(*usersFile); err != nil {
+			fail("Failed to read users", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(logging.WithContext(context.Background(), logger), os.Interrupt)
+	defer stop()
+
+	var t loadtest.Target
+	switch {
+	case *target != "":
+		t = loadtest.NewHTTPTarget(*target, &http.Client{Timeout: *timeout})
+		// the server creates wallets on first use, users only have to exist
+		// with Postgres storage, and then -users-file names them
+		if cfg.Users == nil {
+			cfg.Users = newUsers(*users)
+		}
+	case *storage == "memory":
+		store := memory.NewStore()
+		t = loadtest.NewServiceTarget(service.NewWalletService(store, store, store))
+		if cfg.Users == nil {
+			cfg.Users = newUsers(*users)
+		}
+	case *storage == "postgres":
+		db, err := database.NewPostgresDB(database.Config{
+			Host:     getEnv("DB_HOST", "localhost"),
+			Port:     getEnv("DB_PORT", "5432"),
+			User:     getEnv("DB_USER", "postgres"),
+			Password: getEnv("DB_PASSWORD", "920313"),
+			DBName:   getEnv("DB_NAME", "walletapi"),
+			SSLMode:  getEnv("DB_SSL_MODE", "disable"),
+		})
+		if err != nil {
+			fail("Failed to connect to database", err)
+		}
+		defer db.Close()
+		transactionRepo := repository.NewTransactionRepository(db)
+		t = loadtest.NewServiceTarget(service.NewWalletService(
+			repository.NewWalletRepository(db),
+			transactionRepo,
+			transactionRepo.(repository.TxManager),
+		))
+		if cfg.Users == nil {
+			if cfg.Users, err = createUsers(ctx, db, *users); err != nil {
+				fail("Failed to create users", err)
+			}
+		}
+	default:
+		log.Fatalf("unknown storage %q", *storage)
+	}
+
+	report, err := loadtest.Run(ctx, t, cfg)
+	if err != nil {
+		fail("Load test failed", err)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fail("Failed to write report", err)
+	}
+	if !report.Verification.Consistent {
+		os.Exit(1)
+	}
+}
+
+// parseMix reads weights such as "deposit=20,transfer=80"
+func parseMix(s string) (map[loadtest.Op]int, error) {
+	mix := make(map[loadtest.Op]int)
+	for _, part := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not op=weight", part)
+		}
+		o := loadtest.Op(name)
+		known := false
+		for _, k := range loadtest.Ops {
+			known = known || k == o
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown operation %q", name)
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("weight of %s: %w", name, err)
+		}
+		mix[o] = weight
+	}
+	return mix, nil
+}
+
+func readUsers(path string) ([]uuid.UUID, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var users []uuid.UUID
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, err := uuid.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", line, err)
+		}
+		users = append(users, id)
+	}
+	return users, scanner.Err()
+}
+
+func newUsers(n int) []uuid.UUID {
+	users := make([]uuid.UUID, n)
+	for i := range users {
+		users[i] = uuid.New()
+	}
+	return users
+}
+
+// createUsers inserts the users, their wallets reference them. They are named
+// after the run so a database can be cleaned up afterwards.
+func createUsers(ctx context.Context, db *sqlx.DB, n int) ([]uuid.UUID, error) {
+	users := newUsers(n)
+	run := time.Now().UTC().Format("20060102150405")
+	for i, id := range users {
+		name := fmt.Sprintf("loadtest-%s-%d", run, i)
+		if _, err := db.ExecContext(ctx,
+			`INSERT INTO users (id, username, email) VALUES ($1, $2, $3)`, id, name, name+"@loadtest.invalid"); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+func fail(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(2)
+}
+
+func getEnv(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,88 @@
+// Command reconcile checks every wallet against the transaction log once and
+// prints the discrepancies. It exits 1 when there are any and 2 when it fails.
+//
+//	reconcile [-format text|json] [-limit 100]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/package/database"
+)
+
+func main() {
+	format := flag.String("format", "text", "report format, text or json")
+	limit := flag.Int("limit", 100, "discrepancies listed per kind")
+	timeout := flag.Duration("timeout", 10*time.Minute, "give up after this long")
+	flag.Parse()
+	if *format != "text" && *format != "json" {
+		log.Fatalf("unknown format %q", *format)
+	}
+
+	// logs go to stderr, the report to stdout
+	logger, err := logging.New(logging.Config{
+		Format: getEnv("LOG_FORMAT", logging.FormatText),
+		Level:  getEnv("LOG_LEVEL", "error"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	db, err := database.NewPostgresDB(database.Config{
+		Host:     getEnv("DB_HOST", "localhost"),
+		Port:     getEnv("DB_PORT", "5432"),
+		User:     getEnv("DB_USER", "postgres"),
+		Password: getEnv("DB_PASSWORD", "920313"),
+		DBName:   getEnv("DB_NAME", "walletapi"),
+		SSLMode:  getEnv("DB_SSL_MODE", "disable"),
+	})
+	if err != nil {
+		fail("Failed to connect to database", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(logging.WithContext(context.Background(), logger), *timeout)
+	defer cancel()
+
+	svc := service.NewReconciliationService(repository.NewReconciliationRepository(db), *limit)
+	report, err := svc.Reconcile(ctx)
+	if err != nil {
+		fail("Reconciliation failed", err)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fail("Failed to write report", err)
+	}
+	if report.Total() > 0 {
+		os.Exit(1)
+	}
+}
+
+func fail(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(2)
+}
+
+func getEnv(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/Jiang-hao/walletApiService/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// authenticate recognises the gateway and back-office tools by the
+// ADMIN_AUTH_TOKENS they send as bearer tokens. Only they reach the operator
+// routes and may name the actor of audit events. Without tokens the operator
+// routes answer 401.
+func authenticate(logger *slog.Logger) gin.HandlerFunc {
+	tokens, err := auth.ParseTokens(getEnv("ADMIN_AUTH_TOKENS", ""))
+	if err != nil {
+		fatal("Invalid ADMIN_AUTH_TOKENS", err)
+	}
+	if len(tokens) == 0 {
+		logger.Info("Operator routes locked, set ADMIN_AUTH_TOKENS to open them")
+	}
+	return auth.Middleware(tokens)
+}
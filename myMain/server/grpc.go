@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/Jiang-hao/walletApiService/internal/auth"
+	"github.com/Jiang-hao/walletApiService/internal/rpc"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+)
+
+// serveGRPC serves the wallet service over gRPC on GRPC_PORT, in the
+// background. Without GRPC_PORT it is off. Callers authenticate with one of
+// the GRPC_AUTH_TOKENS, which must be set.
+func serveGRPC(logger *slog.Logger, walletService service.WalletService) {
+	port := getEnv("GRPC_PORT", "")
+	if port == "" {
+		logger.Info("gRPC API disabled, set GRPC_PORT to serve it")
+		return
+	}
+	tokens, err := auth.ParseTokens(getEnv("GRPC_AUTH_TOKENS", ""))
+	if err != nil {
+		fatal("Invalid GRPC_AUTH_TOKENS", err)
+	}
+	if len(tokens) == 0 {
+		logger.Error("GRPC_AUTH_TOKENS must name at least one caller to serve the gRPC API")
+		os.Exit(1)
+	}
+
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		fatal("Failed to listen for gRPC", err)
+	}
+	srv := rpc.NewServer(walletService, rpc.Config{Tokens: tokens}, logger)
+	logger.Info("gRPC server starting", "port", port, "callers", len(tokens))
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			fatal("Failed to serve gRPC", err)
+		}
+	}()
+}
@@ -1,59 +1,308 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
+	// schedules evaluate cron rules in IANA zones, don't depend on the host's zoneinfo
+	_ "time/tzdata"
 
 	"github.com/Jiang-hao/walletApiService/internal/api"
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/auth"
+	"github.com/Jiang-hao/walletApiService/internal/events"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/openapi"
 	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/schedule"
 	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/internal/stream"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/Jiang-hao/walletApiService/internal/webhook"
 	"github.com/Jiang-hao/walletApiService/package/database"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	storage := flag.String("storage", getEnv("STORAGE", "postgres"), "where wallets are kept, postgres or memory")
+	flag.Parse()
+
+	// Initialize logging
+	logger, err := logging.New(logging.Config{
+		Format: getEnv("LOG_FORMAT", logging.FormatJSON),
+		Level:  getEnv("LOG_LEVEL", "info"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Initialize tracing
+	var sampleRatio *float64
+	if raw := getEnv("TRACING_SAMPLE_RATIO", ""); raw != "" {
+		ratio, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			log.Fatalf("Invalid TRACING_SAMPLE_RATIO %q: %v", raw, err)
+		}
+		sampleRatio = &ratio
+	}
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Exporter:    getEnv("TRACING_EXPORTER", tracing.ExporterNone),
+		Endpoint:    getEnv("TRACING_OTLP_ENDPOINT", ""),
+		Insecure:    getEnv("TRACING_OTLP_INSECURE", "true") == "true",
+		ServiceName: getEnv("TRACING_SERVICE_NAME", "wallet-api"),
+		SampleRatio: sampleRatio,
+	})
+	if err != nil {
+		fatal("Failed to initialize tracing", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
+	}()
+
+	switch *storage {
+	case "postgres":
+	case "memory":
+		serveMemory(logger)
+		return
+	default:
+		logger.Error("Unknown storage", "storage", *storage)
+		os.Exit(1)
+	}
+
 	// Initialize database
-	db, err := database.NewPostgresDB(database.Config{
+	dbConfig := database.Config{
 		Host:     getEnv("DB_HOST", "localhost"),
 		Port:     getEnv("DB_PORT", "5432"),
 		User:     getEnv("DB_USER", "postgres"),
 		Password: getEnv("DB_PASSWORD", "920313"),
 		DBName:   getEnv("DB_NAME", "walletapi"),
 		SSLMode:  getEnv("DB_SSL_MODE", "disable"),
-	})
+	}
+	db, err := database.NewPostgresDB(dbConfig)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to connect to database", err)
 	}
 	defer db.Close()
 
+	if err := metrics.RegisterDBStats(db); err != nil {
+		fatal("Failed to register database metrics", err)
+	}
+
 	// Initialize repositories
 	walletRepo := repository.NewWalletRepository(db)
 	transactionRepo := repository.NewTransactionRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	outboxRepo := repository.NewOutboxRepository(db)
+	webhookRepo := repository.NewWebhookRepository(db)
+	batchRepo := repository.NewBatchRepository(db)
+	scheduleRepo := repository.NewScheduleRepository(db)
+	paymentRequestRepo := repository.NewPaymentRequestRepository(db)
+	escrowRepo := repository.NewEscrowRepository(db)
+	balanceHistoryRepo := repository.NewBalanceHistoryRepository(db)
+	statementRepo := repository.NewStatementRepository(db)
+	reconciliationRepo := repository.NewReconciliationRepository(db)
+	settlementRepo := repository.NewSettlementRepository(db)
+
+	workerCtx, stopWorkers := context.WithCancel(logging.WithContext(context.Background(), logger))
+	defer stopWorkers()
+
+	// Set up balance streaming
+	hub := stream.NewHub(stream.HubConfig{})
+	var notifier service.Notifier = hub
+	switch notifyKind := getEnv("STREAM_NOTIFY", "local"); notifyKind {
+	case "local":
+	case "postgres":
+		// every instance listens, so clients see updates made through any of them
+		notifier = stream.NewPGNotifier(db)
+		go func() {
+			if err := stream.Listen(workerCtx, dbConfig.DSN(), hub); err != nil {
+				logger.Error("Balance listener stopped", logging.Err(err))
+			}
+		}()
+	default:
+		logger.Error("Unknown STREAM_NOTIFY", "notify", notifyKind)
+		os.Exit(1)
+	}
 
 	// Initialize services
 	walletService := service.NewWalletService(
 		walletRepo,
 		transactionRepo,
 		transactionRepo.(repository.TxManager),
+		service.WithNotifier(notifier),
 	)
+	serveGRPC(logger, walletService)
+	auditService := service.NewAuditService(auditRepo)
+	webhookService := service.NewWebhookService(webhookRepo)
+	asyncThreshold, _ := strconv.Atoi(getEnv("BATCH_ASYNC_THRESHOLD", "100"))
+	batchLease, _ := time.ParseDuration(getEnv("BATCH_LEASE", "5m"))
+	batchService := service.NewBatchService(batchRepo, walletService, service.BatchConfig{
+		AsyncThreshold: asyncThreshold,
+		Lease:          batchLease,
+	})
+	go service.RunBatchWorker(workerCtx, batchService, time.Second)
+	scheduleService := service.NewScheduleService(scheduleRepo)
+	if getEnv("SCHEDULER_ENABLED", "true") == "true" {
+		go schedule.NewScheduler(scheduleRepo, walletService, schedule.SchedulerConfig{}).Run(workerCtx)
+	}
+	paymentRequestService := service.NewPaymentRequestService(paymentRequestRepo, walletService)
+	go service.RunPaymentRequestExpiry(workerCtx, paymentRequestService, time.Minute)
+	escrowService := service.NewEscrowService(escrowRepo, walletRepo, transactionRepo, transactionRepo.(repository.TxManager), notifier)
+	go service.RunEscrowDeadlines(workerCtx, escrowService, time.Minute)
+	balanceHistoryService := service.NewBalanceHistoryService(balanceHistoryRepo, walletRepo)
+	go service.RunBalanceSnapshots(workerCtx, balanceHistoryService, time.Hour)
+	statementService := service.NewStatementService(statementRepo, walletRepo)
+	settlementService := service.NewSettlementService(settlementRepo)
+	if interval, _ := time.ParseDuration(getEnv("RECONCILE_INTERVAL", "1h")); interval > 0 {
+		go service.RunReconciliation(workerCtx, service.NewReconciliationService(reconciliationRepo, 0), interval)
+	}
+
+	// Start outbox relay
+	var publishers events.Fanout
+	switch publisherKind := getEnv("OUTBOX_PUBLISHER", "none"); publisherKind {
+	case "none":
+	case "file":
+		publisher, err := events.NewFilePublisher(getEnv("OUTBOX_FILE", "events.jsonl"))
+		if err != nil {
+			fatal("Failed to open outbox publisher", err)
+		}
+		defer publisher.Close()
+		publishers = append(publishers, publisher)
+	default:
+		logger.Error("Unknown OUTBOX_PUBLISHER", "publisher", publisherKind)
+		os.Exit(1)
+	}
+	if getEnv("WEBHOOKS_ENABLED", "true") == "true" {
+		publishers = append(publishers, webhook.NewDispatcher(webhookRepo))
+		go webhook.NewDeliverer(webhookRepo, nil, webhook.DelivererConfig{}).Run(workerCtx)
+	}
+	if len(publishers) > 0 {
+		go events.NewRelay(outboxRepo, publishers, events.RelayConfig{}).Run(workerCtx)
+	} else {
+		logger.Info("Outbox relay disabled, events stay queued in outbox_events")
+	}
 
 	// Initialize handlers
 	walletHandler := api.NewWalletHandler(walletService)
+	balanceHistoryHandler := api.NewBalanceHistoryHandler(balanceHistoryService)
+	statementHandler := api.NewStatementHandler(statementService)
+	auditHandler := api.NewAuditHandler(auditService)
+	webhookHandler := api.NewWebhookHandler(webhookService)
+	batchHandler := api.NewBatchHandler(batchService)
+	scheduleHandler := api.NewScheduleHandler(scheduleService)
+	paymentRequestHandler := api.NewPaymentRequestHandler(paymentRequestService)
+	escrowHandler := api.NewEscrowHandler(escrowService)
+	settlementHandler := api.NewSettlementHandler(settlementService)
+	heartbeat, _ := time.ParseDuration(getEnv("STREAM_HEARTBEAT", "15s"))
+	streamHandler := api.NewStreamHandler(hub, heartbeat)
+
+	spec, err := openapi.New()
+	if err != nil {
+		fatal("Failed to load the OpenAPI document", err)
+	}
 
 	// Set up router
-	router := gin.Default()
+	router := gin.New()
+	trustProxies(logger, router)
+	router.Use(
+		gin.Recovery(),
+		tracing.Middleware(),
+		logging.RequestID(logger),
+		logging.AccessLog(),
+		authenticate(logger),
+		audit.Middleware(),
+		metrics.Middleware(),
+	)
 
 	// API routes
-	apiGroup := router.Group("/api/v1")
+	apiGroup := router.Group("/api/v1", rateLimit(logger))
 	{
-		users := apiGroup.Group("/wallet")
+		users := apiGroup.Group("/wallet", spec.Middleware(openapi.Config{}))
 		{
 			users.POST("/deposit", walletHandler.Deposit)
 			users.POST("/withdraw", walletHandler.Withdraw)
 			users.POST("/transfer", walletHandler.Transfer)
 			users.GET("/balance", walletHandler.GetBalance)
+			users.GET("/balance/history", balanceHistoryHandler.GetBalanceAt)
+			users.GET("/balance/daily", balanceHistoryHandler.GetDailyBalances)
 			users.GET("/transactions", walletHandler.GetTransactionHistory)
+			users.GET("/statements", statementHandler.GetStatement)
+			users.GET("/stream", streamHandler.Balances)
+		}
+
+		auditGroup := apiGroup.Group("/audit", auth.Require())
+		{
+			auditGroup.GET("/events", auditHandler.ListEvents)
+			auditGroup.GET("/verify", auditHandler.VerifyChain)
+		}
+
+		webhooks := apiGroup.Group("/webhooks")
+		{
+			webhooks.POST("", webhookHandler.CreateSubscription)
+			webhooks.GET("", webhookHandler.ListSubscriptions)
+			webhooks.DELETE("/:id", webhookHandler.DeleteSubscription)
+			webhooks.GET("/:id/deliveries", webhookHandler.ListDeliveries)
+		}
+
+		deliveries := apiGroup.Group("/webhook-deliveries")
+		{
+			deliveries.GET("/:id/attempts", webhookHandler.ListAttempts)
+			deliveries.POST("/:id/redeliver", webhookHandler.Redeliver)
+		}
+
+		// a batch moves money of any user, so only operators submit them
+		batches := apiGroup.Group("/batches", auth.Require())
+		{
+			batches.POST("", batchHandler.Submit)
+			batches.GET("/:id", batchHandler.GetBatch)
+		}
+
+		schedules := apiGroup.Group("/schedules")
+		{
+			schedules.POST("", scheduleHandler.CreateSchedule)
+			schedules.GET("", scheduleHandler.ListSchedules)
+			schedules.GET("/:id", scheduleHandler.GetSchedule)
+			schedules.GET("/:id/runs", scheduleHandler.ListRuns)
+			schedules.POST("/:id/pause", scheduleHandler.PauseSchedule)
+			schedules.POST("/:id/resume", scheduleHandler.ResumeSchedule)
+			schedules.POST("/:id/cancel", scheduleHandler.CancelSchedule)
+		}
+
+		paymentRequests := apiGroup.Group("/payment-requests")
+		{
+			paymentRequests.POST("", paymentRequestHandler.CreatePaymentRequest)
+			paymentRequests.GET("/incoming", paymentRequestHandler.ListIncoming)
+			paymentRequests.GET("/outgoing", paymentRequestHandler.ListOutgoing)
+			paymentRequests.GET("/:id", paymentRequestHandler.GetPaymentRequest)
+			paymentRequests.POST("/:id/accept", paymentRequestHandler.Accept)
+			paymentRequests.POST("/:id/decline", paymentRequestHandler.Decline)
+			paymentRequests.POST("/:id/cancel", paymentRequestHandler.Cancel)
+		}
+
+		escrows := apiGroup.Group("/escrows")
+		{
+			escrows.POST("", escrowHandler.CreateEscrow)
+			escrows.GET("", escrowHandler.ListEscrows)
+			escrows.GET("/:id", escrowHandler.GetEscrow)
+			escrows.POST("/:id/release", escrowHandler.Release)
+			escrows.POST("/:id/refund", escrowHandler.Refund)
+			escrows.POST("/:id/split", escrowHandler.Split)
+		}
+
+		settlements := apiGroup.Group("/settlements", auth.Require())
+		{
+			settlements.POST("", settlementHandler.Import)
+			settlements.GET("/:id", settlementHandler.GetSettlement)
+			settlements.GET("/:id/lines", settlementHandler.ListLines)
 		}
 	}
 
@@ -62,14 +311,36 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	// Metrics
+	router.GET("/metrics", metrics.Handler())
+
+	// API description
+	router.GET("/openapi.json", spec.Handler())
+	router.GET("/docs", openapi.Docs("/openapi.json"))
+	checkDocumented(logger, spec, router)
+
 	// Start server
 	port := getEnv("PORT", "8080")
-	log.Printf("Server starting on port %s", port)
+	logger.Info("Server starting", "port", port)
 	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		fatal("Failed to start server", err)
 	}
 }
 
+// checkDocumented refuses to serve wallet routes the OpenAPI document doesn't
+// describe, the middleware would let their requests through unchecked
+func checkDocumented(logger *slog.Logger, spec *openapi.Spec, router *gin.Engine) {
+	if missing := spec.Undocumented(router.Routes(), "/api/v1/wallet"); len(missing) > 0 {
+		logger.Error("Wallet routes missing from the OpenAPI document", "routes", missing)
+		os.Exit(1)
+	}
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/api"
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/openapi"
+	"github.com/Jiang-hao/walletApiService/internal/repository/memory"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/internal/stream"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/gin-gonic/gin"
+)
+
+// serveMemory runs the wallet API against an in-memory store, for local
+// development without Postgres. Everything is lost on exit, and only the
+// wallet routes are served, the other features need the database.
+func serveMemory(logger *slog.Logger) {
+	store := memory.NewStore()
+	hub := stream.NewHub(stream.HubConfig{})
+	walletService := service.NewWalletService(store, store, store, service.WithNotifier(hub))
+	serveGRPC(logger, walletService)
+
+	walletHandler := api.NewWalletHandler(walletService)
+	heartbeat, _ := time.ParseDuration(getEnv("STREAM_HEARTBEAT", "15s"))
+	streamHandler := api.NewStreamHandler(hub, heartbeat)
+
+	spec, err := openapi.New()
+	if err != nil {
+		fatal("Failed to load the OpenAPI document", err)
+	}
+
+	router := gin.New()
+	trustProxies(logger, router)
+	router.Use(
+		gin.Recovery(),
+		tracing.Middleware(),
+		logging.RequestID(logger),
+		logging.AccessLog(),
+		authenticate(logger),
+		audit.Middleware(),
+		metrics.Middleware(),
+	)
+
+	users := router.Group("/api/v1/wallet", rateLimit(logger), spec.Middleware(openapi.Config{}))
+	{
+		users.POST("/deposit", walletHandler.Deposit)
+		users.POST("/withdraw", walletHandler.Withdraw)
+		users.POST("/transfer", walletHandler.Transfer)
+		users.GET("/balance", walletHandler.GetBalance)
+		users.GET("/transactions", walletHandler.GetTransactionHistory)
+		users.GET("/stream", streamHandler.Balances)
+	}
+
+	router.GET("/api/v1/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "storage": "memory"})
+	})
+	router.GET("/metrics", metrics.Handler())
+	router.GET("/openapi.json", spec.Handler())
+	router.GET("/docs", openapi.Docs("/openapi.json"))
+	checkDocumented(logger, spec, router)
+
+	port := getEnv("PORT", "8080")
+	logger.Warn("Server starting with in-memory storage, data is not persisted and only wallet routes are served", "port", port)
+	if err := router.Run(":" + port); err != nil {
+		fatal("Failed to start server", err)
+	}
+}
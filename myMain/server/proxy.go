@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trustProxies makes the client IP, which rate limits, logs and audit events
+// use, come from X-Forwarded-For only on requests from the TRUSTED_PROXIES,
+// a list of addresses and CIDRs. None are trusted by default, so a client
+// can't pick its IP with the header.
+func trustProxies(logger *slog.Logger, router *gin.Engine) {
+	var proxies []string
+	for _, proxy := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		fatal("Invalid TRUSTED_PROXIES", err)
+	}
+	if len(proxies) > 0 {
+		logger.Info("Trusting X-Forwarded-For from proxies", "proxies", proxies)
+	}
+}
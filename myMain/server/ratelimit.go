@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/Jiang-hao/walletApiService/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimit limits the API's requests per user and per client IP, with the
+// RATE_LIMIT_* limits and the buckets in memory. RATE_LIMIT_ENABLED=false
+// turns it off.
+func rateLimit(logger *slog.Logger) gin.HandlerFunc {
+	if getEnv("RATE_LIMIT_ENABLED", "true") != "true" {
+		logger.Info("Rate limiting disabled")
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	var cfg ratelimit.Config
+	for env, limit := range map[string]*ratelimit.Limit{
+		"RATE_LIMIT_READ":     &cfg.Read.User,
+		"RATE_LIMIT_WRITE":    &cfg.Write.User,
+		"RATE_LIMIT_IP_READ":  &cfg.Read.IP,
+		"RATE_LIMIT_IP_WRITE": &cfg.Write.IP,
+	} {
+		value := getEnv(env, "")
+		if value == "" {
+			continue
+		}
+		parsed, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			fatal("Invalid "+env, err)
+		}
+		*limit = parsed
+	}
+	return ratelimit.Middleware(ratelimit.NewMemoryStore(), cfg)
+}
@@ -0,0 +1,576 @@
+// Command walletctl is the operators' tool for looking after wallets. It goes
+// through the same services as the API, so the business rules apply, and the
+// changes it makes are audited as the database role it connects as, which
+// Postgres authenticated.
+//
+//	walletctl wallets     -user ID
+//	walletctl history     -user ID [-currency USD] [-page 1] [-page-size 50]
+//	walletctl freeze      -user ID -currency USD -reason TEXT
+//	walletctl unfreeze    -user ID -currency USD -reason TEXT
+//	walletctl adjust      -user ID -currency USD -amount -12.50 -reason-code duplicate -reason TEXT
+//	walletctl adjustments [-status pending|applied|rejected] [-page 1] [-page-size 50]
+//	walletctl approve     -id ADJUSTMENT
+//	walletctl reject      -id ADJUSTMENT
+//	walletctl reconcile   [-format text|json] [-limit 100]
+//	walletctl migrate     [-status] [-baseline VERSION]
+//	walletctl statement   -user ID -currency USD -from 2024-01-01 [-to 2024-02-01] [-format csv|ndjson|pdf] [-o FILE]
+//
+// It exits 1 when reconcile finds discrepancies and 2 when it fails.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/migrate"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/migrations"
+	"github.com/Jiang-hao/walletApiService/package/database"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, db *sqlx.DB, args []string) error
+}
+
+var commands = map[string]command{
+	"wallets":     {"list a user's wallets", listWallets},
+	"history":     {"print a user's transaction history, newest first", history},
+	"freeze":      {"stop money leaving a wallet", freeze(true)},
+	"unfreeze":    {"let money leave a frozen wallet again", freeze(false)},
+	"adjust":      {"credit or debit a wallet, a negative amount debits", adjust},
+	"adjustments": {"list adjustments, the pending ones by default", listAdjustments},
+	"approve":     {"apply a pending adjustment, as a second operator", review(true)},
+	"reject":      {"reject a pending adjustment", review(false)},
+	"reconcile":   {"check every wallet against the transaction log", reconcile},
+	"migrate":     {"apply the database migrations not applied yet", runMigrations},
+	"statement":   {"export a user's statement", exportStatement},
+}
+
+// errDiscrepancies makes reconcile exit 1 after printing its report
+var errDiscrepancies = errors.New("discrepancies found")
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+
+	// logs go to stderr, the output to stdout
+	logger, err := logging.New(logging.Config{
+		Format: getEnv("LOG_FORMAT", logging.FormatText),
+		Level:  getEnv("LOG_LEVEL", "error"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	db, err := database.NewPostgresDB(database.Config{
+		Host:     getEnv("DB_HOST", "localhost"),
+		Port:     getEnv("DB_PORT", "5432"),
+		User:     getEnv("DB_USER", "postgres"),
+		Password: getEnv("DB_PASSWORD", "920313"),
+		DBName:   getEnv("DB_NAME", "walletapi"),
+		SSLMode:  getEnv("DB_SSL_MODE", "disable"),
+	})
+	if err != nil {
+		fail("Failed to connect to database", err)
+	}
+	defer db.Close()
+
+	ctx := logging.WithContext(context.Background(), logger)
+	err = cmd.run(ctx, db, os.Args[2:])
+	if err == errDiscrepancies {
+		db.Close()
+		os.Exit(1)
+	}
+	if err != nil {
+		db.Close()
+		fail(os.Args[1]+" failed", err)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: walletctl <command> [flags], where the commands are")
+	tw := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(tw, "  %s\t%s\n", name, commands[name].usage)
+	}
+	tw.Flush()
+	fmt.Fprintln(os.Stderr, "Run walletctl <command> -h for a command's flags.")
+	os.Exit(2)
+}
+
+// flags is a command's flag set, with the flags most commands share
+type flags struct {
+	*flag.FlagSet
+	user     string
+	currency string
+	reason   string
+}
+
+func newFlags(name string) *flags {
+	return &flags{FlagSet: flag.NewFlagSet("walletctl "+name, flag.ExitOnError)}
+}
+
+func (f *flags) withUser() *flags {
+	f.StringVar(&f.user, "user", "", "user id")
+	return f
+}
+
+func (f *flags) withCurrency(value string) *flags {
+	f.StringVar(&f.currency, "currency", value, "wallet currency")
+	return f
+}
+
+// withReason adds the flag every change needs, why it is made
+func (f *flags) withReason() *flags {
+	f.StringVar(&f.reason, "reason", "", "why the change is made")
+	return f
+}
+
+func (f *flags) userID() (uuid.UUID, error) {
+	if f.user == "" {
+		return uuid.Nil, fmt.Errorf("-user is required")
+	}
+	id, err := uuid.Parse(f.user)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("-user: %w", err)
+	}
+	return id, nil
+}
+
+// operator is the database role walletctl connected as. Postgres checked its
+// credentials, so unlike a name given on the command line it can't be made up,
+// and two operators can't share it.
+func operator(ctx context.Context, db *sqlx.DB) (string, error) {
+	var role string
+	if err := db.GetContext(ctx, &role, `SELECT session_user`); err != nil {
+		return "", fmt.Errorf("looking up the database role: %w", err)
+	}
+	return role, nil
+}
+
+// operatorContext records the operator as the actor of the audited changes
+func (f *flags) operatorContext(ctx context.Context, db *sqlx.DB) (context.Context, string, error) {
+	if strings.TrimSpace(f.reason) == "" {
+		return nil, "", fmt.Errorf("-reason is required")
+	}
+	name, err := operator(ctx, db)
+	if err != nil {
+		return nil, "", err
+	}
+	return audit.WithMetadata(ctx, audit.Metadata{Actor: audit.OperatorActor(name)}), name, nil
+}
+
+func adminService(db *sqlx.DB) service.WalletAdminService {
+	transactionRepo := repository.NewTransactionRepository(db)
+	return service.NewWalletAdminService(
+		repository.NewAdjustmentRepository(db),
+		repository.NewWalletRepository(db),
+		transactionRepo,
+		transactionRepo.(repository.TxManager),
+	)
+}
+
+func walletService(db *sqlx.DB) (service.WalletService, error) {
+	var opts []service.Option
+	if value := getEnv("ADJUSTMENT_APPROVAL_THRESHOLD", ""); value != "" {
+		threshold, err := decimal.NewFromString(value)
+		if err != nil {
+			return nil, fmt.Errorf("ADJUSTMENT_APPROVAL_THRESHOLD: %w", err)
+		}
+		opts = append(opts, service.WithAdjustmentApprovalThreshold(threshold))
+	}
+	if value := getEnv("ADJUSTMENT_APPROVAL_THRESHOLDS", ""); value != "" {
+		thresholds, err := parseThresholds(value)
+		if err != nil {
+			return nil, fmt.Errorf("ADJUSTMENT_APPROVAL_THRESHOLDS: %w", err)
+		}
+		opts = append(opts, service.WithAdjustmentApprovalThresholds(thresholds))
+	}
+	transactionRepo := repository.NewTransactionRepository(db)
+	return service.NewWalletService(repository.NewWalletRepository(db), transactionRepo, transactionRepo.(repository.TxManager), opts...), nil
+}
+
+// parseThresholds reads thresholds per currency, e.g. "USD=1000,JPY=150000"
+func parseThresholds(s string) (map[string]decimal.Decimal, error) {
+	thresholds := make(map[string]decimal.Decimal)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		currency, value, ok := strings.Cut(pair, "=")
+		if !ok || currency == "" {
+			return nil, fmt.Errorf("entries must be CURRENCY=amount, got %q", pair)
+		}
+		threshold, err := decimal.NewFromString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", currency, err)
+		}
+		thresholds[strings.ToUpper(currency)] = threshold
+	}
+	return thresholds, nil
+}
+
+func listWallets(ctx context.Context, db *sqlx.DB, args []string) error {
+	f := newFlags("wallets").withUser()
+	f.Parse(args)
+	userID, err := f.userID()
+	if err != nil {
+		return err
+	}
+
+	wallets, err := adminService(db).ListWallets(ctx, userID)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WALLET\tCURRENCY\tBALANCE\tVERSION\tESCROW\tFROZEN")
+	for _, w := range wallets {
+		escrow, frozen := "-", "-"
+		if w.EscrowID != nil {
+			escrow = w.EscrowID.String()
+		}
+		if w.Frozen() {
+			frozen = w.FrozenAt.UTC().Format(time.RFC3339) + " " + w.FrozenReason
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", w.ID, w.Currency, w.Balance, w.Version, escrow, frozen)
+	}
+	return tw.Flush()
+}
+
+func history(ctx context.Context, db *sqlx.DB, args []string) error {
+	f := newFlags("history").withUser().withCurrency("")
+	page := f.Int("page", 1, "page, starting at 1")
+	pageSize := f.Int("page-size", 50, "transactions per page")
+	f.Parse(args)
+	userID, err := f.userID()
+	if err != nil {
+		return err
+	}
+
+	svc, err := walletService(db)
+	if err != nil {
+		return err
+	}
+	transactions, err := svc.GetTransactionHistory(ctx, userID, f.currency, *page, *pageSize)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TRANSACTION\tCREATED\tTYPE\tCURRENCY\tAMOUNT\tBALANCE AFTER\tREFERENCE")
+	for _, tx := range transactions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			tx.ID, tx.CreatedAt, tx.Type, tx.Currency, tx.Amount, tx.BalanceAfter, tx.Reference)
+	}
+	return tw.Flush()
+}
+
+func freeze(frozen bool) func(context.Context, *sqlx.DB, []string) error {
+	name := "unfreeze"
+	if frozen {
+		name = "freeze"
+	}
+	return func(ctx context.Context, db *sqlx.DB, args []string) error {
+		f := newFlags(name).withUser().withCurrency("USD").withReason()
+		f.Parse(args)
+		userID, err := f.userID()
+		if err != nil {
+			return err
+		}
+		ctx, _, err = f.operatorContext(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		svc := adminService(db)
+		change := svc.Unfreeze
+		if frozen {
+			change = svc.Freeze
+		}
+		wallet, err := change(ctx, userID, f.currency, f.reason)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wallet %s %sd, version %d\n", wallet.ID, name, wallet.Version)
+		return nil
+	}
+}
+
+// adjust asks for an adjustment, which waits for another operator's approval
+// once the wallet's adjustments of the day go above the approval threshold
+func adjust(ctx context.Context, db *sqlx.DB, args []string) error {
+	f := newFlags("adjust").withUser().withCurrency("USD").withReason()
+	amount := f.String("amount", "", "signed amount, negative to debit")
+	reasonCode := f.String("reason-code", "", "one of "+strings.Join(model.AdjustmentReasonCodes, ", "))
+	f.Parse(args)
+	userID, err := f.userID()
+	if err != nil {
+		return err
+	}
+	value, err := decimal.NewFromString(*amount)
+	if err != nil {
+		return fmt.Errorf("-amount: %w", err)
+	}
+	if *reasonCode == "" {
+		return fmt.Errorf("-reason-code is required")
+	}
+	ctx, name, err := f.operatorContext(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	svc, err := walletService(db)
+	if err != nil {
+		return err
+	}
+	adjustment, err := svc.Adjust(ctx, model.AdjustmentRequest{
+		UserID:     userID,
+		Currency:   f.currency,
+		Amount:     value,
+		ReasonCode: *reasonCode,
+		Note:       f.reason,
+		Operator:   name,
+	})
+	if err != nil {
+		return err
+	}
+	if adjustment.Status == model.AdjustmentPending {
+		fmt.Printf("Adjustment %s is pending, another operator approves it with\n  walletctl approve -id %s\n", adjustment.ID, adjustment.ID)
+		return nil
+	}
+	fmt.Printf("Adjustment %s applied, transaction %s\n", adjustment.ID, adjustment.TransactionID)
+	return nil
+}
+
+func listAdjustments(ctx context.Context, db *sqlx.DB, args []string) error {
+	f := newFlags("adjustments")
+	status := f.String("status", model.AdjustmentPending, "pending, applied or rejected, every status when empty")
+	page := f.Int("page", 1, "page, starting at 1")
+	pageSize := f.Int("page-size", 50, "adjustments per page")
+	f.Parse(args)
+
+	adjustments, err := adminService(db).ListAdjustments(ctx, *status, *page, *pageSize)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ADJUSTMENT\tCREATED\tSTATUS\tUSER\tCURRENCY\tAMOUNT\tREASON\tREQUESTED BY\tREVIEWED BY\tNOTE")
+	for _, a := range adjustments {
+		reviewedBy := "-"
+		if a.ReviewedBy != nil {
+			reviewedBy = *a.ReviewedBy
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			a.ID, a.CreatedAt.UTC().Format(time.RFC3339), a.Status, a.UserID, a.Currency, a.Amount,
+			a.ReasonCode, a.RequestedBy, reviewedBy, a.Note)
+	}
+	return tw.Flush()
+}
+
+func review(approve bool) func(context.Context, *sqlx.DB, []string) error {
+	name := "reject"
+	if approve {
+		name = "approve"
+	}
+	return func(ctx context.Context, db *sqlx.DB, args []string) error {
+		f := newFlags(name)
+		id := f.String("id", "", "adjustment id")
+		f.Parse(args)
+		adjustmentID, err := uuid.Parse(*id)
+		if err != nil {
+			return fmt.Errorf("-id: %w", err)
+		}
+		// the reviewer connects as another role than the operator who asked
+		reviewer, err := operator(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		svc, err := walletService(db)
+		if err != nil {
+			return err
+		}
+		resolve := svc.RejectAdjustment
+		if approve {
+			resolve = svc.ApproveAdjustment
+		}
+		adjustment, err := resolve(ctx, adjustmentID, reviewer)
+		if err != nil {
+			return err
+		}
+		if adjustment.TransactionID != nil {
+			fmt.Printf("Adjustment %s applied, transaction %s\n", adjustment.ID, adjustment.TransactionID)
+			return nil
+		}
+		fmt.Printf("Adjustment %s %s\n", adjustment.ID, adjustment.Status)
+		return nil
+	}
+}
+
+func reconcile(ctx context.Context, db *sqlx.DB, args []string) error {
+	f := newFlags("reconcile")
+	format := f.String("format", "text", "report format, text or json")
+	limit := f.Int("limit", 100, "discrepancies listed per kind")
+	timeout := f.Duration("timeout", 10*time.Minute, "give up after this long")
+	f.Parse(args)
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	svc := service.NewReconciliationService(repository.NewReconciliationRepository(db), *limit)
+	report, err := svc.Reconcile(ctx)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	if report.Total() > 0 {
+		return errDiscrepancies
+	}
+	return nil
+}
+
+func runMigrations(ctx context.Context, db *sqlx.DB, args []string) error {
+	f := newFlags("migrate")
+	status := f.Bool("status", false, "list the migrations and when each was applied, without applying any")
+	baseline := f.String("baseline", "", "record the migrations up to this version as applied without running them, for a database migrated by hand")
+	f.Parse(args)
+
+	if *status {
+		all, err := migrate.Status(ctx, db, migrations.FS)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tAPPLIED")
+		for _, m := range all {
+			applied := "pending"
+			if m.Applied() {
+				applied = m.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", m.Version, applied)
+		}
+		return tw.Flush()
+	}
+
+	var versions []string
+	var err error
+	verb := "Applied"
+	if *baseline != "" {
+		verb = "Recorded"
+		versions, err = migrate.Baseline(ctx, db, migrations.FS, *baseline)
+	} else {
+		versions, err = migrate.Up(ctx, db, migrations.FS)
+	}
+	for _, version := range versions {
+		fmt.Printf("%s %s\n", verb, version)
+	}
+	if err == nil && len(versions) == 0 {
+		fmt.Println("Up to date")
+	}
+	return err
+}
+
+func exportStatement(ctx context.Context, db *sqlx.DB, args []string) error {
+	f := newFlags("statement").withUser().withCurrency("USD")
+	from := f.String("from", "", "first day or RFC 3339 time, inclusive")
+	to := f.String("to", "", "last day or RFC 3339 time, exclusive, now by default")
+	format := f.String("format", "csv", "csv, ndjson or pdf")
+	output := f.String("o", "", "file to write, stdout by default")
+	f.Parse(args)
+	userID, err := f.userID()
+	if err != nil {
+		return err
+	}
+	start, err := parseTime("-from", *from)
+	if err != nil {
+		return err
+	}
+	end := time.Now()
+	if *to != "" {
+		if end, err = parseTime("-to", *to); err != nil {
+			return err
+		}
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	svc := service.NewStatementService(repository.NewStatementRepository(db), repository.NewWalletRepository(db))
+	summary, err := svc.WriteStatement(ctx, userID, f.currency, start, end, *format, out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d transactions\n", summary.Count)
+	return out.Close()
+}
+
+func parseTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("%s is required", name)
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: want YYYY-MM-DD or RFC 3339, got %q", name, value)
+	}
+	return t, nil
+}
+
+func fail(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(2)
+}
+
+func getEnv(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
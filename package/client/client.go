@@ -0,0 +1,218 @@
+// Package client is a Go client for the /api/v1/wallet routes. Amounts are
+// decimals end to end, failed calls are retried with backoff where that can't
+// apply a write twice, and errors carry the same types as the server's.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKeyHeader carries the key that ties the attempts of one write
+// together
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type RetryPolicy struct {
+	// MaxAttempts bounds the attempts of one call, 1 turns retries off
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+type Config struct {
+	Retry RetryPolicy
+	// Header is sent with every request, e.g. for credentials
+	Header http.Header
+}
+
+func (c Config) withDefaults() Config {
+	if c.Retry.MaxAttempts <= 0 {
+		c.Retry.MaxAttempts = 3
+	}
+	if c.Retry.BaseBackoff <= 0 {
+		c.Retry.BaseBackoff = 100 * time.Millisecond
+	}
+	if c.Retry.MaxBackoff <= 0 {
+		c.Retry.MaxBackoff = 2 * time.Second
+	}
+	return c
+}
+
+type Client struct {
+	baseURL string
+	http    *http.Client
+	cfg     Config
+}
+
+// New returns a client for the server at baseURL, e.g. "http://localhost:8080".
+// A nil httpClient uses http.DefaultClient.
+func New(baseURL string, httpClient *http.Client, cfg Config) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    httpClient,
+		cfg:     cfg.withDefaults(),
+	}
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey makes writes under ctx send key instead of a generated
+// one, so a caller retrying a call itself reuses the key it sent first
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// do sends the request and decodes a JSON response into out, if given
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	resp, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: decoding response: %w", method, path, err)
+	}
+	return nil
+}
+
+// send makes up to MaxAttempts attempts and returns the first successful
+// response, whose body the caller closes. Every attempt of a write carries the
+// same Idempotency-Key, but the server doesn't deduplicate on it, so a write is
+// only sent again when the last attempt never reached the handlers.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("%s %s: encoding request: %w", method, path, err)
+		}
+	}
+	var key string
+	if method != http.MethodGet {
+		key, _ = ctx.Value(idempotencyKey{}).(string)
+		if key == "" {
+			key = uuid.NewString()
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.attempt(ctx, method, endpoint, payload, key)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		var retryAfter time.Duration
+		if err == nil {
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			err = responseError(method, path, resp)
+		} else {
+			err = fmt.Errorf("%s %s: %w", method, path, err)
+		}
+		if attempt >= c.cfg.Retry.MaxAttempts || !retryable(ctx, method, err) {
+			return nil, err
+		}
+
+		wait := c.backoff(attempt)
+		if retryAfter > wait {
+			wait = min(retryAfter, c.cfg.Retry.MaxBackoff)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, endpoint string, payload []byte, key string) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range c.cfg.Header {
+		req.Header[name] = values
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	return c.http.Do(req)
+}
+
+// retryable tells whether another attempt may succeed, unless ctx is done.
+// Reads are retried on conflicts, rate limits, server errors and failed
+// connections. Writes only when the last attempt wasn't applied: on rate limits
+// and refused connections. A 409 or 5xx may come after the write committed, and
+// a dropped connection after the server got the request.
+func retryable(ctx context.Context, method string, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var e *Error
+	if !stderrors.As(err, &e) {
+		return method == http.MethodGet || refused(err)
+	}
+	if method != http.MethodGet {
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return e.StatusCode == http.StatusConflict || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// refused tells whether err is a refused connection, failing before any of the
+// request was sent
+func refused(err error) bool {
+	var opErr *net.OpError
+	return stderrors.As(err, &opErr) && opErr.Op == "dial" && stderrors.Is(err, syscall.ECONNREFUSED)
+}
+
+// backoff doubles per failed attempt up to MaxBackoff, with jitter so that
+// clients failing together don't retry together
+func (c *Client) backoff(attempt int) time.Duration {
+	b := c.cfg.Retry.BaseBackoff
+	for i := 1; i < attempt && b < c.cfg.Retry.MaxBackoff; i++ {
+		b *= 2
+	}
+	b = min(b, c.cfg.Retry.MaxBackoff)
+	return b/2 + rand.N(b/2+1)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
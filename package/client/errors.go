@@ -0,0 +1,111 @@
+package client
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+)
+
+// ErrorType is the server's error type, e.g. INSUFFICIENT_FUND
+type ErrorType = errors.ErrorType
+
+const (
+	InvalidRequest   = errors.InvalidRequest
+	NotFound         = errors.NotFound
+	InsufficientFund = errors.InsufficientFund
+	Conflict         = errors.Conflict
+	WalletFrozen     = errors.WalletFrozen
+	Forbidden        = errors.Forbidden
+	Internal         = errors.Internal
+	// RateLimited is answered with 429 by rate limiting in front of the routes
+	RateLimited ErrorType = "RATE_LIMITED"
+)
+
+// Error is a response the server answered with an error status
+type Error struct {
+	Type       ErrorType
+	StatusCode int
+	// Message is the server's error message
+	Message string
+	Method  string
+	Path    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// TypeOf returns the type of the server error in err's chain, Internal for
+// any other error and "" for nil
+func TypeOf(err error) ErrorType {
+	if err == nil {
+		return ""
+	}
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e.Type
+	}
+	return Internal
+}
+
+func IsNotFound(err error) bool {
+	return TypeOf(err) == NotFound
+}
+
+// responseError reads the error body of resp and closes it
+func responseError(method, path string, resp *http.Response) *Error {
+	defer resp.Body.Close()
+	var failure struct {
+		Error string `json:"error"`
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(raw, &failure) != nil || failure.Error == "" {
+		failure.Error = strings.TrimSpace(string(raw))
+	}
+	return &Error{
+		Type:       responseErrorType(resp.StatusCode, failure.Error),
+		StatusCode: resp.StatusCode,
+		Message:    failure.Error,
+		Method:     method,
+		Path:       path,
+	}
+}
+
+// responseErrorType recovers the error type from the message the server
+// answered with, the text of an error chain such as
+// "INTERNAL_ERROR [service.Transfer]: ... -> CONFLICT [...]: ...". Like
+// errors.TypeOf it skips internal wrappers for the business error inside.
+// Messages without a chain fall back on the status.
+func responseErrorType(status int, message string) ErrorType {
+	for _, part := range strings.Split(message, " -> ") {
+		name, _, found := strings.Cut(part, " [")
+		if !found {
+			continue
+		}
+		switch t := ErrorType(name); t {
+		case InvalidRequest, NotFound, InsufficientFund, Conflict, WalletFrozen, Forbidden:
+			return t
+		}
+	}
+	switch status {
+	case http.StatusBadRequest:
+		return InvalidRequest
+	case http.StatusForbidden:
+		return WalletFrozen
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusUnprocessableEntity:
+		return InsufficientFund
+	case http.StatusConflict:
+		return Conflict
+	case http.StatusTooManyRequests:
+		return RateLimited
+	default:
+		return Internal
+	}
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Event types of the balance stream
+const (
+	EventBalance = "balance"
+	// EventReset means updates since the last event id were missed and
+	// balances should be refetched
+	EventReset = "reset"
+)
+
+type Event struct {
+	// ID is opaque, it is only good for resuming with StreamQuery.LastEventID
+	ID   string
+	Type string
+	// Update is set for balance events
+	Update *BalanceUpdate
+}
+
+// BalanceStream reads the Server-Sent Events of the balance stream
+type BalanceStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	lastID  string
+}
+
+type StreamQuery struct {
+	// Currency picks one currency's updates, every currency's when empty
+	Currency string
+	// LastEventID resumes after that event, "" starts with new updates
+	LastEventID string
+}
+
+// Stream subscribes to the user's balance updates until ctx is done or
+// Close is called
+func (c *Client) Stream(ctx context.Context, userID uuid.UUID, q StreamQuery) (*BalanceStream, error) {
+	query := userQuery(userID, q.Currency)
+	if q.LastEventID != "" {
+		query.Set("last_event_id", q.LastEventID)
+	}
+	resp, err := c.send(ctx, http.MethodGet, walletPath+"stream", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &BalanceStream{body: resp.Body, scanner: bufio.NewScanner(resp.Body), lastID: q.LastEventID}, nil
+}
+
+// Next blocks for the next event. It returns io.EOF when the server ends
+// the stream, after which the caller resumes with Stream and LastEventID.
+func (s *BalanceStream) Next() (Event, error) {
+	var event Event
+	var data strings.Builder
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			// a blank line ends an event, heartbeats have neither type nor data
+			if event.Type == "" {
+				continue
+			}
+			s.lastID = event.ID
+			if event.Type == EventBalance {
+				event.Update = new(BalanceUpdate)
+				if err := json.Unmarshal([]byte(data.String()), event.Update); err != nil {
+					return Event{}, fmt.Errorf("decoding event %s: %w", event.ID, err)
+				}
+			}
+			return event, nil
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			event.ID = value
+		case "event":
+			event.Type = value
+		case "data":
+			data.WriteString(value)
+		}
+	}
+	if err := s.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	return Event{}, io.EOF
+}
+
+// LastEventID is the id of the last event returned, to resume from
+func (s *BalanceStream) LastEventID() string {
+	return s.lastID
+}
+
+func (s *BalanceStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/google/uuid"
+)
+
+// The requests and responses are the server's own models, amounts are
+// decimal.Decimal and travel as decimal strings
+type (
+	DepositRequest    = model.DepositRequest
+	WithdrawalRequest = model.WithdrawalRequest
+	TransferRequest   = model.TransferRequest
+	Wallet            = model.WalletResponse
+	Balance           = model.BalanceResponse
+	HistoricalBalance = model.HistoricalBalanceResponse
+	DailyBalances     = model.DailyBalancesResponse
+	Transaction       = model.TransactionResponse
+	BalanceUpdate     = model.BalanceUpdate
+)
+
+// MaxPageSize is the largest page the server answers
+const MaxPageSize = 100
+
+const walletPath = "/api/v1/wallet/"
+
+func userQuery(userID uuid.UUID, currency string) url.Values {
+	query := url.Values{"user_id": {userID.String()}}
+	if currency != "" {
+		query.Set("currency", currency)
+	}
+	return query
+}
+
+func (c *Client) Deposit(ctx context.Context, userID uuid.UUID, req DepositRequest) (*Wallet, error) {
+	var wallet Wallet
+	if err := c.do(ctx, http.MethodPost, walletPath+"deposit", userQuery(userID, ""), req, &wallet); err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+func (c *Client) Withdraw(ctx context.Context, userID uuid.UUID, req WithdrawalRequest) (*Wallet, error) {
+	var wallet Wallet
+	if err := c.do(ctx, http.MethodPost, walletPath+"withdraw", userQuery(userID, ""), req, &wallet); err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// Transfer moves money from fromUserID to req.ToUserId and returns the
+// sender's wallet
+func (c *Client) Transfer(ctx context.Context, fromUserID uuid.UUID, req TransferRequest) (*Wallet, error) {
+	var wallet Wallet
+	if err := c.do(ctx, http.MethodPost, walletPath+"transfer", userQuery(fromUserID, ""), req, &wallet); err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// Balance returns the current balance, currency defaults to USD
+func (c *Client) Balance(ctx context.Context, userID uuid.UUID, currency string) (*Balance, error) {
+	var balance Balance
+	if err := c.do(ctx, http.MethodGet, walletPath+"balance", userQuery(userID, currency), nil, &balance); err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// BalanceAt returns the balance after the last transaction up to at
+func (c *Client) BalanceAt(ctx context.Context, userID uuid.UUID, currency string, at time.Time) (*HistoricalBalance, error) {
+	query := userQuery(userID, currency)
+	query.Set("at", at.Format(time.RFC3339Nano))
+	var balance HistoricalBalance
+	if err := c.do(ctx, http.MethodGet, walletPath+"balance/history", query, nil, &balance); err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// DailyBalances returns the end-of-day balances from one day to another. Zero
+// times leave the server's defaults, the 30 days up to today.
+func (c *Client) DailyBalances(ctx context.Context, userID uuid.UUID, currency string, from, to time.Time) (*DailyBalances, error) {
+	query := userQuery(userID, currency)
+	if !from.IsZero() {
+		query.Set("from", from.Format(time.DateOnly))
+	}
+	if !to.IsZero() {
+		query.Set("to", to.Format(time.DateOnly))
+	}
+	var balances DailyBalances
+	if err := c.do(ctx, http.MethodGet, walletPath+"balance/daily", query, nil, &balances); err != nil {
+		return nil, err
+	}
+	return &balances, nil
+}
+
+type TransactionsQuery struct {
+	// Currency picks one wallet, every wallet when empty
+	Currency string
+	// Page starts at 1, and PageSize is at most MaxPageSize. Zero leaves the
+	// server's defaults.
+	Page     int
+	PageSize int
+}
+
+// Transactions returns one page of the history, newest first
+func (c *Client) Transactions(ctx context.Context, userID uuid.UUID, q TransactionsQuery) ([]Transaction, error) {
+	query := userQuery(userID, q.Currency)
+	if q.Page > 0 {
+		query.Set("page", strconv.Itoa(q.Page))
+	}
+	if q.PageSize > 0 {
+		query.Set("page_size", strconv.Itoa(q.PageSize))
+	}
+	var transactions []Transaction
+	if err := c.do(ctx, http.MethodGet, walletPath+"transactions", query, nil, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// AllTransactions iterates over the whole history, newest first, a page at a
+// time. A transaction committed meanwhile shifts the pages, so one already
+// yielded can come round again and is skipped. Iteration stops after the
+// first error.
+func (c *Client) AllTransactions(ctx context.Context, userID uuid.UUID, currency string) iter.Seq2[Transaction, error] {
+	return func(yield func(Transaction, error) bool) {
+		seen := make(map[uuid.UUID]bool)
+		for page := 1; ; page++ {
+			transactions, err := c.Transactions(ctx, userID, TransactionsQuery{Currency: currency, Page: page, PageSize: MaxPageSize})
+			if err != nil {
+				yield(Transaction{}, err)
+				return
+			}
+			for _, tx := range transactions {
+				if seen[tx.ID] {
+					continue
+				}
+				seen[tx.ID] = true
+				if !yield(tx, nil) {
+					return
+				}
+			}
+			if len(transactions) < MaxPageSize {
+				return
+			}
+		}
+	}
+}
+
+type StatementQuery struct {
+	Currency string
+	From     time.Time
+	// To defaults to now
+	To time.Time
+	// Format is csv, ndjson or pdf, csv by default
+	Format string
+}
+
+// Statement downloads a statement of the transactions in [From, To). The
+// caller closes the returned body.
+func (c *Client) Statement(ctx context.Context, userID uuid.UUID, q StatementQuery) (io.ReadCloser, error) {
+	query := userQuery(userID, q.Currency)
+	query.Set("from", q.From.Format(time.RFC3339Nano))
+	if !q.To.IsZero() {
+		query.Set("to", q.To.Format(time.RFC3339Nano))
+	}
+	if q.Format != "" {
+		query.Set("format", q.Format)
+	}
+	resp, err := c.send(ctx, http.MethodGet, walletPath+"statements", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,183 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository/memory"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The tests below run the wallet service against the in-memory store, so
+// the version checks and row locks are real and the final balances can be
+// checked rather than the calls made.
+
+func TestMemoryStoreConcurrentDeposits(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	svc := service.NewWalletService(store, store, store)
+	userID := uuid.New()
+	numDeposits := 20
+
+	_, err := svc.Deposit(ctx, userID, decimal.NewFromInt(100), "USD", "initial")
+	require.NoError(t, err)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	wg.Add(numDeposits)
+	for i := 0; i < numDeposits; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := svc.Deposit(ctx, userID, decimal.NewFromInt(10), "USD", "deposit")
+			if err != nil {
+				// a deposit that keeps losing the version check gives up, it never half applies
+				assert.Equal(t, errors.Conflict, errors.TypeOf(err))
+				return
+			}
+			mu.Lock()
+			succeeded++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	require.Positive(t, succeeded)
+	balance, err := svc.GetBalance(ctx, userID, "USD")
+	require.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromInt(int64(100+10*succeeded))), "balance %s after %d deposits", balance, succeeded)
+
+	txs, err := svc.GetTransactionHistory(ctx, userID, "USD", 1, 100)
+	require.NoError(t, err)
+	assert.Len(t, txs, succeeded+1)
+
+	verifier := audit.NewVerifier()
+	for _, e := range store.AuditEvents() {
+		verifier.Check(&e)
+	}
+	assert.True(t, verifier.Result().Valid)
+	assert.Equal(t, succeeded+1, verifier.Result().EventsChecked)
+}
+
+func TestMemoryStoreConcurrentWithdrawalsNeverOverdraw(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	svc := service.NewWalletService(store, store, store)
+	userID := uuid.New()
+	numWithdrawals := 20
+
+	_, err := svc.Deposit(ctx, userID, decimal.NewFromInt(50), "USD", "initial")
+	require.NoError(t, err)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	wg.Add(numWithdrawals)
+	for i := 0; i < numWithdrawals; i++ {
+		go func() {
+			defer wg.Done()
+			if _, err := svc.Withdraw(ctx, userID, decimal.NewFromInt(10), "USD", "withdrawal"); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, succeeded, 5)
+	balance, err := svc.GetBalance(ctx, userID, "USD")
+	require.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromInt(int64(50-10*succeeded))), "balance %s after %d withdrawals", balance, succeeded)
+	assert.False(t, balance.IsNegative())
+}
+
+func TestMemoryStoreConcurrentTransfersConserveMoney(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	svc := service.NewWalletService(store, store, store)
+	alice, bob := uuid.New(), uuid.New()
+	numTransfers := 100
+
+	for _, userID := range []uuid.UUID{alice, bob} {
+		_, err := svc.Deposit(ctx, userID, decimal.NewFromInt(100), "USD", "initial")
+		require.NoError(t, err)
+	}
+
+	// transfers both ways at once, so the row locks are taken in both orders
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	wg.Add(numTransfers)
+	for i := 0; i < numTransfers; i++ {
+		from, to := alice, bob
+		if i%2 == 1 {
+			from, to = bob, alice
+		}
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := svc.Transfer(ctx, from, to, decimal.NewFromInt(15), "USD", "transfer")
+			if err != nil {
+				assert.Equal(t, errors.InsufficientFund, errors.TypeOf(err))
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	aliceBalance, err := svc.GetBalance(ctx, alice, "USD")
+	require.NoError(t, err)
+	bobBalance, err := svc.GetBalance(ctx, bob, "USD")
+	require.NoError(t, err)
+	assert.False(t, aliceBalance.IsNegative())
+	assert.False(t, bobBalance.IsNegative())
+	assert.True(t, aliceBalance.Add(bobBalance).Equal(decimal.NewFromInt(200)), "alice %s, bob %s", aliceBalance, bobBalance)
+}
+
+func TestMemoryStoreAuditChainsDontBlockEachOther(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	newEvent := func(targetID string) *model.AuditEvent {
+		e, err := audit.NewEvent(ctx, audit.SystemActor("test"), audit.ActionDeposit, audit.TargetWallet, targetID, nil, nil)
+		require.NoError(t, err)
+		return e
+	}
+
+	// a long transaction appends to wallet a's chain and keeps it
+	holder, err := store.BeginTx(ctx)
+	require.NoError(t, err)
+	require.NoError(t, holder.CreateAuditEventTx(ctx, newEvent("a")))
+
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	other, err := store.BeginTx(short)
+	require.NoError(t, err)
+	require.NoError(t, other.CreateAuditEventTx(short, newEvent("b")), "wallet b's chain doesn't wait for a's")
+	require.NoError(t, other.Commit())
+
+	same, err := store.BeginTx(short)
+	require.NoError(t, err)
+	assert.Error(t, same.CreateAuditEventTx(short, newEvent("a")), "wallet a's chain is held")
+	require.NoError(t, same.Rollback())
+	require.NoError(t, holder.Commit())
+
+	verifier := audit.NewVerifier()
+	for _, e := range store.AuditEvents() {
+		verifier.Check(&e)
+	}
+	assert.True(t, verifier.Result().Valid)
+	assert.Equal(t, 2, verifier.Result().EventsChecked)
+}
@@ -0,0 +1,128 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// memPaymentRequestRepo keeps one request in memory with the same
+// compare-and-set semantics as the postgres status update
+type memPaymentRequestRepo struct {
+	mu sync.Mutex
+	pr model.PaymentRequest
+}
+
+func (r *memPaymentRequestRepo) CreatePaymentRequest(ctx context.Context, req *model.PaymentRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pr = *req
+	return nil
+}
+
+func (r *memPaymentRequestRepo) GetPaymentRequest(ctx context.Context, id uuid.UUID) (*model.PaymentRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pr := r.pr
+	return &pr, nil
+}
+
+func (r *memPaymentRequestRepo) ListPaymentRequests(ctx context.Context, userID uuid.UUID, direction, status string, offset, limit int) ([]model.PaymentRequest, error) {
+	return nil, nil
+}
+
+func (r *memPaymentRequestRepo) TransitionPaymentRequest(ctx context.Context, id uuid.UUID, from, to string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pr.Status != from {
+		return false, nil
+	}
+	r.pr.Status = to
+	return true, nil
+}
+
+func (r *memPaymentRequestRepo) ExpirePaymentRequests(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestConcurrentPaymentRequestAccepts(t *testing.T) {
+	ctx := context.Background()
+	requesterID := uuid.New()
+	payerID := uuid.New()
+	currency := "USD"
+	numAccepts := 10
+
+	payerWallet := &model.Wallet{ID: uuid.New(), UserID: payerID, Currency: currency, Balance: decimal.NewFromInt(1000)}
+	requesterWallet := &model.Wallet{ID: uuid.New(), UserID: requesterID, Currency: currency, Balance: decimal.Zero}
+
+	wr := &MockWalletRepository{}
+	tr := &MockTransactionRepository{}
+	mockTx := &MockWalletTx{}
+	tr.On("BeginTx", mock.Anything).Return(mockTx, nil)
+	wr.On("GetWalletByUserAndCurrency", mock.Anything, payerID, currency).Return(payerWallet, nil)
+	wr.On("GetWalletByUserAndCurrency", mock.Anything, requesterID, currency).Return(requesterWallet, nil)
+	mockTx.On("GetWalletForUpdate", mock.Anything, payerWallet.ID).Return(payerWallet, nil)
+	mockTx.On("GetWalletForUpdate", mock.Anything, requesterWallet.ID).Return(requesterWallet, nil)
+	mockTx.On("UpdateWalletBalanceTx", mock.Anything, mock.Anything, mock.AnythingOfType("decimal.Decimal")).Return(nil)
+	mockTx.On("CreateTransactionTx", mock.Anything, mock.AnythingOfType("*model.Transaction")).Return(nil)
+	mockTx.On("CreateAuditEventTx", mock.Anything, mock.AnythingOfType("*model.AuditEvent")).Return(nil)
+	mockTx.On("CreateOutboxEventTx", mock.Anything, mock.AnythingOfType("*model.OutboxEvent")).Return(nil)
+	mockTx.On("Commit").Return(nil)
+	mockTx.On("Rollback").Return(nil)
+
+	repo := &memPaymentRequestRepo{pr: model.PaymentRequest{
+		ID:          uuid.New(),
+		RequesterID: requesterID,
+		PayerID:     payerID,
+		Amount:      decimal.NewFromInt(25),
+		Currency:    currency,
+		Status:      model.PaymentRequestPending,
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}}
+	requestID := repo.pr.ID
+	// the transfer's transaction takes the request with the same compare-and-set
+	mockTx.On("TransitionPaymentRequestTx", mock.Anything, requestID, mock.Anything, mock.Anything).
+		Return(func(_ uuid.UUID, from, to string) bool {
+			ok, _ := repo.TransitionPaymentRequest(ctx, requestID, from, to)
+			return ok
+		}, nil)
+	svc := service.NewPaymentRequestService(repo, service.NewWalletService(wr, tr, tr))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted, conflicts := 0, 0
+	wg.Add(numAccepts)
+	for i := 0; i < numAccepts; i++ {
+		go func() {
+			defer wg.Done()
+			_, err := svc.AcceptPaymentRequest(ctx, payerID, requestID)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				accepted++
+			case errors.TypeOf(err) == errors.Conflict:
+				conflicts++
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the payer is charged exactly once
+	assert.Equal(t, 1, accepted)
+	assert.Equal(t, numAccepts-1, conflicts)
+	mockTx.AssertNumberOfCalls(t, "Commit", 1)
+	mockTx.AssertNumberOfCalls(t, "UpdateWalletBalanceTx", 2)
+	assert.Equal(t, model.PaymentRequestAccepted, repo.pr.Status)
+}
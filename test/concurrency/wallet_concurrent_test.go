@@ -3,6 +3,7 @@ package concurrency
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/Jiang-hao/walletApiService/internal/model"
 	"github.com/Jiang-hao/walletApiService/internal/repository"
@@ -34,6 +35,11 @@ func (m *MockWalletRepository) GetWalletByUserAndCurrency(ctx context.Context, u
 	return args.Get(0).(*model.Wallet), args.Error(1)
 }
 
+func (m *MockWalletRepository) ListWalletsByUser(ctx context.Context, userID uuid.UUID) ([]model.Wallet, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]model.Wallet), args.Error(1)
+}
+
 func (m *MockWalletRepository) GetWalletForUpdate(ctx context.Context, id uuid.UUID) (*model.Wallet, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(*model.Wallet), args.Error(1)
@@ -49,6 +55,11 @@ func (m *MockWalletRepository) UpdateWalletBalanceTx(ctx context.Context, tx *sq
 	return args.Error(0)
 }
 
+func (m *MockWalletRepository) CreateWalletTx(ctx context.Context, tx *sqlx.Tx, wallet *model.Wallet) error {
+	args := m.Called(ctx, tx, wallet)
+	return args.Error(0)
+}
+
 type MockTransactionRepository struct {
 	mock.Mock
 }
@@ -107,6 +118,76 @@ func (m *MockWalletTx) CreateTransactionTx(ctx context.Context, tx *model.Transa
 	return args.Error(0)
 }
 
+func (m *MockWalletTx) UpdateWalletBalanceWithVersionTx(ctx context.Context, id uuid.UUID, newBalance decimal.Decimal, version int) (int64, error) {
+	args := m.Called(ctx, id, newBalance, version)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockWalletTx) SetWalletFrozenTx(ctx context.Context, id uuid.UUID, frozenAt *time.Time, reason string) error {
+	args := m.Called(ctx, id, frozenAt, reason)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) CreateAuditEventTx(ctx context.Context, event *model.AuditEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) CreateOutboxEventTx(ctx context.Context, event *model.OutboxEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) CreateEscrowTx(ctx context.Context, escrow *model.Escrow) error {
+	args := m.Called(ctx, escrow)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) GetEscrowForUpdate(ctx context.Context, id uuid.UUID) (*model.Escrow, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Escrow), args.Error(1)
+}
+
+func (m *MockWalletTx) ResolveEscrowTx(ctx context.Context, escrow *model.Escrow) error {
+	args := m.Called(ctx, escrow)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) CreateAdjustmentTx(ctx context.Context, adjustment *model.Adjustment) error {
+	args := m.Called(ctx, adjustment)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) GetAdjustmentForUpdate(ctx context.Context, id uuid.UUID) (*model.Adjustment, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Adjustment), args.Error(1)
+}
+
+func (m *MockWalletTx) ResolveAdjustmentTx(ctx context.Context, adjustment *model.Adjustment) error {
+	args := m.Called(ctx, adjustment)
+	return args.Error(0)
+}
+
+// TransitionPaymentRequestTx returns what the func it was set up with returns,
+// when it was set up with one
+func (m *MockWalletTx) UnreviewedAdjustmentTotal(ctx context.Context, walletID uuid.UUID, since time.Time) (decimal.Decimal, error) {
+	args := m.Called(ctx, walletID, since)
+	return args.Get(0).(decimal.Decimal), args.Error(1)
+}
+
+func (m *MockWalletTx) TransitionPaymentRequestTx(ctx context.Context, id uuid.UUID, from, to string) (bool, error) {
+	args := m.Called(ctx, id, from, to)
+	if transition, ok := args.Get(0).(func(uuid.UUID, string, string) bool); ok {
+		return transition(id, from, to), args.Error(1)
+	}
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockWalletTx) CreateWalletTx(ctx context.Context, wallet *model.Wallet) error {
+	args := m.Called(ctx, wallet)
+	return args.Error(0)
+}
+
 func TestConcurrentDeposits(t *testing.T) {
 	ctx := context.Background()
 	userID := uuid.New()
@@ -132,13 +213,17 @@ func TestConcurrentDeposits(t *testing.T) {
 	// Expect GetWalletByUserAndCurrency to return our wallet
 	wr.On("GetWalletByUserAndCurrency", ctx, userID, currency).Return(wallet, nil)
 
-	for i := 0; i < numDeposits; i++ {
-		wr.On("UpdateWalletBalance", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), mock.AnythingOfType("int")).
-			Return(int64(1), nil)
-	}
+	// Each deposit runs in its own DB transaction
+	mockTx := &MockWalletTx{}
+	tr.On("BeginTx", ctx).Return(mockTx, nil).Times(numDeposits)
+	mockTx.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), mock.AnythingOfType("int")).
+		Return(int64(1), nil).Times(numDeposits)
 
-	// Expect transaction creation for each deposit
-	tr.On("CreateTransaction", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+	// Expect transaction creation and an audit event for each deposit
+	mockTx.On("CreateTransactionTx", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil).Times(numDeposits)
+	mockTx.On("CreateAuditEventTx", ctx, mock.AnythingOfType("*model.AuditEvent")).Return(nil).Times(numDeposits)
+	mockTx.On("CreateOutboxEventTx", ctx, mock.AnythingOfType("*model.OutboxEvent")).Return(nil).Times(numDeposits)
+	mockTx.On("Commit").Return(nil).Times(numDeposits)
 
 	// Create service
 	service := service.NewWalletService(wr, tr, txManager)
@@ -187,13 +272,17 @@ func TestConcurrentWithdrawals(t *testing.T) {
 	// Expect GetWalletByUserAndCurrency to return our wallet
 	wr.On("GetWalletByUserAndCurrency", ctx, userID, currency).Return(wallet, nil)
 
-	for i := 0; i < numWithdrawals; i++ {
-		wr.On("UpdateWalletBalance", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), mock.AnythingOfType("int")).
-			Return(int64(1), nil)
-	}
+	// Each withdrawal runs in its own DB transaction
+	mockTx := &MockWalletTx{}
+	tr.On("BeginTx", ctx).Return(mockTx, nil).Times(numWithdrawals)
+	mockTx.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), mock.AnythingOfType("int")).
+		Return(int64(1), nil).Times(numWithdrawals)
 
-	// Expect transaction creation for each withdrawal
-	tr.On("CreateTransaction", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+	// Expect transaction creation and an audit event for each withdrawal
+	mockTx.On("CreateTransactionTx", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil).Times(numWithdrawals)
+	mockTx.On("CreateAuditEventTx", ctx, mock.AnythingOfType("*model.AuditEvent")).Return(nil).Times(numWithdrawals)
+	mockTx.On("CreateOutboxEventTx", ctx, mock.AnythingOfType("*model.OutboxEvent")).Return(nil).Times(numWithdrawals)
+	mockTx.On("Commit").Return(nil).Times(numWithdrawals)
 
 	// Create service
 	service := service.NewWalletService(wr, tr, txManager)
@@ -256,6 +345,10 @@ func TestConcurrentTransfers(t *testing.T) {
 	wr.On("GetWalletByUserAndCurrency", ctx, fromUserID, currency).Return(fromWallet, nil)
 	wr.On("GetWalletByUserAndCurrency", ctx, toUserID, currency).Return(toWallet, nil)
 
+	// Each transfer re-reads both wallets under row locks
+	mockTx.On("GetWalletForUpdate", ctx, fromWallet.ID).Return(fromWallet, nil)
+	mockTx.On("GetWalletForUpdate", ctx, toWallet.ID).Return(toWallet, nil)
+
 	// Expect UpdateWalletBalanceTx for both wallets
 	mockTx.On("UpdateWalletBalanceTx", ctx, fromWallet.ID, mock.AnythingOfType("decimal.Decimal")).Return(nil)
 	mockTx.On("UpdateWalletBalanceTx", ctx, toWallet.ID, mock.AnythingOfType("decimal.Decimal")).Return(nil)
@@ -263,6 +356,10 @@ func TestConcurrentTransfers(t *testing.T) {
 	// Expect CreateTransactionTx for both wallets
 	mockTx.On("CreateTransactionTx", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
 
+	// Expect an audit event for each transfer
+	mockTx.On("CreateAuditEventTx", ctx, mock.AnythingOfType("*model.AuditEvent")).Return(nil)
+	mockTx.On("CreateOutboxEventTx", ctx, mock.AnythingOfType("*model.OutboxEvent")).Return(nil)
+
 	// Expect Commit for each transfer
 	mockTx.On("Commit").Return(nil)
 
@@ -314,12 +411,17 @@ func TestConcurrentMixedOperations(t *testing.T) {
 	// Expect GetWalletByUserAndCurrency to return our wallet
 	wr.On("GetWalletByUserAndCurrency", ctx, userID, currency).Return(wallet, nil)
 
-	// Expect UpdateWalletBalance to succeed with optimistic locking
-	wr.On("UpdateWalletBalance", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), mock.AnythingOfType("int")).
+	// Expect the versioned update to succeed inside each operation's DB transaction
+	mockTx := &MockWalletTx{}
+	tr.On("BeginTx", ctx).Return(mockTx, nil)
+	mockTx.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), mock.AnythingOfType("int")).
 		Return(int64(1), nil)
 
-	// Expect transaction creation for each operation
-	tr.On("CreateTransaction", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+	// Expect transaction creation and an audit event for each operation
+	mockTx.On("CreateTransactionTx", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+	mockTx.On("CreateAuditEventTx", ctx, mock.AnythingOfType("*model.AuditEvent")).Return(nil)
+	mockTx.On("CreateOutboxEventTx", ctx, mock.AnythingOfType("*model.OutboxEvent")).Return(nil)
+	mockTx.On("Commit").Return(nil)
 
 	// Create service
 	service := service.NewWalletService(wr, tr, txManager)
@@ -372,19 +474,31 @@ func TestOptimisticLockingWithRetries(t *testing.T) {
 	// Expect GetWalletByUserAndCurrency to return our wallet
 	wr.On("GetWalletByUserAndCurrency", ctx, userID, currency).Return(wallet, nil).Times(numConcurrentOps)
 
+	mockTx := &MockWalletTx{}
+	tr.On("BeginTx", ctx).Return(mockTx, nil)
+
 	// 使用mock.Anything匹配参数，因为并发操作顺序不确定
 	// 前numConcurrentOps次调用返回冲突
 	for i := 0; i < numConcurrentOps; i++ {
-		wr.On("UpdateWalletBalance", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), wallet.Version).
+		mockTx.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), wallet.Version).
 			Return(int64(0), nil).Once()
 	}
+	mockTx.On("Rollback").Return(nil)
+
+	// 冲突后重新读取钱包，重试使用最新的版本号
+	reread := *wallet
+	reread.Version = wallet.Version + 1
+	wr.On("GetWallet", ctx, wallet.ID).Return(&reread, nil)
 
 	// 后续调用返回成功
-	wr.On("UpdateWalletBalance", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), mock.AnythingOfType("int")).
+	mockTx.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), mock.AnythingOfType("int")).
 		Return(int64(1), nil)
 
 	// Expect transaction creation for each successful operation
-	tr.On("CreateTransaction", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil).Times(numConcurrentOps)
+	mockTx.On("CreateTransactionTx", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil).Times(numConcurrentOps)
+	mockTx.On("CreateAuditEventTx", ctx, mock.AnythingOfType("*model.AuditEvent")).Return(nil).Times(numConcurrentOps)
+	mockTx.On("CreateOutboxEventTx", ctx, mock.AnythingOfType("*model.OutboxEvent")).Return(nil).Times(numConcurrentOps)
+	mockTx.On("Commit").Return(nil).Times(numConcurrentOps)
 
 	// Create service
 	service := service.NewWalletService(wr, tr, txManager)
@@ -403,13 +517,19 @@ func TestOptimisticLockingWithRetries(t *testing.T) {
 
 	wg.Wait()
 
-	// 验证UpdateWalletBalance被调用了足够次数
+	// 验证UpdateWalletBalanceWithVersionTx被调用了足够次数
 	// 至少numConcurrentOps次(初始尝试) + numConcurrentOps次(重试)
 	minExpectedCalls := numConcurrentOps * 2
-	assert.True(t, len(wr.Calls) >= minExpectedCalls,
-		"Expected at least %d UpdateWalletBalance calls, got %d",
-		minExpectedCalls, len(wr.Calls))
+	updateCalls := 0
+	for _, call := range mockTx.Calls {
+		if call.Method == "UpdateWalletBalanceWithVersionTx" {
+			updateCalls++
+		}
+	}
+	assert.True(t, updateCalls >= minExpectedCalls,
+		"Expected at least %d UpdateWalletBalanceWithVersionTx calls, got %d",
+		minExpectedCalls, updateCalls)
 
-	// 验证CreateTransaction被调用了numConcurrentOps次
-	tr.AssertNumberOfCalls(t, "CreateTransaction", numConcurrentOps)
+	// 验证CreateTransactionTx被调用了numConcurrentOps次
+	mockTx.AssertNumberOfCalls(t, "CreateTransactionTx", numConcurrentOps)
 }
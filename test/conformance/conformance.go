@@ -0,0 +1,546 @@
+// Package conformance is the behaviour every implementation of the wallet and
+// transaction repositories and the TxManager must share, so the services work
+// the same on Postgres and on the in-memory store.
+package conformance
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockedFor is how long a lock wait must last to count as blocked
+const blockedFor = 100 * time.Millisecond
+
+// Harness is one implementation under test
+type Harness struct {
+	Wallets      repository.WalletRepository
+	Transactions repository.TransactionRepository
+	TxManager    repository.TxManager
+	// NewUserID returns a user that wallets can be created for
+	NewUserID func(t *testing.T) uuid.UUID
+}
+
+// Run runs the suite, calling newHarness for a fresh implementation per test
+func Run(t *testing.T, newHarness func(t *testing.T) Harness) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, h Harness)
+	}{
+		{"CreateAndGetWallet", testCreateAndGetWallet},
+		{"DuplicateWallet", testDuplicateWallet},
+		{"VersionedUpdate", testVersionedUpdate},
+		{"CommitPublishesWrites", testCommitPublishesWrites},
+		{"RollbackDiscardsWrites", testRollbackDiscardsWrites},
+		{"RowLockBlocksUntilCommit", testRowLockBlocksUntilCommit},
+		{"VersionCheckSeesConcurrentCommit", testVersionCheckSeesConcurrentCommit},
+		{"LockedUpdateMovesVersion", testLockedUpdateMovesVersion},
+		{"NegativeBalanceRejected", testNegativeBalanceRejected},
+		{"TransactionForMissingWallet", testTransactionForMissingWallet},
+		{"TransactionHistory", testTransactionHistory},
+		{"FreezeWallet", testFreezeWallet},
+		{"Adjustments", testAdjustments},
+		{"UnreviewedAdjustmentTotal", testUnreviewedAdjustmentTotal},
+		{"DeadlockFailsOneSide", testDeadlockFailsOneSide},
+		{"NoLostUpdates", testNoLostUpdates},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newHarness(t))
+		})
+	}
+}
+
+func newWallet(t *testing.T, h Harness, balance int64) *model.Wallet {
+	t.Helper()
+	w := &model.Wallet{ID: uuid.New(), UserID: h.NewUserID(t), Currency: "USD", Balance: decimal.NewFromInt(balance)}
+	require.NoError(t, h.Wallets.CreateWallet(context.Background(), w))
+	return w
+}
+
+func balanceOf(t *testing.T, h Harness, id uuid.UUID) decimal.Decimal {
+	t.Helper()
+	w, err := h.Wallets.GetWallet(context.Background(), id)
+	require.NoError(t, err)
+	return w.Balance
+}
+
+func deposit(w *model.Wallet, amount int64) *model.Transaction {
+	return &model.Transaction{
+		ID:            uuid.New(),
+		UserID:        w.UserID,
+		WalletID:      w.ID,
+		Amount:        decimal.NewFromInt(amount),
+		Currency:      w.Currency,
+		BalanceBefore: w.Balance,
+		BalanceAfter:  w.Balance.Add(decimal.NewFromInt(amount)),
+		Type:          "deposit",
+		Reference:     "conformance",
+	}
+}
+
+func testCreateAndGetWallet(t *testing.T, h Harness) {
+	ctx := context.Background()
+	w := newWallet(t, h, 10)
+
+	got, err := h.Wallets.GetWallet(ctx, w.ID)
+	require.NoError(t, err)
+	assert.Equal(t, w.UserID, got.UserID)
+	assert.True(t, got.Balance.Equal(decimal.NewFromInt(10)))
+	assert.Equal(t, 0, got.Version)
+
+	got, err = h.Wallets.GetWalletByUserAndCurrency(ctx, w.UserID, "USD")
+	require.NoError(t, err)
+	assert.Equal(t, w.ID, got.ID)
+
+	_, err = h.Wallets.GetWallet(ctx, uuid.New())
+	assert.Equal(t, errors.NotFound, errors.TypeOf(err))
+	_, err = h.Wallets.GetWalletByUserAndCurrency(ctx, w.UserID, "EUR")
+	assert.Equal(t, errors.NotFound, errors.TypeOf(err))
+}
+
+func testDuplicateWallet(t *testing.T, h Harness) {
+	ctx := context.Background()
+	w := newWallet(t, h, 0)
+
+	dup := &model.Wallet{ID: uuid.New(), UserID: w.UserID, Currency: w.Currency, Balance: decimal.Zero}
+	assert.Error(t, h.Wallets.CreateWallet(ctx, dup))
+
+	tx, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	assert.Error(t, tx.CreateWalletTx(ctx, dup))
+}
+
+func testVersionedUpdate(t *testing.T, h Harness) {
+	ctx := context.Background()
+	w := newWallet(t, h, 10)
+
+	rows, err := h.Wallets.UpdateWalletBalance(ctx, w.ID, decimal.NewFromInt(20), 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	// the version moved on, a writer still holding version 0 loses
+	rows, err = h.Wallets.UpdateWalletBalance(ctx, w.ID, decimal.NewFromInt(30), 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), rows)
+
+	got, err := h.Wallets.GetWallet(ctx, w.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.Version)
+	assert.True(t, got.Balance.Equal(decimal.NewFromInt(20)))
+}
+
+func testCommitPublishesWrites(t *testing.T, h Harness) {
+	ctx := context.Background()
+	w := newWallet(t, h, 10)
+
+	tx, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	locked, err := tx.GetWalletForUpdate(ctx, w.ID)
+	require.NoError(t, err)
+	rows, err := tx.UpdateWalletBalanceWithVersionTx(ctx, w.ID, decimal.NewFromInt(15), locked.Version)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rows)
+	require.NoError(t, tx.CreateTransactionTx(ctx, deposit(w, 5)))
+
+	// the transaction sees its own write, nobody else does yet
+	mine, err := tx.GetWalletForUpdate(ctx, w.ID)
+	require.NoError(t, err)
+	assert.True(t, mine.Balance.Equal(decimal.NewFromInt(15)))
+	assert.True(t, balanceOf(t, h, w.ID).Equal(decimal.NewFromInt(10)))
+	txs, err := h.Transactions.GetTransactions(ctx, w.ID, 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, txs)
+
+	require.NoError(t, tx.Commit())
+	assert.True(t, balanceOf(t, h, w.ID).Equal(decimal.NewFromInt(15)))
+	txs, err = h.Transactions.GetTransactions(ctx, w.ID, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, txs, 1)
+	assert.True(t, txs[0].BalanceAfter.Equal(decimal.NewFromInt(15)))
+	assert.NotEmpty(t, txs[0].CreatedAt)
+}
+
+func testRollbackDiscardsWrites(t *testing.T, h Harness) {
+	ctx := context.Background()
+	userID := h.NewUserID(t)
+
+	tx, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	w := &model.Wallet{ID: uuid.New(), UserID: userID, Currency: "USD", Balance: decimal.Zero}
+	require.NoError(t, tx.CreateWalletTx(ctx, w))
+	require.NoError(t, tx.UpdateWalletBalanceTx(ctx, w.ID, decimal.NewFromInt(5)))
+	require.NoError(t, tx.CreateTransactionTx(ctx, deposit(w, 5)))
+
+	_, err = h.Wallets.GetWallet(ctx, w.ID)
+	assert.Equal(t, errors.NotFound, errors.TypeOf(err), "uncommitted wallet is visible")
+
+	require.NoError(t, tx.Rollback())
+	_, err = h.Wallets.GetWallet(ctx, w.ID)
+	assert.Equal(t, errors.NotFound, errors.TypeOf(err))
+	txs, err := h.Transactions.GetAllTransactions(ctx, userID, 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, txs)
+
+	// the rolled back wallet doesn't hold on to its user and currency
+	require.NoError(t, h.Wallets.CreateWallet(ctx, &model.Wallet{ID: uuid.New(), UserID: userID, Currency: "USD", Balance: decimal.Zero}))
+}
+
+func testRowLockBlocksUntilCommit(t *testing.T, h Harness) {
+	ctx := context.Background()
+	w := newWallet(t, h, 10)
+
+	first, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	_, err = first.GetWalletForUpdate(ctx, w.ID)
+	require.NoError(t, err)
+
+	second, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	defer second.Rollback()
+	got := make(chan *model.Wallet, 1)
+	go func() {
+		locked, err := second.GetWalletForUpdate(ctx, w.ID)
+		assert.NoError(t, err)
+		got <- locked
+	}()
+
+	select {
+	case <-got:
+		t.Fatal("second transaction got the row while the first held its lock")
+	case <-time.After(blockedFor):
+	}
+
+	require.NoError(t, first.UpdateWalletBalanceTx(ctx, w.ID, decimal.NewFromInt(25)))
+	require.NoError(t, first.Commit())
+
+	select {
+	case locked := <-got:
+		require.NotNil(t, locked)
+		assert.True(t, locked.Balance.Equal(decimal.NewFromInt(25)), "waiter must read the committed row")
+	case <-time.After(5 * time.Second):
+		t.Fatal("second transaction still blocked after the first committed")
+	}
+}
+
+func testVersionCheckSeesConcurrentCommit(t *testing.T, h Harness) {
+	ctx := context.Background()
+	w := newWallet(t, h, 10)
+
+	stale, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	defer stale.Rollback()
+
+	rows, err := h.Wallets.UpdateWalletBalance(ctx, w.ID, decimal.NewFromInt(11), w.Version)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rows)
+
+	rows, err = stale.UpdateWalletBalanceWithVersionTx(ctx, w.ID, decimal.NewFromInt(12), w.Version)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), rows)
+}
+
+func testLockedUpdateMovesVersion(t *testing.T, h Harness) {
+	ctx := context.Background()
+	w := newWallet(t, h, 10)
+
+	tx, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	_, err = tx.GetWalletForUpdate(ctx, w.ID)
+	require.NoError(t, err)
+	require.NoError(t, tx.UpdateWalletBalanceTx(ctx, w.ID, decimal.NewFromInt(4)))
+	require.NoError(t, tx.Commit())
+
+	// an optimistic update that read the balance before the locked one must not overwrite it
+	rows, err := h.Wallets.UpdateWalletBalance(ctx, w.ID, decimal.NewFromInt(20), w.Version)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), rows)
+
+	got, err := h.Wallets.GetWallet(ctx, w.ID)
+	require.NoError(t, err)
+	assert.True(t, got.Balance.Equal(decimal.NewFromInt(4)))
+	assert.Equal(t, w.Version+1, got.Version)
+}
+
+func testNegativeBalanceRejected(t *testing.T, h Harness) {
+	ctx := context.Background()
+	w := newWallet(t, h, 10)
+
+	tx, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	_, err = tx.UpdateWalletBalanceWithVersionTx(ctx, w.ID, decimal.NewFromInt(-1), w.Version)
+	assert.Error(t, err)
+}
+
+func testTransactionForMissingWallet(t *testing.T, h Harness) {
+	ctx := context.Background()
+	missing := &model.Wallet{ID: uuid.New(), UserID: h.NewUserID(t), Currency: "USD", Balance: decimal.Zero}
+
+	tx, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	assert.Error(t, tx.CreateTransactionTx(ctx, deposit(missing, 5)))
+}
+
+func testTransactionHistory(t *testing.T, h Harness) {
+	ctx := context.Background()
+	usd := newWallet(t, h, 0)
+	eur := &model.Wallet{ID: uuid.New(), UserID: usd.UserID, Currency: "EUR", Balance: decimal.Zero}
+	require.NoError(t, h.Wallets.CreateWallet(ctx, eur))
+
+	// one DB transaction each, so created_at orders them
+	var ids []uuid.UUID
+	for i, w := range []*model.Wallet{usd, eur, usd} {
+		tx, err := h.TxManager.BeginTx(ctx)
+		require.NoError(t, err)
+		rec := deposit(w, int64(i+1))
+		require.NoError(t, tx.CreateTransactionTx(ctx, rec))
+		require.NoError(t, tx.Commit())
+		ids = append(ids, rec.ID)
+		time.Sleep(time.Millisecond)
+	}
+
+	txs, err := h.Transactions.GetTransactions(ctx, usd.ID, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, txs, 2)
+	assert.Equal(t, ids[2], txs[0].ID, "newest first")
+	assert.Equal(t, ids[0], txs[1].ID)
+
+	txs, err = h.Transactions.GetAllTransactions(ctx, usd.UserID, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, txs, 1)
+	assert.Equal(t, ids[1], txs[0].ID)
+}
+
+func testFreezeWallet(t *testing.T, h Harness) {
+	ctx := context.Background()
+	usd := newWallet(t, h, 10)
+	eur := &model.Wallet{ID: uuid.New(), UserID: usd.UserID, Currency: "EUR", Balance: decimal.Zero}
+	require.NoError(t, h.Wallets.CreateWallet(ctx, eur))
+
+	frozenAt := time.Now().UTC().Truncate(time.Second)
+	tx, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	_, err = tx.GetWalletForUpdate(ctx, usd.ID)
+	require.NoError(t, err)
+	require.NoError(t, tx.SetWalletFrozenTx(ctx, usd.ID, &frozenAt, "chargeback"))
+	require.NoError(t, tx.Commit())
+
+	wallets, err := h.Wallets.ListWalletsByUser(ctx, usd.UserID)
+	require.NoError(t, err)
+	require.Len(t, wallets, 2)
+	assert.Equal(t, "EUR", wallets[0].Currency, "ordered by currency")
+	assert.False(t, wallets[0].Frozen())
+	got := wallets[1]
+	require.True(t, got.Frozen())
+	assert.True(t, frozenAt.Equal(*got.FrozenAt))
+	assert.Equal(t, "chargeback", got.FrozenReason)
+	assert.Equal(t, usd.Version+1, got.Version, "freezing fails optimistic updates that read the wallet before")
+
+	tx, err = h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.SetWalletFrozenTx(ctx, usd.ID, nil, ""))
+	require.NoError(t, tx.Commit())
+	unfrozen, err := h.Wallets.GetWallet(ctx, usd.ID)
+	require.NoError(t, err)
+	assert.False(t, unfrozen.Frozen())
+	assert.Empty(t, unfrozen.FrozenReason)
+}
+
+func testAdjustments(t *testing.T, h Harness) {
+	ctx := context.Background()
+	w := newWallet(t, h, 10)
+	now := time.Now().UTC().Truncate(time.Second)
+	adjustment := &model.Adjustment{
+		ID:          uuid.New(),
+		WalletID:    w.ID,
+		UserID:      w.UserID,
+		Currency:    w.Currency,
+		Amount:      decimal.NewFromInt(-4),
+		ReasonCode:  model.AdjustmentDuplicate,
+		RequestedBy: "alice",
+		Status:      model.AdjustmentPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	tx, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.CreateAdjustmentTx(ctx, adjustment))
+	require.NoError(t, tx.Commit())
+
+	// the requester can't review their own adjustment
+	tx, err = h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	got, err := tx.GetAdjustmentForUpdate(ctx, adjustment.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentPending, got.Status)
+	assert.True(t, got.Amount.Equal(decimal.NewFromInt(-4)))
+	got.Status, got.ReviewedBy, got.ResolvedAt = model.AdjustmentRejected, &got.RequestedBy, &now
+	assert.Error(t, tx.ResolveAdjustmentTx(ctx, got))
+	require.NoError(t, tx.Rollback())
+
+	// an applied adjustment points at its ledger entry
+	tx, err = h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	got, err = tx.GetAdjustmentForUpdate(ctx, adjustment.ID)
+	require.NoError(t, err)
+	reviewer := "bob"
+	got.Status, got.ReviewedBy, got.ResolvedAt = model.AdjustmentApplied, &reviewer, &now
+	assert.Error(t, tx.ResolveAdjustmentTx(ctx, got), "applied without a transaction")
+	require.NoError(t, tx.Rollback())
+
+	tx, err = h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	got, err = tx.GetAdjustmentForUpdate(ctx, adjustment.ID)
+	require.NoError(t, err)
+	rec := deposit(w, -4)
+	rec.Type = model.TxAdjustment
+	require.NoError(t, tx.CreateTransactionTx(ctx, rec))
+	got.Status, got.ReviewedBy, got.TransactionID, got.ResolvedAt = model.AdjustmentApplied, &reviewer, &rec.ID, &now
+	require.NoError(t, tx.ResolveAdjustmentTx(ctx, got))
+	require.NoError(t, tx.Commit())
+
+	tx, err = h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	got, err = tx.GetAdjustmentForUpdate(ctx, adjustment.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentApplied, got.Status)
+	require.NotNil(t, got.ReviewedBy)
+	assert.Equal(t, "bob", *got.ReviewedBy)
+	assert.Equal(t, rec.ID, *got.TransactionID)
+
+	_, err = tx.GetAdjustmentForUpdate(ctx, uuid.New())
+	assert.Equal(t, errors.NotFound, errors.TypeOf(err))
+}
+
+func testUnreviewedAdjustmentTotal(t *testing.T, h Harness) {
+	ctx := context.Background()
+	w, other := newWallet(t, h, 100), newWallet(t, h, 100)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	reviewer := "bob"
+
+	tx, err := h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	adjust := func(w *model.Wallet, amount int64, status string, reviewedBy *string, createdAt time.Time) {
+		adjustment := &model.Adjustment{
+			ID: uuid.New(), WalletID: w.ID, UserID: w.UserID, Currency: w.Currency, Amount: decimal.NewFromInt(amount),
+			ReasonCode: model.AdjustmentIncident, RequestedBy: "alice", ReviewedBy: reviewedBy, Status: status,
+			CreatedAt: createdAt, UpdatedAt: createdAt,
+		}
+		if status == model.AdjustmentApplied {
+			rec := deposit(w, amount)
+			rec.Type = model.TxAdjustment
+			require.NoError(t, tx.CreateTransactionTx(ctx, rec))
+			adjustment.TransactionID = &rec.ID
+		}
+		require.NoError(t, tx.CreateAdjustmentTx(ctx, adjustment))
+	}
+	adjust(w, -4, model.AdjustmentApplied, nil, today.Add(time.Minute))
+	adjust(w, 3, model.AdjustmentApplied, nil, today.Add(2*time.Minute))
+	adjust(w, 8, model.AdjustmentApplied, nil, today.Add(-time.Minute))
+	adjust(w, 5, model.AdjustmentApplied, &reviewer, today.Add(time.Minute))
+	adjust(w, 6, model.AdjustmentPending, nil, today.Add(time.Minute))
+	adjust(other, 9, model.AdjustmentApplied, nil, today.Add(time.Minute))
+
+	// the transaction's own writes count
+	total, err := tx.UnreviewedAdjustmentTotal(ctx, w.ID, today)
+	require.NoError(t, err)
+	assert.True(t, total.Equal(decimal.NewFromInt(7)), total.String())
+	require.NoError(t, tx.Commit())
+
+	tx, err = h.TxManager.BeginTx(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	total, err = tx.UnreviewedAdjustmentTotal(ctx, w.ID, today)
+	require.NoError(t, err)
+	assert.True(t, total.Equal(decimal.NewFromInt(7)), total.String())
+	total, err = tx.UnreviewedAdjustmentTotal(ctx, uuid.New(), today)
+	require.NoError(t, err)
+	assert.True(t, total.IsZero())
+}
+
+func testDeadlockFailsOneSide(t *testing.T, h Harness) {
+	ctx := context.Background()
+	a, b := newWallet(t, h, 0), newWallet(t, h, 0)
+
+	// each transaction locks one wallet, then wants the other's
+	errs := make(chan error, 2)
+	var locked sync.WaitGroup
+	locked.Add(2)
+	for _, order := range [][2]uuid.UUID{{a.ID, b.ID}, {b.ID, a.ID}} {
+		go func() {
+			tx, err := h.TxManager.BeginTx(ctx)
+			if err != nil {
+				locked.Done()
+				errs <- err
+				return
+			}
+			_, err = tx.GetWalletForUpdate(ctx, order[0])
+			locked.Done()
+			locked.Wait()
+			if err == nil {
+				_, err = tx.GetWalletForUpdate(ctx, order[1])
+			}
+			if err != nil {
+				tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+			errs <- err
+		}()
+	}
+
+	var failed int
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				failed++
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("deadlock was not broken")
+		}
+	}
+	assert.Equal(t, 1, failed)
+}
+
+func testNoLostUpdates(t *testing.T, h Harness) {
+	ctx := context.Background()
+	w := newWallet(t, h, 0)
+	const workers = 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx, err := h.TxManager.BeginTx(ctx)
+			if !assert.NoError(t, err) {
+				return
+			}
+			locked, err := tx.GetWalletForUpdate(ctx, w.ID)
+			if !assert.NoError(t, err) {
+				tx.Rollback()
+				return
+			}
+			assert.NoError(t, tx.UpdateWalletBalanceTx(ctx, w.ID, locked.Balance.Add(decimal.NewFromInt(1))))
+			assert.NoError(t, tx.Commit())
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, balanceOf(t, h, w.ID).Equal(decimal.NewFromInt(workers)))
+}
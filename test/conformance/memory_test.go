@@ -0,0 +1,20 @@
+package conformance
+
+import (
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/repository/memory"
+	"github.com/google/uuid"
+)
+
+func TestMemoryStore(t *testing.T) {
+	Run(t, func(t *testing.T) Harness {
+		store := memory.NewStore()
+		return Harness{
+			Wallets:      store,
+			Transactions: store,
+			TxManager:    store,
+			NewUserID:    func(t *testing.T) uuid.UUID { return uuid.New() },
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package conformance
+
+import (
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/test/pgtest"
+	"github.com/google/uuid"
+)
+
+func TestMain(m *testing.M) {
+	pgtest.Main(m)
+}
+
+// TestPostgres runs the suite against a migrated schema of its own, creating a
+// user per wallet so tests don't share rows
+func TestPostgres(t *testing.T) {
+	db := pgtest.New(t)
+
+	transactionRepo := repository.NewTransactionRepository(db)
+	Run(t, func(t *testing.T) Harness {
+		return Harness{
+			Wallets:      repository.NewWalletRepository(db),
+			Transactions: transactionRepo,
+			TxManager:    transactionRepo.(repository.TxManager),
+			NewUserID: func(t *testing.T) uuid.UUID {
+				return pgtest.CreateUser(t, db)
+			},
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package integration
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/test/pgtest"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// workers stays well below the server's connection limit, as a transfer
+// can hold two connections at once
+const workers = 20
+
+// hammer runs fn from every worker at once, n times in all
+func hammer(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	jobs := make(chan int, n)
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			<-start
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestConcurrentDepositsLoseNothing(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	alice := pgtest.CreateUser(t, f.db)
+
+	_, err := f.wallets.Deposit(ctx, alice, decimal.NewFromInt(100), "USD", "")
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	succeeded := 0
+	hammer(200, func(int) {
+		_, err := f.wallets.Deposit(ctx, alice, decimal.NewFromInt(10), "USD", "")
+		if err != nil {
+			// losing the version check too often is the only acceptable failure
+			assert.Equal(t, errors.Conflict, errors.TypeOf(err))
+			return
+		}
+		mu.Lock()
+		succeeded++
+		mu.Unlock()
+	})
+
+	balance, err := f.wallets.GetBalance(ctx, alice, "USD")
+	require.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromInt(int64(100+10*succeeded))), "balance %s after %d deposits", balance, succeeded)
+	assertReconciled(t, f)
+}
+
+func TestConcurrentWithdrawalsNeverOverdraw(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	alice := pgtest.CreateUser(t, f.db)
+
+	_, err := f.wallets.Deposit(ctx, alice, decimal.NewFromInt(100), "USD", "")
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	succeeded := 0
+	hammer(200, func(int) {
+		if _, err := f.wallets.Withdraw(ctx, alice, decimal.NewFromInt(7), "USD", ""); err == nil {
+			mu.Lock()
+			succeeded++
+			mu.Unlock()
+		}
+	})
+
+	balance, err := f.wallets.GetBalance(ctx, alice, "USD")
+	require.NoError(t, err)
+	assert.False(t, balance.IsNegative())
+	assert.LessOrEqual(t, succeeded, 14)
+	assert.True(t, balance.Equal(decimal.NewFromInt(int64(100-7*succeeded))), "balance %s after %d withdrawals", balance, succeeded)
+	assertReconciled(t, f)
+}
+
+func TestConcurrentTransfersConserveMoney(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	users := make([]uuid.UUID, 4)
+	for i := range users {
+		users[i] = pgtest.CreateUser(t, f.db)
+		_, err := f.wallets.Deposit(ctx, users[i], decimal.NewFromInt(100), "USD", "")
+		require.NoError(t, err)
+	}
+
+	// every pair in both directions, mixed with deposits and withdrawals
+	// that race the transfers' row locks with version checks
+	hammer(400, func(i int) {
+		from, to := users[i%4], users[(i/4+i+1)%4]
+		var err error
+		switch i % 10 {
+		case 0:
+			_, err = f.wallets.Deposit(ctx, from, decimal.NewFromInt(5), "USD", "")
+		case 1:
+			_, err = f.wallets.Withdraw(ctx, from, decimal.NewFromInt(5), "USD", "")
+		default:
+			if from == to {
+				return
+			}
+			_, err = f.wallets.Transfer(ctx, from, to, decimal.NewFromInt(13), "USD", "")
+		}
+		if err != nil {
+			assert.Contains(t, []errors.ErrorType{errors.Conflict, errors.InsufficientFund}, errors.TypeOf(err), err.Error())
+		}
+	})
+
+	for _, user := range users {
+		balance, err := f.wallets.GetBalance(ctx, user, "USD")
+		require.NoError(t, err)
+		assert.False(t, balance.IsNegative())
+	}
+	assertReconciled(t, f)
+}
+
+// assertReconciled checks every balance against the ledger: a lost update
+// leaves a wallet whose balance differs from the sum of its transactions
+func assertReconciled(t *testing.T, f *fixture) {
+	t.Helper()
+	report, err := service.NewReconciliationService(repository.NewReconciliationRepository(f.db), 0).Reconcile(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, report.Total(), "%+v", report.Discrepancies)
+}
@@ -0,0 +1,179 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/api"
+	"github.com/Jiang-hao/walletApiService/internal/auth"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/openapi"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/test/pgtest"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// operatorToken authenticates the test's requests to the operator routes
+const operatorToken = "operator-token"
+
+// newServer routes the wallet, audit, batch and settlement handlers the way the
+// server does, over the fixture's schema, with the wallet routes behind the
+// OpenAPI middleware
+func newServer(t *testing.T, f *fixture) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	walletHandler := api.NewWalletHandler(f.wallets)
+	auditHandler := api.NewAuditHandler(service.NewAuditService(repository.NewAuditRepository(f.db)))
+	settlementHandler := api.NewSettlementHandler(service.NewSettlementService(repository.NewSettlementRepository(f.db)))
+	batchHandler := api.NewBatchHandler(service.NewBatchService(repository.NewBatchRepository(f.db), f.wallets, service.BatchConfig{}))
+
+	spec, err := openapi.New()
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(auth.Middleware(map[string]string{operatorToken: "backoffice"}))
+	v1 := router.Group("/api/v1")
+	// responses are checked against the document too
+	wallet := v1.Group("/wallet", spec.Middleware(openapi.Config{Responses: true}))
+	wallet.POST("/deposit", walletHandler.Deposit)
+	wallet.POST("/withdraw", walletHandler.Withdraw)
+	wallet.POST("/transfer", walletHandler.Transfer)
+	wallet.GET("/balance", walletHandler.GetBalance)
+	wallet.GET("/transactions", walletHandler.GetTransactionHistory)
+	v1.GET("/audit/verify", auth.Require(), auditHandler.VerifyChain)
+	v1.POST("/settlements", auth.Require(), settlementHandler.Import)
+	v1.POST("/batches", auth.Require(), batchHandler.Submit)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// call sends the request and decodes a JSON response into out, if given
+func call(t *testing.T, method, url, body string, out any) int {
+	t.Helper()
+	return callAs(t, "", method, url, body, out)
+}
+
+// callAs is call authenticated with the bearer token, if given
+func callAs(t *testing.T, token, method, url, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	if out != nil {
+		require.NoError(t, json.Unmarshal(raw, out), string(raw))
+	}
+	return resp.StatusCode
+}
+
+func TestHTTPWalletFlow(t *testing.T) {
+	f := newFixture(t)
+	server := newServer(t, f)
+	alice, bob := pgtest.CreateUser(t, f.db), pgtest.CreateUser(t, f.db)
+	wallet := func(path string, user uuid.UUID) string {
+		return server.URL + "/api/v1/wallet/" + path + "?user_id=" + user.String()
+	}
+
+	var deposited model.WalletResponse
+	status := call(t, http.MethodPost, wallet("deposit", alice), `{"amount":"100.50","currency":"USD","reference":"dep-1"}`, &deposited)
+	require.Equal(t, http.StatusOK, status)
+	assert.True(t, deposited.Balance.Equal(decimal.RequireFromString("100.50")))
+
+	var sent model.WalletResponse
+	status = call(t, http.MethodPost, wallet("transfer", alice), `{"to_user_id":"`+bob.String()+`","amount":"40","currency":"USD"}`, &sent)
+	require.Equal(t, http.StatusOK, status)
+	assert.True(t, sent.Balance.Equal(decimal.RequireFromString("60.50")))
+
+	var failed map[string]string
+	status = call(t, http.MethodPost, wallet("withdraw", alice), `{"amount":"1000","currency":"USD"}`, &failed)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Contains(t, failed["error"], "insufficient balance")
+
+	status = call(t, http.MethodPost, wallet("withdraw", alice), `{"amount":"-5","currency":"USD"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status = call(t, http.MethodPost, wallet("withdraw", bob), `{"amount":"15","currency":"USD","reference":"wd-1"}`, nil)
+	require.Equal(t, http.StatusOK, status)
+
+	var balance model.BalanceResponse
+	status = call(t, http.MethodGet, wallet("balance", bob)+"&currency=USD", "", &balance)
+	require.Equal(t, http.StatusOK, status)
+	assert.True(t, balance.Balance.Equal(decimal.NewFromInt(25)))
+
+	var history []model.TransactionResponse
+	status = call(t, http.MethodGet, wallet("transactions", alice)+"&currency=USD", "", &history)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, history, 2)
+	assert.Equal(t, "transfer", history[0].Type)
+	assert.True(t, history[0].BalanceAfter.Equal(decimal.RequireFromString("60.50")))
+
+	settlementFile := "reference,amount,currency\ndep-1,100.50,USD\nwd-1,-15,USD\n"
+	status = call(t, http.MethodPost, server.URL+"/api/v1/settlements?source=bank.csv", settlementFile, nil)
+	require.Equal(t, http.StatusUnauthorized, status)
+	var settled model.SettlementResponse
+	status = callAs(t, operatorToken, http.MethodPost, server.URL+"/api/v1/settlements?source=bank.csv", settlementFile, &settled)
+	require.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, 2, settled.Matched)
+
+	status = call(t, http.MethodGet, server.URL+"/api/v1/audit/verify", "", nil)
+	require.Equal(t, http.StatusUnauthorized, status)
+	var verification model.AuditVerification
+	status = callAs(t, operatorToken, http.MethodGet, server.URL+"/api/v1/audit/verify", "", &verification)
+	require.Equal(t, http.StatusOK, status)
+	assert.True(t, verification.Valid, verification.Reason)
+	assert.Equal(t, 3, verification.EventsChecked)
+}
+
+func TestHTTPBatchesAreOperatorOnly(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	server := newServer(t, f)
+	operator, alice := pgtest.CreateUser(t, f.db), pgtest.CreateUser(t, f.db)
+	_, err := f.wallets.Deposit(ctx, alice, decimal.NewFromInt(20), "USD", "")
+	require.NoError(t, err)
+
+	// a batch debiting someone else's wallet
+	submit := func(token string) int {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/batches?user_id="+operator.String(),
+			strings.NewReader(`{"items":[{"type":"withdraw","user_id":"`+alice.String()+`","amount":"5","currency":"USD"}]}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(api.IdempotencyKeyHeader, "payout-1")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, submit(""))
+	balance, err := f.wallets.GetBalance(ctx, alice, "USD")
+	require.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromInt(20)))
+
+	assert.Equal(t, http.StatusCreated, submit(operatorToken))
+	balance, err = f.wallets.GetBalance(ctx, alice, "USD")
+	require.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromInt(15)))
+}
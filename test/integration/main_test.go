@@ -0,0 +1,34 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/test/pgtest"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestMain(m *testing.M) {
+	pgtest.Main(m)
+}
+
+// fixture wires the wallet service to a fresh schema
+type fixture struct {
+	db      *sqlx.DB
+	wallets service.WalletService
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	db := pgtest.New(t)
+	transactionRepo := repository.NewTransactionRepository(db)
+	return &fixture{
+		db: db,
+		wallets: service.NewWalletService(
+			repository.NewWalletRepository(db),
+			transactionRepo,
+			transactionRepo.(repository.TxManager),
+		),
+	}
+}
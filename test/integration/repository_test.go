@@ -0,0 +1,510 @@
+package integration
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/events"
+	"github.com/Jiang-hao/walletApiService/internal/migrate"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/internal/settlement"
+	"github.com/Jiang-hao/walletApiService/migrations"
+	"github.com/Jiang-hao/walletApiService/test/pgtest"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionHistory(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	alice, bob := pgtest.CreateUser(t, f.db), pgtest.CreateUser(t, f.db)
+
+	_, err := f.wallets.Deposit(ctx, alice, decimal.NewFromInt(100), "USD", "dep-1")
+	require.NoError(t, err)
+	_, err = f.wallets.Deposit(ctx, alice, decimal.NewFromInt(7), "EUR", "dep-2")
+	require.NoError(t, err)
+	_, err = f.wallets.Transfer(ctx, alice, bob, decimal.NewFromInt(40), "USD", "rent")
+	require.NoError(t, err)
+	_, err = f.wallets.Withdraw(ctx, alice, decimal.NewFromInt(10), "USD", "wd-1")
+	require.NoError(t, err)
+
+	usd, err := f.wallets.GetTransactionHistory(ctx, alice, "USD", 1, 10)
+	require.NoError(t, err)
+	require.Len(t, usd, 3)
+	assert.Equal(t, "withdrawal", usd[0].Type)
+	assert.True(t, usd[0].BalanceAfter.Equal(decimal.NewFromInt(50)))
+	assert.Equal(t, "transfer", usd[1].Type)
+	assert.True(t, usd[1].Amount.Equal(decimal.NewFromInt(-40)))
+	assert.Equal(t, "deposit", usd[2].Type)
+
+	page, err := f.wallets.GetTransactionHistory(ctx, alice, "USD", 2, 2)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, usd[2].ID, page[0].ID)
+
+	all, err := f.wallets.GetTransactionHistory(ctx, alice, "", 1, 10)
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+
+	received, err := f.wallets.GetTransactionHistory(ctx, bob, "USD", 1, 10)
+	require.NoError(t, err)
+	require.Len(t, received, 1)
+	require.NotNil(t, received[0].RelatedTxID)
+	assert.Equal(t, usd[1].ID, *received[0].RelatedTxID)
+}
+
+func TestAuditChain(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	alice, bob := pgtest.CreateUser(t, f.db), pgtest.CreateUser(t, f.db)
+
+	_, err := f.wallets.Deposit(ctx, alice, decimal.NewFromInt(50), "USD", "")
+	require.NoError(t, err)
+	_, err = f.wallets.Transfer(ctx, alice, bob, decimal.NewFromInt(20), "USD", "")
+	require.NoError(t, err)
+	_, err = f.wallets.Withdraw(ctx, bob, decimal.NewFromInt(5), "USD", "")
+	require.NoError(t, err)
+
+	audits := service.NewAuditService(repository.NewAuditRepository(f.db))
+	result, err := audits.VerifyChain(ctx)
+	require.NoError(t, err)
+	assert.True(t, result.Valid, result.Reason)
+	assert.Equal(t, 3, result.EventsChecked)
+
+	// alice's wallet and bob's have chains of their own
+	var chains []string
+	require.NoError(t, f.db.Select(&chains, `SELECT DISTINCT chain FROM audit_events ORDER BY chain`))
+	assert.Len(t, chains, 2)
+	assert.NotContains(t, chains, "")
+
+	// the table only takes appends
+	_, err = f.db.Exec(`UPDATE audit_events SET actor = 'someone-else'`)
+	assert.Error(t, err)
+	_, err = f.db.Exec(`DELETE FROM audit_events`)
+	assert.Error(t, err)
+}
+
+func TestOutboxClaim(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	alice := pgtest.CreateUser(t, f.db)
+
+	// the wallet's creation, then the deposit, both events of the wallet
+	_, err := f.wallets.Deposit(ctx, alice, decimal.NewFromInt(5), "USD", "")
+	require.NoError(t, err)
+
+	outbox := repository.NewOutboxRepository(f.db)
+	batch, err := outbox.ClaimBatch(ctx, 10)
+	require.NoError(t, err)
+	claimed := batch.Events()
+	require.Len(t, claimed, 1)
+	assert.Equal(t, events.WalletCreated, claimed[0].EventType)
+
+	// the claimed row is locked and the deposit waits behind it, a second relay gets nothing
+	other, err := outbox.ClaimBatch(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, other.Events())
+	require.NoError(t, other.Commit())
+
+	require.NoError(t, batch.MarkPublished(ctx, claimed[0].ID))
+	require.NoError(t, batch.Commit())
+
+	next, err := outbox.ClaimBatch(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, next.Events(), 1)
+	assert.Equal(t, claimed[0].AggregateID, next.Events()[0].AggregateID)
+	assert.Equal(t, events.FundsDeposited, next.Events()[0].EventType)
+	require.NoError(t, next.Rollback())
+}
+
+func TestBatchLease(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	alice := pgtest.CreateUser(t, f.db)
+	batches := repository.NewBatchRepository(f.db)
+
+	// a synchronous batch whose instance died, its lease ran out a minute ago
+	expired := time.Now().Add(-time.Minute)
+	batch := &model.Batch{
+		ID: uuid.New(), UserID: alice, IdempotencyKey: "k", RequestHash: strings.Repeat("0", 64),
+		Mode: model.BatchBestEffort, Status: model.BatchProcessing, Items: `[]`, ItemCount: 2,
+		CreatedAt: time.Now(), UpdatedAt: time.Now(), LockedUntil: &expired, Claims: 1,
+	}
+	created, err := batches.CreateBatch(ctx, batch)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	claimed, err := batches.ClaimPendingBatch(ctx, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, batch.ID, claimed.ID)
+	assert.Equal(t, 2, claimed.Claims)
+	require.NotNil(t, claimed.LockedUntil)
+	assert.True(t, claimed.LockedUntil.After(time.Now()))
+
+	// leased again, no one else gets it
+	none, err := batches.ClaimPendingBatch(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+
+	// the first claim's worker lost the batch, the second records its progress
+	inFlight := 1
+	batch.InFlight = &inFlight
+	held, err := batches.RecordBatchProgress(ctx, batch, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, held)
+	claimed.InFlight = &inFlight
+	held, err = batches.RecordBatchProgress(ctx, claimed, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, held)
+
+	stored, err := batches.GetBatch(ctx, batch.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.InFlight)
+	assert.Equal(t, 1, *stored.InFlight)
+}
+
+func TestWebhookDeliveryLease(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	webhooks := repository.NewWebhookRepository(f.db)
+
+	sub := &model.WebhookSubscription{
+		ID: uuid.New(), UserID: pgtest.CreateUser(t, f.db), URL: "https://example.com/hook",
+		Secret: "whsec", Active: true, CreatedAt: time.Now(),
+	}
+	require.NoError(t, webhooks.CreateSubscription(ctx, sub))
+	require.NoError(t, webhooks.EnqueueDeliveries(ctx, []model.WebhookDelivery{{
+		ID: uuid.New(), SubscriptionID: sub.ID, EventID: uuid.New(),
+		EventType: events.FundsDeposited, Payload: `{}`,
+	}}))
+
+	// a worker whose send outlived its lease, the delivery is claimed again
+	first, err := webhooks.ClaimDueDeliveries(ctx, 1, -time.Minute)
+	require.NoError(t, err)
+	require.Len(t, first, 1)
+	second, err := webhooks.ClaimDueDeliveries(ctx, 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, second, 1)
+	assert.Equal(t, first[0].Claims+1, second[0].Claims)
+
+	none, err := webhooks.ClaimDueDeliveries(ctx, 1, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	// only the latest claim records its attempt
+	record := func(d model.WebhookDelivery) bool {
+		d.Status, d.Attempts = model.WebhookSucceeded, d.Attempts+1
+		held, err := webhooks.RecordAttempt(ctx, &d, &model.WebhookAttempt{DeliveryID: d.ID, Attempt: d.Attempts})
+		require.NoError(t, err)
+		return held
+	}
+	assert.False(t, record(first[0]))
+	assert.True(t, record(second[0]))
+
+	attempts, err := webhooks.ListAttempts(ctx, first[0].ID)
+	require.NoError(t, err)
+	assert.Len(t, attempts, 1)
+
+	// redelivering takes the delivery from an attempt still in flight
+	require.NoError(t, webhooks.Redeliver(ctx, first[0].ID))
+	assert.False(t, record(second[0]))
+}
+
+func TestSettlementImport(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	alice := pgtest.CreateUser(t, f.db)
+
+	_, err := f.wallets.Deposit(ctx, alice, decimal.NewFromInt(100), "USD", "dep-1")
+	require.NoError(t, err)
+	_, err = f.wallets.Withdraw(ctx, alice, decimal.NewFromInt(45), "USD", "wd-1")
+	require.NoError(t, err)
+
+	settlements := service.NewSettlementService(repository.NewSettlementRepository(f.db))
+	file := "reference,direction,amount,currency\n" +
+		"dep-1,credit,100,USD\n" +
+		"wd-1,debit,40,USD\n" +
+		"dep-9,credit,5,USD\n"
+
+	result, created, err := settlements.Import(ctx, "bank.csv", settlement.FormatCSV, strings.NewReader(file))
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, 1, result.Matched)
+	assert.Equal(t, 1, result.Mismatched)
+	assert.Equal(t, 1, result.Unmatched)
+
+	lines, err := settlements.ListLines(ctx, result.ID, model.SettlementMatched, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, lines, 1)
+	require.NotNil(t, lines[0].TransactionID)
+
+	history, err := f.wallets.GetTransactionHistory(ctx, alice, "USD", 1, 10)
+	require.NoError(t, err)
+	for _, tx := range history {
+		if tx.ID == *lines[0].TransactionID {
+			assert.NotNil(t, tx.SettledAt)
+			assert.Equal(t, result.ID, *tx.SettlementID)
+		} else {
+			assert.Nil(t, tx.SettledAt)
+		}
+	}
+
+	again, created, err := settlements.Import(ctx, "bank-copy.csv", settlement.FormatCSV, strings.NewReader(file))
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, result.ID, again.ID)
+
+	// the deposit is already settled, a later file reporting it again flags it
+	later, _, err := settlements.Import(ctx, "", settlement.FormatCSV, strings.NewReader("reference,amount,currency\ndep-1,100,USD\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, later.Duplicate)
+}
+
+func TestPaymentRequestTransition(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	repo := repository.NewPaymentRequestRepository(f.db)
+
+	pr := &model.PaymentRequest{
+		ID:          uuid.New(),
+		RequesterID: pgtest.CreateUser(t, f.db),
+		PayerID:     pgtest.CreateUser(t, f.db),
+		Amount:      decimal.NewFromInt(25),
+		Currency:    "USD",
+		Status:      model.PaymentRequestPending,
+		CreatedAt:   time.Now().UTC(),
+	}
+	pr.UpdatedAt = pr.CreatedAt
+	pr.ExpiresAt = pr.CreatedAt.Add(time.Hour)
+	require.NoError(t, repo.CreatePaymentRequest(ctx, pr))
+
+	moved, err := repo.TransitionPaymentRequest(ctx, pr.ID, model.PaymentRequestPending, model.PaymentRequestAccepted)
+	require.NoError(t, err)
+	assert.True(t, moved)
+
+	// the status is compared and set in one statement, the second caller loses
+	moved, err = repo.TransitionPaymentRequest(ctx, pr.ID, model.PaymentRequestPending, model.PaymentRequestDeclined)
+	require.NoError(t, err)
+	assert.False(t, moved)
+
+	got, err := repo.GetPaymentRequest(ctx, pr.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.PaymentRequestAccepted, got.Status)
+
+	_, err = repo.GetPaymentRequest(ctx, uuid.New())
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestReconciliation(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	alice, bob := pgtest.CreateUser(t, f.db), pgtest.CreateUser(t, f.db)
+
+	_, err := f.wallets.Deposit(ctx, alice, decimal.NewFromInt(100), "USD", "")
+	require.NoError(t, err)
+	_, err = f.wallets.Transfer(ctx, alice, bob, decimal.NewFromInt(30), "USD", "")
+	require.NoError(t, err)
+
+	reconciler := service.NewReconciliationService(repository.NewReconciliationRepository(f.db), 0)
+	report, err := reconciler.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, report.Total(), "%+v", report.Discrepancies)
+	assert.Equal(t, int64(2), report.Wallets, "only the test's own wallets")
+
+	// a balance changed behind the ledger's back
+	_, err = f.db.Exec(`UPDATE wallets SET balance = balance + 1 WHERE user_id = $1`, bob)
+	require.NoError(t, err)
+	report, err = reconciler.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), report.Counts[model.DiscrepancyBalanceMismatch])
+}
+
+func TestReconciliation_OpeningBalances(t *testing.T) {
+	ctx := context.Background()
+	db := pgtest.NewSeeded(t)
+	reconciler := service.NewReconciliationService(repository.NewReconciliationRepository(db), 0)
+	seedWallet := uuid.MustParse("33333333-3333-3333-3333-333333333333")
+
+	// the seed wallets of 000001 open at their balance, no transaction is made up for it
+	var opening decimal.Decimal
+	require.NoError(t, db.Get(&opening, `SELECT opening_balance FROM wallets WHERE id = $1`, seedWallet))
+	assert.True(t, opening.Equal(decimal.NewFromInt(1000)), opening.String())
+	var transactions int
+	require.NoError(t, db.Get(&transactions, `SELECT COUNT(*) FROM transactions`))
+	assert.Zero(t, transactions)
+	report, err := reconciler.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, report.Total(), "%+v", report.Discrepancies)
+
+	// past balances start from it too, once the wallet exists
+	history := repository.NewBalanceHistoryRepository(db)
+	balance, err := history.GetBalanceAt(ctx, seedWallet, time.Now())
+	require.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromInt(1000)), balance.String())
+	balance, err = history.GetBalanceAt(ctx, seedWallet, time.Now().AddDate(-1, 0, 0))
+	require.NoError(t, err)
+	assert.True(t, balance.IsZero(), balance.String())
+
+	// the first transaction chains from the opening balance
+	alice := pgtest.CreateUser(t, db)
+	var walletID uuid.UUID
+	require.NoError(t, db.Get(&walletID, `INSERT INTO wallets (user_id, balance, opening_balance, currency)
+		VALUES ($1, 50, 40, 'USD') RETURNING id`, alice))
+	_, err = db.Exec(`INSERT INTO transactions (wallet_id, user_id, amount, currency, balance_before, balance_after, type)
+		VALUES ($1, $2, 10, 'USD', 40, 50, 'deposit')`, walletID, alice)
+	require.NoError(t, err)
+	report, err = reconciler.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, report.Total(), "%+v", report.Discrepancies)
+
+	// later rows still chain from the one before
+	_, err = db.Exec(`UPDATE wallets SET balance = 55 WHERE id = $1`, walletID)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO transactions (wallet_id, user_id, amount, currency, balance_before, balance_after, type)
+		VALUES ($1, $2, 10, 'USD', 45, 55, 'deposit')`, walletID, alice)
+	require.NoError(t, err)
+	report, err = reconciler.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), report.Counts[model.DiscrepancyBrokenChain], "%+v", report.Discrepancies)
+	assert.Zero(t, report.Counts[model.DiscrepancyBalanceMismatch])
+
+	// a wallet funded without an opening balance or a transaction is drift
+	bob := pgtest.CreateUser(t, db)
+	_, err = db.Exec(`INSERT INTO wallets (user_id, balance, currency) VALUES ($1, 5, 'EUR')`, bob)
+	require.NoError(t, err)
+	report, err = reconciler.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), report.Counts[model.DiscrepancyBalanceMismatch], "%+v", report.Discrepancies)
+	assert.Equal(t, int64(1), report.Counts[model.DiscrepancyCurrencyTotal], "%+v", report.Discrepancies)
+}
+
+func TestOpeningBalanceMigration(t *testing.T) {
+	db := pgtest.NewMigratedTo(t, "000015_batch_leases")
+
+	// a seed wallet whose ledger starts after it was funded
+	alice := uuid.MustParse(pgtest.SeedUsers[0])
+	_, err := db.Exec(`UPDATE wallets SET balance = 1010 WHERE user_id = $1`, alice)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO transactions (wallet_id, user_id, amount, currency, balance_before, balance_after, type)
+		SELECT id, user_id, 10, 'USD', 1000, 1010, 'deposit' FROM wallets WHERE user_id = $1`, alice)
+	require.NoError(t, err)
+
+	require.NoError(t, pgtest.Migrate(db))
+
+	openings := map[string]decimal.Decimal{}
+	rows, err := db.Queryx(`SELECT user_id, opening_balance FROM wallets`)
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var user string
+		var opening decimal.Decimal
+		require.NoError(t, rows.Scan(&user, &opening))
+		openings[user] = opening
+	}
+	require.NoError(t, rows.Err())
+	assert.True(t, openings[pgtest.SeedUsers[0]].Equal(decimal.NewFromInt(1000)), "from the first balance_before")
+	assert.True(t, openings[pgtest.SeedUsers[1]].Equal(decimal.NewFromInt(500)), "from the balance")
+
+	var transactions int
+	require.NoError(t, db.Get(&transactions, `SELECT COUNT(*) FROM transactions`))
+	assert.Equal(t, 1, transactions, "no transaction is made up")
+}
+
+func TestFreezeWallet(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	transactionRepo := repository.NewTransactionRepository(f.db)
+	admin := service.NewWalletAdminService(repository.NewAdjustmentRepository(f.db), repository.NewWalletRepository(f.db),
+		transactionRepo, transactionRepo.(repository.TxManager))
+	alice, bob := pgtest.CreateUser(t, f.db), pgtest.CreateUser(t, f.db)
+
+	_, err := f.wallets.Deposit(ctx, alice, decimal.NewFromInt(100), "USD", "")
+	require.NoError(t, err)
+	frozen, err := admin.Freeze(ctx, alice, "USD", "chargeback")
+	require.NoError(t, err)
+
+	_, err = f.wallets.Transfer(ctx, alice, bob, decimal.NewFromInt(10), "USD", "")
+	assert.Equal(t, errors.WalletFrozen, errors.TypeOf(err))
+	_, err = f.wallets.Deposit(ctx, alice, decimal.NewFromInt(1), "USD", "")
+	require.NoError(t, err)
+
+	wallets, err := admin.ListWallets(ctx, alice)
+	require.NoError(t, err)
+	require.Len(t, wallets, 1)
+	require.True(t, wallets[0].Frozen())
+	assert.True(t, frozen.FrozenAt.Equal(*wallets[0].FrozenAt))
+	assert.Equal(t, "chargeback", wallets[0].FrozenReason)
+}
+
+func TestAdjustmentApproval(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	transactionRepo := repository.NewTransactionRepository(f.db)
+	wallets := service.NewWalletService(repository.NewWalletRepository(f.db), transactionRepo, transactionRepo.(repository.TxManager),
+		service.WithAdjustmentApprovalThreshold(decimal.NewFromInt(50)))
+	adjustments := repository.NewAdjustmentRepository(f.db)
+	alice := pgtest.CreateUser(t, f.db)
+
+	_, err := wallets.Deposit(ctx, alice, decimal.NewFromInt(100), "USD", "")
+	require.NoError(t, err)
+	small, err := wallets.Adjust(ctx, model.AdjustmentRequest{
+		UserID: alice, Currency: "USD", Amount: decimal.NewFromInt(-20), ReasonCode: model.AdjustmentDuplicate, Note: "dep twice", Operator: "ops-1",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentApplied, small.Status)
+	large, err := wallets.Adjust(ctx, model.AdjustmentRequest{
+		UserID: alice, Currency: "USD", Amount: decimal.NewFromInt(500), ReasonCode: model.AdjustmentIncident, Operator: "ops-1",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentPending, large.Status)
+
+	_, err = wallets.ApproveAdjustment(ctx, large.ID, "ops-1")
+	assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+	approved, err := wallets.ApproveAdjustment(ctx, large.ID, "ops-2")
+	require.NoError(t, err)
+
+	stored, err := adjustments.GetAdjustment(ctx, large.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentApplied, stored.Status)
+	assert.Equal(t, *approved.TransactionID, *stored.TransactionID)
+	assert.Equal(t, "ops-2", *stored.ReviewedBy)
+
+	history, err := wallets.GetTransactionHistory(ctx, alice, "USD", 1, 10)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, model.TxAdjustment, history[0].Type)
+	assert.True(t, history[0].BalanceAfter.Equal(decimal.NewFromInt(580)))
+	assert.Equal(t, "duplicate: dep twice", history[1].Reference)
+
+	pending, err := adjustments.ListAdjustments(ctx, model.AdjustmentPending, 0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	all, err := adjustments.ListAdjustments(ctx, "", 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+}
+
+func TestMigrationsRecorded(t *testing.T) {
+	ctx := context.Background()
+	db := pgtest.New(t)
+
+	applied, err := migrate.Up(ctx, db, migrations.FS)
+	require.NoError(t, err)
+	assert.Empty(t, applied, "pgtest applied them all")
+
+	status, err := migrate.Status(ctx, db, migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, status)
+	for _, m := range status {
+		assert.True(t, m.Applied(), m.Version)
+	}
+	assert.Equal(t, "000001_init_schema", status[0].Version)
+}
@@ -0,0 +1,199 @@
+// Package pgtest gives integration tests a Postgres schema of their own, with
+// the migrations applied. The server is the one WALLET_TEST_DSN names, or
+// with WALLET_TEST_POSTGRES=embedded a throwaway one started from the
+// embedded-postgres binaries, downloaded on first use. With neither set the
+// tests that need Postgres skip.
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Jiang-hao/walletApiService/internal/migrate"
+	"github.com/Jiang-hao/walletApiService/migrations"
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	EnvDSN      = "WALLET_TEST_DSN"
+	EnvPostgres = "WALLET_TEST_POSTGRES"
+)
+
+// serverDSN is set by Main when it started the embedded server
+var serverDSN string
+
+// Main runs a package's tests, starting the embedded server first when it is
+// asked for and stopping it after. Call it from TestMain.
+func Main(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	if os.Getenv(EnvDSN) == "" && os.Getenv(EnvPostgres) == "embedded" {
+		stop, err := startEmbedded()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "pgtest:", err)
+			return 1
+		}
+		defer stop()
+	}
+	return m.Run()
+}
+
+func startEmbedded() (stop func() error, err error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "walletapi-pgtest-")
+	if err != nil {
+		return nil, err
+	}
+
+	pg := embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().
+		Version(embeddedpostgres.V16).
+		Port(uint32(port)).
+		Database("walletapi").
+		RuntimePath(dir).
+		Logger(io.Discard))
+	if err := pg.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("starting embedded postgres: %w", err)
+	}
+
+	serverDSN = fmt.Sprintf("host=localhost port=%d user=postgres password=postgres dbname=walletapi sslmode=disable", port)
+	return func() error {
+		defer os.RemoveAll(dir)
+		return pg.Stop()
+	}, nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// DSN returns the server's connection string, skipping t when there is none
+func DSN(t testing.TB) string {
+	t.Helper()
+	if dsn := os.Getenv(EnvDSN); dsn != "" {
+		return dsn
+	}
+	if serverDSN == "" {
+		t.Skipf("set %s, or %s=embedded, to run against Postgres", EnvDSN, EnvPostgres)
+	}
+	return serverDSN
+}
+
+// SeedUsers are the users, with a USD wallet each, that 000001 inserts
+var SeedUsers = []string{"11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222"}
+
+// New is NewSeeded without the seed users, their wallets and transactions,
+// so a test only sees the rows it wrote
+func New(t testing.TB) *sqlx.DB {
+	t.Helper()
+	db := NewSeeded(t)
+	_, err := db.Exec(`DELETE FROM users WHERE id = ANY($1)`, pq.Array(SeedUsers))
+	require.NoError(t, err)
+	return db
+}
+
+// NewSeeded creates an empty schema, migrates it and returns a pool whose
+// connections all use it. The schema is dropped when t finishes.
+func NewSeeded(t testing.TB) *sqlx.DB {
+	t.Helper()
+	db := newSchema(t)
+	require.NoError(t, Migrate(db))
+	return db
+}
+
+// NewMigratedTo is NewSeeded with only the migrations up to and including
+// version applied, so a test can write the rows a later migration finds and
+// apply the rest with Migrate
+func NewMigratedTo(t testing.TB, version string) *sqlx.DB {
+	t.Helper()
+	db := newSchema(t)
+
+	upTo := fstest.MapFS{}
+	names, err := fs.Glob(migrations.FS, "*.up.sql")
+	require.NoError(t, err)
+	for _, name := range names {
+		if strings.TrimSuffix(name, ".up.sql") > version {
+			continue
+		}
+		data, err := fs.ReadFile(migrations.FS, name)
+		require.NoError(t, err)
+		upTo[name] = &fstest.MapFile{Data: data}
+	}
+	_, err = migrate.Up(context.Background(), db, upTo)
+	require.NoError(t, err)
+	return db
+}
+
+// newSchema creates an empty schema and returns a pool whose connections all
+// use it. The schema is dropped when t finishes.
+func newSchema(t testing.TB) *sqlx.DB {
+	t.Helper()
+	dsn := DSN(t)
+
+	admin, err := sqlx.Connect("postgres", dsn)
+	require.NoError(t, err)
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	_, err = admin.Exec(`CREATE SCHEMA ` + schema)
+	require.NoError(t, err)
+
+	db, err := sqlx.Connect("postgres", withSearchPath(dsn, schema))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+		admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		admin.Close()
+	})
+	return db
+}
+
+// withSearchPath adds search_path to the DSN, which lib/pq sends as a
+// run-time parameter when each connection starts
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if u, err := url.Parse(dsn); err == nil {
+			q := u.Query()
+			q.Set("search_path", schema)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " search_path=" + schema
+}
+
+// Migrate applies every up migration in order
+func Migrate(db *sqlx.DB) error {
+	_, err := migrate.Up(context.Background(), db, migrations.FS)
+	return err
+}
+
+// CreateUser inserts a user for wallets to reference
+func CreateUser(t testing.TB, db *sqlx.DB) uuid.UUID {
+	t.Helper()
+	id := uuid.New()
+	name := "user-" + id.String()[:8]
+	_, err := db.Exec(`INSERT INTO users (id, username, email) VALUES ($1, $2, $3)`, id, name, name+"@example.com")
+	require.NoError(t, err)
+	return id
+}
@@ -0,0 +1,264 @@
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository/memory"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type adjustmentFixture struct {
+	store  *memory.Store
+	wallet service.WalletService
+	admin  service.WalletAdminService
+	user   uuid.UUID
+}
+
+// newAdjustmentFixture funds a user's USD wallet with 100, adjustments above
+// 50 need approval
+func newAdjustmentFixture(t *testing.T) *adjustmentFixture {
+	store := memory.NewStore()
+	f := &adjustmentFixture{
+		store:  store,
+		wallet: service.NewWalletService(store, store, store, service.WithAdjustmentApprovalThreshold(decimal.NewFromInt(50))),
+		admin:  service.NewWalletAdminService(store, store, store, store),
+		user:   uuid.New(),
+	}
+	_, err := f.wallet.Deposit(context.Background(), f.user, decimal.NewFromInt(100), "USD", "")
+	require.NoError(t, err)
+	return f
+}
+
+func (f *adjustmentFixture) adjust(amount int64, operator string) (*model.Adjustment, error) {
+	return f.wallet.Adjust(context.Background(), model.AdjustmentRequest{
+		UserID:     f.user,
+		Currency:   "USD",
+		Amount:     decimal.NewFromInt(amount),
+		ReasonCode: model.AdjustmentIncident,
+		Note:       "INC-42",
+		Operator:   operator,
+	})
+}
+
+func (f *adjustmentFixture) balance(t *testing.T) decimal.Decimal {
+	balance, err := f.wallet.GetBalance(context.Background(), f.user, "USD")
+	require.NoError(t, err)
+	return balance
+}
+
+func TestAdjust_BelowThresholdApplies(t *testing.T) {
+	f := newAdjustmentFixture(t)
+
+	adjustment, err := f.adjust(-30, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentApplied, adjustment.Status)
+	require.NotNil(t, adjustment.TransactionID)
+	assert.Nil(t, adjustment.ReviewedBy)
+	assert.True(t, f.balance(t).Equal(decimal.NewFromInt(70)))
+
+	history, err := f.wallet.GetTransactionHistory(context.Background(), f.user, "USD", 1, 10)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, *adjustment.TransactionID, history[0].ID)
+	assert.Equal(t, model.TxAdjustment, history[0].Type)
+	assert.Equal(t, "incident: INC-42", history[0].Reference)
+	assert.True(t, history[0].Amount.Equal(decimal.NewFromInt(-30)))
+
+	events := f.store.AuditEvents()
+	require.Len(t, events, 3, "the deposit, the balance change and the adjustment")
+	assert.Equal(t, audit.ActionAdjustment, events[1].Action)
+	assert.Equal(t, "operator:alice", events[1].Actor)
+	assert.Equal(t, audit.ActionAdjustmentRequest, events[2].Action)
+	assert.Equal(t, audit.TargetAdjustment, events[2].TargetType)
+	assert.Equal(t, adjustment.ID.String(), events[2].TargetID)
+	assert.Equal(t, "operator:alice", events[2].Actor)
+}
+
+func TestAdjust_AboveThresholdNeedsASecondOperator(t *testing.T) {
+	f := newAdjustmentFixture(t)
+	ctx := context.Background()
+
+	adjustment, err := f.adjust(80, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentPending, adjustment.Status)
+	assert.Nil(t, adjustment.TransactionID)
+	assert.True(t, f.balance(t).Equal(decimal.NewFromInt(100)), "nothing moves before the approval")
+
+	pending, err := f.admin.ListAdjustments(ctx, model.AdjustmentPending, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, adjustment.ID, pending[0].ID)
+
+	_, err = f.wallet.ApproveAdjustment(ctx, adjustment.ID, "alice")
+	assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err), "four eyes")
+	_, err = f.wallet.ApproveAdjustment(ctx, adjustment.ID, "")
+	assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+
+	approved, err := f.wallet.ApproveAdjustment(ctx, adjustment.ID, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentApplied, approved.Status)
+	require.NotNil(t, approved.ReviewedBy)
+	assert.Equal(t, "bob", *approved.ReviewedBy)
+	require.NotNil(t, approved.TransactionID)
+	assert.NotNil(t, approved.ResolvedAt)
+	assert.True(t, f.balance(t).Equal(decimal.NewFromInt(180)))
+
+	_, err = f.wallet.ApproveAdjustment(ctx, adjustment.ID, "carol")
+	assert.Equal(t, errors.Conflict, errors.TypeOf(err), "applied once")
+	_, err = f.wallet.RejectAdjustment(ctx, adjustment.ID, "carol")
+	assert.Equal(t, errors.Conflict, errors.TypeOf(err))
+
+	events := f.store.AuditEvents()
+	last := events[len(events)-1]
+	assert.Equal(t, audit.ActionAdjustmentApprove, last.Action)
+	assert.Equal(t, "operator:bob", last.Actor)
+	require.NotNil(t, last.Before)
+	assert.Contains(t, *last.Before, `"status":"pending"`)
+	assert.Contains(t, *last.After, `"reviewed_by":"bob"`)
+	assert.Equal(t, audit.ActionAdjustment, events[len(events)-2].Action)
+	assert.Equal(t, "operator:bob", events[len(events)-2].Actor)
+
+	applied, err := f.admin.ListAdjustments(ctx, model.AdjustmentApplied, 1, 10)
+	require.NoError(t, err)
+	assert.Len(t, applied, 1)
+}
+
+func TestAdjust_Reject(t *testing.T) {
+	f := newAdjustmentFixture(t)
+	ctx := context.Background()
+
+	adjustment, err := f.adjust(-90, "alice")
+	require.NoError(t, err)
+	rejected, err := f.wallet.RejectAdjustment(ctx, adjustment.ID, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentRejected, rejected.Status)
+	assert.Nil(t, rejected.TransactionID)
+	assert.True(t, f.balance(t).Equal(decimal.NewFromInt(100)))
+
+	_, err = f.wallet.ApproveAdjustment(ctx, adjustment.ID, "carol")
+	assert.Equal(t, errors.Conflict, errors.TypeOf(err))
+	_, err = f.wallet.ApproveAdjustment(ctx, uuid.New(), "carol")
+	assert.Equal(t, errors.NotFound, errors.TypeOf(err))
+
+	events := f.store.AuditEvents()
+	assert.Equal(t, audit.ActionAdjustmentReject, events[len(events)-1].Action)
+}
+
+func TestAdjust_Validation(t *testing.T) {
+	f := newAdjustmentFixture(t)
+
+	_, err := f.adjust(0, "alice")
+	assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+	_, err = f.adjust(5, " ")
+	assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err), "an operator is required")
+	_, err = f.wallet.Adjust(context.Background(), model.AdjustmentRequest{
+		UserID: f.user, Currency: "USD", Amount: decimal.NewFromInt(5), Operator: "alice",
+	})
+	assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err), "a reason code is required")
+
+	// never below zero, neither directly nor on approval
+	approved, err := f.adjust(-70, "alice")
+	require.NoError(t, err)
+	_, err = f.wallet.ApproveAdjustment(context.Background(), approved.ID, "bob")
+	require.NoError(t, err)
+	_, err = f.adjust(-45, "alice")
+	assert.Equal(t, errors.InsufficientFund, errors.TypeOf(err))
+	large, err := f.adjust(-70, "alice")
+	require.NoError(t, err)
+	_, err = f.wallet.ApproveAdjustment(context.Background(), large.ID, "bob")
+	assert.Equal(t, errors.InsufficientFund, errors.TypeOf(err))
+	assert.True(t, f.balance(t).Equal(decimal.NewFromInt(30)))
+
+	pending, err := f.admin.ListAdjustments(context.Background(), model.AdjustmentPending, 1, 10)
+	require.NoError(t, err)
+	assert.Len(t, pending, 1, "a failed approval leaves it pending")
+	_, err = f.admin.ListAdjustments(context.Background(), "unknown", 1, 10)
+	assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+}
+
+func TestAdjust_FrozenWallet(t *testing.T) {
+	f := newAdjustmentFixture(t)
+	ctx := context.Background()
+	_, err := f.admin.Freeze(ctx, f.user, "USD", "investigation")
+	require.NoError(t, err)
+
+	// operators correct balances whatever the wallet's state
+	adjustment, err := f.adjust(-10, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentApplied, adjustment.Status)
+	assert.True(t, f.balance(t).Equal(decimal.NewFromInt(90)))
+}
+
+// The threshold bounds what a wallet is adjusted without review in a day, so
+// a large adjustment split into small ones still needs an approval
+func TestAdjust_DailyTotalNeedsApproval(t *testing.T) {
+	f := newAdjustmentFixture(t)
+	ctx := context.Background()
+
+	first, err := f.adjust(-30, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentApplied, first.Status)
+	second, err := f.adjust(20, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentApplied, second.Status, "credits count too, 50 in all")
+	third, err := f.adjust(-1, "carol")
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentPending, third.Status, "whoever asks for it")
+	assert.True(t, f.balance(t).Equal(decimal.NewFromInt(90)))
+
+	// approved ones don't count
+	_, err = f.wallet.ApproveAdjustment(ctx, third.ID, "bob")
+	require.NoError(t, err)
+	large, err := f.adjust(60, "alice")
+	require.NoError(t, err)
+	_, err = f.wallet.ApproveAdjustment(ctx, large.ID, "bob")
+	require.NoError(t, err)
+	fourth, err := f.adjust(1, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentPending, fourth.Status)
+
+	// other wallets have totals of their own
+	other, err := f.wallet.Adjust(ctx, model.AdjustmentRequest{
+		UserID: uuid.New(), Currency: "USD", Amount: decimal.NewFromInt(50), ReasonCode: model.AdjustmentGoodwill, Operator: "alice",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentApplied, other.Status)
+}
+
+func TestAdjust_PerCurrencyThresholds(t *testing.T) {
+	store := memory.NewStore()
+	svc := service.NewWalletService(store, store, store,
+		service.WithAdjustmentApprovalThreshold(decimal.NewFromInt(50)),
+		service.WithAdjustmentApprovalThresholds(map[string]decimal.Decimal{"JPY": decimal.NewFromInt(-7000)}))
+	adjust := func(currency string, amount int64) *model.Adjustment {
+		adjustment, err := svc.Adjust(context.Background(), model.AdjustmentRequest{
+			UserID: uuid.New(), Currency: currency, Amount: decimal.NewFromInt(amount), ReasonCode: model.AdjustmentGoodwill, Operator: "alice",
+		})
+		require.NoError(t, err)
+		return adjustment
+	}
+
+	assert.Equal(t, model.AdjustmentApplied, adjust("JPY", 7000).Status)
+	assert.Equal(t, model.AdjustmentPending, adjust("JPY", 7001).Status)
+	assert.Equal(t, model.AdjustmentApplied, adjust("USD", 50).Status)
+	assert.Equal(t, model.AdjustmentPending, adjust("EUR", 51).Status, "the default for the others")
+}
+
+func TestAdjust_ZeroThresholdApprovesEverything(t *testing.T) {
+	store := memory.NewStore()
+	svc := service.NewWalletService(store, store, store, service.WithAdjustmentApprovalThreshold(decimal.Zero))
+
+	adjustment, err := svc.Adjust(context.Background(), model.AdjustmentRequest{
+		UserID: uuid.New(), Currency: "USD", Amount: decimal.RequireFromString("0.01"), ReasonCode: model.AdjustmentGoodwill, Operator: "alice",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentPending, adjustment.Status)
+}
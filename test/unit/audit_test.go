@@ -0,0 +1,205 @@
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockAuditRepository struct {
+	mock.Mock
+}
+
+func (m *MockAuditRepository) ListEvents(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, error) {
+	args := m.Called(ctx, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.AuditEvent), args.Error(1)
+}
+
+func (m *MockAuditRepository) ListChain(ctx context.Context, afterID int64, limit int) ([]model.AuditEvent, error) {
+	args := m.Called(ctx, afterID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.AuditEvent), args.Error(1)
+}
+
+func (m *MockAuditRepository) CreateAuditEventTx(ctx context.Context, tx *sqlx.Tx, event *model.AuditEvent) error {
+	args := m.Called(ctx, tx, event)
+	return args.Error(0)
+}
+
+// buildChain links events the same way the repository does on insert, in
+// round robin over the wallets' chains
+func buildChain(t *testing.T, n, wallets int) []model.AuditEvent {
+	ctx := audit.WithMetadata(context.Background(), audit.Metadata{RequestID: "req-1", ClientIP: "10.0.0.1"})
+	ids := make([]uuid.UUID, wallets)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+	heads := make(map[string]string)
+	events := make([]model.AuditEvent, 0, n)
+	for i := 0; i < n; i++ {
+		w := &model.Wallet{ID: ids[i%wallets], Balance: decimal.NewFromInt(int64(i)), Version: i}
+		e, err := audit.NewEvent(ctx, audit.UserActor(uuid.New()), audit.ActionDeposit, audit.TargetWallet, w.ID.String(),
+			audit.SnapshotWallet(w), audit.SnapshotWallet(w))
+		require.NoError(t, err)
+		prev, ok := heads[e.Chain]
+		if !ok {
+			prev = audit.GenesisHash
+		}
+		e.ID = int64(i + 1)
+		e.PrevHash = prev
+		e.Hash = audit.ComputeHash(prev, e)
+		heads[e.Chain] = e.Hash
+		events = append(events, *e)
+	}
+	return events
+}
+
+func TestAudit_NewEventUsesRequestMetadata(t *testing.T) {
+	userID := uuid.New()
+	ctx := audit.WithMetadata(context.Background(), audit.Metadata{
+		Actor:     "operator:alice",
+		RequestID: "req-42",
+		ClientIP:  "192.0.2.1",
+		UserAgent: "curl/8.0",
+	})
+
+	e, err := audit.NewEvent(ctx, audit.UserActor(userID), audit.ActionWithdrawal, audit.TargetWallet, "w1", nil, map[string]string{"balance": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, "operator:alice", e.Actor)
+	assert.Equal(t, "req-42", e.RequestID)
+	assert.Equal(t, "192.0.2.1", e.ClientIP)
+	assert.Nil(t, e.Before)
+	require.NotNil(t, e.After)
+	assert.JSONEq(t, `{"balance":"1"}`, *e.After)
+
+	e, err = audit.NewEvent(context.Background(), audit.UserActor(userID), audit.ActionDeposit, audit.TargetWallet, "w1", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "user:"+userID.String(), e.Actor)
+}
+
+func TestAudit_VerifyChain(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("intact chain", func(t *testing.T) {
+		repo := &MockAuditRepository{}
+		repo.On("ListChain", ctx, int64(0), mock.AnythingOfType("int")).Return(buildChain(t, 5, 1), nil)
+
+		result, err := service.NewAuditService(repo).VerifyChain(ctx)
+		require.NoError(t, err)
+		assert.True(t, result.Valid)
+		assert.Equal(t, 5, result.EventsChecked)
+	})
+
+	t.Run("tampered event", func(t *testing.T) {
+		events := buildChain(t, 5, 1)
+		tampered := `{"balance":"1000000"}`
+		events[2].After = &tampered
+
+		repo := &MockAuditRepository{}
+		repo.On("ListChain", ctx, int64(0), mock.AnythingOfType("int")).Return(events, nil)
+
+		result, err := service.NewAuditService(repo).VerifyChain(ctx)
+		require.NoError(t, err)
+		assert.False(t, result.Valid)
+		require.NotNil(t, result.FirstInvalidID)
+		assert.Equal(t, int64(3), *result.FirstInvalidID)
+	})
+
+	t.Run("deleted event", func(t *testing.T) {
+		events := buildChain(t, 5, 1)
+		events = append(events[:1], events[2:]...)
+
+		repo := &MockAuditRepository{}
+		repo.On("ListChain", ctx, int64(0), mock.AnythingOfType("int")).Return(events, nil)
+
+		result, err := service.NewAuditService(repo).VerifyChain(ctx)
+		require.NoError(t, err)
+		assert.False(t, result.Valid)
+		assert.Equal(t, int64(3), *result.FirstInvalidID)
+		assert.Equal(t, "prev_hash does not match the previous event", result.Reason)
+	})
+
+	verify := func(t *testing.T, events []model.AuditEvent) *model.AuditVerification {
+		repo := &MockAuditRepository{}
+		repo.On("ListChain", ctx, int64(0), mock.AnythingOfType("int")).Return(events, nil)
+		result, err := service.NewAuditService(repo).VerifyChain(ctx)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("interleaved chains", func(t *testing.T) {
+		result := verify(t, buildChain(t, 9, 3))
+		assert.True(t, result.Valid, result.Reason)
+		assert.Equal(t, 9, result.EventsChecked)
+	})
+
+	t.Run("event deleted from one of several chains", func(t *testing.T) {
+		events := buildChain(t, 9, 3)
+		// the second event of the second wallet's chain
+		events = append(events[:4], events[5:]...)
+
+		result := verify(t, events)
+		assert.False(t, result.Valid)
+		assert.Equal(t, int64(8), *result.FirstInvalidID)
+	})
+
+	t.Run("event moved to another chain", func(t *testing.T) {
+		events := buildChain(t, 4, 2)
+		events[2].Chain = events[1].Chain
+
+		result := verify(t, events)
+		assert.False(t, result.Valid)
+		assert.Equal(t, int64(3), *result.FirstInvalidID)
+		assert.Equal(t, "chain does not match the event's target", result.Reason)
+	})
+
+	t.Run("events from before per-target chains", func(t *testing.T) {
+		legacy := buildChain(t, 4, 1)
+		prev := audit.GenesisHash
+		for i := range legacy {
+			legacy[i].Chain = ""
+			legacy[i].PrevHash = prev
+			legacy[i].Hash = audit.ComputeHash(prev, &legacy[i])
+			prev = legacy[i].Hash
+		}
+		events := append(legacy, buildChain(t, 4, 2)...)
+		for i := range events {
+			events[i].ID = int64(i + 1)
+		}
+
+		result := verify(t, events)
+		assert.True(t, result.Valid, result.Reason)
+		assert.Equal(t, 8, result.EventsChecked)
+	})
+}
+
+func TestAudit_ListEventsValidation(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockAuditRepository{}
+	svc := service.NewAuditService(repo)
+
+	_, err := svc.ListEvents(ctx, model.AuditFilter{}, 0, 10)
+	assert.Error(t, err)
+
+	_, err = svc.ListEvents(ctx, model.AuditFilter{}, 1, 101)
+	assert.Error(t, err)
+
+	repo.On("ListEvents", ctx, model.AuditFilter{Actor: "user:x", Offset: 20, Limit: 10}).Return([]model.AuditEvent{}, nil)
+	_, err = svc.ListEvents(ctx, model.AuditFilter{Actor: "user:x"}, 3, 10)
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+}
@@ -0,0 +1,135 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockBalanceHistoryRepository struct {
+	mock.Mock
+}
+
+func (m *MockBalanceHistoryRepository) GetBalanceAt(ctx context.Context, walletID uuid.UUID, at time.Time) (decimal.Decimal, error) {
+	args := m.Called(ctx, walletID, at)
+	return args.Get(0).(decimal.Decimal), args.Error(1)
+}
+
+func (m *MockBalanceHistoryRepository) CreateSnapshots(ctx context.Context, day time.Time) (int64, error) {
+	args := m.Called(ctx, day)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockBalanceHistoryRepository) GetDailyBalances(ctx context.Context, walletID uuid.UUID, from, to time.Time) ([]model.DailyBalance, error) {
+	args := m.Called(ctx, walletID, from, to)
+	return args.Get(0).([]model.DailyBalance), args.Error(1)
+}
+
+func TestBalanceHistory_GetBalanceAt(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	wallet := &model.Wallet{ID: uuid.New(), UserID: userID, Currency: "USD"}
+	at := time.Now().Add(-48 * time.Hour)
+
+	t.Run("balance after the last transaction before at", func(t *testing.T) {
+		repo, wr := &MockBalanceHistoryRepository{}, &MockWalletRepository{}
+		wr.On("GetWalletByUserAndCurrency", ctx, userID, "USD").Return(wallet, nil)
+		repo.On("GetBalanceAt", ctx, wallet.ID, at).Return(decimal.NewFromInt(75), nil)
+
+		balance, err := service.NewBalanceHistoryService(repo, wr).GetBalanceAt(ctx, userID, "USD", at)
+		require.NoError(t, err)
+		assert.True(t, balance.Equal(decimal.NewFromInt(75)))
+	})
+
+	t.Run("future timestamp", func(t *testing.T) {
+		repo, wr := &MockBalanceHistoryRepository{}, &MockWalletRepository{}
+
+		_, err := service.NewBalanceHistoryService(repo, wr).GetBalanceAt(ctx, userID, "USD", time.Now().Add(time.Hour))
+		assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+		wr.AssertNotCalled(t, "GetWalletByUserAndCurrency", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("no wallet in currency", func(t *testing.T) {
+		repo, wr := &MockBalanceHistoryRepository{}, &MockWalletRepository{}
+		wr.On("GetWalletByUserAndCurrency", ctx, userID, "EUR").
+			Return((*model.Wallet)(nil), errors.NewNotFound("wallet.GetByUserAndCurrency", "wallet"))
+
+		_, err := service.NewBalanceHistoryService(repo, wr).GetBalanceAt(ctx, userID, "EUR", at)
+		assert.Equal(t, errors.NotFound, errors.TypeOf(err))
+	})
+}
+
+func TestBalanceHistory_GetDailyBalances(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	wallet := &model.Wallet{ID: uuid.New(), UserID: userID, Currency: "USD"}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	t.Run("days are UTC", func(t *testing.T) {
+		repo, wr := &MockBalanceHistoryRepository{}, &MockWalletRepository{}
+		wr.On("GetWalletByUserAndCurrency", ctx, userID, "USD").Return(wallet, nil)
+		from := today.AddDate(0, 0, -2)
+		repo.On("GetDailyBalances", ctx, wallet.ID, from, today).Return([]model.DailyBalance{
+			{Date: from, Balance: decimal.NewFromInt(10)},
+			{Date: from.AddDate(0, 0, 1), Balance: decimal.NewFromInt(10)},
+			{Date: today, Balance: decimal.NewFromInt(25)},
+		}, nil)
+
+		// late evening west of UTC is already the next UTC day
+		local := time.FixedZone("UTC-8", -8*60*60)
+		balances, err := service.NewBalanceHistoryService(repo, wr).GetDailyBalances(ctx, userID, "USD",
+			from.Add(12*time.Hour).In(local), today.Add(time.Hour).In(local))
+		require.NoError(t, err)
+		assert.Len(t, balances, 3)
+	})
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+	}{
+		{"to in the future", today, today.AddDate(0, 0, 1)},
+		{"from after to", today, today.AddDate(0, 0, -1)},
+		{"more than a year", today.AddDate(0, 0, -366), today},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, wr := &MockBalanceHistoryRepository{}, &MockWalletRepository{}
+
+			_, err := service.NewBalanceHistoryService(repo, wr).GetDailyBalances(ctx, userID, "USD", tt.from, tt.to)
+			assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+			repo.AssertNotCalled(t, "GetDailyBalances", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestBalanceHistory_SnapshotDay(t *testing.T) {
+	ctx := context.Background()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	t.Run("completed day", func(t *testing.T) {
+		repo := &MockBalanceHistoryRepository{}
+		day := today.AddDate(0, 0, -2)
+		repo.On("CreateSnapshots", ctx, day).Return(int64(3), nil)
+
+		n, err := service.NewBalanceHistoryService(repo, &MockWalletRepository{}).SnapshotDay(ctx, day.Add(15*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+	})
+
+	t.Run("day not over yet", func(t *testing.T) {
+		repo := &MockBalanceHistoryRepository{}
+
+		_, err := service.NewBalanceHistoryService(repo, &MockWalletRepository{}).SnapshotDay(ctx, today)
+		assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+		repo.AssertNotCalled(t, "CreateSnapshots", mock.Anything, mock.Anything)
+	})
+}
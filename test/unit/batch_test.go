@@ -0,0 +1,412 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockBatchRepository struct {
+	mock.Mock
+}
+
+func (m *MockBatchRepository) CreateBatch(ctx context.Context, batch *model.Batch) (bool, error) {
+	args := m.Called(ctx, batch)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockBatchRepository) GetBatch(ctx context.Context, id uuid.UUID) (*model.Batch, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Batch), args.Error(1)
+}
+
+func (m *MockBatchRepository) GetBatchByIdempotencyKey(ctx context.Context, userID uuid.UUID, key string) (*model.Batch, error) {
+	args := m.Called(ctx, userID, key)
+	return args.Get(0).(*model.Batch), args.Error(1)
+}
+
+func (m *MockBatchRepository) ClaimPendingBatch(ctx context.Context, lease time.Duration) (*model.Batch, error) {
+	args := m.Called(ctx, lease)
+	return args.Get(0).(*model.Batch), args.Error(1)
+}
+
+func (m *MockBatchRepository) RecordBatchProgress(ctx context.Context, batch *model.Batch, lease time.Duration) (bool, error) {
+	args := m.Called(ctx, batch, lease)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockBatchRepository) CompleteBatch(ctx context.Context, batch *model.Batch) error {
+	args := m.Called(ctx, batch)
+	return args.Error(0)
+}
+
+type batchFixture struct {
+	// progress holds a copy of the batch at every RecordBatchProgress
+	progress []model.Batch
+	wr       *MockWalletRepository
+	tr       *MockTransactionRepository
+	wt       *MockWalletTx
+	repo     *MockBatchRepository
+	notifier *recordingNotifier
+	svc      service.BatchService
+}
+
+func newBatchFixture(cfg service.BatchConfig) *batchFixture {
+	f := &batchFixture{
+		wr:       &MockWalletRepository{},
+		tr:       &MockTransactionRepository{},
+		wt:       &MockWalletTx{},
+		repo:     &MockBatchRepository{},
+		notifier: &recordingNotifier{},
+	}
+	wallets := service.NewWalletService(f.wr, f.tr, f.tr, service.WithNotifier(f.notifier))
+	f.svc = service.NewBatchService(f.repo, wallets, cfg)
+	f.tr.On("BeginTx", mock.Anything).Return(f.wt, nil)
+	f.wt.On("CreateTransactionTx", mock.Anything, mock.AnythingOfType("*model.Transaction")).Return(nil)
+	f.wt.On("CreateAuditEventTx", mock.Anything, mock.AnythingOfType("*model.AuditEvent")).Return(nil)
+	f.wt.On("CreateOutboxEventTx", mock.Anything, mock.AnythingOfType("*model.OutboxEvent")).Return(nil)
+	f.wt.On("Commit").Return(nil)
+	f.wt.On("Rollback").Return(nil)
+	return f
+}
+
+// holdLease has every RecordBatchProgress renew the lease, or find it lost
+func (f *batchFixture) holdLease(held bool) {
+	f.repo.On("RecordBatchProgress", mock.Anything, mock.AnythingOfType("*model.Batch"), 5*time.Minute).
+		Run(func(args mock.Arguments) { f.progress = append(f.progress, *args.Get(1).(*model.Batch)) }).
+		Return(held, nil)
+}
+
+// wallet registers an existing wallet for both the lookup and the row lock
+func (f *batchFixture) wallet(id uuid.UUID, userID uuid.UUID, balance int64) *model.Wallet {
+	w := &model.Wallet{ID: id, UserID: userID, Currency: "USD", Balance: decimal.NewFromInt(balance), Version: 1}
+	f.wr.On("GetWalletByUserAndCurrency", mock.Anything, userID, "USD").Return(w, nil)
+	locked := *w
+	f.wt.On("GetWalletForUpdate", mock.Anything, id).Return(&locked, nil)
+	return w
+}
+
+func batchResults(t *testing.T, batch *model.Batch) []model.BatchItemResult {
+	require.NotNil(t, batch.Results)
+	var results []model.BatchItemResult
+	require.NoError(t, json.Unmarshal([]byte(*batch.Results), &results))
+	return results
+}
+
+func TestBatch_SubmitValidation(t *testing.T) {
+	ctx := context.Background()
+	f := newBatchFixture(service.BatchConfig{MaxItems: 2})
+	userID := uuid.New()
+	deposit := model.BatchItem{Type: model.BatchItemDeposit, UserID: userID, Amount: decimal.NewFromInt(1), Currency: "USD"}
+
+	tests := []struct {
+		name string
+		key  string
+		req  model.CreateBatchRequest
+	}{
+		{"missing idempotency key", "", model.CreateBatchRequest{Items: []model.BatchItem{deposit}}},
+		{"too many items", "k", model.CreateBatchRequest{Items: []model.BatchItem{deposit, deposit, deposit}}},
+		{"unknown mode", "k", model.CreateBatchRequest{Mode: "eventually", Items: []model.BatchItem{deposit}}},
+		{"zero amount", "k", model.CreateBatchRequest{Items: []model.BatchItem{
+			{Type: model.BatchItemDeposit, UserID: userID, Amount: decimal.Zero, Currency: "USD"},
+		}}},
+		{"transfer to self", "k", model.CreateBatchRequest{Items: []model.BatchItem{
+			{Type: model.BatchItemTransfer, UserID: userID, ToUserID: &userID, Amount: decimal.NewFromInt(1), Currency: "USD"},
+		}}},
+		{"transfer without recipient", "k", model.CreateBatchRequest{Items: []model.BatchItem{
+			{Type: model.BatchItemTransfer, UserID: userID, Amount: decimal.NewFromInt(1), Currency: "USD"},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := f.svc.Submit(ctx, userID, tt.key, tt.req)
+			assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+		})
+	}
+	f.repo.AssertNotCalled(t, "CreateBatch", mock.Anything, mock.Anything)
+}
+
+func TestBatch_DuplicateSubmission(t *testing.T) {
+	ctx := context.Background()
+	f := newBatchFixture(service.BatchConfig{})
+	userID := uuid.New()
+	req := model.CreateBatchRequest{
+		Async: true,
+		Items: []model.BatchItem{{Type: model.BatchItemDeposit, UserID: uuid.New(), Amount: decimal.NewFromInt(5), Currency: "USD"}},
+	}
+
+	var stored *model.Batch
+	f.repo.On("CreateBatch", mock.Anything, mock.AnythingOfType("*model.Batch")).
+		Run(func(args mock.Arguments) { stored = args.Get(1).(*model.Batch) }).
+		Return(true, nil).Once()
+	first, created, err := f.svc.Submit(ctx, userID, "payroll-2026-10", req)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, model.BatchPending, first.Status)
+
+	f.repo.On("CreateBatch", mock.Anything, mock.AnythingOfType("*model.Batch")).Return(false, nil)
+	f.repo.On("GetBatchByIdempotencyKey", mock.Anything, userID, "payroll-2026-10").Return(stored, nil)
+
+	t.Run("same batch is replayed", func(t *testing.T) {
+		replay, created, err := f.svc.Submit(ctx, userID, "payroll-2026-10", req)
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, first.ID, replay.ID)
+	})
+
+	t.Run("different batch is rejected", func(t *testing.T) {
+		changed := req
+		changed.Items = []model.BatchItem{{Type: model.BatchItemDeposit, UserID: uuid.New(), Amount: decimal.NewFromInt(6), Currency: "USD"}}
+		_, _, err := f.svc.Submit(ctx, userID, "payroll-2026-10", changed)
+		assert.Equal(t, errors.Conflict, errors.TypeOf(err))
+	})
+
+	// nothing was processed, the async batch waits for the worker
+	f.wt.AssertNotCalled(t, "Commit")
+}
+
+func TestBatch_Atomic(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := uuid.New(), uuid.New()
+	// ids chosen so bob's wallet sorts first
+	aliceWalletID := uuid.MustParse("ffffffff-0000-0000-0000-000000000000")
+	bobWalletID := uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+	t.Run("all items commit together", func(t *testing.T) {
+		f := newBatchFixture(service.BatchConfig{})
+		f.wallet(aliceWalletID, alice, 100)
+		f.wallet(bobWalletID, bob, 0)
+		f.repo.On("CreateBatch", mock.Anything, mock.AnythingOfType("*model.Batch")).Return(true, nil)
+		f.repo.On("CompleteBatch", mock.Anything, mock.AnythingOfType("*model.Batch")).Return(nil)
+		f.holdLease(true)
+		f.wt.On("UpdateWalletBalanceWithVersionTx", mock.Anything, aliceWalletID, decimal.NewFromInt(70), 1).Return(int64(1), nil)
+		f.wt.On("UpdateWalletBalanceWithVersionTx", mock.Anything, bobWalletID, decimal.NewFromInt(30), 1).Return(int64(1), nil)
+
+		batch, created, err := f.svc.Submit(ctx, alice, "k1", model.CreateBatchRequest{Items: []model.BatchItem{
+			{Type: model.BatchItemDeposit, UserID: alice, Amount: decimal.NewFromInt(10), Currency: "USD"},
+			{Type: model.BatchItemTransfer, UserID: alice, ToUserID: &bob, Amount: decimal.NewFromInt(40), Currency: "USD"},
+			{Type: model.BatchItemWithdraw, UserID: bob, Amount: decimal.NewFromInt(10), Currency: "USD"},
+		}})
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.Equal(t, model.BatchAtomic, batch.Mode)
+		assert.Equal(t, model.BatchCompleted, batch.Status)
+		assert.Equal(t, 3, batch.Succeeded)
+
+		results := batchResults(t, batch)
+		require.Len(t, results, 3)
+		assert.True(t, decimal.NewFromInt(110).Equal(*results[0].Balance))
+		assert.True(t, decimal.NewFromInt(70).Equal(*results[1].Balance))
+		assert.True(t, decimal.NewFromInt(30).Equal(*results[2].Balance))
+
+		var locked []uuid.UUID
+		for _, call := range f.wt.Calls {
+			if call.Method == "GetWalletForUpdate" {
+				locked = append(locked, call.Arguments.Get(1).(uuid.UUID))
+			}
+		}
+		assert.Equal(t, []uuid.UUID{bobWalletID, aliceWalletID}, locked)
+		f.wt.AssertNumberOfCalls(t, "Commit", 1)
+		assert.Len(t, f.notifier.updates, 4)
+	})
+
+	t.Run("a failing item rolls back the batch", func(t *testing.T) {
+		f := newBatchFixture(service.BatchConfig{})
+		f.wallet(aliceWalletID, alice, 100)
+		f.wallet(bobWalletID, bob, 0)
+		f.repo.On("CreateBatch", mock.Anything, mock.AnythingOfType("*model.Batch")).Return(true, nil)
+		f.repo.On("CompleteBatch", mock.Anything, mock.AnythingOfType("*model.Batch")).Return(nil)
+		f.holdLease(true)
+
+		batch, _, err := f.svc.Submit(ctx, alice, "k2", model.CreateBatchRequest{Items: []model.BatchItem{
+			{Type: model.BatchItemDeposit, UserID: alice, Amount: decimal.NewFromInt(10), Currency: "USD"},
+			{Type: model.BatchItemWithdraw, UserID: bob, Amount: decimal.NewFromInt(10), Currency: "USD"},
+		}})
+		require.NoError(t, err)
+		assert.Equal(t, model.BatchFailed, batch.Status)
+		assert.Equal(t, 0, batch.Succeeded)
+
+		results := batchResults(t, batch)
+		assert.Equal(t, model.BatchItemRolledBack, results[0].Status)
+		assert.Equal(t, model.BatchItemFailed, results[1].Status)
+		assert.Equal(t, "insufficient balance", results[1].Error)
+
+		f.wt.AssertNotCalled(t, "Commit")
+		f.wt.AssertCalled(t, "Rollback")
+		f.wt.AssertNotCalled(t, "UpdateWalletBalanceWithVersionTx", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		assert.Empty(t, f.notifier.updates)
+	})
+}
+
+func TestBatch_BestEffort(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := uuid.New(), uuid.New()
+	f := newBatchFixture(service.BatchConfig{})
+	aliceWallet := f.wallet(uuid.New(), alice, 0)
+	f.wallet(uuid.New(), bob, 0)
+	f.repo.On("CreateBatch", mock.Anything, mock.AnythingOfType("*model.Batch")).Return(true, nil)
+	f.repo.On("CompleteBatch", mock.Anything, mock.AnythingOfType("*model.Batch")).Return(nil)
+	f.holdLease(true)
+	f.wt.On("UpdateWalletBalanceWithVersionTx", mock.Anything, aliceWallet.ID, decimal.NewFromInt(25), 1).Return(int64(1), nil)
+
+	batch, _, err := f.svc.Submit(ctx, alice, "k3", model.CreateBatchRequest{
+		Mode: model.BatchBestEffort,
+		Items: []model.BatchItem{
+			{Type: model.BatchItemDeposit, UserID: alice, Amount: decimal.NewFromInt(25), Currency: "USD", Reference: "cashback"},
+			{Type: model.BatchItemWithdraw, UserID: bob, Amount: decimal.NewFromInt(5), Currency: "USD"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, model.BatchCompleted, batch.Status)
+	assert.Equal(t, 1, batch.Succeeded)
+	assert.Equal(t, 1, batch.Failed)
+
+	results := batchResults(t, batch)
+	assert.Equal(t, model.BatchItemSucceeded, results[0].Status)
+	assert.True(t, decimal.NewFromInt(25).Equal(*results[0].Balance))
+	assert.Equal(t, model.BatchItemFailed, results[1].Status)
+	assert.Equal(t, "insufficient balance", results[1].Error)
+
+	// leased to the request, with the progress recorded before every item
+	require.NotNil(t, batch.LockedUntil)
+	assert.Equal(t, 1, batch.Claims)
+	assert.Nil(t, batch.InFlight)
+	require.Len(t, f.progress, 2)
+	assert.Equal(t, 0, *f.progress[0].InFlight)
+	assert.Nil(t, f.progress[0].Results)
+	assert.Equal(t, 1, *f.progress[1].InFlight)
+	assert.Len(t, batchResults(t, &f.progress[1]), 1)
+	assert.Equal(t, 1, f.progress[1].Succeeded)
+}
+
+// claimedBatch is a batch as a worker that died while running item inFlight left it
+func claimedBatch(t *testing.T, mode string, items []model.BatchItem, done []model.BatchItemResult, inFlight int) *model.Batch {
+	encodedItems, err := json.Marshal(items)
+	require.NoError(t, err)
+	batch := &model.Batch{
+		ID:        uuid.New(),
+		UserID:    items[0].UserID,
+		Mode:      mode,
+		Status:    model.BatchProcessing,
+		Items:     string(encodedItems),
+		ItemCount: len(items),
+		Claims:    2,
+		InFlight:  &inFlight,
+	}
+	if len(done) > 0 {
+		encoded, err := json.Marshal(done)
+		require.NoError(t, err)
+		results := string(encoded)
+		batch.Results = &results
+	}
+	return batch
+}
+
+func TestBatch_TakeOverInterruptedBatch(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	deposit := func(amount int64) model.BatchItem {
+		return model.BatchItem{Type: model.BatchItemDeposit, UserID: userID, Amount: decimal.NewFromInt(amount), Currency: "USD"}
+	}
+	items := []model.BatchItem{deposit(1), deposit(2), deposit(4)}
+	balance := decimal.NewFromInt(1)
+	done := []model.BatchItemResult{{Index: 0, Status: model.BatchItemSucceeded, Balance: &balance}}
+
+	t.Run("best effort resumes after the interrupted item", func(t *testing.T) {
+		f := newBatchFixture(service.BatchConfig{})
+		w := f.wallet(uuid.New(), userID, 3)
+		f.wt.On("UpdateWalletBalanceWithVersionTx", mock.Anything, w.ID, decimal.NewFromInt(7), 1).Return(int64(1), nil)
+		claimed := claimedBatch(t, model.BatchBestEffort, items, done, 1)
+		f.repo.On("ClaimPendingBatch", mock.Anything, 5*time.Minute).Return(claimed, nil)
+		f.repo.On("CompleteBatch", mock.Anything, claimed).Return(nil)
+		f.holdLease(true)
+
+		processed, err := f.svc.ProcessPending(ctx)
+		require.NoError(t, err)
+		assert.True(t, processed)
+
+		assert.Equal(t, model.BatchCompleted, claimed.Status)
+		assert.Equal(t, 2, claimed.Succeeded)
+		assert.Equal(t, 1, claimed.Failed)
+		assert.Nil(t, claimed.InFlight)
+		results := batchResults(t, claimed)
+		require.Len(t, results, 3)
+		assert.Equal(t, model.BatchItemSucceeded, results[0].Status)
+		assert.Equal(t, model.BatchItemInterrupted, results[1].Status)
+		assert.Equal(t, model.BatchItemSucceeded, results[2].Status)
+		// only the last item was applied
+		f.wt.AssertNumberOfCalls(t, "Commit", 1)
+		require.Len(t, f.progress, 1)
+		assert.Equal(t, 2, *f.progress[0].InFlight)
+	})
+
+	t.Run("atomic is not run again", func(t *testing.T) {
+		f := newBatchFixture(service.BatchConfig{})
+		claimed := claimedBatch(t, model.BatchAtomic, items, nil, 0)
+		f.repo.On("ClaimPendingBatch", mock.Anything, 5*time.Minute).Return(claimed, nil)
+		f.repo.On("CompleteBatch", mock.Anything, claimed).Return(nil)
+
+		_, err := f.svc.ProcessPending(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, model.BatchFailed, claimed.Status)
+		for _, r := range batchResults(t, claimed) {
+			assert.Equal(t, model.BatchItemInterrupted, r.Status)
+		}
+		f.tr.AssertNotCalled(t, "BeginTx", mock.Anything)
+	})
+
+	t.Run("a worker that lost its lease stops", func(t *testing.T) {
+		f := newBatchFixture(service.BatchConfig{})
+		claimed := claimedBatch(t, model.BatchBestEffort, items, done, 1)
+		f.repo.On("ClaimPendingBatch", mock.Anything, 5*time.Minute).Return(claimed, nil)
+		f.holdLease(false)
+
+		_, err := f.svc.ProcessPending(ctx)
+		require.NoError(t, err)
+		f.tr.AssertNotCalled(t, "BeginTx", mock.Anything)
+		f.repo.AssertNotCalled(t, "CompleteBatch", mock.Anything, mock.Anything)
+	})
+}
+
+func TestBatch_LargeBatchIsQueued(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	f := newBatchFixture(service.BatchConfig{AsyncThreshold: 1})
+	w := f.wallet(uuid.New(), userID, 0)
+	f.wt.On("UpdateWalletBalanceWithVersionTx", mock.Anything, w.ID, decimal.NewFromInt(3), 1).Return(int64(1), nil)
+
+	var stored *model.Batch
+	f.repo.On("CreateBatch", mock.Anything, mock.AnythingOfType("*model.Batch")).
+		Run(func(args mock.Arguments) { stored = args.Get(1).(*model.Batch) }).
+		Return(true, nil)
+	item := model.BatchItem{Type: model.BatchItemDeposit, UserID: userID, Amount: decimal.NewFromInt(1), Currency: "USD"}
+	batch, _, err := f.svc.Submit(ctx, userID, "k4", model.CreateBatchRequest{Items: []model.BatchItem{item, item, item}})
+	require.NoError(t, err)
+	assert.Equal(t, model.BatchPending, batch.Status)
+	assert.Nil(t, batch.Results)
+
+	f.repo.On("ClaimPendingBatch", mock.Anything, 5*time.Minute).Return(stored, nil).Once()
+	f.repo.On("ClaimPendingBatch", mock.Anything, 5*time.Minute).Return((*model.Batch)(nil), nil)
+	f.repo.On("CompleteBatch", mock.Anything, stored).Return(nil)
+	f.holdLease(true)
+
+	processed, err := f.svc.ProcessPending(ctx)
+	require.NoError(t, err)
+	assert.True(t, processed)
+	assert.Equal(t, model.BatchCompleted, stored.Status)
+	assert.Equal(t, 3, stored.Succeeded)
+
+	processed, err = f.svc.ProcessPending(ctx)
+	require.NoError(t, err)
+	assert.False(t, processed)
+}
@@ -0,0 +1,371 @@
+package unit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/api"
+	"github.com/Jiang-hao/walletApiService/internal/openapi"
+	"github.com/Jiang-hao/walletApiService/internal/repository/memory"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/internal/stream"
+	"github.com/Jiang-hao/walletApiService/package/client"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientServer serves the wallet handlers over a memory store, behind the
+// OpenAPI checks, and records the requests it sees
+type clientServer struct {
+	*httptest.Server
+	wallets service.WalletService
+
+	mu       sync.Mutex
+	requests []*http.Request
+	// failures answers the next requests with these statuses before the
+	// handlers see them
+	failures []int
+}
+
+func newClientServer(t *testing.T) *clientServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	spec, err := openapi.New()
+	require.NoError(t, err)
+
+	store := memory.NewStore()
+	hub := stream.NewHub(stream.HubConfig{})
+	s := &clientServer{wallets: service.NewWalletService(store, store, store, service.WithNotifier(hub))}
+	walletHandler := api.NewWalletHandler(s.wallets)
+	streamHandler := api.NewStreamHandler(hub, time.Second)
+
+	router := gin.New()
+	wallet := router.Group("/api/v1/wallet", s.record, spec.Middleware(openapi.Config{Responses: true}))
+	wallet.POST("/deposit", walletHandler.Deposit)
+	wallet.POST("/withdraw", walletHandler.Withdraw)
+	wallet.POST("/transfer", walletHandler.Transfer)
+	wallet.GET("/balance", walletHandler.GetBalance)
+	wallet.GET("/transactions", walletHandler.GetTransactionHistory)
+	wallet.GET("/stream", streamHandler.Balances)
+	s.Server = httptest.NewServer(router)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *clientServer) record(c *gin.Context) {
+	s.mu.Lock()
+	s.requests = append(s.requests, c.Request.Clone(context.Background()))
+	var status int
+	if len(s.failures) > 0 {
+		status, s.failures = s.failures[0], s.failures[1:]
+	}
+	s.mu.Unlock()
+	if status != 0 {
+		c.AbortWithStatusJSON(status, gin.H{"error": http.StatusText(status)})
+	}
+}
+
+func (s *clientServer) fail(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = statuses
+	s.requests = nil
+}
+
+func (s *clientServer) seen() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// fastRetries keeps retrying tests quick
+var fastRetries = client.Config{Retry: client.RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}}
+
+func TestClient_WalletOperations(t *testing.T) {
+	server := newClientServer(t)
+	c := client.New(server.URL, server.Client(), fastRetries)
+	ctx := context.Background()
+	alice, bob := uuid.New(), uuid.New()
+
+	wallet, err := c.Deposit(ctx, alice, client.DepositRequest{Amount: decimal.RequireFromString("0.1"), Currency: "USD"})
+	require.NoError(t, err)
+	assert.Equal(t, alice, wallet.UserID)
+	wallet, err = c.Deposit(ctx, alice, client.DepositRequest{Amount: decimal.RequireFromString("100.2"), Currency: "USD"})
+	require.NoError(t, err)
+	assert.Equal(t, "100.3", wallet.Balance.String(), "amounts stay exact decimals")
+
+	wallet, err = c.Transfer(ctx, alice, client.TransferRequest{ToUserId: bob, Amount: decimal.RequireFromString("40.15"), Currency: "USD"})
+	require.NoError(t, err)
+	assert.Equal(t, "60.15", wallet.Balance.String())
+
+	wallet, err = c.Withdraw(ctx, bob, client.WithdrawalRequest{Amount: decimal.NewFromInt(40), Currency: "USD", Reference: "atm"})
+	require.NoError(t, err)
+	assert.Equal(t, "0.15", wallet.Balance.String())
+
+	balance, err := c.Balance(ctx, bob, "")
+	require.NoError(t, err)
+	assert.Equal(t, "0.15", balance.Balance.String())
+	assert.Equal(t, "USD", balance.Currency)
+
+	transactions, err := c.Transactions(ctx, bob, client.TransactionsQuery{Currency: "USD", PageSize: 1})
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+	assert.Equal(t, "atm", transactions[0].Reference)
+	assert.Equal(t, "USD", transactions[0].Currency)
+}
+
+func TestClient_TypedErrors(t *testing.T) {
+	server := newClientServer(t)
+	c := client.New(server.URL, server.Client(), fastRetries)
+	ctx := context.Background()
+	alice := uuid.New()
+
+	server.fail()
+	_, err := c.Withdraw(ctx, alice, client.WithdrawalRequest{Amount: decimal.NewFromInt(1), Currency: "USD"})
+	require.Error(t, err)
+	assert.Equal(t, client.InsufficientFund, client.TypeOf(err))
+	var answered *client.Error
+	require.ErrorAs(t, err, &answered)
+	assert.Equal(t, http.StatusUnprocessableEntity, answered.StatusCode)
+	assert.Contains(t, answered.Message, "insufficient balance")
+	assert.Len(t, server.seen(), 1, "business errors aren't retried")
+
+	_, err = c.Deposit(ctx, alice, client.DepositRequest{Amount: decimal.NewFromInt(-1), Currency: "USD"})
+	assert.Equal(t, client.InvalidRequest, client.TypeOf(err))
+	_, err = c.Transfer(ctx, alice, client.TransferRequest{ToUserId: alice, Amount: decimal.NewFromInt(1), Currency: "USD"})
+	assert.Equal(t, client.InvalidRequest, client.TypeOf(err))
+
+	assert.Equal(t, client.ErrorType(""), client.TypeOf(nil))
+	assert.Equal(t, client.Internal, client.TypeOf(io.ErrUnexpectedEOF))
+}
+
+func TestClient_RetriesWithOneIdempotencyKey(t *testing.T) {
+	server := newClientServer(t)
+	c := client.New(server.URL, server.Client(), fastRetries)
+	ctx := context.Background()
+	alice := uuid.New()
+
+	server.fail(http.StatusTooManyRequests, http.StatusTooManyRequests)
+	wallet, err := c.Deposit(ctx, alice, client.DepositRequest{Amount: decimal.NewFromInt(10), Currency: "USD"})
+	require.NoError(t, err)
+	assert.Equal(t, "10", wallet.Balance.String(), "applied once")
+
+	requests := server.seen()
+	require.Len(t, requests, 3)
+	key := requests[0].Header.Get(client.IdempotencyKeyHeader)
+	assert.NotEmpty(t, key)
+	for _, r := range requests {
+		assert.Equal(t, key, r.Header.Get(client.IdempotencyKeyHeader), "every attempt carries the same key")
+	}
+
+	server.fail()
+	_, err = c.Deposit(ctx, alice, client.DepositRequest{Amount: decimal.NewFromInt(1), Currency: "USD"})
+	require.NoError(t, err)
+	assert.NotEqual(t, key, server.seen()[0].Header.Get(client.IdempotencyKeyHeader), "another call, another key")
+
+	server.fail()
+	_, err = c.Deposit(client.WithIdempotencyKey(ctx, "mine"), alice, client.DepositRequest{Amount: decimal.NewFromInt(1), Currency: "USD"})
+	require.NoError(t, err)
+	assert.Equal(t, "mine", server.seen()[0].Header.Get(client.IdempotencyKeyHeader))
+
+	server.fail(http.StatusConflict, http.StatusBadGateway)
+	_, err = c.Balance(ctx, alice, "USD")
+	require.NoError(t, err)
+	require.Len(t, server.seen(), 3, "reads are retried on conflicts and server errors too")
+	assert.Empty(t, server.seen()[0].Header.Get(client.IdempotencyKeyHeader), "reads carry no key")
+}
+
+// A write that failed after reaching the server may have been applied, and
+// the server doesn't deduplicate on the key, so it isn't sent again
+func TestClient_WritesOnlyRetriedWhenNotApplied(t *testing.T) {
+	server := newClientServer(t)
+	c := client.New(server.URL, server.Client(), fastRetries)
+	ctx := context.Background()
+
+	for _, status := range []int{http.StatusConflict, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable} {
+		server.fail(status)
+		_, err := c.Deposit(ctx, uuid.New(), client.DepositRequest{Amount: decimal.NewFromInt(1), Currency: "USD"})
+		assert.Error(t, err, status)
+		assert.Len(t, server.seen(), 1, status)
+	}
+
+	// a refused connection never sent the request
+	refused := &countingTransport{err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}
+	_, err := client.New(server.URL, &http.Client{Transport: refused}, fastRetries).
+		Deposit(ctx, uuid.New(), client.DepositRequest{Amount: decimal.NewFromInt(1), Currency: "USD"})
+	assert.Error(t, err)
+	assert.Equal(t, 3, refused.attempts)
+
+	// a dropped connection may have sent all of it
+	dropped := &countingTransport{err: io.ErrUnexpectedEOF}
+	_, err = client.New(server.URL, &http.Client{Transport: dropped}, fastRetries).
+		Deposit(ctx, uuid.New(), client.DepositRequest{Amount: decimal.NewFromInt(1), Currency: "USD"})
+	assert.Error(t, err)
+	assert.Equal(t, 1, dropped.attempts)
+	_, err = client.New(server.URL, &http.Client{Transport: dropped}, fastRetries).Balance(ctx, uuid.New(), "USD")
+	assert.Error(t, err)
+	assert.Equal(t, 4, dropped.attempts, "reads are retried")
+}
+
+// countingTransport fails every request with err
+type countingTransport struct {
+	err      error
+	attempts int
+}
+
+func (t *countingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	t.attempts++
+	return nil, t.err
+}
+
+func TestClient_GivesUpAfterMaxAttempts(t *testing.T) {
+	server := newClientServer(t)
+	c := client.New(server.URL, server.Client(), fastRetries)
+	ctx := context.Background()
+
+	server.fail(http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests)
+	_, err := c.Deposit(ctx, uuid.New(), client.DepositRequest{Amount: decimal.NewFromInt(1), Currency: "USD"})
+	assert.Equal(t, client.RateLimited, client.TypeOf(err))
+	assert.Len(t, server.seen(), 3)
+
+	once := client.New(server.URL, server.Client(), client.Config{Retry: client.RetryPolicy{MaxAttempts: 1}})
+	server.fail(http.StatusInternalServerError)
+	_, err = once.Balance(ctx, uuid.New(), "USD")
+	assert.Equal(t, client.Internal, client.TypeOf(err))
+	assert.Len(t, server.seen(), 1)
+
+	slow := client.New(server.URL, server.Client(), client.Config{Retry: client.RetryPolicy{MaxAttempts: 5, BaseBackoff: time.Hour, MaxBackoff: time.Hour}})
+	server.fail(http.StatusServiceUnavailable)
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = slow.Balance(timeout, uuid.New(), "USD")
+	assert.Equal(t, client.Internal, client.TypeOf(err), "the wait for the next attempt ends with the context")
+	assert.Len(t, server.seen(), 1)
+}
+
+func TestClient_AllTransactions(t *testing.T) {
+	server := newClientServer(t)
+	c := client.New(server.URL, server.Client(), fastRetries)
+	ctx := context.Background()
+	alice := uuid.New()
+
+	// more than two pages of history
+	const deposits = 230
+	for i := 1; i <= deposits; i++ {
+		_, err := server.wallets.Deposit(ctx, alice, decimal.NewFromInt(1), "USD", fmt.Sprint(i))
+		require.NoError(t, err)
+	}
+	_, err := server.wallets.Deposit(ctx, alice, decimal.NewFromInt(1), "EUR", "")
+	require.NoError(t, err)
+
+	var references []string
+	ids := make(map[uuid.UUID]bool)
+	for tx, err := range c.AllTransactions(ctx, alice, "USD") {
+		require.NoError(t, err)
+		references = append(references, tx.Reference)
+		ids[tx.ID] = true
+	}
+	require.Len(t, references, deposits)
+	assert.Len(t, ids, deposits)
+	assert.Equal(t, fmt.Sprint(deposits), references[0], "newest first")
+	assert.Equal(t, "1", references[deposits-1])
+
+	count := 0
+	for range c.AllTransactions(ctx, alice, "") {
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	assert.Equal(t, 3, count)
+
+	for _, err := range c.AllTransactions(ctx, alice, "DOLLAR") {
+		assert.Equal(t, client.InvalidRequest, client.TypeOf(err))
+	}
+}
+
+func TestClient_Stream(t *testing.T) {
+	server := newClientServer(t)
+	c := client.New(server.URL, server.Client(), fastRetries)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	alice := uuid.New()
+
+	events, err := c.Stream(ctx, alice, client.StreamQuery{Currency: "USD"})
+	require.NoError(t, err)
+	defer events.Close()
+
+	_, err = c.Deposit(ctx, alice, client.DepositRequest{Amount: decimal.NewFromInt(1), Currency: "EUR"})
+	require.NoError(t, err)
+	_, err = c.Deposit(ctx, alice, client.DepositRequest{Amount: decimal.RequireFromString("2.5"), Currency: "USD"})
+	require.NoError(t, err)
+
+	event, err := events.Next()
+	require.NoError(t, err)
+	assert.Equal(t, client.EventBalance, event.Type)
+	require.NotNil(t, event.Update)
+	assert.Equal(t, "2.5", event.Update.Balance.String(), "the EUR update is filtered out")
+	assert.Equal(t, event.ID, events.LastEventID())
+
+	// resuming from an id the hub never issued asks for a refetch
+	resumed, err := c.Stream(ctx, alice, client.StreamQuery{LastEventID: "0badcafe-1"})
+	require.NoError(t, err)
+	defer resumed.Close()
+	event, err = resumed.Next()
+	require.NoError(t, err)
+	assert.Equal(t, client.EventReset, event.Type)
+}
+
+func TestClient_HistoryAndStatementQueries(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.Path+"?"+r.URL.RawQuery)
+		switch r.URL.Path {
+		case "/api/v1/wallet/statements":
+			w.Header().Set("Content-Type", "text/csv")
+			fmt.Fprint(w, "date,amount\n")
+		case "/api/v1/wallet/balance/daily":
+			fmt.Fprint(w, `{"currency":"EUR","balances":[{"date":"2025-06-01","balance":"1.10"}]}`)
+		default:
+			fmt.Fprint(w, `{"balance":"12.34","currency":"EUR","at":"2025-06-30T12:00:00Z"}`)
+		}
+	}))
+	defer server.Close()
+	c := client.New(server.URL+"/", server.Client(), fastRetries)
+	ctx := context.Background()
+	user := uuid.MustParse("6f1e4bd4-3c2a-4a52-9d0e-1f5c8b7a9e01")
+	june := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	at, err := c.BalanceAt(ctx, user, "EUR", june.AddDate(0, 0, 29).Add(12*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "12.34", at.Balance.String())
+
+	daily, err := c.DailyBalances(ctx, user, "EUR", june, june.AddDate(0, 0, 29))
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", daily.Balances[0].Balance.String())
+
+	body, err := c.Statement(ctx, user, client.StatementQuery{Currency: "EUR", From: june, Format: "csv"})
+	require.NoError(t, err)
+	raw, err := io.ReadAll(body)
+	require.NoError(t, err)
+	body.Close()
+	assert.Equal(t, "date,amount\n", string(raw))
+
+	assert.Equal(t, []string{
+		"/api/v1/wallet/balance/history?at=2025-06-30T12%3A00%3A00Z&currency=EUR&user_id=" + user.String(),
+		"/api/v1/wallet/balance/daily?currency=EUR&from=2025-06-01&to=2025-06-30&user_id=" + user.String(),
+		"/api/v1/wallet/statements?currency=EUR&format=csv&from=2025-06-01T00%3A00%3A00Z&user_id=" + user.String(),
+	}, got)
+}
@@ -0,0 +1,364 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/api"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockEscrowRepository struct {
+	mock.Mock
+}
+
+func (m *MockEscrowRepository) GetEscrow(ctx context.Context, id uuid.UUID) (*model.Escrow, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Escrow), args.Error(1)
+}
+
+func (m *MockEscrowRepository) ListEscrows(ctx context.Context, userID uuid.UUID, offset, limit int) ([]model.Escrow, error) {
+	args := m.Called(ctx, userID, offset, limit)
+	return args.Get(0).([]model.Escrow), args.Error(1)
+}
+
+func (m *MockEscrowRepository) ListDueEscrows(ctx context.Context, limit int) ([]uuid.UUID, error) {
+	args := m.Called(ctx, limit)
+	return args.Get(0).([]uuid.UUID), args.Error(1)
+}
+
+func (m *MockEscrowRepository) CreateEscrowTx(ctx context.Context, tx *sqlx.Tx, escrow *model.Escrow) error {
+	args := m.Called(ctx, tx, escrow)
+	return args.Error(0)
+}
+
+func (m *MockEscrowRepository) GetEscrowForUpdateTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID) (*model.Escrow, error) {
+	args := m.Called(ctx, tx, id)
+	return args.Get(0).(*model.Escrow), args.Error(1)
+}
+
+func (m *MockEscrowRepository) ResolveEscrowTx(ctx context.Context, tx *sqlx.Tx, escrow *model.Escrow) error {
+	args := m.Called(ctx, tx, escrow)
+	return args.Error(0)
+}
+
+type escrowFixture struct {
+	*batchFixture
+	repo         *MockEscrowRepository
+	svc          service.EscrowService
+	buyer        uuid.UUID
+	seller       uuid.UUID
+	arbiter      uuid.UUID
+	buyerWallet  *model.Wallet
+	sellerWallet *model.Wallet
+	escrow       *model.Escrow
+}
+
+// newEscrowFixture holds 40 USD of the buyer's 100 in escrow for the seller
+func newEscrowFixture() *escrowFixture {
+	f := &escrowFixture{
+		batchFixture: newBatchFixture(service.BatchConfig{}),
+		repo:         &MockEscrowRepository{},
+		buyer:        uuid.New(),
+		seller:       uuid.New(),
+		arbiter:      uuid.New(),
+	}
+	f.svc = service.NewEscrowService(f.repo, f.wr, f.tr, f.tr, f.notifier)
+	f.buyerWallet = f.wallet(uuid.New(), f.buyer, 100)
+	f.sellerWallet = f.wallet(uuid.New(), f.seller, 0)
+
+	f.escrow = &model.Escrow{
+		ID:             uuid.New(),
+		BuyerID:        f.buyer,
+		SellerID:       f.seller,
+		ArbiterID:      &f.arbiter,
+		WalletID:       uuid.New(),
+		Amount:         decimal.NewFromInt(40),
+		Currency:       "USD",
+		Status:         model.EscrowHeld,
+		DeadlineAction: model.EscrowDeadlineRelease,
+	}
+	held := &model.Wallet{
+		ID:       f.escrow.WalletID,
+		UserID:   model.EscrowUserID,
+		Currency: "USD",
+		Balance:  decimal.NewFromInt(40),
+		Version:  1,
+		EscrowID: &f.escrow.ID,
+	}
+	f.wt.On("GetWalletForUpdate", mock.Anything, held.ID).Return(held, nil)
+	f.repo.On("GetEscrow", mock.Anything, f.escrow.ID).Return(f.escrow, nil)
+	locked := *f.escrow
+	f.wt.On("GetEscrowForUpdate", mock.Anything, f.escrow.ID).Return(&locked, nil)
+	f.wt.On("UpdateWalletBalanceWithVersionTx", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(int64(1), nil)
+	f.wt.On("ResolveEscrowTx", mock.Anything, mock.AnythingOfType("*model.Escrow")).Return(nil)
+	return f
+}
+
+func TestEscrow_CreateValidation(t *testing.T) {
+	ctx := context.Background()
+	f := newEscrowFixture()
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		req  model.CreateEscrowRequest
+	}{
+		{"zero amount", model.CreateEscrowRequest{SellerID: f.seller, Amount: decimal.Zero, Currency: "USD"}},
+		{"buyer is seller", model.CreateEscrowRequest{SellerID: f.buyer, Amount: decimal.NewFromInt(10), Currency: "USD"}},
+		{"arbiter is seller", model.CreateEscrowRequest{SellerID: f.seller, ArbiterID: &f.seller, Amount: decimal.NewFromInt(10), Currency: "USD"}},
+		{"deadline in the past", model.CreateEscrowRequest{SellerID: f.seller, Amount: decimal.NewFromInt(10), Currency: "USD", Deadline: &past}},
+		{"unknown deadline action", model.CreateEscrowRequest{SellerID: f.seller, Amount: decimal.NewFromInt(10), Currency: "USD", DeadlineAction: "keep"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.svc.CreateEscrow(ctx, f.buyer, tt.req)
+			assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+		})
+	}
+	f.tr.AssertNotCalled(t, "BeginTx", mock.Anything)
+}
+
+func TestEscrow_Create(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("moves the amount into a new escrow wallet", func(t *testing.T) {
+		f := newEscrowFixture()
+		var created *model.Wallet
+		f.wt.On("CreateWalletTx", mock.Anything, mock.AnythingOfType("*model.Wallet")).
+			Run(func(args mock.Arguments) { created = args.Get(1).(*model.Wallet) }).Return(nil)
+		f.wt.On("CreateEscrowTx", mock.Anything, mock.AnythingOfType("*model.Escrow")).Return(nil)
+
+		escrow, err := f.svc.CreateEscrow(ctx, f.buyer, model.CreateEscrowRequest{
+			SellerID: f.seller,
+			Amount:   decimal.NewFromInt(40),
+			Currency: "USD",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, created)
+
+		assert.Equal(t, model.EscrowHeld, escrow.Status)
+		assert.Equal(t, model.EscrowDeadlineRelease, escrow.DeadlineAction)
+		assert.Equal(t, created.ID, escrow.WalletID)
+		assert.Equal(t, model.EscrowUserID, created.UserID)
+		assert.Equal(t, &escrow.ID, created.EscrowID)
+
+		f.wt.AssertCalled(t, "UpdateWalletBalanceWithVersionTx", mock.Anything, f.buyerWallet.ID, amountOf(60), 1)
+		f.wt.AssertCalled(t, "CreateTransactionTx", mock.Anything, mock.MatchedBy(func(tx *model.Transaction) bool {
+			return tx.Type == model.TxEscrowHold && tx.WalletID == created.ID
+		}))
+		f.wt.AssertCalled(t, "Commit")
+
+		// nobody subscribes to the escrow wallet's balance
+		require.Len(t, f.notifier.updates, 1)
+		assert.Equal(t, f.buyer, f.notifier.updates[0].UserID)
+	})
+
+	t.Run("buyer short of funds", func(t *testing.T) {
+		f := newEscrowFixture()
+
+		_, err := f.svc.CreateEscrow(ctx, f.buyer, model.CreateEscrowRequest{
+			SellerID: f.seller,
+			Amount:   decimal.NewFromInt(150),
+			Currency: "USD",
+		})
+		assert.Equal(t, errors.InsufficientFund, errors.TypeOf(err))
+		f.wt.AssertCalled(t, "Rollback")
+		f.wt.AssertNotCalled(t, "Commit")
+	})
+}
+
+func TestEscrow_Resolve(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		apply        func(f *escrowFixture) (*model.Escrow, error)
+		status       string
+		sellerAmount int64
+		buyerAmount  int64
+	}{
+		{
+			name:         "buyer releases",
+			apply:        func(f *escrowFixture) (*model.Escrow, error) { return f.svc.Release(ctx, f.buyer, f.escrow.ID) },
+			status:       model.EscrowReleased,
+			sellerAmount: 40,
+		},
+		{
+			name:        "seller refunds",
+			apply:       func(f *escrowFixture) (*model.Escrow, error) { return f.svc.Refund(ctx, f.seller, f.escrow.ID) },
+			status:      model.EscrowRefunded,
+			buyerAmount: 40,
+		},
+		{
+			name: "arbiter splits",
+			apply: func(f *escrowFixture) (*model.Escrow, error) {
+				return f.svc.Split(ctx, f.arbiter, f.escrow.ID, decimal.NewFromInt(15))
+			},
+			status:       model.EscrowSplit,
+			sellerAmount: 15,
+			buyerAmount:  25,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newEscrowFixture()
+
+			escrow, err := tt.apply(f)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, escrow.Status)
+			assert.True(t, escrow.ReleasedAmount.Equal(decimal.NewFromInt(tt.sellerAmount)))
+			assert.True(t, escrow.RefundedAmount.Equal(decimal.NewFromInt(tt.buyerAmount)))
+			require.NotNil(t, escrow.ResolvedBy)
+			require.NotNil(t, escrow.ResolvedAt)
+
+			f.wt.AssertCalled(t, "UpdateWalletBalanceWithVersionTx", mock.Anything, f.escrow.WalletID, amountOf(0), 1)
+			f.wt.AssertCalled(t, "UpdateWalletBalanceWithVersionTx", mock.Anything, f.sellerWallet.ID, amountOf(tt.sellerAmount), 1)
+			f.wt.AssertCalled(t, "UpdateWalletBalanceWithVersionTx", mock.Anything, f.buyerWallet.ID, amountOf(100+tt.buyerAmount), 1)
+			f.wt.AssertCalled(t, "ResolveEscrowTx", mock.Anything, escrow)
+			f.wt.AssertCalled(t, "Commit")
+		})
+	}
+}
+
+func TestEscrow_ResolveRules(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		apply   func(f *escrowFixture) (*model.Escrow, error)
+		errType errors.ErrorType
+	}{
+		{
+			name:    "seller cannot release",
+			apply:   func(f *escrowFixture) (*model.Escrow, error) { return f.svc.Release(ctx, f.seller, f.escrow.ID) },
+			errType: errors.Forbidden,
+		},
+		{
+			name:    "buyer cannot refund",
+			apply:   func(f *escrowFixture) (*model.Escrow, error) { return f.svc.Refund(ctx, f.buyer, f.escrow.ID) },
+			errType: errors.Forbidden,
+		},
+		{
+			name: "buyer cannot split",
+			apply: func(f *escrowFixture) (*model.Escrow, error) {
+				return f.svc.Split(ctx, f.buyer, f.escrow.ID, decimal.NewFromInt(10))
+			},
+			errType: errors.Forbidden,
+		},
+		{
+			name: "split gives the seller everything",
+			apply: func(f *escrowFixture) (*model.Escrow, error) {
+				return f.svc.Split(ctx, f.arbiter, f.escrow.ID, decimal.NewFromInt(40))
+			},
+			errType: errors.InvalidRequest,
+		},
+		{
+			name:    "outsider sees no escrow",
+			apply:   func(f *escrowFixture) (*model.Escrow, error) { return f.svc.Release(ctx, uuid.New(), f.escrow.ID) },
+			errType: errors.NotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newEscrowFixture()
+
+			_, err := tt.apply(f)
+			assert.Equal(t, tt.errType, errors.TypeOf(err))
+			f.tr.AssertNotCalled(t, "BeginTx", mock.Anything)
+		})
+	}
+
+	t.Run("already resolved", func(t *testing.T) {
+		f := newEscrowFixture()
+		f.wt.ExpectedCalls = removeCalls(f.wt.ExpectedCalls, "GetEscrowForUpdate")
+		released := *f.escrow
+		released.Status = model.EscrowReleased
+		f.wt.On("GetEscrowForUpdate", mock.Anything, f.escrow.ID).Return(&released, nil)
+
+		_, err := f.svc.Refund(ctx, f.arbiter, f.escrow.ID)
+		assert.Equal(t, errors.Conflict, errors.TypeOf(err))
+		f.wt.AssertCalled(t, "Rollback")
+		f.wt.AssertNotCalled(t, "CreateTransactionTx", mock.Anything, mock.Anything)
+	})
+}
+
+func TestEscrow_HandlerStatuses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	f := newEscrowFixture()
+	handler := api.NewEscrowHandler(f.svc)
+	router := gin.New()
+	router.POST("/escrows/:id/release", handler.Release)
+	router.POST("/escrows/:id/split", handler.Split)
+	escrow := func(action string, user uuid.UUID) string {
+		return "/escrows/" + f.escrow.ID.String() + "/" + action + "?user_id=" + user.String()
+	}
+
+	// a party acting outside its role is refused, an outsider doesn't see the escrow
+	w := serve(router, http.MethodPost, escrow("release", f.seller), "")
+	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "only the buyer or the arbiter")
+	w = serve(router, http.MethodPost, escrow("split", f.buyer), `{"seller_amount":"10"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	w = serve(router, http.MethodPost, escrow("release", uuid.New()), "")
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	f.tr.AssertNotCalled(t, "BeginTx", mock.Anything)
+}
+
+func TestEscrow_ResolveDue(t *testing.T) {
+	ctx := context.Background()
+	f := newEscrowFixture()
+	past := time.Now().Add(-time.Minute)
+
+	// the due escrow refunds, a second one was released by the buyer since it was listed
+	f.wt.ExpectedCalls = removeCalls(f.wt.ExpectedCalls, "GetEscrowForUpdate")
+	due := *f.escrow
+	due.Deadline = &past
+	due.DeadlineAction = model.EscrowDeadlineRefund
+	f.wt.On("GetEscrowForUpdate", mock.Anything, f.escrow.ID).Return(&due, nil)
+
+	other := &model.Escrow{ID: uuid.New(), BuyerID: f.buyer, SellerID: f.seller, Currency: "USD", Status: model.EscrowHeld}
+	f.repo.On("GetEscrow", mock.Anything, other.ID).Return(other, nil)
+	released := *other
+	released.Status = model.EscrowReleased
+	f.wt.On("GetEscrowForUpdate", mock.Anything, other.ID).Return(&released, nil)
+
+	f.repo.On("ListDueEscrows", mock.Anything, 100).Return([]uuid.UUID{f.escrow.ID, other.ID}, nil)
+
+	n, err := f.svc.ResolveDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	f.wt.AssertCalled(t, "ResolveEscrowTx", mock.Anything, mock.MatchedBy(func(e *model.Escrow) bool {
+		return e.ID == f.escrow.ID && e.Status == model.EscrowRefunded && e.ResolvedBy == nil
+	}))
+	f.wt.AssertCalled(t, "UpdateWalletBalanceWithVersionTx", mock.Anything, f.buyerWallet.ID, amountOf(140), 1)
+	f.wt.AssertNumberOfCalls(t, "ResolveEscrowTx", 1)
+}
+
+func removeCalls(calls []*mock.Call, method string) []*mock.Call {
+	kept := calls[:0]
+	for _, c := range calls {
+		if c.Method != method {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// amountOf matches a decimal by value, results of arithmetic don't compare equal field by field
+func amountOf(n int64) any {
+	return mock.MatchedBy(func(d decimal.Decimal) bool { return d.Equal(decimal.NewFromInt(n)) })
+}
@@ -0,0 +1,212 @@
+package unit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/events"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockOutboxRepository struct {
+	mock.Mock
+}
+
+func (m *MockOutboxRepository) ClaimBatch(ctx context.Context, limit int) (repository.OutboxBatch, error) {
+	args := m.Called(ctx, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(repository.OutboxBatch), args.Error(1)
+}
+
+func (m *MockOutboxRepository) CreateOutboxEventTx(ctx context.Context, tx *sqlx.Tx, event *model.OutboxEvent) error {
+	args := m.Called(ctx, tx, event)
+	return args.Error(0)
+}
+
+type MockOutboxBatch struct {
+	mock.Mock
+	events []model.OutboxEvent
+}
+
+func (m *MockOutboxBatch) Events() []model.OutboxEvent {
+	return m.events
+}
+
+func (m *MockOutboxBatch) MarkPublished(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockOutboxBatch) MarkFailed(ctx context.Context, id int64, nextAttemptAt time.Time, reason string) error {
+	args := m.Called(ctx, id, nextAttemptAt, reason)
+	return args.Error(0)
+}
+
+func (m *MockOutboxBatch) Commit() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockOutboxBatch) Rollback() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func outboxEvent(t *testing.T, id int64, walletID uuid.UUID, attempts int) model.OutboxEvent {
+	e, err := events.New(events.FundsDeposited, walletID, map[string]string{"amount": "10"})
+	require.NoError(t, err)
+	e.ID = id
+	e.Attempts = attempts
+	e.CreatedAt = time.Now()
+	return *e
+}
+
+func TestRelay_PublishesAndMarksBatch(t *testing.T) {
+	ctx := context.Background()
+	walletA, walletB := uuid.New(), uuid.New()
+
+	batch := &MockOutboxBatch{events: []model.OutboxEvent{
+		outboxEvent(t, 1, walletA, 0),
+		outboxEvent(t, 2, walletB, 0),
+	}}
+	batch.On("MarkPublished", ctx, int64(1)).Return(nil)
+	batch.On("MarkPublished", ctx, int64(2)).Return(nil)
+	batch.On("Commit").Return(nil)
+
+	repo := &MockOutboxRepository{}
+	repo.On("ClaimBatch", ctx, 10).Return(batch, nil)
+
+	pub := events.NewMemoryPublisher()
+	relay := events.NewRelay(repo, pub, events.RelayConfig{BatchSize: 10})
+
+	n, err := relay.ProcessBatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	msgs := pub.Messages()
+	require.Len(t, msgs, 2)
+	assert.Equal(t, int64(1), msgs[0].Sequence)
+	assert.Equal(t, walletA, msgs[0].AggregateID)
+	assert.Equal(t, events.FundsDeposited, msgs[0].Type)
+	assert.JSONEq(t, `{"amount":"10"}`, string(msgs[0].Payload))
+	batch.AssertExpectations(t)
+}
+
+func TestRelay_FailedPublishIsRetriedWithBackoff(t *testing.T) {
+	ctx := context.Background()
+
+	batch := &MockOutboxBatch{events: []model.OutboxEvent{outboxEvent(t, 7, uuid.New(), 2)}}
+	var nextAttempt time.Time
+	batch.On("MarkFailed", ctx, int64(7), mock.AnythingOfType("time.Time"), "broker unavailable").
+		Run(func(args mock.Arguments) { nextAttempt = args.Get(2).(time.Time) }).
+		Return(nil)
+	batch.On("Commit").Return(nil)
+
+	repo := &MockOutboxRepository{}
+	repo.On("ClaimBatch", ctx, 100).Return(batch, nil)
+
+	pub := events.NewMemoryPublisher()
+	pub.SetError(fmt.Errorf("broker unavailable"))
+	relay := events.NewRelay(repo, pub, events.RelayConfig{BaseBackoff: time.Second, MaxBackoff: time.Minute})
+
+	before := time.Now()
+	_, err := relay.ProcessBatch(ctx)
+	require.NoError(t, err)
+
+	// third attempt: 1s * 2^2
+	assert.WithinDuration(t, before.Add(4*time.Second), nextAttempt, time.Second)
+	assert.Empty(t, pub.Messages())
+	batch.AssertNotCalled(t, "MarkPublished", mock.Anything, mock.Anything)
+	batch.AssertExpectations(t)
+}
+
+func TestRelay_RollsBackWhenMarkingFails(t *testing.T) {
+	ctx := context.Background()
+
+	batch := &MockOutboxBatch{events: []model.OutboxEvent{outboxEvent(t, 3, uuid.New(), 0)}}
+	batch.On("MarkPublished", ctx, int64(3)).Return(errors.NewInternal("outbox.MarkPublished", fmt.Errorf("connection reset")))
+	batch.On("Rollback").Return(nil)
+
+	repo := &MockOutboxRepository{}
+	repo.On("ClaimBatch", ctx, 100).Return(batch, nil)
+
+	_, err := events.NewRelay(repo, events.NewMemoryPublisher(), events.RelayConfig{}).ProcessBatch(ctx)
+	assert.Error(t, err)
+	batch.AssertCalled(t, "Rollback")
+	batch.AssertNotCalled(t, "Commit")
+}
+
+func TestEvents_FilePublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	pub, err := events.NewFilePublisher(path)
+	require.NoError(t, err)
+
+	e := outboxEvent(t, 1, uuid.New(), 0)
+	require.NoError(t, pub.Publish(context.Background(), events.MessageFromOutbox(&e)))
+	require.NoError(t, pub.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	require.True(t, scanner.Scan())
+	var msg events.Message
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &msg))
+	assert.Equal(t, e.EventID, msg.ID)
+	assert.Equal(t, events.FundsDeposited, msg.Type)
+}
+
+func TestEvents_WalletCreatedWithNewWallet(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	currency := "EUR"
+
+	wr := &MockWalletRepository{}
+	tr := &MockTransactionRepository{}
+	wt := &MockWalletTx{}
+	wr.On("GetWalletByUserAndCurrency", ctx, userID, currency).
+		Return((*model.Wallet)(nil), errors.NewNotFound("wallet.GetByUserAndCurrency", "wallet"))
+	tr.On("BeginTx", ctx).Return(wt, nil)
+	wt.On("CreateWalletTx", ctx, mock.AnythingOfType("*model.Wallet")).Return(nil)
+	wt.On("CreateOutboxEventTx", ctx, mock.MatchedBy(func(e *model.OutboxEvent) bool {
+		return e.EventType == events.WalletCreated
+	})).Return(nil)
+	wt.On("Commit").Return(nil)
+
+	balance, err := service.NewWalletService(wr, tr, tr).GetBalance(ctx, userID, currency)
+	require.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.Zero))
+	wt.AssertExpectations(t)
+}
+
+func TestEvents_TransferEventsPerWallet(t *testing.T) {
+	from := &model.Wallet{ID: uuid.New(), UserID: uuid.New(), Currency: "USD"}
+	to := &model.Wallet{ID: uuid.New(), UserID: uuid.New(), Currency: "USD"}
+
+	evts, err := events.NewTransferEvents(uuid.New(), from, to, decimal.NewFromInt(25), "rent")
+	require.NoError(t, err)
+	require.Len(t, evts, 2)
+	assert.Equal(t, events.TransferCompleted, evts[0].EventType)
+	assert.Equal(t, from.ID, evts[0].AggregateID)
+	assert.Equal(t, events.TransferReceived, evts[1].EventType)
+	assert.Equal(t, to.ID, evts[1].AggregateID)
+	assert.Equal(t, evts[0].Payload, evts[1].Payload)
+}
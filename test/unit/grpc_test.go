@@ -0,0 +1,228 @@
+package unit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/repository/memory"
+	"github.com/Jiang-hao/walletApiService/internal/rpc"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/package/walletpb"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const grpcTestToken = "test-token"
+
+// newGRPCClient serves a memory store over an in-process connection
+func newGRPCClient(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	store := memory.NewStore()
+	srv := rpc.NewServer(
+		service.NewWalletService(store, store, store),
+		rpc.Config{Tokens: map[string]string{grpcTestToken: "tests"}},
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+	)
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func authorized() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+grpcTestToken)
+}
+
+func TestGRPC_Auth(t *testing.T) {
+	client := walletpb.NewWalletServiceClient(newGRPCClient(t))
+	req := &walletpb.GetBalanceRequest{UserId: uuid.NewString()}
+
+	_, err := client.GetBalance(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer wrong")
+	_, err = client.GetBalance(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", grpcTestToken)
+	_, err = client.GetBalance(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "the token must be a bearer token")
+
+	_, err = client.GetBalance(authorized(), req)
+	assert.NoError(t, err)
+}
+
+func TestGRPC_HealthNeedsNoToken(t *testing.T) {
+	resp, err := healthpb.NewHealthClient(newGRPCClient(t)).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func TestGRPC_WalletOperations(t *testing.T) {
+	client := walletpb.NewWalletServiceClient(newGRPCClient(t))
+	ctx := authorized()
+	alice, bob := uuid.NewString(), uuid.NewString()
+
+	var header metadata.MD
+	deposited, err := client.Deposit(ctx, &walletpb.DepositRequest{UserId: alice, Amount: "100.50", Currency: "USD"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, "100.5", deposited.Wallet.Balance)
+	assert.Equal(t, alice, deposited.Wallet.UserId)
+	assert.NotEmpty(t, header.Get(rpc.RequestIDMetadata))
+
+	transferred, err := client.Transfer(ctx, &walletpb.TransferRequest{FromUserId: alice, ToUserId: bob, Amount: "30", Currency: "USD"})
+	require.NoError(t, err)
+	assert.Equal(t, "70.5", transferred.Wallet.Balance)
+
+	withdrawn, err := client.Withdraw(ctx, &walletpb.WithdrawRequest{UserId: bob, Amount: "10", Currency: "USD"})
+	require.NoError(t, err)
+	assert.Equal(t, "20", withdrawn.Wallet.Balance)
+
+	balance, err := client.GetBalance(ctx, &walletpb.GetBalanceRequest{UserId: bob})
+	require.NoError(t, err)
+	assert.Equal(t, "20", balance.Balance)
+	assert.Equal(t, "USD", balance.Currency)
+}
+
+func TestGRPC_ErrorCodes(t *testing.T) {
+	client := walletpb.NewWalletServiceClient(newGRPCClient(t))
+	ctx := authorized()
+	alice := uuid.NewString()
+	_, err := client.Deposit(ctx, &walletpb.DepositRequest{UserId: alice, Amount: "10", Currency: "USD"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"invalid user id", func() error {
+			_, err := client.Deposit(ctx, &walletpb.DepositRequest{UserId: "nope", Amount: "1", Currency: "USD"})
+			return err
+		}, codes.InvalidArgument},
+		{"negative amount", func() error {
+			_, err := client.Deposit(ctx, &walletpb.DepositRequest{UserId: alice, Amount: "-1", Currency: "USD"})
+			return err
+		}, codes.InvalidArgument},
+		{"malformed amount", func() error {
+			_, err := client.Withdraw(ctx, &walletpb.WithdrawRequest{UserId: alice, Amount: "ten", Currency: "USD"})
+			return err
+		}, codes.InvalidArgument},
+		{"invalid currency", func() error {
+			_, err := client.Deposit(ctx, &walletpb.DepositRequest{UserId: alice, Amount: "1", Currency: "DOLLAR"})
+			return err
+		}, codes.InvalidArgument},
+		{"insufficient balance", func() error {
+			_, err := client.Withdraw(ctx, &walletpb.WithdrawRequest{UserId: alice, Amount: "11", Currency: "USD"})
+			return err
+		}, codes.FailedPrecondition},
+		{"transfer to self", func() error {
+			_, err := client.Transfer(ctx, &walletpb.TransferRequest{FromUserId: alice, ToUserId: alice, Amount: "1", Currency: "USD"})
+			return err
+		}, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			assert.Equal(t, tt.code, status.Code(err), "%v", err)
+		})
+	}
+
+	_, err = client.Withdraw(ctx, &walletpb.WithdrawRequest{UserId: alice, Amount: "11", Currency: "USD"})
+	assert.Equal(t, "insufficient balance", status.Convert(err).Message(), "the op chain stays in the log")
+}
+
+func TestGRPC_ListTransactionsStreamsEveryPage(t *testing.T) {
+	client := walletpb.NewWalletServiceClient(newGRPCClient(t))
+	ctx := authorized()
+	alice := uuid.NewString()
+
+	// more than one page of history
+	const deposits = 130
+	for i := 1; i <= deposits; i++ {
+		_, err := client.Deposit(ctx, &walletpb.DepositRequest{UserId: alice, Amount: "1", Currency: "USD", Reference: fmt.Sprint(i)})
+		require.NoError(t, err)
+	}
+	_, err := client.Deposit(ctx, &walletpb.DepositRequest{UserId: alice, Amount: "5", Currency: "EUR"})
+	require.NoError(t, err)
+
+	receive := func(req *walletpb.ListTransactionsRequest) []*walletpb.Transaction {
+		stream, err := client.ListTransactions(ctx, req)
+		require.NoError(t, err)
+		var txs []*walletpb.Transaction
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return txs
+			}
+			require.NoError(t, err)
+			txs = append(txs, resp.Transaction)
+		}
+	}
+
+	usd := receive(&walletpb.ListTransactionsRequest{UserId: alice, Currency: "USD"})
+	require.Len(t, usd, deposits)
+	ids := make(map[string]bool)
+	for _, tx := range usd {
+		ids[tx.Id] = true
+		assert.Equal(t, "USD", tx.Currency)
+		assert.NotNil(t, tx.CreatedAt)
+	}
+	assert.Len(t, ids, deposits, "no transaction is sent twice")
+	assert.Equal(t, fmt.Sprint(deposits), usd[0].Reference, "newest first")
+	assert.Equal(t, "130", usd[0].BalanceAfter)
+
+	assert.Len(t, receive(&walletpb.ListTransactionsRequest{UserId: alice}), deposits+1)
+	assert.Len(t, receive(&walletpb.ListTransactionsRequest{UserId: alice, Currency: "USD", Limit: 7}), 7)
+
+	stream, err := client.ListTransactions(ctx, &walletpb.ListTransactionsRequest{UserId: "nope"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPC_StatusMapping(t *testing.T) {
+	const op = "test"
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{nil, codes.OK},
+		{errors.NewInvalidInput(op, "amount", 0), codes.InvalidArgument},
+		{errors.NewNotFound(op, "wallet"), codes.NotFound},
+		{errors.NewInsufficientBalance(op), codes.FailedPrecondition},
+		{errors.NewWalletFrozen(op), codes.FailedPrecondition},
+		{errors.NewForbidden(op, "only the arbiter can split an escrow"), codes.PermissionDenied},
+		{errors.NewConflict(op, "version changed"), codes.Aborted},
+		{errors.WrapInternal(op, errors.NewConflict(op, "version changed")), codes.Aborted},
+		{errors.WrapInternal(op, io.ErrUnexpectedEOF), codes.Internal},
+		{errors.WrapInternal(op, context.DeadlineExceeded), codes.DeadlineExceeded},
+		{context.Canceled, codes.Canceled},
+		{status.Error(codes.Unauthenticated, "no"), codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, rpc.Code(tt.err), "%v", tt.err)
+		assert.Equal(t, tt.code, status.Code(rpc.Status(tt.err)), "%v", tt.err)
+	}
+	assert.Equal(t, "internal server error", status.Convert(rpc.Status(errors.WrapInternal(op, io.ErrUnexpectedEOF))).Message())
+}
@@ -0,0 +1,150 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/api"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/loadtest"
+	"github.com/Jiang-hao/walletApiService/internal/repository/memory"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadtestUsers(n int) []uuid.UUID {
+	users := make([]uuid.UUID, n)
+	for i := range users {
+		users[i] = uuid.New()
+	}
+	return users
+}
+
+func TestLoadtest_ServiceTarget(t *testing.T) {
+	store := memory.NewStore()
+	target := loadtest.NewServiceTarget(service.NewWalletService(store, store, store))
+
+	report, err := loadtest.Run(context.Background(), target, loadtest.Config{
+		Users:          loadtestUsers(10),
+		Currency:       "USD",
+		InitialBalance: decimal.NewFromInt(50),
+		MaxAmount:      40,
+		Mix:            map[loadtest.Op]int{loadtest.OpDeposit: 1, loadtest.OpWithdraw: 3, loadtest.OpTransfer: 5, loadtest.OpRead: 1},
+		Skew:           1.5,
+		Workers:        8,
+		Requests:       500,
+		Seed:           1,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 500, report.Requests)
+	total, failed := 0, 0
+	for _, op := range report.Ops {
+		total += op.Requests
+		failed += op.Failed
+		assert.LessOrEqual(t, op.P50, op.P99)
+		assert.LessOrEqual(t, op.P99, op.Max)
+	}
+	assert.Equal(t, report.Requests, total)
+	assert.Equal(t, report.Failed, failed)
+
+	// withdrawals outweigh deposits, so some run the balance dry
+	assert.Positive(t, report.Errors[errors.InsufficientFund])
+	assert.Zero(t, report.Errors[errors.Internal])
+
+	v := report.Verification
+	assert.True(t, v.Consistent, "mismatches: %v", v.Mismatches)
+	assert.Equal(t, 10, v.Users)
+	assert.True(t, v.Total.Equal(v.ExpectedTotal))
+
+	var out bytes.Buffer
+	require.NoError(t, report.WriteText(&out))
+	assert.Contains(t, out.String(), "Balances consistent")
+	assert.Contains(t, out.String(), string(errors.InsufficientFund))
+}
+
+func TestLoadtest_HTTPTarget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := memory.NewStore()
+	handler := api.NewWalletHandler(service.NewWalletService(store, store, store))
+	router := gin.New()
+	wallet := router.Group("/api/v1/wallet")
+	wallet.POST("/deposit", handler.Deposit)
+	wallet.POST("/withdraw", handler.Withdraw)
+	wallet.POST("/transfer", handler.Transfer)
+	wallet.GET("/balance", handler.GetBalance)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx := context.Background()
+	target := loadtest.NewHTTPTarget(server.URL, server.Client())
+	alice, bob := uuid.New(), uuid.New()
+
+	require.NoError(t, target.Deposit(ctx, alice, decimal.NewFromInt(30), "USD"))
+	require.NoError(t, target.Transfer(ctx, alice, bob, decimal.NewFromInt(10), "USD"))
+	balance, err := target.GetBalance(ctx, bob, "USD")
+	require.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromInt(10)), "bob holds %s", balance)
+
+	// the error type survives the trip through the response body
+	err = target.Withdraw(ctx, bob, decimal.NewFromInt(11), "USD")
+	assert.Equal(t, errors.InsufficientFund, errors.TypeOf(err))
+	err = target.Transfer(ctx, alice, alice, decimal.NewFromInt(1), "USD")
+	assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+	err = target.Deposit(ctx, alice, decimal.NewFromInt(-1), "USD")
+	assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+
+	report, err := loadtest.Run(ctx, target, loadtest.Config{
+		Users:          loadtestUsers(5),
+		Currency:       "EUR",
+		InitialBalance: decimal.NewFromInt(100),
+		MaxAmount:      60,
+		Mix:            map[loadtest.Op]int{loadtest.OpWithdraw: 1, loadtest.OpTransfer: 2, loadtest.OpRead: 1},
+		Workers:        4,
+		Requests:       100,
+		Seed:           7,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 100, report.Requests)
+	assert.Zero(t, report.Errors[loadtest.Transport])
+	assert.True(t, report.Verification.Consistent, "mismatches: %v", report.Verification.Mismatches)
+}
+
+func TestLoadtest_InvalidConfig(t *testing.T) {
+	store := memory.NewStore()
+	target := loadtest.NewServiceTarget(service.NewWalletService(store, store, store))
+	valid := loadtest.Config{
+		Users:     loadtestUsers(2),
+		Currency:  "USD",
+		MaxAmount: 10,
+		Mix:       map[loadtest.Op]int{loadtest.OpRead: 1},
+		Workers:   1,
+		Requests:  1,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*loadtest.Config)
+	}{
+		{"one user", func(c *loadtest.Config) { c.Users = c.Users[:1] }},
+		{"no workers", func(c *loadtest.Config) { c.Workers = 0 }},
+		{"no amount", func(c *loadtest.Config) { c.MaxAmount = 0 }},
+		{"flat skew", func(c *loadtest.Config) { c.Skew = 0.5 }},
+		{"no limit", func(c *loadtest.Config) { c.Requests = 0 }},
+		{"empty mix", func(c *loadtest.Config) { c.Mix = map[loadtest.Op]int{loadtest.OpRead: 0} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			_, err := loadtest.Run(context.Background(), target, cfg)
+			assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/api"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/logging"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestLogging_RequestIDAndErrorChain(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(logging.Config{Format: logging.FormatJSON, Level: "debug", Writer: &buf})
+	require.NoError(t, err)
+
+	userID := uuid.New()
+	wr := &MockWalletRepository{}
+	tr := &MockTransactionRepository{}
+	wr.On("GetWalletByUserAndCurrency", mock.Anything, userID, "USD").
+		Return((*model.Wallet)(nil), errors.NewInternal("wallet.GetByUserAndCurrency", assert.AnError))
+
+	handler := api.NewWalletHandler(service.NewWalletService(wr, tr, tr))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(logging.RequestID(logger), logging.AccessLog())
+	router.GET("/api/v1/wallet/balance", handler.GetBalance)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/wallet/balance?user_id="+userID.String(), nil)
+	req.Header.Set(logging.RequestIDHeader, "req-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "req-123", w.Header().Get(logging.RequestIDHeader))
+
+	var failed map[string]any
+	for _, entry := range decodeLogLines(t, &buf) {
+		assert.Equal(t, "req-123", entry[logging.KeyRequestID], "every line carries the request id: %v", entry)
+		if entry["msg"] == "request failed" {
+			failed = entry
+		}
+	}
+	require.NotNil(t, failed)
+	errGroup := failed[logging.KeyError].(map[string]any)
+	assert.Equal(t, string(errors.Internal), errGroup["type"])
+	assert.Equal(t, []any{"service.GetBalance", "utils.GetOrCreateWallet", "wallet.GetByUserAndCurrency"}, errGroup["ops"])
+}
+
+func TestLogging_GeneratesRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(logging.Config{Format: logging.FormatText, Writer: &buf})
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(logging.RequestID(logger), logging.AccessLog())
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+	id := w.Header().Get(logging.RequestIDHeader)
+	_, err = uuid.Parse(id)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "request_id="+id)
+}
+
+func TestLogging_Redaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(logging.Config{Writer: &buf})
+	require.NoError(t, err)
+
+	logger.Info("connecting",
+		"password", "920313",
+		"dsn_note", "host=localhost password=920313 dbname=walletapi",
+	)
+
+	out := buf.String()
+	assert.NotContains(t, out, "920313")
+	assert.Contains(t, out, "[REDACTED]")
+}
+
+func TestLogging_InvalidConfig(t *testing.T) {
+	_, err := logging.New(logging.Config{Format: "xml"})
+	assert.Error(t, err)
+
+	_, err = logging.New(logging.Config{Level: "loud"})
+	assert.Error(t, err)
+}
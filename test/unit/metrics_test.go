@@ -0,0 +1,99 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMetrics_Outcome(t *testing.T) {
+	assert.Equal(t, metrics.OutcomeSuccess, metrics.Outcome(nil))
+	assert.Equal(t, metrics.OutcomeInsufficientFunds, metrics.Outcome(errors.NewInsufficientBalance("op")))
+	assert.Equal(t, metrics.OutcomeInsufficientFunds, metrics.Outcome(errors.WrapInternal("outer", errors.NewInsufficientBalance("op"))))
+	assert.Equal(t, metrics.OutcomeConflict, metrics.Outcome(errors.NewConflict("op", "optimistic lock conflict")))
+	assert.Equal(t, metrics.OutcomeError, metrics.Outcome(errors.NewInvalidInput("op", "amount", 0)))
+}
+
+func TestMetrics_WalletOperations(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	currency := "JPY"
+	wallet := &model.Wallet{
+		ID:       uuid.New(),
+		UserID:   userID,
+		Currency: currency,
+		Balance:  decimal.NewFromInt(10),
+		Version:  1,
+	}
+
+	wr := &MockWalletRepository{}
+	tr := &MockTransactionRepository{}
+	wt := &MockWalletTx{}
+	wr.On("GetWalletByUserAndCurrency", ctx, userID, currency).Return(wallet, nil)
+	tr.On("BeginTx", ctx).Return(wt, nil)
+	reread := *wallet
+	reread.Version = wallet.Version + 1
+	wr.On("GetWallet", ctx, wallet.ID).Return(&reread, nil)
+	wt.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), wallet.Version).
+		Return(int64(0), nil).Once()
+	wt.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), reread.Version).
+		Return(int64(1), nil).Once()
+	wt.On("CreateTransactionTx", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+	wt.On("CreateAuditEventTx", ctx, mock.AnythingOfType("*model.AuditEvent")).Return(nil)
+	wt.On("CreateOutboxEventTx", ctx, mock.AnythingOfType("*model.OutboxEvent")).Return(nil)
+	wt.On("Commit").Return(nil)
+	wt.On("Rollback").Return(nil)
+
+	svc := service.NewWalletService(wr, tr, tr)
+
+	successBefore := testutil.ToFloat64(metrics.WalletOperations.WithLabelValues("deposit", metrics.OutcomeSuccess))
+	insufficientBefore := testutil.ToFloat64(metrics.WalletOperations.WithLabelValues("withdraw", metrics.OutcomeInsufficientFunds))
+	retriesBefore := testutil.ToFloat64(metrics.OptimisticLockRetries.WithLabelValues("deposit"))
+	amountBefore := testutil.ToFloat64(metrics.AmountMoved.WithLabelValues("deposit", currency))
+
+	_, err := svc.Deposit(ctx, userID, decimal.NewFromInt(5), currency, "metrics deposit")
+	assert.NoError(t, err)
+
+	_, err = svc.Withdraw(ctx, userID, decimal.NewFromInt(100), currency, "metrics withdrawal")
+	assert.Error(t, err)
+
+	assert.Equal(t, successBefore+1, testutil.ToFloat64(metrics.WalletOperations.WithLabelValues("deposit", metrics.OutcomeSuccess)))
+	assert.Equal(t, insufficientBefore+1, testutil.ToFloat64(metrics.WalletOperations.WithLabelValues("withdraw", metrics.OutcomeInsufficientFunds)))
+	assert.Equal(t, retriesBefore+1, testutil.ToFloat64(metrics.OptimisticLockRetries.WithLabelValues("deposit")))
+	assert.Equal(t, amountBefore+5, testutil.ToFloat64(metrics.AmountMoved.WithLabelValues("deposit", currency)))
+}
+
+func TestMetrics_Middleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(metrics.Middleware())
+	router.GET("/api/v1/wallet/balance", func(c *gin.Context) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+	})
+	router.GET("/metrics", metrics.Handler())
+
+	before := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("/api/v1/wallet/balance", http.MethodGet, "400"))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/wallet/balance", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("/api/v1/wallet/balance", http.MethodGet, "400")))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "walletapi_http_requests_total")
+}
@@ -0,0 +1,210 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/api"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/openapi"
+	"github.com/Jiang-hao/walletApiService/internal/repository/memory"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newOpenAPIRouter routes the wallet handlers behind the middleware, checking
+// responses too
+func newOpenAPIRouter(t *testing.T) (*gin.Engine, *openapi.Spec) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	spec, err := openapi.New()
+	require.NoError(t, err)
+
+	store := memory.NewStore()
+	handler := api.NewWalletHandler(service.NewWalletService(store, store, store))
+	router := gin.New()
+	router.GET("/openapi.json", spec.Handler())
+	wallet := router.Group("/api/v1/wallet", spec.Middleware(openapi.Config{Responses: true}))
+	wallet.POST("/deposit", handler.Deposit)
+	wallet.POST("/withdraw", handler.Withdraw)
+	wallet.POST("/transfer", handler.Transfer)
+	wallet.GET("/balance", handler.GetBalance)
+	wallet.GET("/transactions", handler.GetTransactionHistory)
+	return router, spec
+}
+
+func serve(router *gin.Engine, method, url, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+	return w
+}
+
+func TestOpenAPI_DocumentLoads(t *testing.T) {
+	router, spec := newOpenAPIRouter(t)
+	assert.NotNil(t, spec.Document().Paths.Find("/api/v1/wallet/deposit"))
+
+	w := serve(router, http.MethodGet, "/openapi.json", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, "3.0.3", doc["openapi"])
+}
+
+func TestOpenAPI_ResponsesMatchTheDocument(t *testing.T) {
+	router, _ := newOpenAPIRouter(t)
+	alice, bob := uuid.NewString(), uuid.NewString()
+	wallet := func(path, user string) string { return "/api/v1/wallet/" + path + "?user_id=" + user }
+
+	// an empty history is an empty array, not null
+	w := serve(router, http.MethodGet, wallet("transactions", alice), "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	w = serve(router, http.MethodPost, wallet("deposit", alice), `{"amount":"100.50","currency":"USD"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(router, http.MethodPost, wallet("deposit", alice), `{"amount":5,"currency":"EUR"}`)
+	require.Equal(t, http.StatusOK, w.Code, "numeric amounts are accepted: %s", w.Body.String())
+	w = serve(router, http.MethodPost, wallet("transfer", alice), `{"to_user_id":"`+bob+`","amount":"40","currency":"USD"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(router, http.MethodPost, wallet("withdraw", bob), `{"amount":"10","currency":"USD"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(router, http.MethodGet, wallet("balance", bob), "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"balance":"30","currency":"USD"}`, w.Body.String())
+
+	w = serve(router, http.MethodGet, wallet("transactions", alice)+"&page_size=100", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var transactions []map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &transactions))
+	require.Len(t, transactions, 3)
+	assert.Contains(t, transactions[0], "currency")
+
+	w = serve(router, http.MethodPost, wallet("withdraw", bob), `{"amount":"31","currency":"USD"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+}
+
+func TestOpenAPI_RejectsInvalidRequests(t *testing.T) {
+	router, _ := newOpenAPIRouter(t)
+	user := uuid.NewString()
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		body    string
+		message string
+	}{
+		{"malformed user id", http.MethodGet, "/api/v1/wallet/balance?user_id=abc", "", `query parameter "user_id"`},
+		{"missing user id", http.MethodGet, "/api/v1/wallet/balance", "", `query parameter "user_id"`},
+		{"page size too large", http.MethodGet, "/api/v1/wallet/transactions?user_id=" + user + "&page_size=1000", "", `query parameter "page_size"`},
+		{"negative amount", http.MethodPost, "/api/v1/wallet/deposit?user_id=" + user, `{"amount":"-1","currency":"USD"}`, "request body"},
+		{"missing currency", http.MethodPost, "/api/v1/wallet/deposit?user_id=" + user, `{"amount":"1"}`, "request body"},
+		{"malformed recipient", http.MethodPost, "/api/v1/wallet/transfer?user_id=" + user, `{"to_user_id":"bob","amount":"1","currency":"USD"}`, "to_user_id"},
+		{"not json", http.MethodPost, "/api/v1/wallet/deposit?user_id=" + user, `amount=1`, "request body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, tt.method, tt.url, tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var body map[string]string
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Contains(t, body["error"], tt.message)
+		})
+	}
+}
+
+func TestOpenAPI_ResponseDriftIsAnError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	spec, err := openapi.New()
+	require.NoError(t, err)
+	router := gin.New()
+	router.GET("/api/v1/wallet/balance", spec.Middleware(openapi.Config{Responses: true}), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"amount": "1"})
+	})
+
+	w := serve(router, http.MethodGet, "/api/v1/wallet/balance?user_id="+uuid.NewString(), "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "OpenAPI document")
+}
+
+func TestOpenAPI_Undocumented(t *testing.T) {
+	router, spec := newOpenAPIRouter(t)
+	assert.Empty(t, spec.Undocumented(router.Routes(), "/api/v1/wallet"))
+
+	router.DELETE("/api/v1/wallet/balance", func(*gin.Context) {})
+	router.GET("/api/v1/wallet/freeze", func(*gin.Context) {})
+	router.GET("/api/v1/audit/events", func(*gin.Context) {})
+	assert.Equal(t, []string{"DELETE /api/v1/wallet/balance", "GET /api/v1/wallet/freeze"},
+		spec.Undocumented(router.Routes(), "/api/v1/wallet"))
+}
+
+// failingWalletService fails every money movement with err
+type failingWalletService struct {
+	service.WalletService
+	err error
+}
+
+func (s failingWalletService) Deposit(context.Context, uuid.UUID, decimal.Decimal, string, string) (*model.WalletResponse, error) {
+	return nil, s.err
+}
+
+func (s failingWalletService) Withdraw(context.Context, uuid.UUID, decimal.Decimal, string, string) (*model.WalletResponse, error) {
+	return nil, s.err
+}
+
+func (s failingWalletService) Transfer(context.Context, uuid.UUID, uuid.UUID, decimal.Decimal, string, string) (*model.WalletResponse, error) {
+	return nil, s.err
+}
+
+// TestOpenAPI_ErrorStatuses pins the status of each error type per route to
+// the documented one; a status the document doesn't list would come back 500
+func TestOpenAPI_ErrorStatuses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	spec, err := openapi.New()
+	require.NoError(t, err)
+
+	user := uuid.NewString()
+	deposit := `{"amount":"1","currency":"USD"}`
+	transfer := `{"to_user_id":"` + uuid.NewString() + `","amount":"1","currency":"USD"}`
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		err    error
+		status int
+	}{
+		{"deposit invalid", "deposit", deposit, errors.NewInvalidInput("op", "to_user_id", user), http.StatusBadRequest},
+		{"deposit conflict", "deposit", deposit, errors.NewConflict("op", "optimistic lock conflict"), http.StatusConflict},
+		{"deposit failure", "deposit", deposit, errors.WrapInternal("op", fmt.Errorf("connection reset")), http.StatusInternalServerError},
+		{"withdraw frozen", "withdraw", deposit, errors.NewWalletFrozen("op"), http.StatusForbidden},
+		{"withdraw conflict", "withdraw", deposit, errors.NewConflict("op", "optimistic lock conflict"), http.StatusConflict},
+		{"withdraw insufficient", "withdraw", deposit, errors.NewInsufficientBalance("op"), http.StatusUnprocessableEntity},
+		{"transfer frozen", "transfer", transfer, errors.NewWalletFrozen("op"), http.StatusForbidden},
+		{"transfer insufficient", "transfer", transfer, errors.NewInsufficientBalance("op"), http.StatusUnprocessableEntity},
+		{"transfer failure", "transfer", transfer, errors.WrapInternal("op", fmt.Errorf("connection reset")), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := api.NewWalletHandler(failingWalletService{err: tt.err})
+			router := gin.New()
+			wallet := router.Group("/api/v1/wallet", spec.Middleware(openapi.Config{Responses: true}))
+			wallet.POST("/deposit", handler.Deposit)
+			wallet.POST("/withdraw", handler.Withdraw)
+			wallet.POST("/transfer", handler.Transfer)
+
+			w := serve(router, http.MethodPost, "/api/v1/wallet/"+tt.path+"?user_id="+user, tt.body)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.NotContains(t, w.Body.String(), "OpenAPI document")
+		})
+	}
+}
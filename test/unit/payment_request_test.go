@@ -0,0 +1,235 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockPaymentRequestRepository struct {
+	mock.Mock
+}
+
+func (m *MockPaymentRequestRepository) CreatePaymentRequest(ctx context.Context, req *model.PaymentRequest) error {
+	args := m.Called(ctx, req)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRequestRepository) GetPaymentRequest(ctx context.Context, id uuid.UUID) (*model.PaymentRequest, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.PaymentRequest), args.Error(1)
+}
+
+func (m *MockPaymentRequestRepository) ListPaymentRequests(ctx context.Context, userID uuid.UUID, direction, status string, offset, limit int) ([]model.PaymentRequest, error) {
+	args := m.Called(ctx, userID, direction, status, offset, limit)
+	return args.Get(0).([]model.PaymentRequest), args.Error(1)
+}
+
+func (m *MockPaymentRequestRepository) TransitionPaymentRequest(ctx context.Context, id uuid.UUID, from, to string) (bool, error) {
+	args := m.Called(ctx, id, from, to)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockPaymentRequestRepository) ExpirePaymentRequests(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+type paymentRequestFixture struct {
+	*batchFixture
+	repo      *MockPaymentRequestRepository
+	svc       service.PaymentRequestService
+	requester uuid.UUID
+	payer     uuid.UUID
+	request   *model.PaymentRequest
+}
+
+func newPaymentRequestFixture() *paymentRequestFixture {
+	f := &paymentRequestFixture{
+		batchFixture: newBatchFixture(service.BatchConfig{}),
+		repo:         &MockPaymentRequestRepository{},
+		requester:    uuid.New(),
+		payer:        uuid.New(),
+	}
+	f.svc = service.NewPaymentRequestService(f.repo, service.NewWalletService(f.wr, f.tr, f.tr))
+	f.request = &model.PaymentRequest{
+		ID:          uuid.New(),
+		RequesterID: f.requester,
+		PayerID:     f.payer,
+		Amount:      decimal.NewFromInt(30),
+		Currency:    "USD",
+		Status:      model.PaymentRequestPending,
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+	f.repo.On("GetPaymentRequest", mock.Anything, f.request.ID).Return(f.request, nil)
+	return f
+}
+
+func TestPaymentRequest_Create(t *testing.T) {
+	ctx := context.Background()
+	requester := uuid.New()
+
+	invalid := map[string]model.CreatePaymentRequest{
+		"zero amount":     {PayerID: uuid.New(), Amount: decimal.Zero, Currency: "USD"},
+		"request to self": {PayerID: requester, Amount: decimal.NewFromInt(5), Currency: "USD"},
+		"bad expiry":      {PayerID: uuid.New(), Amount: decimal.NewFromInt(5), Currency: "USD", ExpiresIn: "soon"},
+		"expiry too long": {PayerID: uuid.New(), Amount: decimal.NewFromInt(5), Currency: "USD", ExpiresIn: "2000h"},
+	}
+	for name, req := range invalid {
+		t.Run(name, func(t *testing.T) {
+			repo := &MockPaymentRequestRepository{}
+			_, err := service.NewPaymentRequestService(repo, nil).CreatePaymentRequest(ctx, requester, req)
+			assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+			repo.AssertNotCalled(t, "CreatePaymentRequest", mock.Anything, mock.Anything)
+		})
+	}
+
+	t.Run("defaults to a week", func(t *testing.T) {
+		repo := &MockPaymentRequestRepository{}
+		repo.On("CreatePaymentRequest", mock.Anything, mock.AnythingOfType("*model.PaymentRequest")).Return(nil)
+
+		pr, err := service.NewPaymentRequestService(repo, nil).CreatePaymentRequest(ctx, requester, model.CreatePaymentRequest{
+			PayerID: uuid.New(), Amount: decimal.NewFromInt(5), Currency: "USD", Note: "dinner",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, model.PaymentRequestPending, pr.Status)
+		assert.WithinDuration(t, time.Now().Add(7*24*time.Hour), pr.ExpiresAt, time.Minute)
+	})
+}
+
+func TestPaymentRequest_Accept(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("transfers from payer to requester", func(t *testing.T) {
+		f := newPaymentRequestFixture()
+		payerWallet := &model.Wallet{ID: uuid.New(), UserID: f.payer, Currency: "USD", Balance: decimal.NewFromInt(100)}
+		requesterWallet := &model.Wallet{ID: uuid.New(), UserID: f.requester, Currency: "USD", Balance: decimal.Zero}
+		f.wr.On("GetWalletByUserAndCurrency", mock.Anything, f.payer, "USD").Return(payerWallet, nil)
+		f.wr.On("GetWalletByUserAndCurrency", mock.Anything, f.requester, "USD").Return(requesterWallet, nil)
+		f.wt.On("GetWalletForUpdate", mock.Anything, payerWallet.ID).Return(payerWallet, nil)
+		f.wt.On("GetWalletForUpdate", mock.Anything, requesterWallet.ID).Return(requesterWallet, nil)
+		f.wt.On("UpdateWalletBalanceTx", mock.Anything, payerWallet.ID, decimal.NewFromInt(70)).Return(nil)
+		f.wt.On("UpdateWalletBalanceTx", mock.Anything, requesterWallet.ID, decimal.NewFromInt(30)).Return(nil)
+		f.wt.On("TransitionPaymentRequestTx", mock.Anything, f.request.ID, model.PaymentRequestPending, model.PaymentRequestAccepted).Return(true, nil)
+
+		_, err := f.svc.AcceptPaymentRequest(ctx, f.payer, f.request.ID)
+		require.NoError(t, err)
+		// accepted in the transfer's transaction, not on its own
+		f.wt.AssertCalled(t, "TransitionPaymentRequestTx", mock.Anything, f.request.ID, model.PaymentRequestPending, model.PaymentRequestAccepted)
+		f.repo.AssertNotCalled(t, "TransitionPaymentRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		f.wt.AssertCalled(t, "Commit")
+		f.wt.AssertNumberOfCalls(t, "UpdateWalletBalanceTx", 2)
+		f.wt.AssertCalled(t, "CreateTransactionTx", mock.Anything, mock.MatchedBy(func(tx *model.Transaction) bool {
+			return tx.Reference == "payment request "+f.request.ID.String()
+		}))
+	})
+
+	t.Run("failed transfer leaves the request pending", func(t *testing.T) {
+		f := newPaymentRequestFixture()
+		payerWallet := &model.Wallet{ID: uuid.New(), UserID: f.payer, Currency: "USD", Balance: decimal.NewFromInt(10)}
+		requesterWallet := &model.Wallet{ID: uuid.New(), UserID: f.requester, Currency: "USD", Balance: decimal.Zero}
+		f.wr.On("GetWalletByUserAndCurrency", mock.Anything, f.payer, "USD").Return(payerWallet, nil)
+		f.wr.On("GetWalletByUserAndCurrency", mock.Anything, f.requester, "USD").Return(requesterWallet, nil)
+		f.wt.On("GetWalletForUpdate", mock.Anything, payerWallet.ID).Return(payerWallet, nil)
+		f.wt.On("GetWalletForUpdate", mock.Anything, requesterWallet.ID).Return(requesterWallet, nil)
+		f.wt.On("TransitionPaymentRequestTx", mock.Anything, f.request.ID, model.PaymentRequestPending, model.PaymentRequestAccepted).Return(true, nil)
+
+		_, err := f.svc.AcceptPaymentRequest(ctx, f.payer, f.request.ID)
+		assert.Equal(t, errors.InsufficientFund, errors.TypeOf(err))
+		// rolling back the transfer rolls back the acceptance, nothing to revert
+		f.wt.AssertNotCalled(t, "Commit")
+		f.wt.AssertCalled(t, "Rollback")
+		f.repo.AssertNotCalled(t, "TransitionPaymentRequest", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("already accepted is a conflict", func(t *testing.T) {
+		f := newPaymentRequestFixture()
+		accepted := *f.request
+		accepted.Status = model.PaymentRequestAccepted
+		// the first read still sees it pending, another accept wins before the transition
+		f.repo.ExpectedCalls = nil
+		f.repo.On("GetPaymentRequest", mock.Anything, f.request.ID).Return(f.request, nil).Once()
+		f.wt.On("TransitionPaymentRequestTx", mock.Anything, f.request.ID, model.PaymentRequestPending, model.PaymentRequestAccepted).Return(false, nil)
+		f.repo.On("GetPaymentRequest", mock.Anything, f.request.ID).Return(&accepted, nil)
+
+		_, err := f.svc.AcceptPaymentRequest(ctx, f.payer, f.request.ID)
+		assert.Equal(t, errors.Conflict, errors.TypeOf(err))
+		assert.Equal(t, "payment request is already accepted", errors.MessageOf(err))
+		f.wt.AssertNotCalled(t, "GetWalletForUpdate", mock.Anything, mock.Anything)
+		f.wt.AssertNotCalled(t, "Commit")
+		f.wt.AssertCalled(t, "Rollback")
+	})
+
+	t.Run("expired request is a conflict", func(t *testing.T) {
+		f := newPaymentRequestFixture()
+		f.request.ExpiresAt = time.Now().Add(-time.Minute)
+		f.wt.On("TransitionPaymentRequestTx", mock.Anything, f.request.ID, model.PaymentRequestPending, model.PaymentRequestAccepted).Return(false, nil)
+
+		_, err := f.svc.AcceptPaymentRequest(ctx, f.payer, f.request.ID)
+		assert.Equal(t, "payment request has expired", errors.MessageOf(err))
+	})
+
+	t.Run("requester cannot accept", func(t *testing.T) {
+		f := newPaymentRequestFixture()
+
+		_, err := f.svc.AcceptPaymentRequest(ctx, f.requester, f.request.ID)
+		assert.Equal(t, errors.Forbidden, errors.TypeOf(err))
+		f.tr.AssertNotCalled(t, "BeginTx", mock.Anything)
+	})
+
+	t.Run("other users' requests are not found", func(t *testing.T) {
+		f := newPaymentRequestFixture()
+
+		_, err := f.svc.AcceptPaymentRequest(ctx, uuid.New(), f.request.ID)
+		assert.Equal(t, errors.NotFound, errors.TypeOf(err))
+	})
+}
+
+func TestPaymentRequest_DeclineAndCancel(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("payer declines", func(t *testing.T) {
+		f := newPaymentRequestFixture()
+		f.repo.On("TransitionPaymentRequest", mock.Anything, f.request.ID, model.PaymentRequestPending, model.PaymentRequestDeclined).Return(true, nil)
+
+		_, err := f.svc.DeclinePaymentRequest(ctx, f.payer, f.request.ID)
+		require.NoError(t, err)
+		f.tr.AssertNotCalled(t, "BeginTx", mock.Anything)
+	})
+
+	t.Run("only the requester cancels", func(t *testing.T) {
+		f := newPaymentRequestFixture()
+		f.repo.On("TransitionPaymentRequest", mock.Anything, f.request.ID, model.PaymentRequestPending, model.PaymentRequestCancelled).Return(true, nil)
+
+		_, err := f.svc.CancelPaymentRequest(ctx, f.payer, f.request.ID)
+		assert.Equal(t, errors.Forbidden, errors.TypeOf(err))
+
+		_, err = f.svc.CancelPaymentRequest(ctx, f.requester, f.request.ID)
+		require.NoError(t, err)
+	})
+}
+
+func TestPaymentRequest_List(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	repo := &MockPaymentRequestRepository{}
+	repo.On("ListPaymentRequests", mock.Anything, userID, model.PaymentRequestsIncoming, model.PaymentRequestPending, 20, 20).
+		Return([]model.PaymentRequest{}, nil)
+	svc := service.NewPaymentRequestService(repo, nil)
+
+	_, err := svc.ListPaymentRequests(ctx, userID, model.PaymentRequestsIncoming, model.PaymentRequestPending, 2, 20)
+	require.NoError(t, err)
+
+	_, err = svc.ListPaymentRequests(ctx, userID, model.PaymentRequestsIncoming, "paid", 1, 20)
+	assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+	repo.AssertNumberOfCalls(t, "ListPaymentRequests", 1)
+}
@@ -0,0 +1,285 @@
+package unit
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/auth"
+	"github.com/Jiang-hao/walletApiService/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ratelimit.Limit
+	}{
+		{"20/s", ratelimit.Limit{Requests: 20, Period: time.Second}},
+		{"300/m", ratelimit.Limit{Requests: 300, Period: time.Minute}},
+		{" 50/10s ", ratelimit.Limit{Requests: 50, Period: 10 * time.Second}},
+		{"1000/h", ratelimit.Limit{Requests: 1000, Period: time.Hour}},
+	}
+	for _, tt := range tests {
+		got, err := ratelimit.ParseLimit(tt.in)
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+	assert.Equal(t, "300/m", ratelimit.Limit{Requests: 300, Period: time.Minute}.String())
+	assert.Equal(t, "50/10s", ratelimit.Limit{Requests: 50, Period: 10 * time.Second}.String())
+
+	for _, in := range []string{"", "20", "0/s", "-1/s", "x/s", "20/", "20/fortnight", "20/-1s"} {
+		_, err := ratelimit.ParseLimit(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestMemoryStore_TokenBucket(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	ctx := context.Background()
+	limit := ratelimit.Limit{Requests: 2, Period: time.Second}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// a new bucket starts full
+	result, err := store.Take(ctx, "k", limit, start)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+	assert.Equal(t, 500*time.Millisecond, result.Reset)
+
+	result, _ = store.Take(ctx, "k", limit, start)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, time.Second, result.Reset)
+
+	result, _ = store.Take(ctx, "k", limit, start.Add(100*time.Millisecond))
+	assert.False(t, result.Allowed)
+	assert.InDelta(t, 400*time.Millisecond, result.RetryAfter, float64(time.Microsecond))
+
+	// other keys have buckets of their own
+	result, _ = store.Take(ctx, "other", limit, start.Add(100*time.Millisecond))
+	assert.True(t, result.Allowed)
+
+	// refilled at 2 per second, never above the burst
+	result, _ = store.Take(ctx, "k", limit, start.Add(600*time.Millisecond))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	result, _ = store.Take(ctx, "k", limit, start.Add(time.Hour))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+}
+
+func TestMemoryStore_DropsRefilledBuckets(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	ctx := context.Background()
+	limit := ratelimit.Limit{Requests: 10, Period: time.Minute}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := store.Take(ctx, "idle", limit, start)
+	require.NoError(t, err)
+	for range 10 {
+		_, err = store.Take(ctx, "busy", limit, start.Add(50*time.Second))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, store.Len())
+
+	// a minute on, the idle bucket is full again and dropped, the busy one
+	// still has tokens to refill
+	_, err = store.Take(ctx, "busy", limit, start.Add(70*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.Len())
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, ratelimit.Limit, time.Time) (ratelimit.Result, error) {
+	return ratelimit.Result{}, stderrors.New("store unavailable")
+}
+
+// newRateLimitedRouter authenticates alice, bob and carol with their names as
+// bearer tokens, as the server does with ADMIN_AUTH_TOKENS, and trusts no proxy
+func newRateLimitedRouter(store ratelimit.Store, cfg ratelimit.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies(nil); err != nil {
+		panic(err)
+	}
+	router.Use(auth.Middleware(map[string]string{"alice": "alice", "bob": "bob", "carol": "carol"}))
+	wallet := router.Group("/api/v1/wallet", ratelimit.Middleware(store, cfg))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	wallet.POST("/transfer", ok)
+	wallet.GET("/balance", ok)
+	return router
+}
+
+// serveFrom sends the request from ip, authenticated as user unless it is ""
+func serveFrom(router *gin.Engine, method, url, user, ip string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, url, nil)
+	req.RemoteAddr = ip + ":40000"
+	if user != "" {
+		req.Header.Set("Authorization", "Bearer "+user)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimit_PerUserAndClass(t *testing.T) {
+	router := newRateLimitedRouter(ratelimit.NewMemoryStore(), ratelimit.Config{
+		Read:  ratelimit.Limits{User: ratelimit.Limit{Requests: 5, Period: time.Minute}},
+		Write: ratelimit.Limits{User: ratelimit.Limit{Requests: 2, Period: time.Minute}},
+	})
+	const ip = "192.0.2.1"
+
+	w := serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "alice", ip)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get(ratelimit.LimitHeader))
+	assert.Equal(t, "1", w.Header().Get(ratelimit.RemainingHeader))
+	assert.Equal(t, "30", w.Header().Get(ratelimit.ResetHeader))
+	assert.Empty(t, w.Header().Get(ratelimit.RetryAfterHeader))
+
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "alice", ip)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "alice", ip)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get(ratelimit.RemainingHeader))
+	assert.Equal(t, "30", w.Header().Get(ratelimit.RetryAfterHeader))
+	assert.JSONEq(t, `{"error":"rate limit of 2/m per user exceeded, retry in 30s"}`, w.Body.String())
+
+	// reads have a bucket of their own, and so does every other user
+	w = serveFrom(router, http.MethodGet, "/api/v1/wallet/balance", "alice", ip)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "5", w.Header().Get(ratelimit.LimitHeader))
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "bob", ip)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimit_PerClientIP(t *testing.T) {
+	router := newRateLimitedRouter(ratelimit.NewMemoryStore(), ratelimit.Config{
+		Write: ratelimit.Limits{IP: ratelimit.Limit{Requests: 3, Period: time.Second}},
+	})
+
+	// users sharing an IP share its limit, authenticated or not
+	for _, user := range []string{"alice", "bob", ""} {
+		w := serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", user, "192.0.2.1")
+		require.Equal(t, http.StatusOK, w.Code, user)
+	}
+	w := serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "carol", "192.0.2.1")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get(ratelimit.RetryAfterHeader))
+	assert.Contains(t, w.Body.String(), "per ip")
+
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "carol", "192.0.2.2")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimit_UserDeniedFirstSparesTheIP(t *testing.T) {
+	router := newRateLimitedRouter(ratelimit.NewMemoryStore(), ratelimit.Config{
+		Write: ratelimit.Limits{
+			User: ratelimit.Limit{Requests: 1, Period: time.Minute},
+			IP:   ratelimit.Limit{Requests: 2, Period: time.Minute},
+		},
+	})
+
+	require.Equal(t, http.StatusOK, serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "alice", "192.0.2.1").Code)
+	for range 5 {
+		require.Equal(t, http.StatusTooManyRequests, serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "alice", "192.0.2.1").Code)
+	}
+	// alice's rejected requests took nothing from the IP
+	w := serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "bob", "192.0.2.1")
+	require.Equal(t, http.StatusOK, w.Code)
+	// the headers describe the tighter bucket, bob's is as empty as the IP's
+	assert.Equal(t, "0", w.Header().Get(ratelimit.RemainingHeader))
+}
+
+// Users acting through one caller, e.g. a gateway, get buckets of their own
+func TestRateLimit_UsersOfOneCaller(t *testing.T) {
+	router := newRateLimitedRouter(ratelimit.NewMemoryStore(), ratelimit.Config{
+		Write: ratelimit.Limits{User: ratelimit.Limit{Requests: 1, Period: time.Minute}},
+	})
+	const ip = "192.0.2.1"
+
+	w := serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer?user_id=u1", "alice", ip)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer?user_id=u1", "alice", ip)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "per user")
+
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer?user_id=u2", "alice", ip)
+	assert.Equal(t, http.StatusOK, w.Code, "u2 has a bucket of its own")
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer?user_id=u1", "bob", ip)
+	assert.Equal(t, http.StatusOK, w.Code, "u1 through another caller has a bucket of its own")
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "alice", ip)
+	assert.Equal(t, http.StatusOK, w.Code, "alice acting for no user has a bucket of its own")
+}
+
+// A user_id an unauthenticated client claims is limited per client IP, so it
+// can't spend the tokens of the same user elsewhere. Nor does the client's
+// own X-Forwarded-For let it escape its IP's limit.
+func TestRateLimit_ClaimedUsersScopedToTheIP(t *testing.T) {
+	router := newRateLimitedRouter(ratelimit.NewMemoryStore(), ratelimit.Config{
+		Write: ratelimit.Limits{
+			User: ratelimit.Limit{Requests: 1, Period: time.Minute},
+			IP:   ratelimit.Limit{Requests: 3, Period: time.Minute},
+		},
+	})
+
+	w := serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer?user_id=u1", "", "192.0.2.1")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer?user_id=u1", "", "192.0.2.1")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "per user")
+
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer?user_id=u1", "", "192.0.2.2")
+	assert.Equal(t, http.StatusOK, w.Code, "u1 from another IP is untouched")
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer?user_id=u1", "alice", "192.0.2.1")
+	assert.Equal(t, http.StatusOK, w.Code, "u1 through a caller is untouched")
+
+	// an unauthenticated request for no user is limited per IP only
+	for range 2 {
+		w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "", "192.0.2.3")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "3", w.Header().Get(ratelimit.LimitHeader), "only the IP's bucket")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet/transfer", nil)
+	req.RemoteAddr = "192.0.2.3:40000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "", "192.0.2.3")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "an untrusted client's X-Forwarded-For is ignored")
+}
+
+func TestRateLimit_CustomUserKey(t *testing.T) {
+	router := newRateLimitedRouter(ratelimit.NewMemoryStore(), ratelimit.Config{
+		Read:    ratelimit.Limits{User: ratelimit.Limit{Requests: 1, Period: time.Minute}},
+		UserKey: func(c *gin.Context) string { return c.GetHeader("X-User") },
+	})
+
+	serveAs := func(user string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/wallet/balance", nil)
+		req.Header.Set("X-User", user)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, serveAs("alice"))
+	assert.Equal(t, http.StatusTooManyRequests, serveAs("alice"))
+	assert.Equal(t, http.StatusOK, serveAs("bob"))
+}
+
+func TestRateLimit_FailingStoreLetsRequestsThrough(t *testing.T) {
+	router := newRateLimitedRouter(failingStore{}, ratelimit.Config{})
+	for range 3 {
+		w := serveFrom(router, http.MethodPost, "/api/v1/wallet/transfer", "alice", "192.0.2.1")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get(ratelimit.LimitHeader))
+	}
+}
@@ -0,0 +1,81 @@
+package unit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/metrics"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockReconciliationRepository struct {
+	mock.Mock
+}
+
+func (m *MockReconciliationRepository) Reconcile(ctx context.Context, limit int) (*model.ReconciliationReport, error) {
+	args := m.Called(ctx, limit)
+	return args.Get(0).(*model.ReconciliationReport), args.Error(1)
+}
+
+func TestReconciliation_Reconcile(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("exports counts of every kind", func(t *testing.T) {
+		walletID := uuid.New()
+		repo := &MockReconciliationRepository{}
+		repo.On("Reconcile", mock.Anything, 100).Return(&model.ReconciliationReport{
+			Wallets:      3,
+			Transactions: 12,
+			Counts: map[string]int64{
+				model.DiscrepancyBalanceMismatch: 1,
+				model.DiscrepancyBrokenChain:     250,
+			},
+			Discrepancies: []model.Discrepancy{{
+				Kind:     model.DiscrepancyBalanceMismatch,
+				WalletID: &walletID,
+				Currency: "USD",
+				Expected: decimal.NewFromInt(90),
+				Actual:   decimal.NewFromInt(100),
+			}},
+			Truncated: true,
+		}, nil)
+		// a kind fixed since the previous run must drop back to zero
+		metrics.ReconciliationDiscrepancies.WithLabelValues(model.DiscrepancyCurrencyTotal).Set(4)
+
+		report, err := service.NewReconciliationService(repo, 0).Reconcile(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(251), report.Total())
+		assert.False(t, report.FinishedAt.Before(report.StartedAt))
+
+		for kind, want := range map[string]float64{
+			model.DiscrepancyBalanceMismatch:   1,
+			model.DiscrepancyBrokenChain:       250,
+			model.DiscrepancyUnpairedTransfer:  0,
+			model.DiscrepancyTransferImbalance: 0,
+			model.DiscrepancyCurrencyTotal:     0,
+		} {
+			assert.Equal(t, want, testutil.ToFloat64(metrics.ReconciliationDiscrepancies.WithLabelValues(kind)), kind)
+		}
+		assert.InDelta(t, float64(report.FinishedAt.Unix()), testutil.ToFloat64(metrics.ReconciliationLastSuccess), 1)
+	})
+
+	t.Run("failure keeps the last counts", func(t *testing.T) {
+		repo := &MockReconciliationRepository{}
+		repo.On("Reconcile", mock.Anything, 10).
+			Return((*model.ReconciliationReport)(nil), errors.NewInternal("reconciliation.Reconcile", fmt.Errorf("connection reset")))
+		metrics.ReconciliationDiscrepancies.WithLabelValues(model.DiscrepancyBrokenChain).Set(7)
+
+		_, err := service.NewReconciliationService(repo, 10).Reconcile(ctx)
+		assert.Equal(t, errors.Internal, errors.TypeOf(err))
+		assert.Equal(t, float64(7), testutil.ToFloat64(metrics.ReconciliationDiscrepancies.WithLabelValues(model.DiscrepancyBrokenChain)))
+	})
+}
@@ -0,0 +1,334 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/schedule"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockScheduleRepository struct {
+	mock.Mock
+}
+
+func (m *MockScheduleRepository) CreateSchedule(ctx context.Context, s *model.TransferSchedule) error {
+	args := m.Called(ctx, s)
+	return args.Error(0)
+}
+
+func (m *MockScheduleRepository) GetSchedule(ctx context.Context, id uuid.UUID) (*model.TransferSchedule, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.TransferSchedule), args.Error(1)
+}
+
+func (m *MockScheduleRepository) ListSchedules(ctx context.Context, userID uuid.UUID) ([]model.TransferSchedule, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]model.TransferSchedule), args.Error(1)
+}
+
+func (m *MockScheduleRepository) SetScheduleStatus(ctx context.Context, id uuid.UUID, from []string, status string, nextRunAt *time.Time) (bool, error) {
+	args := m.Called(ctx, id, from, status, nextRunAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockScheduleRepository) ClaimDueSchedules(ctx context.Context, limit int, lease time.Duration) ([]model.ClaimedSchedule, error) {
+	args := m.Called(ctx, limit, lease)
+	return args.Get(0).([]model.ClaimedSchedule), args.Error(1)
+}
+
+func (m *MockScheduleRepository) RecordRun(ctx context.Context, s *model.TransferSchedule, run *model.ScheduleRun) error {
+	args := m.Called(ctx, s, run)
+	return args.Error(0)
+}
+
+func (m *MockScheduleRepository) ListRuns(ctx context.Context, scheduleID uuid.UUID, offset, limit int) ([]model.ScheduleRun, error) {
+	args := m.Called(ctx, scheduleID, offset, limit)
+	return args.Get(0).([]model.ScheduleRun), args.Error(1)
+}
+
+type MockTransferer struct {
+	mock.Mock
+}
+
+func (m *MockTransferer) Transfer(ctx context.Context, fromUserID, toUserID uuid.UUID, amount decimal.Decimal, currency, reference string) (*model.WalletResponse, error) {
+	args := m.Called(ctx, fromUserID, toUserID, amount, currency, reference)
+	return args.Get(0).(*model.WalletResponse), args.Error(1)
+}
+
+func mustTime(t *testing.T, value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	require.NoError(t, err)
+	return parsed
+}
+
+func TestSchedule_NextRun(t *testing.T) {
+	t.Run("interval stays on the start grid", func(t *testing.T) {
+		seconds := int64(3600)
+		s := &model.TransferSchedule{Kind: model.ScheduleInterval, IntervalSeconds: &seconds, StartAt: mustTime(t, "2026-01-01T00:00:00Z")}
+
+		next, ok, err := schedule.Next(s, mustTime(t, "2026-01-01T05:17:00Z"))
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, mustTime(t, "2026-01-01T06:00:00Z"), next)
+
+		first, ok, err := schedule.First(s, mustTime(t, "2026-01-01T05:00:00Z"))
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, mustTime(t, "2026-01-01T05:00:00Z"), first)
+	})
+
+	t.Run("cron keeps local time across DST", func(t *testing.T) {
+		expr := "0 9 * * *"
+		s := &model.TransferSchedule{Kind: model.ScheduleCron, CronExpr: &expr, Timezone: "Europe/London", StartAt: mustTime(t, "2026-03-01T00:00:00Z")}
+
+		// GMT before the change on 29 March, BST after it
+		before, ok, err := schedule.Next(s, mustTime(t, "2026-03-27T12:00:00Z"))
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, mustTime(t, "2026-03-28T09:00:00Z"), before)
+
+		after, ok, err := schedule.Next(s, before)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, mustTime(t, "2026-03-29T08:00:00Z"), after)
+	})
+
+	t.Run("no run after end_at", func(t *testing.T) {
+		seconds := int64(86400)
+		end := mustTime(t, "2026-01-03T12:00:00Z")
+		s := &model.TransferSchedule{Kind: model.ScheduleInterval, IntervalSeconds: &seconds, StartAt: mustTime(t, "2026-01-01T00:00:00Z"), EndAt: &end}
+
+		_, ok, err := schedule.Next(s, mustTime(t, "2026-01-03T00:00:00Z"))
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("one-off has no next run", func(t *testing.T) {
+		s := &model.TransferSchedule{Kind: model.ScheduleOnce, StartAt: mustTime(t, "2026-01-01T00:00:00Z")}
+
+		_, ok, err := schedule.Next(s, s.StartAt)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func dueSchedule(kind string, attempt, maxRetries int) model.ClaimedSchedule {
+	due := time.Now().UTC().Add(-time.Minute)
+	s := model.TransferSchedule{
+		ID:                   uuid.New(),
+		UserID:               uuid.New(),
+		ToUserID:             uuid.New(),
+		Amount:               decimal.NewFromInt(25),
+		Currency:             "USD",
+		Kind:                 kind,
+		Timezone:             "UTC",
+		StartAt:              due.Add(-24 * time.Hour),
+		Status:               model.ScheduleActive,
+		NextRunAt:            &due,
+		Attempt:              attempt,
+		MaxRetries:           maxRetries,
+		RetryIntervalSeconds: 600,
+	}
+	if kind == model.ScheduleInterval {
+		seconds := int64(86400)
+		s.IntervalSeconds = &seconds
+	}
+	return model.ClaimedSchedule{TransferSchedule: s}
+}
+
+func runSchedule(t *testing.T, claimed model.ClaimedSchedule, transferErr error) (*model.TransferSchedule, *model.ScheduleRun, *MockTransferer) {
+	repo := &MockScheduleRepository{}
+	transfers := &MockTransferer{}
+	repo.On("ClaimDueSchedules", mock.Anything, 50, 5*time.Minute).Return([]model.ClaimedSchedule{claimed}, nil)
+	transfers.On("Transfer", mock.Anything, claimed.UserID, claimed.ToUserID, claimed.Amount, "USD", "schedule "+claimed.ID.String()).
+		Return((*model.WalletResponse)(nil), transferErr)
+
+	var recorded *model.TransferSchedule
+	var run *model.ScheduleRun
+	repo.On("RecordRun", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		recorded = args.Get(1).(*model.TransferSchedule)
+		run = args.Get(2).(*model.ScheduleRun)
+	}).Return(nil)
+
+	n, err := schedule.NewScheduler(repo, transfers, schedule.SchedulerConfig{}).ProcessBatch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NotNil(t, recorded)
+	return recorded, run, transfers
+}
+
+func TestScheduler_ProcessBatch(t *testing.T) {
+	t.Run("success advances to the next occurrence", func(t *testing.T) {
+		claimed := dueSchedule(model.ScheduleInterval, 0, 3)
+		sched, run, _ := runSchedule(t, claimed, nil)
+
+		assert.Equal(t, model.RunSucceeded, run.Status)
+		assert.Equal(t, model.ScheduleActive, sched.Status)
+		assert.Equal(t, 1, sched.Runs)
+		require.NotNil(t, sched.NextRunAt)
+		assert.True(t, sched.NextRunAt.After(time.Now()))
+	})
+
+	t.Run("insufficient funds is retried", func(t *testing.T) {
+		claimed := dueSchedule(model.ScheduleInterval, 0, 3)
+		sched, run, _ := runSchedule(t, claimed, errors.NewInsufficientBalance("test"))
+
+		assert.Equal(t, model.RunRetrying, run.Status)
+		assert.Equal(t, 1, sched.Attempt)
+		assert.Equal(t, model.ScheduleActive, sched.Status)
+		assert.WithinDuration(t, time.Now().Add(10*time.Minute), *sched.NextRunAt, 5*time.Second)
+		assert.Equal(t, "insufficient balance", *run.Error)
+	})
+
+	t.Run("one-off fails once retries are used up", func(t *testing.T) {
+		claimed := dueSchedule(model.ScheduleOnce, 3, 3)
+		sched, run, _ := runSchedule(t, claimed, errors.NewInsufficientBalance("test"))
+
+		assert.Equal(t, model.RunFailed, run.Status)
+		assert.Equal(t, 4, run.Attempt)
+		assert.Equal(t, model.ScheduleFailed, sched.Status)
+		assert.Nil(t, sched.NextRunAt)
+	})
+
+	t.Run("recurring skips the occurrence once retries are used up", func(t *testing.T) {
+		claimed := dueSchedule(model.ScheduleInterval, 3, 3)
+		sched, run, _ := runSchedule(t, claimed, errors.NewInsufficientBalance("test"))
+
+		assert.Equal(t, model.RunFailed, run.Status)
+		assert.Equal(t, model.ScheduleActive, sched.Status)
+		assert.Equal(t, 0, sched.Attempt)
+		require.NotNil(t, sched.NextRunAt)
+		assert.True(t, sched.NextRunAt.After(time.Now()))
+	})
+
+	t.Run("permanent error fails the schedule", func(t *testing.T) {
+		claimed := dueSchedule(model.ScheduleInterval, 0, 3)
+		sched, run, _ := runSchedule(t, claimed, errors.NewCurrencyMismatch("test"))
+
+		assert.Equal(t, model.RunFailed, run.Status)
+		assert.Equal(t, model.ScheduleFailed, sched.Status)
+		assert.Nil(t, sched.NextRunAt)
+	})
+
+	t.Run("interrupted run is not repeated", func(t *testing.T) {
+		claimed := dueSchedule(model.ScheduleInterval, 0, 3)
+		claimed.Interrupted = true
+		sched, run, transfers := runSchedule(t, claimed, nil)
+
+		transfers.AssertNotCalled(t, "Transfer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		assert.Equal(t, model.RunFailed, run.Status)
+		assert.Equal(t, model.ScheduleActive, sched.Status)
+		assert.True(t, sched.NextRunAt.After(time.Now()))
+	})
+}
+
+func TestScheduler_ProcessBatchRunsEveryClaimedSchedule(t *testing.T) {
+	repo := &MockScheduleRepository{}
+	transfers := &MockTransferer{}
+	claimed := []model.ClaimedSchedule{
+		dueSchedule(model.ScheduleOnce, 0, 3),
+		dueSchedule(model.ScheduleOnce, 0, 3),
+		dueSchedule(model.ScheduleOnce, 0, 3),
+	}
+	repo.On("ClaimDueSchedules", mock.Anything, 50, 5*time.Minute).Return(claimed, nil)
+	transfers.On("Transfer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, "USD", mock.Anything).
+		Return((*model.WalletResponse)(nil), nil)
+	// the first run can't be recorded, the others still are
+	repo.On("RecordRun", mock.Anything, mock.MatchedBy(func(s *model.TransferSchedule) bool { return s.ID == claimed[0].ID }), mock.Anything).
+		Return(errors.NewInternal("test", assert.AnError))
+	repo.On("RecordRun", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	n, err := schedule.NewScheduler(repo, transfers, schedule.SchedulerConfig{}).ProcessBatch(context.Background())
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, 3, n)
+	transfers.AssertNumberOfCalls(t, "Transfer", 3)
+	repo.AssertNumberOfCalls(t, "RecordRun", 3)
+}
+
+func TestScheduleService_CreateSchedule(t *testing.T) {
+	userID := uuid.New()
+	runAt := time.Now().Add(time.Hour)
+
+	invalid := map[string]model.CreateScheduleRequest{
+		"self transfer":        {ToUserID: userID, Amount: decimal.NewFromInt(10), RunAt: &runAt},
+		"zero amount":          {ToUserID: uuid.New(), Amount: decimal.Zero, RunAt: &runAt},
+		"one-off without time": {ToUserID: uuid.New(), Amount: decimal.NewFromInt(10)},
+		"bad cron":             {ToUserID: uuid.New(), Amount: decimal.NewFromInt(10), Cron: "every day"},
+		"cron and interval":    {ToUserID: uuid.New(), Amount: decimal.NewFromInt(10), Cron: "@daily", Interval: "24h"},
+		"interval too short":   {ToUserID: uuid.New(), Amount: decimal.NewFromInt(10), Interval: "5s"},
+		"unknown timezone":     {ToUserID: uuid.New(), Amount: decimal.NewFromInt(10), Cron: "@daily", Timezone: "Mars/Olympus"},
+	}
+	for name, req := range invalid {
+		t.Run(name, func(t *testing.T) {
+			repo := &MockScheduleRepository{}
+			_, err := service.NewScheduleService(repo).CreateSchedule(context.Background(), userID, req)
+			assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+			repo.AssertNotCalled(t, "CreateSchedule", mock.Anything, mock.Anything)
+		})
+	}
+
+	t.Run("cron schedule", func(t *testing.T) {
+		repo := &MockScheduleRepository{}
+		repo.On("CreateSchedule", mock.Anything, mock.AnythingOfType("*model.TransferSchedule")).Return(nil)
+
+		sched, err := service.NewScheduleService(repo).CreateSchedule(context.Background(), userID, model.CreateScheduleRequest{
+			ToUserID: uuid.New(),
+			Amount:   decimal.NewFromInt(10),
+			Currency: "USD",
+			Cron:     "0 9 1 * *",
+			Timezone: "Asia/Shanghai",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, model.ScheduleCron, sched.Kind)
+		assert.Equal(t, 3, sched.MaxRetries)
+		require.NotNil(t, sched.NextRunAt)
+		local := sched.NextRunAt.In(time.FixedZone("CST", 8*3600))
+		assert.Equal(t, 1, local.Day())
+		assert.Equal(t, 9, local.Hour())
+	})
+}
+
+func TestScheduleService_Transitions(t *testing.T) {
+	userID := uuid.New()
+	sched := dueSchedule(model.ScheduleInterval, 0, 3).TransferSchedule
+	sched.UserID = userID
+
+	t.Run("pause of a finished schedule conflicts", func(t *testing.T) {
+		repo := &MockScheduleRepository{}
+		repo.On("GetSchedule", mock.Anything, sched.ID).Return(&sched, nil)
+		repo.On("SetScheduleStatus", mock.Anything, sched.ID, []string{model.ScheduleActive}, model.SchedulePaused, (*time.Time)(nil)).Return(false, nil)
+
+		err := service.NewScheduleService(repo).PauseSchedule(context.Background(), userID, sched.ID)
+		assert.Equal(t, errors.Conflict, errors.TypeOf(err))
+	})
+
+	t.Run("resume picks the next occurrence", func(t *testing.T) {
+		repo := &MockScheduleRepository{}
+		repo.On("GetSchedule", mock.Anything, sched.ID).Return(&sched, nil)
+		repo.On("SetScheduleStatus", mock.Anything, sched.ID, []string{model.SchedulePaused}, model.ScheduleActive, mock.MatchedBy(func(next *time.Time) bool {
+			return next != nil && next.After(time.Now())
+		})).Return(true, nil)
+
+		require.NoError(t, service.NewScheduleService(repo).ResumeSchedule(context.Background(), userID, sched.ID))
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("other users' schedules are not found", func(t *testing.T) {
+		repo := &MockScheduleRepository{}
+		repo.On("GetSchedule", mock.Anything, sched.ID).Return(&sched, nil)
+
+		err := service.NewScheduleService(repo).CancelSchedule(context.Background(), uuid.New(), sched.ID)
+		assert.Equal(t, errors.NotFound, errors.TypeOf(err))
+		repo.AssertNotCalled(t, "SetScheduleStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,200 @@
+package unit
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/internal/settlement"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockSettlementRepository struct {
+	mock.Mock
+}
+
+func (m *MockSettlementRepository) GetSettlement(ctx context.Context, id uuid.UUID) (*model.Settlement, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Settlement), args.Error(1)
+}
+
+func (m *MockSettlementRepository) GetSettlementByHash(ctx context.Context, fileHash string) (*model.Settlement, error) {
+	args := m.Called(ctx, fileHash)
+	return args.Get(0).(*model.Settlement), args.Error(1)
+}
+
+func (m *MockSettlementRepository) ListSettlementLines(ctx context.Context, settlementID uuid.UUID, status string, offset, limit int) ([]model.SettlementLine, error) {
+	args := m.Called(ctx, settlementID, status, offset, limit)
+	return args.Get(0).([]model.SettlementLine), args.Error(1)
+}
+
+func (m *MockSettlementRepository) FindSettlementCandidates(ctx context.Context, references []string) ([]model.Transaction, error) {
+	args := m.Called(ctx, references)
+	return args.Get(0).([]model.Transaction), args.Error(1)
+}
+
+func (m *MockSettlementRepository) CreateSettlement(ctx context.Context, s *model.Settlement, lines []model.SettlementLine) error {
+	args := m.Called(ctx, s, lines)
+	return args.Error(0)
+}
+
+const camt053File = `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <Stmt>
+      <Ntry>
+        <Amt Ccy="USD">250.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts>BOOK</Sts>
+        <ValDt><Dt>2025-06-30</Dt></ValDt>
+        <NtryDtls><TxDtls><Refs><EndToEndId>dep-1</EndToEndId></Refs></TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="USD">99.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <Sts>PDNG</Sts>
+        <NtryRef>dep-pending</NtryRef>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR">30.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <Sts><Cd>BOOK</Cd></Sts>
+        <BookgDt><DtTm>2025-06-30T15:04:05Z</DtTm></BookgDt>
+        <NtryDtls>
+          <TxDtls><Refs><EndToEndId>wd-1</EndToEndId></Refs><Amt Ccy="EUR">10.00</Amt></TxDtls>
+          <TxDtls><Refs><EndToEndId>wd-2</EndToEndId></Refs><AmtDtls><TxAmt><Amt Ccy="EUR">20.00</Amt></TxAmt></AmtDtls></TxDtls>
+        </NtryDtls>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+
+func TestSettlementParsers(t *testing.T) {
+	t.Run("csv", func(t *testing.T) {
+		p, err := settlement.NewParser(settlement.FormatCSV)
+		require.NoError(t, err)
+
+		lines, err := p.Parse(strings.NewReader(
+			"Reference,Amount,Currency,Value_Date\n" +
+				"dep-1,250.00,usd,2025-06-30\n" +
+				"wd-1,-30,USD,\n"))
+		require.NoError(t, err)
+		require.Len(t, lines, 2)
+		assert.Equal(t, 2, lines[0].Number)
+		assert.Equal(t, "USD", lines[0].Currency)
+		assert.Equal(t, settlement.DirectionCredit, lines[0].Direction)
+		assert.Equal(t, 30, lines[0].ValueDate.Day())
+		assert.Equal(t, settlement.DirectionDebit, lines[1].Direction)
+		assert.True(t, lines[1].Amount.Equal(decimal.NewFromInt(30)))
+		assert.True(t, lines[1].SignedAmount().Equal(decimal.NewFromInt(-30)))
+	})
+
+	t.Run("csv reports the bad line", func(t *testing.T) {
+		p, _ := settlement.NewParser(settlement.FormatCSV)
+		_, err := p.Parse(strings.NewReader("reference,direction,amount,currency\ndep-1,credit,10,USD\ndep-2,sideways,10,USD\n"))
+		var parseErr *settlement.ParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.Equal(t, 3, parseErr.Line)
+	})
+
+	t.Run("camt.053", func(t *testing.T) {
+		p, err := settlement.NewParser(settlement.FormatCamt053)
+		require.NoError(t, err)
+
+		lines, err := p.Parse(strings.NewReader(camt053File))
+		require.NoError(t, err)
+		require.Len(t, lines, 3)
+		assert.Equal(t, "dep-1", lines[0].Reference)
+		assert.Equal(t, settlement.DirectionCredit, lines[0].Direction)
+		assert.True(t, lines[0].Amount.Equal(decimal.NewFromInt(250)))
+		// the pending entry is skipped, the batched entry split per transaction
+		assert.Equal(t, "wd-1", lines[1].Reference)
+		assert.Equal(t, "wd-2", lines[2].Reference)
+		assert.Equal(t, 3, lines[2].Number)
+		assert.Equal(t, settlement.DirectionDebit, lines[2].Direction)
+		assert.True(t, lines[2].Amount.Equal(decimal.NewFromInt(20)))
+		assert.Equal(t, "EUR", lines[2].Currency)
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		_, err := settlement.NewParser("mt940")
+		assert.Error(t, err)
+	})
+}
+
+func TestSettlementService_Import(t *testing.T) {
+	ctx := context.Background()
+	file := "reference,direction,amount,currency\n" +
+		"dep-1,credit,100,USD\n" + // matches
+		"dep-1,credit,100,USD\n" + // the same deposit twice in the file
+		"wd-1,debit,40,USD\n" + // our withdrawal was 45
+		"dep-2,credit,10,USD\n" + // settled by an earlier file
+		"dep-3,credit,5,USD\n" // unknown
+
+	earlier := uuid.New()
+	candidates := []model.Transaction{
+		{ID: uuid.New(), Type: "deposit", Amount: decimal.NewFromInt(100), Currency: "USD", Reference: "dep-1"},
+		{ID: uuid.New(), Type: "withdrawal", Amount: decimal.NewFromInt(-45), Currency: "USD", Reference: "wd-1"},
+		{ID: uuid.New(), Type: "deposit", Amount: decimal.NewFromInt(10), Currency: "USD", Reference: "dep-2", SettlementID: &earlier},
+	}
+
+	t.Run("matches and flags lines", func(t *testing.T) {
+		repo := &MockSettlementRepository{}
+		repo.On("GetSettlementByHash", mock.Anything, mock.Anything).
+			Return((*model.Settlement)(nil), errors.NewNotFound("settlement.GetByHash", "settlement"))
+		repo.On("FindSettlementCandidates", mock.Anything, []string{"dep-1", "wd-1", "dep-2", "dep-3"}).Return(candidates, nil)
+		repo.On("CreateSettlement", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+		result, created, err := service.NewSettlementService(repo).Import(ctx, "bank-0630.csv", settlement.FormatCSV, strings.NewReader(file))
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.Equal(t, 5, result.LineCount)
+		assert.Equal(t, 1, result.Matched)
+		assert.Equal(t, 2, result.Duplicate)
+		assert.Equal(t, 1, result.Mismatched)
+		assert.Equal(t, 1, result.Unmatched)
+
+		lines := repo.Calls[2].Arguments.Get(2).([]model.SettlementLine)
+		statuses := make([]string, len(lines))
+		for i, l := range lines {
+			statuses[i] = l.Status
+		}
+		assert.Equal(t, []string{
+			model.SettlementMatched, model.SettlementDuplicate, model.SettlementMismatched,
+			model.SettlementDuplicate, model.SettlementUnmatched,
+		}, statuses)
+		assert.Equal(t, candidates[0].ID, *lines[0].TransactionID)
+		assert.Equal(t, candidates[1].ID, *lines[2].TransactionID)
+		assert.Contains(t, lines[2].Detail, "a withdrawal of 45 USD")
+		assert.Nil(t, lines[4].TransactionID)
+	})
+
+	t.Run("same file returns the first import", func(t *testing.T) {
+		first := &model.Settlement{ID: uuid.New(), LineCount: 5}
+		repo := &MockSettlementRepository{}
+		repo.On("GetSettlementByHash", mock.Anything, mock.Anything).Return(first, nil)
+
+		result, created, err := service.NewSettlementService(repo).Import(ctx, "", settlement.FormatCSV, strings.NewReader(file))
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, first, result)
+		repo.AssertNotCalled(t, "CreateSettlement", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("malformed file", func(t *testing.T) {
+		repo := &MockSettlementRepository{}
+		repo.On("GetSettlementByHash", mock.Anything, mock.Anything).
+			Return((*model.Settlement)(nil), errors.NewNotFound("settlement.GetByHash", "settlement"))
+
+		_, _, err := service.NewSettlementService(repo).Import(ctx, "", settlement.FormatCSV, strings.NewReader("reference,amount\nx,1\n"))
+		assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+		repo.AssertNotCalled(t, "FindSettlementCandidates", mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,197 @@
+package unit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/internal/statement"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockStatementRepository struct {
+	mock.Mock
+}
+
+func (m *MockStatementRepository) OpenStatement(ctx context.Context, walletID uuid.UUID, from, to time.Time) (repository.Statement, error) {
+	args := m.Called(ctx, walletID, from, to)
+	return args.Get(0).(repository.Statement), args.Error(1)
+}
+
+// sliceStatement is a statement cursor over transactions held in memory
+type sliceStatement struct {
+	opening, closing decimal.Decimal
+	txs              []model.Transaction
+	next             int
+	closed           bool
+}
+
+func (s *sliceStatement) OpeningBalance() decimal.Decimal { return s.opening }
+func (s *sliceStatement) ClosingBalance() decimal.Decimal { return s.closing }
+func (s *sliceStatement) Next() bool                      { s.next++; return s.next <= len(s.txs) }
+func (s *sliceStatement) Err() error                      { return nil }
+func (s *sliceStatement) Close() error                    { s.closed = true; return nil }
+
+func (s *sliceStatement) Transaction() (*model.Transaction, error) {
+	t := s.txs[s.next-1]
+	return &t, nil
+}
+
+// newSliceStatement opens at 100 and records a deposit of 50, a withdrawal of
+// 30 and a second deposit of 5, closing at closing
+func newSliceStatement(closing int64) *sliceStatement {
+	tx := func(txType string, amount, after int64) model.Transaction {
+		return model.Transaction{
+			ID:           uuid.New(),
+			Type:         txType,
+			Amount:       decimal.NewFromInt(amount),
+			BalanceAfter: decimal.NewFromInt(after),
+			Reference:    "ref, with comma",
+			CreatedAt:    "2025-06-30T10:00:00Z",
+		}
+	}
+	return &sliceStatement{
+		opening: decimal.NewFromInt(100),
+		closing: decimal.NewFromInt(closing),
+		txs: []model.Transaction{
+			tx("deposit", 50, 150),
+			tx("withdrawal", -30, 120),
+			tx("deposit", 5, 125),
+		},
+	}
+}
+
+func statementHeader(s *sliceStatement) statement.Header {
+	return statement.Header{
+		WalletID:       uuid.New(),
+		Currency:       "USD",
+		From:           time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+		To:             time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC),
+		OpeningBalance: s.opening,
+		ClosingBalance: s.closing,
+	}
+}
+
+func TestStatement_CSV(t *testing.T) {
+	rows := newSliceStatement(125)
+	var buf bytes.Buffer
+	w, err := statement.NewWriter(statement.FormatCSV, &buf)
+	require.NoError(t, err)
+
+	summary, err := statement.Write(w, statementHeader(rows), rows)
+	require.NoError(t, err)
+	assert.True(t, summary.Consistent)
+	assert.Equal(t, 3, summary.Count)
+	require.Len(t, summary.Totals, 2)
+	assert.Equal(t, "deposit", summary.Totals[0].Type)
+	assert.Equal(t, 2, summary.Totals[0].Count)
+	assert.True(t, summary.Totals[0].Amount.Equal(decimal.NewFromInt(55)))
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	var kinds []string
+	for _, r := range records[1:] {
+		kinds = append(kinds, r[0])
+	}
+	assert.Equal(t, []string{
+		"opening_balance", "transaction", "transaction", "transaction",
+		"total", "total", "closing_balance", "check",
+	}, kinds)
+	assert.Equal(t, "100", records[1][7])
+	assert.Equal(t, "ref, with comma", records[2][4])
+	assert.Equal(t, "ok", records[8][4])
+}
+
+func TestStatement_NDJSONFlagsMismatch(t *testing.T) {
+	// closing balance read from the ledger disagrees with the movements
+	rows := newSliceStatement(130)
+	var buf bytes.Buffer
+	w, err := statement.NewWriter(statement.FormatNDJSON, &buf)
+	require.NoError(t, err)
+
+	summary, err := statement.Write(w, statementHeader(rows), rows)
+	require.NoError(t, err)
+	assert.False(t, summary.Consistent)
+
+	var lines []map[string]any
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 7)
+	assert.Equal(t, "opening_balance", lines[0]["record"])
+	assert.Equal(t, "transaction", lines[1]["record"])
+
+	closing := lines[6]
+	assert.Equal(t, "closing_balance", closing["record"])
+	assert.Equal(t, "130", closing["balance"])
+	assert.Equal(t, "125", closing["expected_balance"])
+	assert.Equal(t, false, closing["consistent"])
+}
+
+func TestStatement_PDF(t *testing.T) {
+	rows := newSliceStatement(125)
+	var buf bytes.Buffer
+	w, err := statement.NewWriter(statement.FormatPDF, &buf)
+	require.NoError(t, err)
+
+	_, err = statement.Write(w, statementHeader(rows), rows)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")))
+}
+
+func TestStatementService_WriteStatement(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	wallet := &model.Wallet{ID: uuid.New(), UserID: userID, Currency: "USD"}
+	from := time.Now().Add(-72 * time.Hour)
+	to := time.Now().Add(-24 * time.Hour)
+
+	t.Run("streams and closes the cursor", func(t *testing.T) {
+		repo, wr := &MockStatementRepository{}, &MockWalletRepository{}
+		rows := newSliceStatement(125)
+		wr.On("GetWalletByUserAndCurrency", ctx, userID, "USD").Return(wallet, nil)
+		repo.On("OpenStatement", mock.Anything, wallet.ID, from, to).Return(rows, nil)
+
+		var buf bytes.Buffer
+		summary, err := service.NewStatementService(repo, wr).WriteStatement(ctx, userID, "USD", from, to, statement.FormatNDJSON, &buf)
+		require.NoError(t, err)
+		assert.True(t, summary.Consistent)
+		assert.True(t, rows.closed)
+		assert.NotZero(t, buf.Len())
+	})
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		format   string
+	}{
+		{"unknown format", from, to, "xlsx"},
+		{"empty period", to, from, statement.FormatCSV},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, wr := &MockStatementRepository{}, &MockWalletRepository{}
+
+			var buf bytes.Buffer
+			_, err := service.NewStatementService(repo, wr).WriteStatement(ctx, userID, "USD", tt.from, tt.to, tt.format, &buf)
+			assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err))
+			assert.Zero(t, buf.Len())
+			repo.AssertNotCalled(t, "OpenStatement", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package unit
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/api"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/internal/stream"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingNotifier struct {
+	updates []model.BalanceUpdate
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, update model.BalanceUpdate) {
+	n.updates = append(n.updates, update)
+}
+
+func balanceUpdate(userID uuid.UUID, balance int64) model.BalanceUpdate {
+	return model.BalanceUpdate{
+		UserID:   userID,
+		WalletID: uuid.New(),
+		Currency: "USD",
+		Balance:  decimal.NewFromInt(balance),
+		Type:     "deposit",
+	}
+}
+
+func TestStream_HubResume(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := uuid.New(), uuid.New()
+
+	t.Run("backlog after last event id", func(t *testing.T) {
+		hub := stream.NewHub(stream.HubConfig{})
+		hub.Notify(ctx, balanceUpdate(alice, 1))
+		hub.Notify(ctx, balanceUpdate(bob, 2))
+		hub.Notify(ctx, balanceUpdate(alice, 3))
+
+		sub, backlog, complete := hub.Subscribe(alice, hub.Epoch(), 1, true)
+		defer sub.Close()
+		assert.True(t, complete)
+		require.Len(t, backlog, 1)
+		assert.Equal(t, uint64(3), backlog[0].ID)
+		assert.Equal(t, uint64(3), sub.Head)
+
+		hub.Notify(ctx, balanceUpdate(bob, 4))
+		hub.Notify(ctx, balanceUpdate(alice, 5))
+		e := <-sub.C
+		assert.Equal(t, uint64(5), e.ID)
+	})
+
+	t.Run("evicted history", func(t *testing.T) {
+		hub := stream.NewHub(stream.HubConfig{History: 2})
+		for i := int64(1); i <= 4; i++ {
+			hub.Notify(ctx, balanceUpdate(alice, i))
+		}
+		sub, backlog, complete := hub.Subscribe(alice, hub.Epoch(), 1, true)
+		defer sub.Close()
+		assert.False(t, complete)
+		assert.Len(t, backlog, 2)
+	})
+
+	t.Run("id from before restart", func(t *testing.T) {
+		hub := stream.NewHub(stream.HubConfig{})
+		sub, backlog, complete := hub.Subscribe(alice, hub.Epoch(), 42, true)
+		defer sub.Close()
+		assert.False(t, complete)
+		assert.Empty(t, backlog)
+	})
+
+	t.Run("id from another hub", func(t *testing.T) {
+		restarted := stream.NewHub(stream.HubConfig{})
+		other := stream.NewHub(stream.HubConfig{})
+		require.NotEqual(t, restarted.Epoch(), other.Epoch())
+		for i := int64(1); i <= 3; i++ {
+			restarted.Notify(ctx, balanceUpdate(alice, i))
+		}
+		// the restarted hub has counted past the id, which isn't one of its events
+		sub, _, complete := restarted.Subscribe(alice, other.Epoch(), 1, true)
+		defer sub.Close()
+		assert.False(t, complete)
+
+		sub, _, complete = restarted.Subscribe(alice, "", 1, true)
+		defer sub.Close()
+		assert.False(t, complete, "ids without an epoch can't be trusted either")
+	})
+}
+
+func TestStream_EventIDs(t *testing.T) {
+	assert.Equal(t, "a1b2c3d4-42", stream.FormatEventID("a1b2c3d4", 42))
+
+	epoch, id, err := stream.ParseEventID("a1b2c3d4-42")
+	require.NoError(t, err)
+	assert.Equal(t, "a1b2c3d4", epoch)
+	assert.Equal(t, uint64(42), id)
+
+	epoch, id, err = stream.ParseEventID("7")
+	require.NoError(t, err)
+	assert.Empty(t, epoch)
+	assert.Equal(t, uint64(7), id)
+
+	for _, bad := range []string{"", "-1", "abc-", "abc-x", "x"} {
+		_, _, err := stream.ParseEventID(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestStream_SlowSubscriberIsDropped(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	hub := stream.NewHub(stream.HubConfig{ClientBuffer: 1})
+
+	slow, _, _ := hub.Subscribe(userID, "", 0, false)
+	fast, _, _ := hub.Subscribe(userID, "", 0, false)
+	defer fast.Close()
+
+	hub.Notify(ctx, balanceUpdate(userID, 1))
+	<-fast.C
+	hub.Notify(ctx, balanceUpdate(userID, 2))
+
+	assert.True(t, slow.Dropped())
+	assert.False(t, fast.Dropped())
+	<-slow.C
+	_, ok := <-slow.C
+	assert.False(t, ok, "dropped subscription should be closed")
+	e := <-fast.C
+	assert.Equal(t, uint64(2), e.ID)
+
+	// closing a dropped subscription is a no-op
+	slow.Close()
+}
+
+func TestStream_NotifiedAfterDeposit(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	wallet := &model.Wallet{
+		ID:       uuid.New(),
+		UserID:   userID,
+		Currency: "USD",
+		Balance:  decimal.NewFromInt(50),
+		Version:  1,
+	}
+
+	wr := &MockWalletRepository{}
+	tr := &MockTransactionRepository{}
+	wt := &MockWalletTx{}
+	wr.On("GetWalletByUserAndCurrency", ctx, userID, "USD").Return(wallet, nil)
+	tr.On("BeginTx", ctx).Return(wt, nil)
+	wt.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, mock.AnythingOfType("decimal.Decimal"), wallet.Version).Return(int64(1), nil)
+	wt.On("CreateTransactionTx", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+	wt.On("CreateAuditEventTx", ctx, mock.AnythingOfType("*model.AuditEvent")).Return(nil)
+	wt.On("CreateOutboxEventTx", ctx, mock.AnythingOfType("*model.OutboxEvent")).Return(nil)
+	wt.On("Commit").Return(nil)
+	wt.On("Rollback").Return(nil)
+
+	notifier := &recordingNotifier{}
+	svc := service.NewWalletService(wr, tr, tr, service.WithNotifier(notifier))
+	_, err := svc.Deposit(ctx, userID, decimal.NewFromInt(10), "USD", "streamed")
+	require.NoError(t, err)
+
+	require.Len(t, notifier.updates, 1)
+	update := notifier.updates[0]
+	assert.Equal(t, userID, update.UserID)
+	assert.Equal(t, wallet.ID, update.WalletID)
+	assert.True(t, decimal.NewFromInt(60).Equal(update.Balance))
+	assert.Equal(t, "deposit", update.Type)
+}
+
+func TestStream_BalancesHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID := uuid.New()
+	hub := stream.NewHub(stream.HubConfig{})
+	hub.Notify(context.Background(), balanceUpdate(userID, 1))
+
+	router := gin.New()
+	router.GET("/stream", api.NewStreamHandler(hub, time.Hour).Balances)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	t.Run("invalid user", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/stream?user_id=nope")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("resume then live", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream?user_id="+userID.String(), nil)
+		req.Header.Set("Last-Event-ID", stream.FormatEventID(hub.Epoch(), 0))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		reader := bufio.NewReader(resp.Body)
+		readEvent := func() string {
+			var lines []string
+			for {
+				line, err := reader.ReadString('\n')
+				require.NoError(t, err)
+				if line == "\n" {
+					return strings.Join(lines, "")
+				}
+				lines = append(lines, line)
+			}
+		}
+
+		first := readEvent()
+		assert.Contains(t, first, "id: "+hub.Epoch()+"-1\n")
+		assert.Contains(t, first, "event: balance\n")
+
+		hub.Notify(context.Background(), balanceUpdate(userID, 2))
+		second := readEvent()
+		assert.Contains(t, second, "id: "+hub.Epoch()+"-2\n")
+		assert.Contains(t, second, `"balance":"2"`)
+	})
+
+	t.Run("id from before a restart resets", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream?user_id="+userID.String(), nil)
+		// a small id the hub has issued too, but in an earlier life
+		req.Header.Set("Last-Event-ID", stream.FormatEventID("0badcafe", 1))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		reader := bufio.NewReader(resp.Body)
+		var event strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\n" {
+				break
+			}
+			event.WriteString(line)
+		}
+		assert.Contains(t, event.String(), "event: reset\n")
+		assert.Contains(t, event.String(), "id: "+hub.Epoch()+"-")
+	})
+
+	t.Run("invalid last event id", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/stream?user_id="+userID.String(), nil)
+		req.Header.Set("Last-Event-ID", "abc-x")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,164 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/internal/tracing"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func enableTestTracing(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	tracing.Enable(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(tracing.Disable)
+	return recorder
+}
+
+func spansByName(spans []sdktrace.ReadOnlySpan) map[string][]sdktrace.ReadOnlySpan {
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, s := range spans {
+		byName[s.Name()] = append(byName[s.Name()], s)
+	}
+	return byName
+}
+
+func attrValue(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracing_DepositWithRetry(t *testing.T) {
+	recorder := enableTestTracing(t)
+
+	ctx := context.Background()
+	userID := uuid.New()
+	currency := "USD"
+	wallet := &model.Wallet{
+		ID:       uuid.New(),
+		UserID:   userID,
+		Currency: currency,
+		Balance:  decimal.NewFromInt(50),
+		Version:  1,
+	}
+
+	wr := &MockWalletRepository{}
+	tr := &MockTransactionRepository{}
+	wt := &MockWalletTx{}
+	wr.On("GetWalletByUserAndCurrency", mock.Anything, userID, currency).Return(wallet, nil)
+	tr.On("BeginTx", mock.Anything).Return(wt, nil)
+	reread := *wallet
+	reread.Version = wallet.Version + 1
+	wr.On("GetWallet", mock.Anything, wallet.ID).Return(&reread, nil)
+	wt.On("UpdateWalletBalanceWithVersionTx", mock.Anything, wallet.ID, mock.AnythingOfType("decimal.Decimal"), wallet.Version).
+		Return(int64(0), nil).Once()
+	wt.On("UpdateWalletBalanceWithVersionTx", mock.Anything, wallet.ID, mock.AnythingOfType("decimal.Decimal"), reread.Version).
+		Return(int64(1), nil).Once()
+	wt.On("CreateTransactionTx", mock.Anything, mock.AnythingOfType("*model.Transaction")).Return(nil)
+	wt.On("CreateAuditEventTx", mock.Anything, mock.AnythingOfType("*model.AuditEvent")).Return(nil)
+	wt.On("CreateOutboxEventTx", mock.Anything, mock.AnythingOfType("*model.OutboxEvent")).Return(nil)
+	wt.On("Commit").Return(nil)
+	wt.On("Rollback").Return(nil)
+
+	svc := service.NewWalletService(wr, tr, tr)
+	_, err := svc.Deposit(ctx, userID, decimal.NewFromInt(10), currency, "traced deposit")
+	require.NoError(t, err)
+
+	byName := spansByName(recorder.Ended())
+	require.Len(t, byName["service.Deposit"], 1)
+	require.Len(t, byName["utils.UpdateBalanceWithRetry"], 1)
+	require.Len(t, byName["utils.UpdateBalanceWithRetry.backoff"], 1)
+
+	root := byName["service.Deposit"][0]
+	update := byName["utils.UpdateBalanceWithRetry"][0]
+	backoff := byName["utils.UpdateBalanceWithRetry.backoff"][0]
+
+	assert.Equal(t, root.SpanContext().SpanID(), update.Parent().SpanID())
+	assert.Equal(t, update.SpanContext().SpanID(), backoff.Parent().SpanID())
+
+	v, ok := attrValue(root, tracing.AttrCurrency)
+	assert.True(t, ok)
+	assert.Equal(t, currency, v.AsString())
+
+	v, ok = attrValue(update, tracing.AttrRetryAttempt)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), v.AsInt64())
+}
+
+func TestTracing_MiddlewarePropagatesTraceparent(t *testing.T) {
+	recorder := enableTestTracing(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(tracing.Middleware())
+	router.POST("/api/v1/wallet/deposit", func(c *gin.Context) {
+		_, span := tracing.Start(c.Request.Context(), "handler")
+		span.End()
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet/deposit", bytes.NewBufferString("{}"))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	byName := spansByName(recorder.Ended())
+	require.Len(t, byName["POST /api/v1/wallet/deposit"], 1)
+	server := byName["POST /api/v1/wallet/deposit"][0]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+
+	require.Len(t, byName["handler"], 1)
+	assert.Equal(t, server.SpanContext().SpanID(), byName["handler"][0].Parent().SpanID())
+}
+
+func TestTracing_StdoutExporter(t *testing.T) {
+	var buf bytes.Buffer
+	shutdown, err := tracing.Init(context.Background(), tracing.Config{
+		Exporter: tracing.ExporterStdout,
+		Writer:   &buf,
+	})
+	require.NoError(t, err)
+	t.Cleanup(tracing.Disable)
+
+	_, span := tracing.Start(context.Background(), "stdout-span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	assert.Contains(t, buf.String(), "stdout-span")
+}
+
+func TestTracing_ZeroSampleRatioSamplesNothing(t *testing.T) {
+	var buf bytes.Buffer
+	never := 0.0
+	shutdown, err := tracing.Init(context.Background(), tracing.Config{
+		Exporter:    tracing.ExporterStdout,
+		Writer:      &buf,
+		SampleRatio: &never,
+	})
+	require.NoError(t, err)
+	t.Cleanup(tracing.Disable)
+
+	_, span := tracing.Start(context.Background(), "unsampled-span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	assert.NotContains(t, buf.String(), "unsampled-span")
+}
@@ -0,0 +1,121 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/audit"
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/repository/memory"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type adminFixture struct {
+	store  *memory.Store
+	wallet service.WalletService
+	admin  service.WalletAdminService
+	user   uuid.UUID
+	other  uuid.UUID
+}
+
+func newAdminFixture(t *testing.T) *adminFixture {
+	store := memory.NewStore()
+	f := &adminFixture{
+		store:  store,
+		wallet: service.NewWalletService(store, store, store),
+		admin:  service.NewWalletAdminService(store, store, store, store),
+		user:   uuid.New(),
+		other:  uuid.New(),
+	}
+	_, err := f.wallet.Deposit(context.Background(), f.user, decimal.NewFromInt(100), "USD", "")
+	require.NoError(t, err)
+	return f
+}
+
+func TestWalletAdmin_FreezeStopsMoneyLeaving(t *testing.T) {
+	f := newAdminFixture(t)
+	ctx := audit.WithMetadata(context.Background(), audit.Metadata{Actor: audit.OperatorActor("alice")})
+
+	frozen, err := f.admin.Freeze(ctx, f.user, "USD", "suspected fraud")
+	require.NoError(t, err)
+	assert.True(t, frozen.Frozen())
+	assert.Equal(t, "suspected fraud", frozen.FrozenReason)
+
+	_, err = f.wallet.Withdraw(ctx, f.user, decimal.NewFromInt(1), "USD", "")
+	assert.Equal(t, errors.WalletFrozen, errors.TypeOf(err))
+	_, err = f.wallet.Transfer(ctx, f.user, f.other, decimal.NewFromInt(1), "USD", "")
+	assert.Equal(t, errors.WalletFrozen, errors.TypeOf(err))
+
+	// money still comes in
+	_, err = f.wallet.Deposit(ctx, f.user, decimal.NewFromInt(5), "USD", "")
+	require.NoError(t, err)
+	_, err = f.wallet.Deposit(ctx, f.other, decimal.NewFromInt(5), "USD", "")
+	require.NoError(t, err)
+	_, err = f.wallet.Transfer(ctx, f.other, f.user, decimal.NewFromInt(5), "USD", "")
+	require.NoError(t, err)
+	balance, err := f.wallet.GetBalance(ctx, f.user, "USD")
+	require.NoError(t, err)
+	assert.True(t, balance.Equal(decimal.NewFromInt(110)), balance.String())
+
+	_, err = f.admin.Freeze(ctx, f.user, "USD", "again")
+	assert.Equal(t, errors.Conflict, errors.TypeOf(err))
+
+	unfrozen, err := f.admin.Unfreeze(ctx, f.user, "USD", "cleared by review")
+	require.NoError(t, err)
+	assert.False(t, unfrozen.Frozen())
+	_, err = f.wallet.Withdraw(ctx, f.user, decimal.NewFromInt(1), "USD", "")
+	require.NoError(t, err)
+
+	_, err = f.admin.Unfreeze(ctx, f.user, "USD", "again")
+	assert.Equal(t, errors.Conflict, errors.TypeOf(err))
+}
+
+func TestWalletAdmin_FreezeIsAudited(t *testing.T) {
+	f := newAdminFixture(t)
+	ctx := audit.WithMetadata(context.Background(), audit.Metadata{Actor: audit.OperatorActor("alice")})
+
+	_, err := f.admin.Freeze(ctx, f.user, "USD", "suspected fraud")
+	require.NoError(t, err)
+	_, err = f.admin.Unfreeze(context.Background(), f.user, "USD", "cleared")
+	require.NoError(t, err)
+
+	events := f.store.AuditEvents()
+	require.Len(t, events, 3, "the deposit, the freeze and the unfreeze")
+	freeze, unfreeze := events[1], events[2]
+	assert.Equal(t, audit.ActionFreeze, freeze.Action)
+	assert.Equal(t, "operator:alice", freeze.Actor)
+	assert.Equal(t, audit.ActionUnfreeze, unfreeze.Action)
+	assert.Equal(t, "system:admin", unfreeze.Actor, "without an actor in ctx")
+
+	var after struct {
+		Frozen bool   `json:"frozen"`
+		Reason string `json:"reason"`
+	}
+	require.NotNil(t, freeze.After)
+	require.NoError(t, json.Unmarshal([]byte(*freeze.After), &after))
+	assert.True(t, after.Frozen)
+	assert.Equal(t, "suspected fraud", after.Reason)
+}
+
+func TestWalletAdmin_Validation(t *testing.T) {
+	f := newAdminFixture(t)
+	ctx := context.Background()
+
+	_, err := f.admin.Freeze(ctx, f.user, "USD", "  ")
+	assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err), "a reason is required")
+	_, err = f.admin.Freeze(ctx, f.user, "EUR", "no wallet")
+	assert.Equal(t, errors.NotFound, errors.TypeOf(err))
+
+	_, err = f.wallet.Deposit(ctx, f.user, decimal.NewFromInt(1), "EUR", "")
+	require.NoError(t, err)
+	wallets, err := f.admin.ListWallets(ctx, f.user)
+	require.NoError(t, err)
+	require.Len(t, wallets, 2)
+	assert.Equal(t, "EUR", wallets[0].Currency)
+	assert.Equal(t, "USD", wallets[1].Currency)
+}
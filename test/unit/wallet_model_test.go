@@ -0,0 +1,183 @@
+package unit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/repository/memory"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"pgregory.net/rapid"
+)
+
+// walletModel is the reference the service is checked against: a balance per
+// user and currency, and what came in and went out of the system
+type walletModel struct {
+	svc   service.WalletService
+	store *memory.Store
+
+	users      []uuid.UUID
+	currencies []string
+	balances   map[string]decimal.Decimal
+	// external is deposits less withdrawals per currency, the only money
+	// that enters or leaves, transfers just move it
+	external map[string]decimal.Decimal
+}
+
+func modelKey(userID uuid.UUID, currency string) string {
+	return userID.String() + "/" + currency
+}
+
+func newWalletModel() *walletModel {
+	store := memory.NewStore()
+	m := &walletModel{
+		svc:        service.NewWalletService(store, store, store),
+		store:      store,
+		currencies: []string{"USD", "EUR", "JPY"},
+		balances:   make(map[string]decimal.Decimal),
+		external:   make(map[string]decimal.Decimal),
+	}
+	for i := 0; i < 4; i++ {
+		m.users = append(m.users, uuid.New())
+	}
+	return m
+}
+
+// draw picks a user, a currency and an amount in cents, now and then zero or
+// negative. Indexes and cents keep a shrunk failure readable.
+func (m *walletModel) draw(t *rapid.T) (uuid.UUID, string, decimal.Decimal) {
+	user := m.users[rapid.IntRange(0, len(m.users)-1).Draw(t, "user")]
+	currency := rapid.SampledFrom(m.currencies).Draw(t, "currency")
+	cents := rapid.Int64Range(-100, 20000).Draw(t, "cents")
+	return user, currency, decimal.New(cents, -2)
+}
+
+// expectError checks err against the error type the model predicts, none
+// when want is empty
+func expectError(t *rapid.T, want errors.ErrorType, err error) {
+	t.Helper()
+	if want == "" {
+		require.NoError(t, err)
+		return
+	}
+	require.Error(t, err)
+	require.Equal(t, want, errors.TypeOf(err), err.Error())
+}
+
+func (m *walletModel) deposit(t *rapid.T) {
+	user, currency, amount := m.draw(t)
+	resp, err := m.svc.Deposit(context.Background(), user, amount, currency, "")
+
+	if !amount.IsPositive() {
+		expectError(t, errors.InvalidRequest, err)
+		return
+	}
+	expectError(t, "", err)
+	key := modelKey(user, currency)
+	m.balances[key] = m.balances[key].Add(amount)
+	m.external[currency] = m.external[currency].Add(amount)
+	require.True(t, resp.Balance.Equal(m.balances[key]), "deposit answered %s, model has %s", resp.Balance, m.balances[key])
+}
+
+func (m *walletModel) withdraw(t *rapid.T) {
+	user, currency, amount := m.draw(t)
+	resp, err := m.svc.Withdraw(context.Background(), user, amount, currency, "")
+
+	key := modelKey(user, currency)
+	switch {
+	case !amount.IsPositive():
+		expectError(t, errors.InvalidRequest, err)
+	case amount.GreaterThan(m.balances[key]):
+		expectError(t, errors.InsufficientFund, err)
+	default:
+		expectError(t, "", err)
+		m.balances[key] = m.balances[key].Sub(amount)
+		m.external[currency] = m.external[currency].Sub(amount)
+		require.True(t, resp.Balance.Equal(m.balances[key]), "withdrawal answered %s, model has %s", resp.Balance, m.balances[key])
+	}
+}
+
+func (m *walletModel) transfer(t *rapid.T) {
+	from, currency, amount := m.draw(t)
+	to := m.users[rapid.IntRange(0, len(m.users)-1).Draw(t, "to")]
+	resp, err := m.svc.Transfer(context.Background(), from, to, amount, currency, "")
+
+	fromKey, toKey := modelKey(from, currency), modelKey(to, currency)
+	switch {
+	case !amount.IsPositive(), from == to:
+		expectError(t, errors.InvalidRequest, err)
+	case amount.GreaterThan(m.balances[fromKey]):
+		expectError(t, errors.InsufficientFund, err)
+	default:
+		expectError(t, "", err)
+		m.balances[fromKey] = m.balances[fromKey].Sub(amount)
+		m.balances[toKey] = m.balances[toKey].Add(amount)
+		require.True(t, resp.Balance.Equal(m.balances[fromKey]), "transfer answered %s, model has %s", resp.Balance, m.balances[fromKey])
+	}
+}
+
+// check asserts the invariants after every step
+func (m *walletModel) check(t *rapid.T) {
+	ctx := context.Background()
+	totals := make(map[string]decimal.Decimal)
+
+	for _, user := range m.users {
+		for _, currency := range m.currencies {
+			key := modelKey(user, currency)
+			wallet, err := m.store.GetWalletByUserAndCurrency(ctx, user, currency)
+			if errors.IsNotFound(err) {
+				require.True(t, m.balances[key].IsZero(), "%s has no wallet but the model holds %s", key, m.balances[key])
+				continue
+			}
+			require.NoError(t, err)
+
+			require.False(t, wallet.Balance.IsNegative(), "%s is overdrawn at %s", key, wallet.Balance)
+			require.True(t, wallet.Balance.Equal(m.balances[key]), "%s holds %s, model has %s", key, wallet.Balance, m.balances[key])
+			totals[currency] = totals[currency].Add(wallet.Balance)
+
+			txs, err := m.store.GetTransactions(ctx, wallet.ID, 0, 1<<20)
+			require.NoError(t, err)
+			checkChain(t, key, wallet, txs)
+		}
+	}
+
+	// money is only created by deposits and destroyed by withdrawals
+	for _, currency := range m.currencies {
+		require.True(t, totals[currency].Equal(m.external[currency]),
+			"%s wallets hold %s in all, deposits less withdrawals are %s", currency, totals[currency], m.external[currency])
+	}
+}
+
+// checkChain walks a wallet's transactions oldest first: each starts from the
+// balance the previous one left, and the last leaves the wallet's balance
+func checkChain(t *rapid.T, key string, wallet *model.Wallet, newestFirst []model.Transaction) {
+	balance := decimal.Zero
+	for i := len(newestFirst) - 1; i >= 0; i-- {
+		tx := newestFirst[i]
+		step := fmt.Sprintf("%s transaction %d (%s)", key, len(newestFirst)-i, tx.Type)
+		require.True(t, tx.BalanceBefore.Equal(balance), "%s starts at %s, the previous left %s", step, tx.BalanceBefore, balance)
+		require.True(t, tx.BalanceBefore.Add(tx.Amount).Equal(tx.BalanceAfter), "%s: %s plus %s is not %s", step, tx.BalanceBefore, tx.Amount, tx.BalanceAfter)
+		balance = tx.BalanceAfter
+	}
+	require.True(t, balance.Equal(wallet.Balance), "%s ledger ends at %s, the wallet holds %s", key, balance, wallet.Balance)
+}
+
+// TestWalletService_Model runs random sequences of operations against the
+// service and the model side by side. A failing sequence is shrunk to a
+// minimal one, replay it with the -rapid.failfile flag the failure prints.
+func TestWalletService_Model(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		m := newWalletModel()
+		t.Repeat(map[string]func(*rapid.T){
+			"deposit":  m.deposit,
+			"withdraw": m.withdraw,
+			"transfer": m.transfer,
+			"":         m.check,
+		})
+	})
+}
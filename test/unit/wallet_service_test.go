@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/Jiang-hao/walletApiService/internal/model"
 	"github.com/Jiang-hao/walletApiService/internal/repository"
@@ -35,6 +36,11 @@ func (m *MockWalletRepository) GetWalletByUserAndCurrency(ctx context.Context, u
 	return args.Get(0).(*model.Wallet), args.Error(1)
 }
 
+func (m *MockWalletRepository) ListWalletsByUser(ctx context.Context, userID uuid.UUID) ([]model.Wallet, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]model.Wallet), args.Error(1)
+}
+
 func (m *MockWalletRepository) GetWalletForUpdate(ctx context.Context, id uuid.UUID) (*model.Wallet, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(*model.Wallet), args.Error(1)
@@ -50,6 +56,11 @@ func (m *MockWalletRepository) UpdateWalletBalanceTx(ctx context.Context, tx *sq
 	return args.Error(0)
 }
 
+func (m *MockWalletRepository) CreateWalletTx(ctx context.Context, tx *sqlx.Tx, wallet *model.Wallet) error {
+	args := m.Called(ctx, tx, wallet)
+	return args.Error(0)
+}
+
 // MockTransactionRepository implements TransactionRepository interface
 type MockTransactionRepository struct {
 	mock.Mock
@@ -111,6 +122,71 @@ func (m *MockWalletTx) CreateTransactionTx(ctx context.Context, tx *model.Transa
 	return args.Error(0)
 }
 
+func (m *MockWalletTx) UpdateWalletBalanceWithVersionTx(ctx context.Context, id uuid.UUID, newBalance decimal.Decimal, version int) (int64, error) {
+	args := m.Called(ctx, id, newBalance, version)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockWalletTx) SetWalletFrozenTx(ctx context.Context, id uuid.UUID, frozenAt *time.Time, reason string) error {
+	args := m.Called(ctx, id, frozenAt, reason)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) CreateAuditEventTx(ctx context.Context, event *model.AuditEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) CreateOutboxEventTx(ctx context.Context, event *model.OutboxEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) CreateEscrowTx(ctx context.Context, escrow *model.Escrow) error {
+	args := m.Called(ctx, escrow)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) GetEscrowForUpdate(ctx context.Context, id uuid.UUID) (*model.Escrow, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Escrow), args.Error(1)
+}
+
+func (m *MockWalletTx) ResolveEscrowTx(ctx context.Context, escrow *model.Escrow) error {
+	args := m.Called(ctx, escrow)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) CreateAdjustmentTx(ctx context.Context, adjustment *model.Adjustment) error {
+	args := m.Called(ctx, adjustment)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) GetAdjustmentForUpdate(ctx context.Context, id uuid.UUID) (*model.Adjustment, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Adjustment), args.Error(1)
+}
+
+func (m *MockWalletTx) ResolveAdjustmentTx(ctx context.Context, adjustment *model.Adjustment) error {
+	args := m.Called(ctx, adjustment)
+	return args.Error(0)
+}
+
+func (m *MockWalletTx) UnreviewedAdjustmentTotal(ctx context.Context, walletID uuid.UUID, since time.Time) (decimal.Decimal, error) {
+	args := m.Called(ctx, walletID, since)
+	return args.Get(0).(decimal.Decimal), args.Error(1)
+}
+
+func (m *MockWalletTx) TransitionPaymentRequestTx(ctx context.Context, id uuid.UUID, from, to string) (bool, error) {
+	args := m.Called(ctx, id, from, to)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockWalletTx) CreateWalletTx(ctx context.Context, wallet *model.Wallet) error {
+	args := m.Called(ctx, wallet)
+	return args.Error(0)
+}
+
 func TestWalletService_Deposit(t *testing.T) {
 	ctx := context.Background()
 	userID := uuid.New()
@@ -136,8 +212,12 @@ func TestWalletService_Deposit(t *testing.T) {
 					Version:  1,
 				}
 				wr.On("GetWalletByUserAndCurrency", ctx, userID, currency).Return(wallet, nil)
-				wr.On("UpdateWalletBalance", ctx, wallet.ID, wallet.Balance.Add(amount), wallet.Version).Return(int64(1), nil)
-				tr.On("CreateTransaction", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+				tr.On("BeginTx", ctx).Return(wt, nil)
+				wt.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, wallet.Balance.Add(amount), wallet.Version).Return(int64(1), nil)
+				wt.On("CreateTransactionTx", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+				wt.On("CreateAuditEventTx", ctx, mock.AnythingOfType("*model.AuditEvent")).Return(nil)
+				wt.On("CreateOutboxEventTx", ctx, mock.AnythingOfType("*model.OutboxEvent")).Return(nil)
+				wt.On("Commit").Return(nil)
 			},
 			amount: amount,
 		},
@@ -169,17 +249,29 @@ func TestWalletService_Deposit(t *testing.T) {
 				}
 				wr.On("GetWalletByUserAndCurrency", ctx, userID, currency).Return(wallet, nil)
 
-				// First update fails
-				wr.On("UpdateWalletBalance", ctx, wallet.ID, wallet.Balance.Add(amount), wallet.Version).
+				tr.On("BeginTx", ctx).Return(wt, nil).Twice()
+
+				// First update fails and its transaction is rolled back
+				wt.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, wallet.Balance.Add(amount), wallet.Version).
 					Return(int64(0), nil).
 					Once()
+				wt.On("Rollback").Return(nil).Once()
+
+				// A concurrent deposit won; the retry starts from the wallet as re-read
+				reread := *wallet
+				reread.Balance = wallet.Balance.Add(decimal.NewFromInt(10))
+				reread.Version = wallet.Version + 1
+				wr.On("GetWallet", ctx, wallet.ID).Return(&reread, nil).Once()
 
 				// Second update succeeds
-				wr.On("UpdateWalletBalance", ctx, wallet.ID, wallet.Balance.Add(amount), wallet.Version).
+				wt.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, reread.Balance.Add(amount), reread.Version).
 					Return(int64(1), nil).
 					Once()
 
-				tr.On("CreateTransaction", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+				wt.On("CreateTransactionTx", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+				wt.On("CreateAuditEventTx", ctx, mock.AnythingOfType("*model.AuditEvent")).Return(nil)
+				wt.On("CreateOutboxEventTx", ctx, mock.AnythingOfType("*model.OutboxEvent")).Return(nil)
+				wt.On("Commit").Return(nil).Once()
 			},
 			amount: amount,
 		},
@@ -194,9 +286,13 @@ func TestWalletService_Deposit(t *testing.T) {
 					Version:  1,
 				}
 				wr.On("GetWalletByUserAndCurrency", ctx, userID, currency).Return(wallet, nil)
-				wr.On("UpdateWalletBalance", ctx, wallet.ID, wallet.Balance.Add(amount), wallet.Version).
+				tr.On("BeginTx", ctx).Return(wt, nil).Times(3)
+				// re-read before each of the two retries, not after the last attempt
+				wr.On("GetWallet", ctx, wallet.ID).Return(wallet, nil).Times(2)
+				wt.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, wallet.Balance.Add(amount), wallet.Version).
 					Return(int64(0), nil).
 					Times(3) // Will retry 3 times
+				wt.On("Rollback").Return(nil).Times(3)
 			},
 			amount:      amount,
 			expectError: true,
@@ -230,6 +326,7 @@ func TestWalletService_Deposit(t *testing.T) {
 
 			wr.AssertExpectations(t)
 			tr.AssertExpectations(t)
+			wt.AssertExpectations(t)
 		})
 	}
 }
@@ -259,8 +356,12 @@ func TestWalletService_Withdraw(t *testing.T) {
 					Version:  1,
 				}
 				wr.On("GetWalletByUserAndCurrency", ctx, userID, currency).Return(wallet, nil)
-				wr.On("UpdateWalletBalance", ctx, wallet.ID, wallet.Balance.Sub(amount), wallet.Version).Return(int64(1), nil)
-				tr.On("CreateTransaction", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+				tr.On("BeginTx", ctx).Return(wt, nil)
+				wt.On("UpdateWalletBalanceWithVersionTx", ctx, wallet.ID, wallet.Balance.Sub(amount), wallet.Version).Return(int64(1), nil)
+				wt.On("CreateTransactionTx", ctx, mock.AnythingOfType("*model.Transaction")).Return(nil)
+				wt.On("CreateAuditEventTx", ctx, mock.AnythingOfType("*model.AuditEvent")).Return(nil)
+				wt.On("CreateOutboxEventTx", ctx, mock.AnythingOfType("*model.OutboxEvent")).Return(nil)
+				wt.On("Commit").Return(nil)
 			},
 			amount: amount,
 		},
@@ -318,6 +419,7 @@ func TestWalletService_Withdraw(t *testing.T) {
 
 			wr.AssertExpectations(t)
 			tr.AssertExpectations(t)
+			wt.AssertExpectations(t)
 		})
 	}
 }
@@ -358,6 +460,8 @@ func TestWalletService_Transfer(t *testing.T) {
 					Return(fromWallet, nil)
 				wr.On("GetWalletByUserAndCurrency", ctx, toUserID, currency).
 					Return(toWallet, nil)
+				wt.On("GetWalletForUpdate", ctx, fromWallet.ID).Return(fromWallet, nil)
+				wt.On("GetWalletForUpdate", ctx, toWallet.ID).Return(toWallet, nil)
 
 				wt.On("UpdateWalletBalanceTx", ctx, fromWallet.ID, fromWallet.Balance.Sub(amount)).
 					Return(nil)
@@ -368,6 +472,10 @@ func TestWalletService_Transfer(t *testing.T) {
 					Return(nil).
 					Twice()
 
+				wt.On("CreateAuditEventTx", ctx, mock.AnythingOfType("*model.AuditEvent")).Return(nil)
+
+				wt.On("CreateOutboxEventTx", ctx, mock.AnythingOfType("*model.OutboxEvent")).Return(nil)
+
 				wt.On("Commit").Return(nil)
 			},
 		},
@@ -393,6 +501,38 @@ func TestWalletService_Transfer(t *testing.T) {
 					Return(fromWallet, nil)
 				wr.On("GetWalletByUserAndCurrency", ctx, toUserID, currency).
 					Return(toWallet, nil)
+				wt.On("GetWalletForUpdate", ctx, fromWallet.ID).Return(fromWallet, nil)
+				wt.On("GetWalletForUpdate", ctx, toWallet.ID).Return(toWallet, nil)
+
+				wt.On("Rollback").Return(nil)
+			},
+			expectError: true,
+			errorMsg:    "insufficient balance",
+		},
+		{
+			name: "balance spent before the lock was taken",
+			setup: func(wr *MockWalletRepository, tr *MockTransactionRepository, wt *MockWalletTx) {
+				fromWallet := &model.Wallet{
+					ID:       uuid.New(),
+					UserID:   fromUserID,
+					Currency: currency,
+					Balance:  decimal.NewFromFloat(100.00),
+				}
+				toWallet := &model.Wallet{
+					ID:       uuid.New(),
+					UserID:   toUserID,
+					Currency: currency,
+					Balance:  decimal.NewFromFloat(20.00),
+				}
+				lockedFrom := *fromWallet
+				lockedFrom.Balance = decimal.NewFromFloat(10.00)
+
+				tr.On("BeginTx", ctx).Return(wt, nil)
+
+				wr.On("GetWalletByUserAndCurrency", ctx, fromUserID, currency).Return(fromWallet, nil)
+				wr.On("GetWalletByUserAndCurrency", ctx, toUserID, currency).Return(toWallet, nil)
+				wt.On("GetWalletForUpdate", ctx, fromWallet.ID).Return(&lockedFrom, nil)
+				wt.On("GetWalletForUpdate", ctx, toWallet.ID).Return(toWallet, nil)
 
 				wt.On("Rollback").Return(nil)
 			},
@@ -419,6 +559,8 @@ func TestWalletService_Transfer(t *testing.T) {
 
 				wr.On("GetWalletByUserAndCurrency", ctx, fromUserID, currency).Return(fromWallet, nil)
 				wr.On("GetWalletByUserAndCurrency", ctx, toUserID, currency).Return(toWallet, nil)
+				wt.On("GetWalletForUpdate", ctx, fromWallet.ID).Return(fromWallet, nil)
+				wt.On("GetWalletForUpdate", ctx, toWallet.ID).Return(toWallet, nil)
 
 				wt.On("UpdateWalletBalanceTx", ctx, fromWallet.ID, fromWallet.Balance.Sub(amount)).Return(nil)
 				wt.On("UpdateWalletBalanceTx", ctx, toWallet.ID, toWallet.Balance.Add(amount)).
@@ -460,6 +602,59 @@ func TestWalletService_Transfer(t *testing.T) {
 	}
 }
 
+func TestWalletService_TransferToSelf(t *testing.T) {
+	userID := uuid.New()
+	wr := &MockWalletRepository{}
+	tr := &MockTransactionRepository{}
+
+	_, err := service.NewWalletService(wr, tr, tr).Transfer(context.Background(), userID, userID, decimal.NewFromInt(10), "USD", "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid to_user_id")
+	}
+	tr.AssertNotCalled(t, "BeginTx", mock.Anything)
+}
+
+// Opposite transfers between the same wallets take the row locks in the same
+// order, the wallet id order, so they can't deadlock
+func TestWalletService_TransferLocksInWalletIDOrder(t *testing.T) {
+	ctx := context.Background()
+	low, high := uuid.New(), uuid.New()
+	lowWallet := &model.Wallet{ID: uuid.MustParse("00000000-0000-0000-0000-000000000001"), UserID: low, Currency: "USD"}
+	highWallet := &model.Wallet{ID: uuid.MustParse("ffffffff-0000-0000-0000-000000000001"), UserID: high, Currency: "USD"}
+
+	for _, direction := range []struct {
+		name     string
+		from, to uuid.UUID
+	}{
+		{"low to high", low, high},
+		{"high to low", high, low},
+	} {
+		t.Run(direction.name, func(t *testing.T) {
+			wr := &MockWalletRepository{}
+			tr := &MockTransactionRepository{}
+			wt := &MockWalletTx{}
+			tr.On("BeginTx", ctx).Return(wt, nil)
+			wr.On("GetWalletByUserAndCurrency", ctx, low, "USD").Return(lowWallet, nil)
+			wr.On("GetWalletByUserAndCurrency", ctx, high, "USD").Return(highWallet, nil)
+			wt.On("GetWalletForUpdate", ctx, lowWallet.ID).Return(lowWallet, nil)
+			wt.On("GetWalletForUpdate", ctx, highWallet.ID).Return(highWallet, nil)
+			wt.On("Rollback").Return(nil)
+
+			// both wallets are empty, so the transfer stops after the locks
+			_, err := service.NewWalletService(wr, tr, tr).Transfer(ctx, direction.from, direction.to, decimal.NewFromInt(10), "USD", "")
+			assert.Error(t, err)
+
+			var locked []uuid.UUID
+			for _, call := range wt.Calls {
+				if call.Method == "GetWalletForUpdate" {
+					locked = append(locked, call.Arguments.Get(1).(uuid.UUID))
+				}
+			}
+			assert.Equal(t, []uuid.UUID{lowWallet.ID, highWallet.ID}, locked)
+		})
+	}
+}
+
 func TestWalletService_GetBalance(t *testing.T) {
 	ctx := context.Background()
 	userID := uuid.New()
@@ -0,0 +1,384 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Jiang-hao/walletApiService/internal/errors"
+	"github.com/Jiang-hao/walletApiService/internal/events"
+	"github.com/Jiang-hao/walletApiService/internal/model"
+	"github.com/Jiang-hao/walletApiService/internal/service"
+	"github.com/Jiang-hao/walletApiService/internal/webhook"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockWebhookRepository struct {
+	mock.Mock
+}
+
+func (m *MockWebhookRepository) CreateSubscription(ctx context.Context, sub *model.WebhookSubscription) error {
+	args := m.Called(ctx, sub)
+	return args.Error(0)
+}
+
+func (m *MockWebhookRepository) GetSubscription(ctx context.Context, id uuid.UUID) (*model.WebhookSubscription, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.WebhookSubscription), args.Error(1)
+}
+
+func (m *MockWebhookRepository) ListSubscriptions(ctx context.Context, userID uuid.UUID) ([]model.WebhookSubscription, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]model.WebhookSubscription), args.Error(1)
+}
+
+func (m *MockWebhookRepository) DeactivateSubscription(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockWebhookRepository) EnqueueDeliveries(ctx context.Context, deliveries []model.WebhookDelivery) error {
+	args := m.Called(ctx, deliveries)
+	return args.Error(0)
+}
+
+func (m *MockWebhookRepository) ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	args := m.Called(ctx, limit, lease)
+	return args.Get(0).([]model.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockWebhookRepository) RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery, attempt *model.WebhookAttempt) (bool, error) {
+	args := m.Called(ctx, delivery, attempt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockWebhookRepository) GetDelivery(ctx context.Context, id uuid.UUID) (*model.WebhookDelivery, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockWebhookRepository) ListDeliveries(ctx context.Context, subscriptionID uuid.UUID, offset, limit int) ([]model.WebhookDelivery, error) {
+	args := m.Called(ctx, subscriptionID, offset, limit)
+	return args.Get(0).([]model.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockWebhookRepository) ListAttempts(ctx context.Context, deliveryID uuid.UUID) ([]model.WebhookAttempt, error) {
+	args := m.Called(ctx, deliveryID)
+	return args.Get(0).([]model.WebhookAttempt), args.Error(1)
+}
+
+func (m *MockWebhookRepository) Redeliver(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func TestWebhook_SignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"FundsDeposited"}`)
+	now := time.Now()
+	header := webhook.Sign("whsec_test", now, body)
+
+	assert.NoError(t, webhook.Verify("whsec_test", header, body, 5*time.Minute, now))
+	assert.Error(t, webhook.Verify("whsec_other", header, body, 5*time.Minute, now))
+	assert.Error(t, webhook.Verify("whsec_test", header, []byte(`{"type":"FundsWithdrawn"}`), 5*time.Minute, now))
+	assert.Error(t, webhook.Verify("whsec_test", header, body, 5*time.Minute, now.Add(10*time.Minute)))
+	assert.Error(t, webhook.Verify("whsec_test", "garbage", body, 5*time.Minute, now))
+}
+
+func TestWebhook_DispatcherQueuesMatchingSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	from := &model.Wallet{ID: uuid.New(), UserID: uuid.New(), Currency: "USD"}
+	to := &model.Wallet{ID: uuid.New(), UserID: uuid.New(), Currency: "USD"}
+
+	evts, err := events.NewTransferEvents(uuid.New(), from, to, decimal.NewFromInt(10), "order-1")
+	require.NoError(t, err)
+	received := evts[1]
+	received.ID = 42
+
+	all := model.WebhookSubscription{ID: uuid.New(), UserID: to.UserID, Active: true}
+	deposits := model.WebhookSubscription{ID: uuid.New(), UserID: to.UserID, Active: true, EventTypes: []string{events.FundsDeposited}}
+
+	repo := &MockWebhookRepository{}
+	// TransferReceived belongs to the recipient
+	repo.On("ListSubscriptions", ctx, to.UserID).Return([]model.WebhookSubscription{all, deposits}, nil)
+	var queued []model.WebhookDelivery
+	repo.On("EnqueueDeliveries", ctx, mock.Anything).
+		Run(func(args mock.Arguments) { queued = args.Get(1).([]model.WebhookDelivery) }).
+		Return(nil)
+
+	require.NoError(t, webhook.NewDispatcher(repo).Publish(ctx, events.MessageFromOutbox(received)))
+
+	require.Len(t, queued, 1)
+	assert.Equal(t, all.ID, queued[0].SubscriptionID)
+	assert.Equal(t, received.EventID, queued[0].EventID)
+	var msg events.Message
+	require.NoError(t, json.Unmarshal([]byte(queued[0].Payload), &msg))
+	assert.Equal(t, events.TransferReceived, msg.Type)
+	assert.Equal(t, int64(42), msg.Sequence)
+}
+
+// claimOnce has the next claim return deliveries, and every claim after it none
+func claimOnce(repo *MockWebhookRepository, deliveries ...model.WebhookDelivery) {
+	repo.On("ClaimDueDeliveries", mock.Anything, 1, mock.AnythingOfType("time.Duration")).Return(deliveries, nil).Once()
+	repo.On("ClaimDueDeliveries", mock.Anything, 1, mock.AnythingOfType("time.Duration")).Return([]model.WebhookDelivery{}, nil)
+}
+
+func TestWebhook_DelivererAgainstReceiver(t *testing.T) {
+	ctx := context.Background()
+	secret := "whsec_receiver"
+
+	statuses := []int{http.StatusServiceUnavailable, http.StatusOK}
+	var gotSignatureErr error
+	var gotEvent string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotSignatureErr = webhook.Verify(secret, r.Header.Get(webhook.SignatureHeader), body, time.Minute, time.Now())
+		gotEvent = r.Header.Get(webhook.EventHeader)
+		status := statuses[0]
+		statuses = statuses[1:]
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	sub := &model.WebhookSubscription{ID: uuid.New(), UserID: uuid.New(), URL: receiver.URL, Secret: secret, Active: true}
+	newDelivery := func(attempts int) model.WebhookDelivery {
+		return model.WebhookDelivery{
+			ID:             uuid.New(),
+			SubscriptionID: sub.ID,
+			EventID:        uuid.New(),
+			EventType:      events.FundsDeposited,
+			Payload:        `{"type":"FundsDeposited"}`,
+			Status:         model.WebhookPending,
+			Attempts:       attempts,
+		}
+	}
+
+	cfg := webhook.DelivererConfig{BaseBackoff: time.Minute, MaxAttempts: 3, Timeout: time.Second}
+	// the receivers listen on loopback, which the default client refuses
+	client := receiver.Client()
+
+	t.Run("failure is retried with backoff", func(t *testing.T) {
+		repo := &MockWebhookRepository{}
+		claimOnce(repo, newDelivery(1))
+		repo.On("GetSubscription", ctx, sub.ID).Return(sub, nil)
+		var recorded *model.WebhookDelivery
+		var attempt *model.WebhookAttempt
+		repo.On("RecordAttempt", ctx, mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) {
+				recorded = args.Get(1).(*model.WebhookDelivery)
+				attempt = args.Get(2).(*model.WebhookAttempt)
+			}).
+			Return(true, nil)
+
+		before := time.Now()
+		_, err := webhook.NewDeliverer(repo, client, cfg).ProcessBatch(ctx)
+		require.NoError(t, err)
+
+		assert.NoError(t, gotSignatureErr)
+		assert.Equal(t, events.FundsDeposited, gotEvent)
+		assert.Equal(t, model.WebhookPending, recorded.Status)
+		assert.Equal(t, 2, recorded.Attempts)
+		// second failure: 1m * 2
+		assert.WithinDuration(t, before.Add(2*time.Minute), recorded.NextAttemptAt, 5*time.Second)
+		require.NotNil(t, attempt.StatusCode)
+		assert.Equal(t, http.StatusServiceUnavailable, *attempt.StatusCode)
+		assert.NotNil(t, attempt.Error)
+	})
+
+	t.Run("success is recorded", func(t *testing.T) {
+		repo := &MockWebhookRepository{}
+		claimOnce(repo, newDelivery(2))
+		repo.On("GetSubscription", ctx, sub.ID).Return(sub, nil)
+		var recorded *model.WebhookDelivery
+		repo.On("RecordAttempt", ctx, mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) { recorded = args.Get(1).(*model.WebhookDelivery) }).
+			Return(true, nil)
+
+		_, err := webhook.NewDeliverer(repo, client, cfg).ProcessBatch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, model.WebhookSucceeded, recorded.Status)
+		assert.NotNil(t, recorded.DeliveredAt)
+		assert.Nil(t, recorded.LastError)
+	})
+
+	t.Run("dead after max attempts", func(t *testing.T) {
+		down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer down.Close()
+		downSub := *sub
+		downSub.URL = down.URL
+
+		repo := &MockWebhookRepository{}
+		claimOnce(repo, newDelivery(2))
+		repo.On("GetSubscription", ctx, sub.ID).Return(&downSub, nil)
+		var recorded *model.WebhookDelivery
+		repo.On("RecordAttempt", ctx, mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) { recorded = args.Get(1).(*model.WebhookDelivery) }).
+			Return(true, nil)
+
+		_, err := webhook.NewDeliverer(repo, client, cfg).ProcessBatch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, model.WebhookDead, recorded.Status)
+		assert.Equal(t, 3, recorded.Attempts)
+		require.NotNil(t, recorded.LastStatusCode)
+		assert.Equal(t, http.StatusInternalServerError, *recorded.LastStatusCode)
+	})
+
+	t.Run("default client refuses non-public addresses", func(t *testing.T) {
+		repo := &MockWebhookRepository{}
+		claimOnce(repo, newDelivery(0))
+		repo.On("GetSubscription", ctx, sub.ID).Return(sub, nil)
+		var recorded *model.WebhookDelivery
+		var attempt *model.WebhookAttempt
+		repo.On("RecordAttempt", ctx, mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) {
+				recorded = args.Get(1).(*model.WebhookDelivery)
+				attempt = args.Get(2).(*model.WebhookAttempt)
+			}).
+			Return(true, nil)
+
+		remaining := len(statuses)
+		_, err := webhook.NewDeliverer(repo, nil, cfg).ProcessBatch(ctx)
+		require.NoError(t, err)
+		assert.Len(t, statuses, remaining, "the receiver must not be called")
+		assert.Equal(t, model.WebhookPending, recorded.Status)
+		assert.Nil(t, attempt.StatusCode)
+		require.NotNil(t, attempt.Error)
+		assert.Contains(t, *attempt.Error, "non-public address")
+	})
+}
+
+func TestWebhook_DelivererLeases(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "send")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	sub := &model.WebhookSubscription{ID: uuid.New(), UserID: uuid.New(), URL: receiver.URL, Secret: "whsec", Active: true}
+	delivery := func() model.WebhookDelivery {
+		return model.WebhookDelivery{ID: uuid.New(), SubscriptionID: sub.ID, Payload: `{}`, Status: model.WebhookPending}
+	}
+	cfg := webhook.DelivererConfig{BatchSize: 3, Timeout: time.Second}
+
+	t.Run("each delivery is claimed right before its send", func(t *testing.T) {
+		calls = nil
+		repo := &MockWebhookRepository{}
+		// the lease covers one send, not the whole batch
+		repo.On("ClaimDueDeliveries", ctx, 1, 2*time.Second).
+			Run(func(mock.Arguments) { calls = append(calls, "claim") }).
+			Return([]model.WebhookDelivery{delivery()}, nil).Times(3)
+		repo.On("GetSubscription", ctx, sub.ID).Return(sub, nil)
+		repo.On("RecordAttempt", ctx, mock.Anything, mock.Anything).Return(true, nil)
+
+		n, err := webhook.NewDeliverer(repo, receiver.Client(), cfg).ProcessBatch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.Equal(t, []string{"claim", "send", "claim", "send", "claim", "send"}, calls)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("an attempt that lost its lease is dropped", func(t *testing.T) {
+		repo := &MockWebhookRepository{}
+		claimOnce(repo, delivery())
+		repo.On("GetSubscription", ctx, sub.ID).Return(sub, nil)
+		repo.On("RecordAttempt", ctx, mock.Anything, mock.Anything).Return(false, nil).Once()
+
+		n, err := webhook.NewDeliverer(repo, receiver.Client(), cfg).ProcessBatch(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		repo.AssertExpectations(t)
+	})
+}
+
+func TestWebhook_Redeliver(t *testing.T) {
+	ctx := context.Background()
+	owner := uuid.New()
+	sub := &model.WebhookSubscription{ID: uuid.New(), UserID: owner, Active: true}
+	dead := &model.WebhookDelivery{ID: uuid.New(), SubscriptionID: sub.ID, Status: model.WebhookDead}
+	pending := &model.WebhookDelivery{ID: uuid.New(), SubscriptionID: sub.ID, Status: model.WebhookPending}
+
+	repo := &MockWebhookRepository{}
+	repo.On("GetDelivery", ctx, dead.ID).Return(dead, nil)
+	repo.On("GetDelivery", ctx, pending.ID).Return(pending, nil)
+	repo.On("GetSubscription", ctx, sub.ID).Return(sub, nil)
+	repo.On("Redeliver", ctx, dead.ID).Return(nil)
+	svc := service.NewWebhookService(repo)
+
+	assert.NoError(t, svc.Redeliver(ctx, owner, dead.ID))
+	assert.Error(t, svc.Redeliver(ctx, owner, pending.ID))
+	assert.Error(t, svc.Redeliver(ctx, uuid.New(), dead.ID))
+	repo.AssertNumberOfCalls(t, "Redeliver", 1)
+}
+
+func TestWebhook_CreateSubscriptionValidation(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockWebhookRepository{}
+	repo.On("CreateSubscription", ctx, mock.AnythingOfType("*model.WebhookSubscription")).Return(nil)
+	svc := service.NewWebhookService(repo, service.WithWebhookResolver(stubResolver{
+		"merchant.example": {netip.MustParseAddr("203.0.113.10")},
+		"internal.example": {netip.MustParseAddr("203.0.113.11"), netip.MustParseAddr("10.0.0.5")},
+	}))
+
+	_, err := svc.CreateSubscription(ctx, uuid.New(), "ftp://merchant.example", nil, "")
+	assert.Error(t, err)
+
+	for _, endpoint := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"https://10.1.2.3/hook",
+		"https://192.168.0.10/hook",
+		"https://[::ffff:172.16.0.1]/hook",
+		"https://internal.example/hook",
+		"https://unknown.example/hook",
+	} {
+		_, err = svc.CreateSubscription(ctx, uuid.New(), endpoint, []string{events.FundsDeposited}, "")
+		assert.Equal(t, errors.InvalidRequest, errors.TypeOf(err), endpoint)
+	}
+
+	_, err = svc.CreateSubscription(ctx, uuid.New(), "https://merchant.example/hook", []string{"NotAnEvent"}, "")
+	assert.Error(t, err)
+
+	sub, err := svc.CreateSubscription(ctx, uuid.New(), "https://merchant.example/hook", []string{events.FundsDeposited}, "")
+	require.NoError(t, err)
+	assert.NotEmpty(t, sub.Secret)
+	assert.True(t, sub.Matches(events.FundsDeposited))
+	assert.False(t, sub.Matches(events.FundsWithdrawn))
+	repo.AssertNumberOfCalls(t, "CreateSubscription", 1)
+}
+
+// stubResolver resolves the hosts it has, and nothing else
+type stubResolver map[string][]netip.Addr
+
+func (r stubResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestWebhook_PublicAddr(t *testing.T) {
+	for _, addr := range []string{"203.0.113.10", "8.8.8.8", "2606:4700::1111"} {
+		assert.True(t, webhook.PublicAddr(netip.MustParseAddr(addr)), addr)
+	}
+	for _, addr := range []string{
+		"127.0.0.1", "::1", "0.0.0.0", "::", "10.0.0.1", "172.16.5.4", "192.168.1.1",
+		"169.254.169.254", "fe80::1", "fc00::1", "100.64.0.1", "224.0.0.1",
+		"255.255.255.255", "::ffff:127.0.0.1", "64:ff9b::a00:1",
+	} {
+		assert.False(t, webhook.PublicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}